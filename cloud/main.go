@@ -0,0 +1,22 @@
+// Command terraform-provider-sshtunnel is the released provider binary. It is
+// a separate module, so the AWS, Azure and GCP SDKs resolving secrets and
+// credentials of these clouds are not dependencies of the provider module,
+// which builds without them.
+package main
+
+import (
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/command"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/secretref"
+)
+
+var (
+	// these will be set by the goreleaser configuration
+	// to appropriate values for the compiled binary.
+	version string = "dev"
+)
+
+func main() {
+	secretref.RegisterSDK(SDK{})
+
+	command.Main(version)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/secretref"
+)
+
+// SDK implements secretref.SDK with the AWS, Azure and GCP SDKs.
+type SDK struct{}
+
+var _ secretref.SDK = SDK{}
+
+// AWSSecretsManagerSecret implements secretref.SDK.
+func (SDK) AWSSecretsManagerSecret(ctx context.Context, awsConfig secretref.AWSConfig, secretID string) ([]byte, error) {
+	cfg, err := loadAWSConfig(ctx, awsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretID),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case out.SecretString != nil:
+		return []byte(*out.SecretString), nil
+	case out.SecretBinary != nil:
+		return out.SecretBinary, nil
+	default:
+		return nil, errors.New("secret has no value")
+	}
+}
+
+// AWSSSMParameter implements secretref.SDK.
+func (SDK) AWSSSMParameter(ctx context.Context, awsConfig secretref.AWSConfig, name string) ([]byte, error) {
+	cfg, err := loadAWSConfig(ctx, awsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := ssm.NewFromConfig(cfg).GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if out.Parameter == nil || out.Parameter.Value == nil {
+		return nil, errors.New("parameter has no value")
+	}
+
+	return []byte(*out.Parameter.Value), nil
+}
+
+// AzureCredential implements secretref.SDK.
+func (SDK) AzureCredential() (secretref.AzureCredential, error) {
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	return azureCredential{cred}, nil
+}
+
+// GCPCredentials implements secretref.SDK.
+func (SDK) GCPCredentials(ctx context.Context, scopes ...string) (*secretref.GCPCredentials, error) {
+	creds, err := google.FindDefaultCredentials(ctx, scopes...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &secretref.GCPCredentials{
+		ProjectID: creds.ProjectID,
+		JSON:      creds.JSON,
+		Client:    oauth2.NewClient(ctx, creds.TokenSource),
+		Token: func() (string, error) {
+			token, err := creds.TokenSource.Token()
+			if err != nil {
+				return "", err
+			}
+			return token.AccessToken, nil
+		},
+	}, nil
+}
+
+// azureCredential implements secretref.AzureCredential with an azcore
+// credential.
+type azureCredential struct {
+	cred azcore.TokenCredential
+}
+
+func (c azureCredential) Token(ctx context.Context, scopes []string) (string, error) {
+	token, err := c.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: scopes})
+	if err != nil {
+		return "", err
+	}
+	return token.Token, nil
+}
+
+func loadAWSConfig(ctx context.Context, awsConfig secretref.AWSConfig) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{}
+	if awsConfig.Region != "" {
+		opts = append(opts, config.WithRegion(awsConfig.Region))
+	}
+	if awsConfig.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(awsConfig.Profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if awsConfig.RoleARN != "" {
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), awsConfig.RoleARN))
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/secretref"
+)
+
+func TestReadAWSSecret(t *testing.T) {
+	secretref.RegisterSDK(SDK{})
+	defer secretref.RegisterSDK(nil)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch {
+		case r.Header.Get("X-Amz-Target") == "secretsmanager.GetSecretValue" && body["SecretId"] == "ssh/bastion":
+			_, _ = w.Write([]byte(`{"SecretString":"{\"private_key\":\"secret-key\"}"}`))
+		case r.Header.Get("X-Amz-Target") == "AmazonSSM.GetParameter" && body["Name"] == "/ssh/bastion" && body["WithDecryption"] == true:
+			_, _ = w.Write([]byte(`{"Parameter":{"Value":"parameter-key"}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"ResourceNotFoundException","message":"not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_PROFILE", "")
+	config := secretref.AWSConfig{Region: "eu-west-1"}
+
+	tests := []struct {
+		name string
+		ref  *secretref.Ref
+		want string
+	}{
+		{name: "secrets manager", ref: &secretref.Ref{Scheme: secretref.SchemeAWSSecretsManager, Name: "ssh/bastion", Field: "private_key"}, want: "secret-key"},
+		{name: "ssm", ref: &secretref.Ref{Scheme: secretref.SchemeAWSSSM, Name: "/ssh/bastion"}, want: "parameter-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := secretref.ResolveRef(context.Background(), tt.ref, secretref.Options{AWS: config})
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	_, err := secretref.ResolveRef(context.Background(), &secretref.Ref{Scheme: secretref.SchemeAWSSecretsManager, Name: "missing"}, secretref.Options{AWS: config})
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestGCPCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"Bearer","expires_in":3600}`))
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+
+	credentials := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(credentials, []byte(fmt.Sprintf(`{"type":"authorized_user","client_id":"id","client_secret":"secret","refresh_token":"refresh","project_id":"default-project","token_uri":%q}`, server.URL+"/token")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credentials)
+
+	creds, err := SDK{}.GCPCredentials(context.Background(), "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		t.Fatalf("failed to find credentials: %v", err)
+	}
+	if creds.ProjectID != "default-project" {
+		t.Errorf("got project %q, want default-project", creds.ProjectID)
+	}
+	if !strings.Contains(string(creds.JSON), "authorized_user") {
+		t.Errorf("expected the credentials file, got %q", creds.JSON)
+	}
+
+	token, err := creds.Token()
+	if err != nil || token != "test-token" {
+		t.Errorf("got token %q (%v), want test-token", token, err)
+	}
+
+	res, err := creds.Client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if string(body) != "Bearer test-token" {
+		t.Errorf("got authorization %q, want Bearer test-token", body)
+	}
+}
+
+// staticTokenCredential returns a fixed token, recording the requested
+// scopes.
+type staticTokenCredential struct {
+	scopes *[]string
+}
+
+func (c staticTokenCredential) GetToken(_ context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	*c.scopes = opts.Scopes
+	return azcore.AccessToken{Token: "test-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestAzureCredential(t *testing.T) {
+	var scopes []string
+	token, err := azureCredential{staticTokenCredential{&scopes}}.Token(context.Background(), []string{"https://vault.azure.net/.default"})
+	if err != nil {
+		t.Fatalf("failed to get a token: %v", err)
+	}
+	if token != "test-token" {
+		t.Errorf("got token %q, want test-token", token)
+	}
+	if len(scopes) != 1 || scopes[0] != "https://vault.azure.net/.default" {
+		t.Errorf("got scopes %v, want the Key Vault scope", scopes)
+	}
+}
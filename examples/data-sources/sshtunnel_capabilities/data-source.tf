@@ -0,0 +1,26 @@
+data "sshtunnel_capabilities" "this" {}
+
+# Use the PKCS#11 token directly if this build supports it, otherwise through
+# the SSH agent after `ssh-add -s`.
+ephemeral "sshtunnel_connection" "internal_db" {
+  host = "ssh.jump.server"
+  port = 22
+  user = "jump"
+
+  known_hosts_file = "known_hosts"
+
+  auth = data.sshtunnel_capabilities.this.pkcs11 ? {
+    pkcs11 = {
+      module = "/usr/lib/opensc-pkcs11.so"
+    }
+    agent = null
+  } : {
+    pkcs11 = null
+    agent  = true
+  }
+
+  local_port_forwardings = [{
+    remote_host = "db.server"
+    remote_port = 5432
+  }]
+}
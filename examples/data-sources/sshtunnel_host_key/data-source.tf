@@ -0,0 +1,21 @@
+# Read the host key once and pin it, e.g. after comparing it with the
+# fingerprint shown by the console of the bastion.
+data "sshtunnel_host_key" "bastion" {
+  host = "ssh.jump.server"
+}
+
+ephemeral "sshtunnel_connection" "internal_db" {
+  host                 = "ssh.jump.server"
+  port                 = 22
+  user                 = "jump"
+  host_key_fingerprint = data.sshtunnel_host_key.bastion.fingerprint_sha256
+
+  auth = {
+    agent = true
+  }
+
+  local_port_forwardings = [{
+    remote_host = "db.server"
+    remote_port = 5432
+  }]
+}
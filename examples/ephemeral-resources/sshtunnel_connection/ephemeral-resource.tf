@@ -9,6 +9,10 @@ ephemeral "sshtunnel_connection" "internal_db" {
   port = 2222
   user = "jump"
 
+  # Verify the host key of the jump server against this file instead of ~/.ssh/known_hosts,
+  # e.g. created with `ssh-keyscan -p 2222 ssh.jump.server > known_hosts` and reviewed.
+  known_hosts_file = "known_hosts"
+
   auth = {
     private_key = file("jump.key")
   }
@@ -23,7 +27,7 @@ ephemeral "sshtunnel_connection" "internal_db" {
 # Configure the database provider to connect to the database server through the SSH tunnel.
 provider "postgresql" {
   host = "localhost"
-  port = ephemeral.sshtunnel_connection.tunnel.local_port_forwardings.0.local_port
+  port = ephemeral.sshtunnel_connection.internal_db.local_port_forwardings.0.local_port
 
   # ...
 }
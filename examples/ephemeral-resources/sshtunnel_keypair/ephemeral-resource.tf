@@ -0,0 +1,23 @@
+# Generate a key that is never written to disk or state.
+ephemeral "sshtunnel_keypair" "deploy" {
+  comment = "terraform"
+}
+
+# Add ephemeral.sshtunnel_keypair.deploy.public_key to the server first, e.g. with an
+# API accepting ephemeral values, then authenticate with the private key.
+ephemeral "sshtunnel_connection" "internal_db" {
+  host = "ssh.jump.server"
+  port = 22
+  user = "jump"
+
+  known_hosts_file = "known_hosts"
+
+  auth = {
+    private_key = ephemeral.sshtunnel_keypair.deploy.private_key
+  }
+
+  local_port_forwardings = [{
+    remote_host = "db.server"
+    remote_port = 5432
+  }]
+}
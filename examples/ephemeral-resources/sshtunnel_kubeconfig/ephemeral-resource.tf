@@ -0,0 +1,33 @@
+# Reach a private Kubernetes API server through a jump server.
+
+ephemeral "sshtunnel_connection" "k8s" {
+  host = "ssh.jump.server"
+  port = 22
+  user = "jump"
+
+  known_hosts_file = "known_hosts"
+
+  auth = {
+    private_key = file("jump.key")
+  }
+
+  local_port_forwardings = [{
+    remote_host = "api.internal"
+    remote_port = 6443
+  }]
+}
+
+ephemeral "sshtunnel_kubeconfig" "k8s" {
+  server                 = "https://api.internal:6443"
+  local_port             = ephemeral.sshtunnel_connection.k8s.local_port_forwardings.0.local_port
+  cluster_ca_certificate = file("ca.crt")
+  token                  = var.kubernetes_token
+}
+
+# The API server certificate is verified for api.internal, although connections go to the local port.
+provider "kubernetes" {
+  host                   = ephemeral.sshtunnel_kubeconfig.k8s.host
+  tls_server_name        = ephemeral.sshtunnel_kubeconfig.k8s.tls_server_name
+  cluster_ca_certificate = file("ca.crt")
+  token                  = var.kubernetes_token
+}
@@ -0,0 +1,35 @@
+# Connect to an RDS instance through a jump server, verifying its certificate.
+
+ephemeral "sshtunnel_connection" "db" {
+  host = "ssh.jump.server"
+  port = 22
+  user = "jump"
+
+  known_hosts_file = "known_hosts"
+
+  auth = {
+    private_key = file("jump.key")
+  }
+
+  local_port_forwardings = [{
+    remote_host = "orders.abc123.eu-central-1.rds.amazonaws.com"
+    remote_port = 5432
+  }]
+}
+
+ephemeral "sshtunnel_postgresql" "db" {
+  remote_host = "orders.abc123.eu-central-1.rds.amazonaws.com"
+  local_port  = ephemeral.sshtunnel_connection.db.local_port_forwardings.0.local_port
+  user        = "terraform"
+  password    = var.db_password
+  database    = "orders"
+  sslmode     = "verify-full"
+  sslrootcert = "global-bundle.pem"
+}
+
+provider "postgresql" {
+  # ...
+}
+
+# e.g. for a provider or tool accepting a connection URI:
+# ephemeral.sshtunnel_postgresql.db.uri
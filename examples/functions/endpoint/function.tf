@@ -0,0 +1,8 @@
+# Resolve the local address of a named forwarding of a tunnel, e.g. one kept
+# open by `terraform-provider-sshtunnel daemon` with `connection_id = "db"`.
+provider "postgresql" {
+  host = split(":", provider::sshtunnel::endpoint("db", "primary"))[0]
+  port = split(":", provider::sshtunnel::endpoint("db", "primary"))[1]
+
+  # ...
+}
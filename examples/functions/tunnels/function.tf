@@ -0,0 +1,29 @@
+# Open a tunnel per database, each definition is validated by the provider.
+variable "databases" {
+  default = {
+    orders = {
+      host = "bastion.example.com"
+      local_port_forwardings = [
+        { name = "primary", remote_host = "orders.db.internal", remote_port = 5432 },
+      ]
+    }
+    billing = {
+      host = "bastion.example.com"
+      port = 2222
+      local_port_forwardings = [
+        { name = "primary", remote_host = "billing.db.internal", remote_port = 5432 },
+      ]
+    }
+  }
+}
+
+ephemeral "sshtunnel_connection" "db" {
+  for_each = provider::sshtunnel::tunnels(var.databases)
+
+  definition       = each.value
+  user             = "deploy"
+  known_hosts_file = "known_hosts"
+  auth = {
+    agent = true
+  }
+}
@@ -0,0 +1,77 @@
+// Package command implements the entry point of the provider binary. It is
+// shared by main.go, building the provider without the AWS, Azure and GCP
+// SDKs, and the cloud module, building the released provider binary with
+// them.
+package command
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/daemon"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/debugcli"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/provider"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/setcap"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/sshcmd"
+)
+
+var errNoSubcommand = errors.New("no subcommand")
+
+func runSubcommand(version string, name string, args []string) error {
+	switch name {
+	case "daemon":
+		return daemon.Main(context.Background(), version, args)
+	case "convert":
+		return sshcmd.Main(args)
+	case "setcap":
+		return setcap.Main(context.Background(), args)
+	}
+
+	// The debugging subcommands are hidden.
+	for _, command := range debugcli.Commands {
+		if name == command {
+			return debugcli.Main(context.Background(), version, name, args)
+		}
+	}
+
+	return errNoSubcommand
+}
+
+// Main serves the provider of version to Terraform or runs the subcommand
+// named by the first argument.
+func Main(version string) {
+	// Terraform starts the provider without arguments, subcommands are for
+	// running tunnels outside of Terraform.
+	if len(os.Args) > 1 {
+		if err := runSubcommand(version, os.Args[1], os.Args[2:]); !errors.Is(err, errNoSubcommand) {
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			return
+		}
+	}
+
+	var debug bool
+
+	flag.BoolVar(&debug, "debug", false, "set to true to run the provider with support for debuggers like delve")
+	flag.Parse()
+
+	opts := providerserver.ServeOpts{
+		// TODO: Update this string with the published name of your provider.
+		// Also update the tfplugindocs generate command to either remove the
+		// -provider-name flag or set its value to the updated provider name.
+		Address: "registry.terraform.io/johanneswuerbach/sshtunnel",
+		Debug:   debug,
+	}
+
+	err := providerserver.Serve(context.Background(), provider.New(version), opts)
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
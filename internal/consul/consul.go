@@ -0,0 +1,99 @@
+// Package consul registers services with a Consul agent through its HTTP
+// API, so services of the network discover them through the Consul catalog
+// or DNS interface, e.g. `name.service.consul`.
+package consul
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// AddrEnv and TokenEnv are the environment variables of the Consul CLI
+// providing the defaults of NewClient.
+const (
+	AddrEnv  = "CONSUL_HTTP_ADDR"
+	TokenEnv = "CONSUL_HTTP_TOKEN"
+)
+
+// DefaultAddr is the address of the local Consul agent.
+const DefaultAddr = "http://127.0.0.1:8500"
+
+// Service is a service registered with the agent.
+type Service struct {
+	ID      string            `json:"ID"`
+	Name    string            `json:"Name"`
+	Address string            `json:"Address,omitempty"`
+	Port    int               `json:"Port"`
+	Tags    []string          `json:"Tags,omitempty"`
+	Meta    map[string]string `json:"Meta,omitempty"`
+}
+
+// Client registers services with the agent at Addr.
+type Client struct {
+	Addr       string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for the agent at addr, authenticating with
+// token. Empty values default to AddrEnv, or DefaultAddr, and TokenEnv. An
+// addr without scheme uses http, like the Consul CLI.
+func NewClient(addr, token string) *Client {
+	if addr == "" {
+		addr = os.Getenv(AddrEnv)
+	}
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	if token == "" {
+		token = os.Getenv(TokenEnv)
+	}
+
+	return &Client{Addr: strings.TrimSuffix(addr, "/"), Token: token, HTTPClient: http.DefaultClient}
+}
+
+// Register registers service, replacing a service with the same ID.
+func (c *Client) Register(ctx context.Context, service Service) error {
+	body, err := json.Marshal(service)
+	if err != nil {
+		return err
+	}
+	return c.put(ctx, "/v1/agent/service/register", body)
+}
+
+// Deregister removes the service id.
+func (c *Client) Deregister(ctx context.Context, id string) error {
+	return c.put(ctx, "/v1/agent/service/deregister/"+url.PathEscape(id), nil)
+}
+
+func (c *Client) put(ctx context.Context, path string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.Addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("consul: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package consul_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/consul"
+)
+
+// fakeAgent implements the service endpoints of the Consul agent API.
+type fakeAgent struct {
+	mu       sync.Mutex
+	token    string
+	services map[string]consul.Service
+}
+
+func (a *fakeAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("X-Consul-Token") != a.token {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/v1/agent/service/register":
+		var service consul.Service
+		if err := json.NewDecoder(r.Body).Decode(&service); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.services[service.ID] = service
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/")
+		if _, ok := a.services[id]; !ok {
+			http.Error(w, "Unknown service ID", http.StatusNotFound)
+			return
+		}
+		delete(a.services, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (a *fakeAgent) registered() map[string]consul.Service {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	services := map[string]consul.Service{}
+	for id, service := range a.services {
+		services[id] = service
+	}
+	return services
+}
+
+func TestRegister(t *testing.T) {
+	agent := &fakeAgent{token: "secret", services: map[string]consul.Service{}}
+	server := httptest.NewServer(agent)
+	defer server.Close()
+
+	client := consul.NewClient(strings.TrimPrefix(server.URL, "http://"), "secret")
+	service := consul.Service{ID: "sshtunnel-abc-0", Name: "operator-api", Address: "10.0.0.5", Port: 8080, Tags: []string{"sshtunnel"}}
+	if err := client.Register(context.Background(), service); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if got := agent.registered()[service.ID]; !reflect.DeepEqual(got, service) {
+		t.Errorf("got %+v, want %+v", got, service)
+	}
+
+	if err := client.Deregister(context.Background(), service.ID); err != nil {
+		t.Fatalf("Failed to deregister: %v", err)
+	}
+	if services := agent.registered(); len(services) != 0 {
+		t.Errorf("Expected the service to be deregistered, got %v", services)
+	}
+
+	// Errors of the agent are returned.
+	if err := client.Deregister(context.Background(), service.ID); err == nil || !strings.Contains(err.Error(), "Unknown service ID") {
+		t.Errorf("Expected the error of the agent, got %v", err)
+	}
+	if err := consul.NewClient(server.URL, "wrong").Register(context.Background(), service); err == nil {
+		t.Error("Expected an error for a wrong token")
+	}
+}
+
+func TestNewClientEnvironment(t *testing.T) {
+	t.Setenv(consul.AddrEnv, "consul.internal:8500")
+	t.Setenv(consul.TokenEnv, "from-env")
+
+	client := consul.NewClient("", "")
+	if client.Addr != "http://consul.internal:8500" || client.Token != "from-env" {
+		t.Errorf("got %q and %q, want the environment", client.Addr, client.Token)
+	}
+
+	t.Setenv(consul.AddrEnv, "")
+	if client := consul.NewClient("", ""); client.Addr != consul.DefaultAddr {
+		t.Errorf("got %q, want %q", client.Addr, consul.DefaultAddr)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package daemon
+
+import (
+	"errors"
+	"os"
+)
+
+func inheritedFile(fd int) (*os.File, error) {
+	return nil, errors.New("socket activation is not supported on this platform")
+}
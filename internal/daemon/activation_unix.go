@@ -0,0 +1,14 @@
+//go:build unix
+
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func inheritedFile(fd int) (*os.File, error) {
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd)), nil
+}
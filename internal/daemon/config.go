@@ -0,0 +1,201 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// Config is a parsed daemon configuration file. It contains an optional
+// provider block and a connection block with the same attributes as the
+// provider configuration and the sshtunnel_connection ephemeral resource:
+//
+//	provider {
+//	  lock_dir = "/tmp/sshtunnel"
+//	}
+//
+//	connection {
+//	  host = "bastion.example.com"
+//	  user = "terraform"
+//	  auth = {
+//	    private_key = file("id_ed25519")
+//	  }
+//	  local_port_forwardings = [{
+//	    local_port  = 5432
+//	    remote_host = "db.internal"
+//	    remote_port = 5432
+//	  }]
+//	}
+type Config struct {
+	Provider   map[string]cty.Value
+	Connection map[string]cty.Value
+}
+
+var configSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "provider"},
+		{Type: "connection"},
+	},
+}
+
+// LoadConfig reads and parses the configuration file at path. Files ending in
+// .json are parsed as HCL JSON.
+func LoadConfig(path string) (*Config, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseConfig(path, src)
+}
+
+// ParseConfig parses src as configuration file. Relative paths passed to the
+// file function are resolved against the directory of filename.
+func ParseConfig(filename string, src []byte) (*Config, error) {
+	parser := hclparse.NewParser()
+
+	var file *hcl.File
+	var diags hcl.Diagnostics
+	if strings.HasSuffix(filename, ".json") {
+		file, diags = parser.ParseJSON(src, filename)
+	} else {
+		file, diags = parser.ParseHCL(src, filename)
+	}
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	content, diags := file.Body.Content(configSchema)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	evalCtx := &hcl.EvalContext{
+		Functions: map[string]function.Function{
+			"file": fileFunc(filepath.Dir(filename)),
+			"env":  envFunc,
+		},
+	}
+
+	conf := &Config{}
+	for _, block := range content.Blocks {
+		attrs, err := blockAttributes(block, evalCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		switch block.Type {
+		case "provider":
+			if conf.Provider != nil {
+				return nil, fmt.Errorf("%s: duplicate provider block", block.DefRange)
+			}
+			conf.Provider = attrs
+		case "connection":
+			if conf.Connection != nil {
+				return nil, fmt.Errorf("%s: duplicate connection block", block.DefRange)
+			}
+			conf.Connection = attrs
+		}
+	}
+
+	if conf.Connection == nil {
+		return nil, fmt.Errorf("%s: missing connection block", filename)
+	}
+	if conf.Provider == nil {
+		conf.Provider = map[string]cty.Value{}
+	}
+
+	return conf, nil
+}
+
+func blockAttributes(block *hcl.Block, evalCtx *hcl.EvalContext) (map[string]cty.Value, error) {
+	attrs, diags := block.Body.JustAttributes()
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	values := map[string]cty.Value{}
+	for name, attr := range attrs {
+		value, diags := attr.Expr.Value(evalCtx)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		values[name] = value
+	}
+
+	return values, nil
+}
+
+// ProviderValue returns the provider block as value of type typ.
+func (c *Config) ProviderValue(typ tftypes.Type) (tftypes.Value, error) {
+	return toTerraformValue(c.Provider, typ)
+}
+
+// ConnectionValue returns the connection block as value of type typ.
+func (c *Config) ConnectionValue(typ tftypes.Type) (tftypes.Value, error) {
+	return toTerraformValue(c.Connection, typ)
+}
+
+// toTerraformValue converts attrs to an object of type typ. Attributes not
+// set are null, unknown attributes are rejected.
+func toTerraformValue(attrs map[string]cty.Value, typ tftypes.Type) (tftypes.Value, error) {
+	object := map[string]json.RawMessage{}
+	for name, value := range attrs {
+		b, err := ctyjson.Marshal(value, value.Type())
+		if err != nil {
+			return tftypes.Value{}, fmt.Errorf("%s: %w", name, err)
+		}
+		object[name] = b
+	}
+
+	b, err := json.Marshal(object)
+	if err != nil {
+		return tftypes.Value{}, err
+	}
+
+	return tftypes.ValueFromJSON(b, typ)
+}
+
+func fileFunc(baseDir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "path", Type: cty.String},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			path := args[0].AsString()
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(baseDir, path)
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			return cty.StringVal(string(b)), nil
+		},
+	})
+}
+
+var envFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "name", Type: cty.String},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		value, ok := os.LookupEnv(args[0].AsString())
+		if !ok {
+			return cty.NullVal(cty.String), nil
+		}
+		return cty.StringVal(value), nil
+	},
+})
@@ -0,0 +1,352 @@
+// Package daemon keeps a tunnel open outside of Terraform, e.g. to reach a
+// state backend in a private network before `terraform init`, where the
+// lifecycle of ephemeral resources starts too late.
+//
+// The tunnel is opened by driving the provider through its plugin protocol
+// server, so it behaves exactly like an sshtunnel_connection opened by
+// Terraform.
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	tfprovider "github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/hashicorp/terraform-plugin-log/tfsdklog"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/pidfile"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/provider"
+)
+
+const connectionTypeName = "sshtunnel_connection"
+
+// Main runs the daemon command line with args, excluding the command name.
+// The tunnel is kept open until SIGINT or SIGTERM is received or, if a
+// command is given after the flags, until the command exits.
+func Main(ctx context.Context, version string, args []string) error {
+	flags := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: terraform-provider-sshtunnel daemon -config FILE [flags] [-- command [args...]]")
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", "", "path of the configuration file (required)")
+	pidPath := flags.String("pid-file", "", "path of a pid file to write while running")
+	outputPath := flags.String("output", "", "path of a file the connection result is written to as JSON once the tunnel is open")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		flags.Usage()
+		return errors.New("-config is required")
+	}
+	command := flags.Args()
+
+	conf, err := LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	listeners, err := activationListeners()
+	if err != nil {
+		return fmt.Errorf("socket activation: %w", err)
+	}
+
+	if *pidPath != "" {
+		p, err := pidfile.Acquire(*pidPath)
+		if err != nil {
+			return err
+		}
+		defer p.Release()
+	}
+
+	// On Windows, closing the console, logoff and shutdown are delivered as
+	// SIGTERM.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if os.Getenv("TF_LOG") != "" {
+		ctx = tfsdklog.NewRootProviderLogger(ctx, tfsdklog.WithLogName("sshtunnel"), tfsdklog.WithLevelFromEnv("TF_LOG"))
+	}
+
+	p := provider.NewWithListenerPool(version, provider.NewListenerPool(listeners))()
+
+	cmdErr := make(chan error, 1)
+	err = Run(ctx, p, conf, os.Stderr, func(result []byte) error {
+		if *outputPath != "" {
+			if err := writeFileAtomic(*outputPath, result); err != nil {
+				return err
+			}
+		}
+
+		if len(command) == 0 {
+			fmt.Fprintln(os.Stdout, string(result))
+			return nil
+		}
+
+		// The command is only started once the tunnel is open, so it can
+		// connect right away.
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), resultEnv+"="+string(result))
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		go func() {
+			cmdErr <- cmd.Wait()
+			cancel()
+		}()
+		return nil
+	}, func() {
+		if *outputPath != "" {
+			os.Remove(*outputPath)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(command) > 0 {
+		return <-cmdErr
+	}
+	return nil
+}
+
+// resultEnv is the environment variable a command started by the daemon
+// receives the connection result in.
+const resultEnv = "SSHTUNNEL_RESULT"
+
+// Run opens the connection described by conf using p and calls ready with
+// the connection result encoded as JSON. The connection is closed once ctx is
+// cancelled, after calling done. Warnings are written to stderr.
+func Run(ctx context.Context, p tfprovider.Provider, conf *Config, stderr io.Writer, ready func(result []byte) error, done func()) error {
+	server, ok := providerserver.NewProtocol6(p)().(tfprotov6.ProviderServerWithEphemeralResources)
+	if !ok {
+		return errors.New("provider server does not support ephemeral resources")
+	}
+
+	schemaResp, err := server.GetProviderSchema(ctx, &tfprotov6.GetProviderSchemaRequest{})
+	if err != nil {
+		return err
+	}
+	if err := checkDiagnostics(stderr, schemaResp.Diagnostics); err != nil {
+		return err
+	}
+
+	connectionSchema, ok := schemaResp.EphemeralResourceSchemas[connectionTypeName]
+	if !ok {
+		return fmt.Errorf("provider does not support %s", connectionTypeName)
+	}
+
+	providerConfig, err := dynamicValue(conf.Provider, schemaResp.Provider.ValueType())
+	if err != nil {
+		return fmt.Errorf("provider: %w", err)
+	}
+	connectionType := connectionSchema.ValueType()
+	connectionConfig, err := dynamicValue(conf.Connection, connectionType)
+	if err != nil {
+		return fmt.Errorf("connection: %w", err)
+	}
+
+	validateProviderResp, err := server.ValidateProviderConfig(ctx, &tfprotov6.ValidateProviderConfigRequest{Config: providerConfig})
+	if err != nil {
+		return err
+	}
+	if err := checkDiagnostics(stderr, validateProviderResp.Diagnostics); err != nil {
+		return err
+	}
+
+	configureResp, err := server.ConfigureProvider(ctx, &tfprotov6.ConfigureProviderRequest{Config: providerConfig})
+	if err != nil {
+		return err
+	}
+	if err := checkDiagnostics(stderr, configureResp.Diagnostics); err != nil {
+		return err
+	}
+
+	validateResp, err := server.ValidateEphemeralResourceConfig(ctx, &tfprotov6.ValidateEphemeralResourceConfigRequest{
+		TypeName: connectionTypeName,
+		Config:   connectionConfig,
+	})
+	if err != nil {
+		return err
+	}
+	if err := checkDiagnostics(stderr, validateResp.Diagnostics); err != nil {
+		return err
+	}
+
+	openResp, err := server.OpenEphemeralResource(ctx, &tfprotov6.OpenEphemeralResourceRequest{
+		TypeName: connectionTypeName,
+		Config:   connectionConfig,
+	})
+	if err != nil {
+		return err
+	}
+	if err := checkDiagnostics(stderr, openResp.Diagnostics); err != nil {
+		return err
+	}
+
+	closeConnection := func() error {
+		closeResp, err := server.CloseEphemeralResource(context.WithoutCancel(ctx), &tfprotov6.CloseEphemeralResourceRequest{
+			TypeName: connectionTypeName,
+			Private:  openResp.Private,
+		})
+		if err != nil {
+			return err
+		}
+		return checkDiagnostics(stderr, closeResp.Diagnostics)
+	}
+
+	result, err := resultJSON(openResp.Result, connectionType)
+	if err == nil {
+		err = ready(result)
+	}
+	if err != nil {
+		return errors.Join(err, closeConnection())
+	}
+
+	<-ctx.Done()
+	if done != nil {
+		done()
+	}
+
+	return closeConnection()
+}
+
+func dynamicValue(attrs map[string]cty.Value, typ tftypes.Type) (*tfprotov6.DynamicValue, error) {
+	value, err := toTerraformValue(attrs, typ)
+	if err != nil {
+		return nil, err
+	}
+
+	dv, err := tfprotov6.NewDynamicValue(typ, value)
+	if err != nil {
+		return nil, err
+	}
+	return &dv, nil
+}
+
+// checkDiagnostics writes warnings to stderr and returns all errors.
+func checkDiagnostics(stderr io.Writer, diags []*tfprotov6.Diagnostic) error {
+	var errs []error
+	for _, d := range diags {
+		if d.Severity == tfprotov6.DiagnosticSeverityError {
+			errs = append(errs, fmt.Errorf("%s: %s", d.Summary, d.Detail))
+			continue
+		}
+		fmt.Fprintf(stderr, "Warning: %s: %s\n", d.Summary, d.Detail)
+	}
+
+	return errors.Join(errs...)
+}
+
+func resultJSON(result *tfprotov6.DynamicValue, typ tftypes.Type) ([]byte, error) {
+	if result == nil {
+		return nil, errors.New("provider returned no result")
+	}
+
+	value, err := result.Unmarshal(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := toGoValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// toGoValue converts value to a value encodable as JSON.
+func toGoValue(value tftypes.Value) (interface{}, error) {
+	if value.IsNull() || !value.IsKnown() {
+		return nil, nil
+	}
+
+	switch value.Type().(type) {
+	case tftypes.List, tftypes.Set, tftypes.Tuple:
+		var elems []tftypes.Value
+		if err := value.As(&elems); err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, 0, len(elems))
+		for _, elem := range elems {
+			v, err := toGoValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case tftypes.Map, tftypes.Object:
+		var attrs map[string]tftypes.Value
+		if err := value.As(&attrs); err != nil {
+			return nil, err
+		}
+		values := make(map[string]interface{}, len(attrs))
+		for name, attr := range attrs {
+			v, err := toGoValue(attr)
+			if err != nil {
+				return nil, err
+			}
+			values[name] = v
+		}
+		return values, nil
+	}
+
+	switch {
+	case value.Type().Is(tftypes.String):
+		var s string
+		err := value.As(&s)
+		return s, err
+	case value.Type().Is(tftypes.Bool):
+		var b bool
+		err := value.As(&b)
+		return b, err
+	case value.Type().Is(tftypes.Number):
+		n := new(big.Float)
+		if err := value.As(&n); err != nil {
+			return nil, err
+		}
+		return json.Number(n.Text('f', -1)), nil
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", value.Type())
+}
+
+// writeFileAtomic writes data to path so readers never observe a partially
+// written file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
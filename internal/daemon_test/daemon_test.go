@@ -0,0 +1,281 @@
+package daemon_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/daemon"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/provider"
+)
+
+func TestParseConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "key"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSHTUNNEL_TEST_USER", "terraform")
+
+	conf, err := daemon.ParseConfig(filepath.Join(dir, "tunnel.hcl"), []byte(`
+connection {
+  host = "bastion"
+  user = env("SSHTUNNEL_TEST_USER")
+  auth = {
+    private_key = file("key")
+  }
+}
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	if got := conf.Connection["user"].AsString(); got != "terraform" {
+		t.Errorf("got user %q, want terraform", got)
+	}
+	if got := conf.Connection["auth"].GetAttr("private_key").AsString(); got != "secret" {
+		t.Errorf("got private_key %q, want secret", got)
+	}
+	if len(conf.Provider) != 0 {
+		t.Errorf("Expected empty provider config, got %v", conf.Provider)
+	}
+}
+
+func TestParseConfig_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"missing connection":   `provider {}`,
+		"duplicate connection": "connection {}\nconnection {}",
+		"unknown block":        "tunnel {}",
+		"missing file":         `connection { host = file("missing") }`,
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := daemon.ParseConfig(filepath.Join(t.TempDir(), "tunnel.hcl"), []byte(src)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	addr := startTestSSHServer(t)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeTestKey(t, filepath.Join(dir, "id_ed25519"))
+
+	conf, err := daemon.ParseConfig(filepath.Join(dir, "tunnel.hcl"), []byte(fmt.Sprintf(`
+connection {
+  host = %q
+  port = %s
+  user = "test"
+  auth = {
+    private_key = file("id_ed25519")
+  }
+  host_key = {
+    mode = "insecure"
+  }
+  local_port_forwardings = [{
+    remote_host = "db.internal"
+    remote_port = 5432
+  }]
+}
+`, host, port)))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var localPort int
+	err = daemon.Run(ctx, provider.New("test")(), conf, io.Discard, func(result []byte) error {
+		defer cancel()
+
+		var data struct {
+			LocalPortForwardings []struct {
+				LocalPort int `json:"local_port"`
+			} `json:"local_port_forwardings"`
+		}
+		if err := json.Unmarshal(result, &data); err != nil {
+			return err
+		}
+		if len(data.LocalPortForwardings) != 1 {
+			return fmt.Errorf("unexpected result %s", result)
+		}
+		localPort = data.LocalPortForwardings[0].LocalPort
+
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", localPort))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to run daemon: %v", err)
+	}
+	if localPort == 0 {
+		t.Fatal("Expected a local port")
+	}
+
+	if conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", localPort)); err == nil {
+		conn.Close()
+		t.Error("Expected the tunnel to be closed")
+	}
+}
+
+func TestRun_PreBoundListener(t *testing.T) {
+	addr := startTestSSHServer(t)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeTestKey(t, filepath.Join(dir, "id_ed25519"))
+
+	// Bound before the tunnel is opened, like a socket activated listener.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	localPort := listener.Addr().(*net.TCPAddr).Port
+
+	conf, err := daemon.ParseConfig(filepath.Join(dir, "tunnel.hcl"), []byte(fmt.Sprintf(`
+connection {
+  host = %q
+  port = %s
+  user = "test"
+  auth = {
+    private_key = file("id_ed25519")
+  }
+  host_key = {
+    mode = "insecure"
+  }
+  local_port_forwardings = [{
+    local_port  = %d
+    remote_host = "db.internal"
+    remote_port = 5432
+  }]
+}
+`, host, port, localPort)))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	p := provider.NewWithListenerPool("test", provider.NewListenerPool([]net.Listener{listener}))()
+	err = daemon.Run(ctx, p, conf, io.Discard, func(result []byte) error {
+		defer cancel()
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to run daemon: %v", err)
+	}
+
+	// The tunnel closes the pre-bound listener.
+	if _, err := listener.Accept(); err == nil {
+		t.Error("Expected the pre-bound listener to be closed")
+	}
+}
+
+func TestRun_InvalidConfig(t *testing.T) {
+	conf, err := daemon.ParseConfig("tunnel.hcl", []byte(`
+connection {
+  host = "bastion"
+  user = "test"
+  auth = {}
+}
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	err = daemon.Run(context.Background(), provider.New("test")(), conf, io.Discard, func(result []byte) error {
+		t.Error("Expected the tunnel not to be opened")
+		return nil
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "No authentication method configured") {
+		t.Errorf("Expected an auth error, got %v", err)
+	}
+}
+
+func writeTestKey(t *testing.T, path string) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTestSSHServer starts an SSH server accepting any client and rejecting
+// all channels.
+func startTestSSHServer(t *testing.T) string {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				defer sshConn.Close()
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
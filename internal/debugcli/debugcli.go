@@ -0,0 +1,192 @@
+// Package debugcli implements the hidden debugging subcommands of the
+// provider binary, which reproduce the behavior of the provider outside of
+// Terraform using the same authentication and transport code paths:
+//
+//	terraform-provider-sshtunnel probe -config tunnel.hcl
+//	terraform-provider-sshtunnel relay -config tunnel.hcl [host:port]
+//	terraform-provider-sshtunnel keyscan [-config tunnel.hcl] [host[:port]]
+//
+// The configuration file is the same as for the daemon subcommand.
+package debugcli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tfsdklog"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/daemon"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/provider"
+)
+
+// Commands are the names of the debugging subcommands.
+var Commands = []string{"probe", "relay", "keyscan"}
+
+// Main runs the debugging subcommand command with args, excluding the
+// command name.
+func Main(ctx context.Context, version, command string, args []string) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if os.Getenv("TF_LOG") != "" {
+		ctx = tfsdklog.NewRootProviderLogger(ctx, tfsdklog.WithLogName("sshtunnel"), tfsdklog.WithLevelFromEnv("TF_LOG"))
+	}
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	configPath := flags.String("config", "", "path of the configuration file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var debugger *provider.Debugger
+	if *configPath != "" {
+		conf, err := daemon.LoadConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		debugger, err = provider.NewDebugger(ctx, version, conf.ProviderValue, conf.ConnectionValue)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch command {
+	case "probe":
+		if debugger == nil {
+			return errors.New("-config is required")
+		}
+		return Probe(ctx, debugger, os.Stdout)
+	case "relay":
+		if debugger == nil {
+			return errors.New("-config is required")
+		}
+		return Relay(ctx, debugger, flags.Arg(0), os.Stdin, os.Stdout)
+	case "keyscan":
+		addr := flags.Arg(0)
+		if addr == "" {
+			if debugger == nil {
+				return errors.New("-config or an address is required")
+			}
+			var err error
+			if addr, err = debugger.Addr(ctx); err != nil {
+				return err
+			}
+		} else if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "22")
+		}
+		return Keyscan(ctx, addr, os.Stdout)
+	}
+
+	return fmt.Errorf("unknown command %q", command)
+}
+
+// Probe connects and authenticates, reporting the duration of each phase,
+// and checks that the remote address of every local port forwarding can be
+// dialed through the connection.
+func Probe(ctx context.Context, d *provider.Debugger, w io.Writer) error {
+	addr, err := d.Addr(ctx)
+	if err != nil {
+		return err
+	}
+
+	conn, timings, err := d.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(w, "connected to %s as %s (dns %s, connect %s, handshake %s, auth %s)\n",
+		addr, d.User(), timings.DNS, timings.Connect, timings.Handshake, timings.Auth)
+	fmt.Fprintf(w, "server version %s\n", conn.ServerVersion())
+
+	var errs []error
+	for _, remoteAddr := range d.RemoteAddrs() {
+		start := time.Now()
+		dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		remoteConn, err := conn.DialContext(dialCtx, "tcp", remoteAddr)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(w, "forwarding to %s failed: %s\n", remoteAddr, err)
+			errs = append(errs, fmt.Errorf("%s: %w", remoteAddr, err))
+			continue
+		}
+		remoteConn.Close()
+		fmt.Fprintf(w, "forwarding to %s ok (%s)\n", remoteAddr, time.Since(start))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Relay connects and relays r and w to remoteAddr through the connection,
+// like `ssh -W`. An empty remoteAddr uses the first local port forwarding.
+func Relay(ctx context.Context, d *provider.Debugger, remoteAddr string, r io.Reader, w io.Writer) error {
+	if remoteAddr == "" {
+		addrs := d.RemoteAddrs()
+		if len(addrs) == 0 {
+			return errors.New("no address given and no local port forwarding configured")
+		}
+		remoteAddr = addrs[0]
+	}
+
+	conn, _, err := d.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	remoteConn, err := conn.DialContext(ctx, "tcp", remoteAddr)
+	if err != nil {
+		return err
+	}
+	defer remoteConn.Close()
+
+	go func() {
+		_, _ = io.Copy(remoteConn, r)
+		if cw, ok := remoteConn.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(w, remoteConn)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// Keyscan writes the host keys of the SSH server at addr in known_hosts
+// format, like ssh-keyscan.
+func Keyscan(ctx context.Context, addr string, w io.Writer) error {
+	var errs []error
+	found := false
+	for _, algorithm := range provider.KeyscanAlgorithms {
+		key, err := provider.ScanHostKey(ctx, addr, algorithm)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", algorithm, err))
+			continue
+		}
+		found = true
+		fmt.Fprintln(w, knownhosts.Line([]string{knownhosts.Normalize(addr)}, key))
+	}
+
+	if !found {
+		return errors.Join(errs...)
+	}
+	return nil
+}
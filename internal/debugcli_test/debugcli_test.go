@@ -0,0 +1,147 @@
+package debugcli_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/daemon"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/debugcli"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/provider"
+)
+
+func TestKeyscan(t *testing.T) {
+	addr, hostKey := startTestSSHServer(t)
+
+	var out bytes.Buffer
+	if err := debugcli.Keyscan(context.Background(), addr, &out); err != nil {
+		t.Fatalf("Failed to scan host keys: %v", err)
+	}
+
+	want := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(hostKey)))
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("Expected %q in output, got %q", want, out.String())
+	}
+}
+
+func TestProbe(t *testing.T) {
+	addr, _ := startTestSSHServer(t)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeTestKey(t, filepath.Join(dir, "id_ed25519"))
+
+	conf, err := daemon.ParseConfig(filepath.Join(dir, "tunnel.hcl"), []byte(fmt.Sprintf(`
+connection {
+  host = %q
+  port = %s
+  user = "test"
+  auth = {
+    private_key = file("id_ed25519")
+  }
+  host_key = {
+    mode = "insecure"
+  }
+  local_port_forwardings = [{
+    remote_host = "db.internal"
+    remote_port = 5432
+  }]
+}
+`, host, port)))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	ctx := context.Background()
+	debugger, err := provider.NewDebugger(ctx, "test", conf.ProviderValue, conf.ConnectionValue)
+	if err != nil {
+		t.Fatalf("Failed to configure: %v", err)
+	}
+
+	// The test server rejects all channels, so the forwarding fails.
+	var out bytes.Buffer
+	if err := debugcli.Probe(ctx, debugger, &out); err == nil {
+		t.Error("Expected the forwarding to fail")
+	}
+
+	for _, want := range []string{"connected to " + addr + " as test", "forwarding to db.internal:5432 failed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in output, got %q", want, out.String())
+		}
+	}
+}
+
+func writeTestKey(t *testing.T, path string) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTestSSHServer starts an SSH server accepting any client and rejecting
+// all channels.
+func startTestSSHServer(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				defer sshConn.Close()
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String(), signer.PublicKey()
+}
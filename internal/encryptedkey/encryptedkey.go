@@ -0,0 +1,143 @@
+// Package encryptedkey decrypts age or SOPS encrypted private keys in memory.
+package encryptedkey
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// Decrypt decrypts an age encrypted (binary or armored) or SOPS encrypted
+// blob using the given age identities. When identities is empty, they are
+// read from SOPS_AGE_KEY, SOPS_AGE_KEY_FILE or the default SOPS key file.
+func Decrypt(blob []byte, identities string) ([]byte, error) {
+	ids, err := parseIdentities(identities)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(blob)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte(armor.Header)):
+		return decryptAge(armor.NewReader(bytes.NewReader(trimmed)), ids)
+	case bytes.HasPrefix(trimmed, []byte("age-encryption.org/")):
+		return decryptAge(bytes.NewReader(blob), ids)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return decryptSOPS(trimmed, ids)
+	default:
+		return nil, errors.New("unsupported format, expected an age encrypted file or a SOPS encrypted JSON document")
+	}
+}
+
+func decryptAge(r io.Reader, ids []age.Identity) ([]byte, error) {
+	plaintext, err := age.Decrypt(r, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("age decryption failed: %w", err)
+	}
+
+	return io.ReadAll(plaintext)
+}
+
+func parseIdentities(identities string) ([]age.Identity, error) {
+	source := "age identities"
+	if identities == "" {
+		var err error
+		identities, source, err = defaultIdentities()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ids, err := age.ParseIdentities(strings.NewReader(identities))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+	}
+
+	return ids, nil
+}
+
+// defaultIdentities looks up identities the same way SOPS does.
+func defaultIdentities() (string, string, error) {
+	if key := os.Getenv("SOPS_AGE_KEY"); key != "" {
+		return key, "SOPS_AGE_KEY", nil
+	}
+
+	path := os.Getenv("SOPS_AGE_KEY_FILE")
+	if path == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", "", errors.New("no age identities configured")
+		}
+		path = filepath.Join(configDir, "sops", "age", "keys.txt")
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("no age identities configured and unable to read %s: %w", path, err)
+	}
+
+	return string(b), path, nil
+}
+
+type sopsDocument struct {
+	Data *string `json:"data"`
+	SOPS *struct {
+		Age []struct {
+			Recipient string `json:"recipient"`
+			Enc       string `json:"enc"`
+		} `json:"age"`
+		LastModified string `json:"lastmodified"`
+		MAC          string `json:"mac"`
+	} `json:"sops"`
+}
+
+func decryptSOPS(blob []byte, ids []age.Identity) ([]byte, error) {
+	var doc sopsDocument
+	if err := json.Unmarshal(blob, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse SOPS document: %w", err)
+	}
+
+	if doc.SOPS == nil {
+		return nil, errors.New("document has no SOPS metadata")
+	}
+	if doc.Data == nil {
+		return nil, errors.New("only SOPS encrypted binary files are supported, encrypt the key using `sops encrypt --input-type binary`")
+	}
+	if len(doc.SOPS.Age) == 0 {
+		return nil, errors.New("SOPS document has no age recipients")
+	}
+
+	var dataKey []byte
+	var errs []error
+	for _, recipient := range doc.SOPS.Age {
+		key, err := decryptAge(armor.NewReader(strings.NewReader(recipient.Enc)), ids)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", recipient.Recipient, err))
+			continue
+		}
+		dataKey = key
+		break
+	}
+	if dataKey == nil {
+		return nil, fmt.Errorf("unable to decrypt SOPS data key: %w", errors.Join(errs...))
+	}
+
+	plaintext, err := decryptSOPSValue(*doc.Data, dataKey, "data:")
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt SOPS data: %w", err)
+	}
+
+	if err := verifySOPSMAC(doc.SOPS.MAC, doc.SOPS.LastModified, dataKey, plaintext); err != nil {
+		return nil, err
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,72 @@
+package encryptedkey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sopsNonceSize is the non-standard GCM nonce size used by SOPS.
+const sopsNonceSize = 32
+
+// decryptSOPSValue decrypts a single SOPS value of the form
+// ENC[AES256_GCM,data:...,iv:...,tag:...,type:...].
+func decryptSOPSValue(value string, key []byte, additionalData string) ([]byte, error) {
+	if !strings.HasPrefix(value, "ENC[AES256_GCM,") || !strings.HasSuffix(value, "]") {
+		return nil, errors.New("value is not SOPS encrypted")
+	}
+
+	parts := map[string]string{}
+	for _, part := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(value, "ENC[AES256_GCM,"), "]"), ",") {
+		k, v, _ := strings.Cut(part, ":")
+		parts[k] = v
+	}
+
+	var decoded [3][]byte
+	for i, name := range []string{"data", "iv", "tag"} {
+		b, err := base64.StdEncoding.DecodeString(parts[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		decoded[i] = b
+	}
+	data, iv, tag := decoded[0], decoded[1], decoded[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCMWithNonceSize(block, sopsNonceSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(iv) != sopsNonceSize {
+		return nil, fmt.Errorf("invalid iv length %d", len(iv))
+	}
+
+	return gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+}
+
+// verifySOPSMAC checks the document MAC, which SOPS computes as SHA-512 over
+// all plaintext values and encrypts using the last modified timestamp as
+// additional data.
+func verifySOPSMAC(mac string, lastModified string, key []byte, plaintext []byte) error {
+	expected, err := decryptSOPSValue(mac, key, lastModified)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt SOPS MAC: %w", err)
+	}
+
+	hash := sha512.Sum512(plaintext)
+	if !strings.EqualFold(string(expected), hex.EncodeToString(hash[:])) {
+		return errors.New("SOPS MAC mismatch, the document has been tampered with")
+	}
+
+	return nil
+}
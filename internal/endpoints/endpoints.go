@@ -0,0 +1,183 @@
+// Package endpoints publishes the local addresses of named forwardings of
+// open connections, so they can be resolved by other processes, e.g. the
+// provider function `endpoint` evaluated by a provider instance that didn't
+// open the connection, or Terraform runs using a tunnel kept open by the
+// daemon.
+//
+// Each connection is published as a JSON file named after its id, holding
+// the pid of the publishing process. Files of processes that are no longer
+// alive are ignored and replaced.
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/pidfile"
+)
+
+// DirEnv is the environment variable overriding the default directory.
+const DirEnv = "SSHTUNNEL_ENDPOINTS_DIR"
+
+// ErrPublished is returned by Publish when a live process already publishes
+// a connection with the same id.
+var ErrPublished = errors.New("connection id is already published")
+
+// ErrNotFound is returned by Lookup when no live process publishes the
+// connection or the connection has no endpoint of the name.
+var ErrNotFound = errors.New("endpoint not found")
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+type record struct {
+	PID       int               `json:"pid"`
+	Endpoints map[string]string `json:"endpoints"`
+}
+
+// Dir returns the directory connections are published in, DirEnv if set,
+// otherwise a directory in the user cache directory.
+func Dir() string {
+	if dir := os.Getenv(DirEnv); dir != "" {
+		return dir
+	}
+
+	base, err := os.UserCacheDir()
+	if err != nil {
+		base = os.TempDir()
+	}
+	return filepath.Join(base, "terraform-provider-sshtunnel", "endpoints")
+}
+
+// ValidateID returns an error if id can't be used as a connection id. Ids
+// name files, so only letters, digits, `.`, `_` and `-` are allowed.
+func ValidateID(id string) error {
+	if !validID.MatchString(id) || id == "." || id == ".." {
+		return fmt.Errorf("invalid connection id %q, only letters, digits, '.', '_' and '-' are allowed", id)
+	}
+	return nil
+}
+
+// Publish publishes the endpoints of the connection id in dir, mapping names
+// to addresses. It fails with ErrPublished if another live process, or the
+// current process, already publishes id.
+func Publish(dir, id string, endpoints map[string]string) error {
+	if err := ValidateID(id); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record{PID: os.Getpid(), Endpoints: endpoints})
+	if err != nil {
+		return err
+	}
+
+	// The record is linked into place, so it is never observed partially
+	// written and an existing record is never replaced.
+	f, err := os.CreateTemp(dir, id+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	path := recordPath(dir, id)
+	for attempt := 0; attempt < 2; attempt++ {
+		err := os.Link(f.Name(), path)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		existing, err := read(path)
+		if err == nil && pidfile.Alive(existing.PID) {
+			return fmt.Errorf("%s: pid %d: %w", id, existing.PID, ErrPublished)
+		}
+
+		// The record is stale or unreadable, e.g. from a crashed run.
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("%s: unable to publish connection", id)
+}
+
+// Withdraw removes the connection id from dir if it is published by the
+// current process.
+func Withdraw(dir, id string) error {
+	path := recordPath(dir, id)
+	r, err := read(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if r.PID != os.Getpid() {
+		return nil
+	}
+
+	return os.Remove(path)
+}
+
+// Lookup returns the address of the endpoint name of the connection id
+// published in dir. It fails with ErrNotFound if the connection isn't open or
+// has no such endpoint.
+func Lookup(dir, id, name string) (string, error) {
+	if err := ValidateID(id); err != nil {
+		return "", err
+	}
+
+	r, err := read(recordPath(dir, id))
+	if errors.Is(err, os.ErrNotExist) || (err == nil && !pidfile.Alive(r.PID)) {
+		return "", fmt.Errorf("connection %q is not open: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	addr, ok := r.Endpoints[name]
+	if !ok {
+		names := make([]string, 0, len(r.Endpoints))
+		for n := range r.Endpoints {
+			names = append(names, fmt.Sprintf("%q", n))
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("connection %q has no endpoint %q, available are [%s]: %w", id, name, strings.Join(names, ", "), ErrNotFound)
+	}
+
+	return addr, nil
+}
+
+func recordPath(dir, id string) string {
+	return filepath.Join(dir, id+".json")
+}
+
+func read(path string) (record, error) {
+	var r record
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(b, &r); err != nil || r.PID <= 0 {
+		return r, fmt.Errorf("%s: invalid endpoints file", path)
+	}
+	return r, nil
+}
@@ -0,0 +1,81 @@
+package endpoints_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/endpoints"
+)
+
+func TestPublish(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "endpoints")
+
+	if _, err := endpoints.Lookup(dir, "db", "primary"); !errors.Is(err, endpoints.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound before publishing, got %v", err)
+	}
+
+	if err := endpoints.Publish(dir, "db", map[string]string{"primary": "127.0.0.1:15432"}); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if err := endpoints.Publish(dir, "db", map[string]string{}); !errors.Is(err, endpoints.ErrPublished) {
+		t.Errorf("Expected ErrPublished, got %v", err)
+	}
+
+	addr, err := endpoints.Lookup(dir, "db", "primary")
+	if err != nil {
+		t.Fatalf("Failed to look up endpoint: %v", err)
+	}
+	if addr != "127.0.0.1:15432" {
+		t.Errorf("got %q, want 127.0.0.1:15432", addr)
+	}
+	if _, err := endpoints.Lookup(dir, "db", "replica"); !errors.Is(err, endpoints.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown name, got %v", err)
+	}
+
+	if err := endpoints.Withdraw(dir, "db"); err != nil {
+		t.Fatalf("Failed to withdraw: %v", err)
+	}
+	if _, err := endpoints.Lookup(dir, "db", "primary"); !errors.Is(err, endpoints.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after withdrawing, got %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no files left behind, got %d", len(entries))
+	}
+}
+
+func TestPublishStale(t *testing.T) {
+	dir := t.TempDir()
+	stale := []byte(`{"pid":999999999,"endpoints":{"primary":"127.0.0.1:1"}}`)
+	if err := os.WriteFile(filepath.Join(dir, "db.json"), stale, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := endpoints.Lookup(dir, "db", "primary"); !errors.Is(err, endpoints.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a stale record, got %v", err)
+	}
+	if err := endpoints.Publish(dir, "db", map[string]string{"primary": "127.0.0.1:2"}); err != nil {
+		t.Fatalf("Failed to replace stale record: %v", err)
+	}
+	if addr, err := endpoints.Lookup(dir, "db", "primary"); err != nil || addr != "127.0.0.1:2" {
+		t.Errorf("got %q, %v, want 127.0.0.1:2", addr, err)
+	}
+}
+
+func TestValidateID(t *testing.T) {
+	for _, id := range []string{"db", "prod.db-1_a"} {
+		if err := endpoints.ValidateID(id); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", id, err)
+		}
+	}
+	for _, id := range []string{"", ".", "..", "../db", "a/b", "a b"} {
+		if err := endpoints.ValidateID(id); err == nil {
+			t.Errorf("Expected %q to be invalid", id)
+		}
+	}
+}
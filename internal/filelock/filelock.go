@@ -0,0 +1,89 @@
+// Package filelock coordinates exclusive ownership of named resources, such
+// as local ports, between processes on the same machine using lock files.
+package filelock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const pollInterval = 100 * time.Millisecond
+
+// Dir is a directory holding lock files.
+type Dir struct {
+	path string
+}
+
+// New returns the lock directory at path, creating it if needed.
+func New(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	return &Dir{path: path}, nil
+}
+
+// Path returns the path of the lock directory.
+func (d *Dir) Path() string {
+	return d.path
+}
+
+// Lock is a held lock.
+type Lock struct {
+	f *os.File
+}
+
+// Lock acquires the lock with the given name, waiting until it is released
+// by other processes or ctx is done. The owner is written into the lock file
+// to help identifying who holds a lock.
+func (d *Dir) Lock(ctx context.Context, name, owner string) (*Lock, error) {
+	path := filepath.Join(d.path, name+".lock")
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if locked {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			holder, _ := os.ReadFile(path)
+			if h := strings.TrimSpace(string(holder)); h != "" {
+				return nil, fmt.Errorf("%s is locked by %s: %w", name, h, ctx.Err())
+			}
+			return nil, fmt.Errorf("%s is locked: %w", name, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(fmt.Sprintf("%s (pid %d)\n", owner, os.Getpid())), 0)
+	}
+
+	return &Lock{f: f}, nil
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	_ = l.f.Truncate(0)
+	if err := unlock(l.f); err != nil {
+		l.f.Close()
+		return err
+	}
+
+	return l.f.Close()
+}
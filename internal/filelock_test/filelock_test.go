@@ -0,0 +1,48 @@
+package filelock_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/filelock"
+)
+
+func TestLock(t *testing.T) {
+	dir, err := filelock.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create lock directory: %v", err)
+	}
+
+	lock, err := dir.Lock(context.Background(), "port-15432", "workspace a")
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = dir.Lock(ctx, "port-15432", "workspace b")
+	if err == nil {
+		t.Fatal("Expected the lock to be held")
+	}
+	if !strings.Contains(err.Error(), "workspace a") {
+		t.Errorf("Expected the error to name the holder, got %v", err)
+	}
+
+	if _, err := dir.Lock(context.Background(), "port-15433", "workspace b"); err != nil {
+		t.Errorf("Failed to acquire independent lock: %v", err)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	lock, err = dir.Lock(ctx, "port-15432", "workspace b")
+	if err != nil {
+		t.Fatalf("Failed to acquire released lock: %v", err)
+	}
+	lock.Unlock()
+}
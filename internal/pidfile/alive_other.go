@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package pidfile
+
+// Alive reports whether a process with the given pid exists. Liveness can't
+// be checked on this platform, so existing pid files are always honoured.
+func Alive(pid int) bool {
+	return true
+}
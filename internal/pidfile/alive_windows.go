@@ -0,0 +1,26 @@
+//go:build windows
+
+package pidfile
+
+import (
+	"syscall"
+)
+
+const processQueryLimitedInformation = 0x1000
+
+// Alive reports whether a process with the given pid exists.
+func Alive(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+
+	// STILL_ACTIVE
+	return code == 259
+}
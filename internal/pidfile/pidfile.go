@@ -0,0 +1,96 @@
+// Package pidfile manages pid files of long running processes and replaces
+// files left behind by processes that are no longer alive.
+package pidfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrRunning is returned by Acquire when the pid file belongs to a process
+// that is still alive.
+var ErrRunning = errors.New("process is still running")
+
+// PIDFile is a pid file owned by the current process.
+type PIDFile struct {
+	path string
+}
+
+// Acquire creates the pid file at path containing the pid of the current
+// process. An existing pid file is replaced if the process it names is no
+// longer alive, otherwise an error wrapping ErrRunning is returned.
+func Acquire(path string) (*PIDFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return &PIDFile{path: path}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		pid, err := Read(path)
+		if err == nil && Alive(pid) {
+			return nil, fmt.Errorf("%s: pid %d: %w", path, pid, ErrRunning)
+		}
+
+		// The file is stale or unreadable, e.g. from a crashed run.
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("%s: unable to acquire pid file", path)
+}
+
+// Path returns the path of the pid file.
+func (p *PIDFile) Path() string {
+	return p.path
+}
+
+// Release removes the pid file if it still belongs to the current process.
+func (p *PIDFile) Release() error {
+	pid, err := Read(p.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if pid != os.Getpid() {
+		return nil
+	}
+
+	return os.Remove(p.path)
+}
+
+// Read returns the pid stored in the pid file at path.
+func Read(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("%s: invalid pid file", path)
+	}
+
+	return pid, nil
+}
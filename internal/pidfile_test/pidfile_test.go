@@ -0,0 +1,56 @@
+package pidfile_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/pidfile"
+)
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "tunnel.pid")
+
+	p, err := pidfile.Acquire(path)
+	if err != nil {
+		t.Fatalf("Failed to acquire pid file: %v", err)
+	}
+
+	pid, err := pidfile.Read(path)
+	if err != nil {
+		t.Fatalf("Failed to read pid file: %v", err)
+	}
+	if pid != os.Getpid() {
+		t.Errorf("got pid %d, want %d", pid, os.Getpid())
+	}
+
+	if _, err := pidfile.Acquire(path); !errors.Is(err, pidfile.ErrRunning) {
+		t.Errorf("Expected ErrRunning, got %v", err)
+	}
+
+	if err := p.Release(); err != nil {
+		t.Fatalf("Failed to release pid file: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected pid file to be removed, got %v", err)
+	}
+}
+
+func TestAcquireStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tunnel.pid")
+
+	for _, content := range []string{"garbage\n", "999999999\n"} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write pid file: %v", err)
+		}
+
+		p, err := pidfile.Acquire(path)
+		if err != nil {
+			t.Fatalf("Failed to replace stale pid file %q: %v", content, err)
+		}
+		if err := p.Release(); err != nil {
+			t.Fatalf("Failed to release pid file: %v", err)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+//go:build cgo
+
+package pkcs11key
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+	"golang.org/x/crypto/ssh"
+)
+
+// Supported reports whether this build supports PKCS#11 tokens.
+const Supported = true
+
+var (
+	mu sync.Mutex
+	// modules holds the initialized modules, a module may only be
+	// initialized once per process.
+	modules = map[string]*pkcs11.Ctx{}
+	// signers holds the signers of each token, their sessions are kept open
+	// for the lifetime of the process and shared between connections.
+	signers = map[string][]ssh.Signer{}
+)
+
+// Signers returns signers for the RSA and ECDSA private keys of the token
+// with the given label, logging in with pin if it is not empty. Without a
+// label exactly one token has to be present.
+func Signers(module, tokenLabel, pin string) ([]ssh.Signer, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := strings.Join([]string{module, tokenLabel, pin}, "\x00")
+	if s, ok := signers[key]; ok {
+		return s, nil
+	}
+
+	ctx, err := loadModule(module)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := openToken(ctx, tokenLabel, pin)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := t.signers()
+	if err != nil {
+		t.close()
+		return nil, err
+	}
+
+	signers[key] = s
+	return s, nil
+}
+
+func loadModule(module string) (*pkcs11.Ctx, error) {
+	if ctx, ok := modules[module]; ok {
+		return ctx, nil
+	}
+
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		return nil, fmt.Errorf("unable to load PKCS#11 module %s", module)
+	}
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		ctx.Destroy()
+		return nil, fmt.Errorf("unable to initialize PKCS#11 module %s: %w", module, err)
+	}
+
+	modules[module] = ctx
+	return ctx, nil
+}
+
+// token is a logged in session with a token. PKCS#11 sessions must not be
+// used concurrently, so all operations hold mu.
+type token struct {
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+}
+
+func openToken(ctx *pkcs11.Ctx, label, pin string) (*token, error) {
+	slot, err := findSlot(ctx, label)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open a session with the token: %w", err)
+	}
+	t := &token{ctx: ctx, session: session}
+
+	if pin != "" {
+		if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+			t.close()
+			return nil, fmt.Errorf("unable to log in to the token: %w", err)
+		}
+	}
+
+	return t, nil
+}
+
+func findSlot(ctx *pkcs11.Ctx, label string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("unable to list the tokens: %w", err)
+	}
+
+	matches := []uint{}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if label == "" || strings.TrimSpace(info.Label) == label {
+			matches = append(matches, slot)
+		}
+	}
+
+	switch {
+	case len(matches) == 0 && label == "":
+		return 0, errors.New("no token present")
+	case len(matches) == 0:
+		return 0, fmt.Errorf("no token labeled %q present", label)
+	case len(matches) > 1 && label == "":
+		return 0, fmt.Errorf("%d tokens present, set the label of the token to use", len(matches))
+	}
+	return matches[0], nil
+}
+
+func (t *token) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_ = t.ctx.CloseSession(t.session)
+}
+
+func (t *token) signers() ([]ssh.Signer, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys, err := t.findObjects([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the private keys of the token: %w", err)
+	}
+
+	result := []ssh.Signer{}
+	for _, key := range keys {
+		public, err := t.publicKey(key)
+		if err != nil {
+			// Skip keys of unsupported types or without public key.
+			continue
+		}
+		signer, err := ssh.NewSignerFromSigner(&keySigner{token: t, key: key, public: public})
+		if err != nil {
+			continue
+		}
+		result = append(result, signer)
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("the token holds no RSA or ECDSA private keys")
+	}
+	return result, nil
+}
+
+func (t *token) findObjects(template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error) {
+	if err := t.ctx.FindObjectsInit(t.session, template); err != nil {
+		return nil, err
+	}
+	defer func() { _ = t.ctx.FindObjectsFinal(t.session) }()
+
+	result := []pkcs11.ObjectHandle{}
+	for {
+		objects, _, err := t.ctx.FindObjects(t.session, 16)
+		if err != nil {
+			return nil, err
+		}
+		if len(objects) == 0 {
+			return result, nil
+		}
+		result = append(result, objects...)
+	}
+}
+
+// publicKey returns the public key of a private key, read from the public
+// key object with the same CKA_ID or, for RSA, from the private key object.
+func (t *token) publicKey(key pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attrs, err := t.ctx.GetAttributeValue(t.session, key, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	keyType, id := attrs[0].Value, attrs[1].Value
+
+	source := key
+	publics, err := t.findObjects([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	})
+	if err == nil && len(publics) > 0 {
+		source = publics[0]
+	}
+
+	switch {
+	case bytes.Equal(keyType, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA).Value):
+		attrs, err := t.ctx.GetAttributeValue(t.session, source, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}, nil
+	case bytes.Equal(keyType, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC).Value):
+		attrs, err := t.ctx.GetAttributeValue(t.session, source, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return parseECPublicKey(attrs[0].Value, attrs[1].Value)
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+}
+
+var curves = []struct {
+	oid   asn1.ObjectIdentifier
+	curve elliptic.Curve
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}, elliptic.P256()},
+	{asn1.ObjectIdentifier{1, 3, 132, 0, 34}, elliptic.P384()},
+	{asn1.ObjectIdentifier{1, 3, 132, 0, 35}, elliptic.P521()},
+}
+
+func parseECPublicKey(params, point []byte) (*ecdsa.PublicKey, error) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil, fmt.Errorf("invalid EC parameters: %w", err)
+	}
+
+	// CKA_EC_POINT is a DER encoded OCTET STRING, some modules omit the
+	// encoding.
+	var raw []byte
+	if _, err := asn1.Unmarshal(point, &raw); err != nil {
+		raw = point
+	}
+
+	for _, c := range curves {
+		if !c.oid.Equal(oid) {
+			continue
+		}
+		x, y := elliptic.Unmarshal(c.curve, raw) //nolint:staticcheck // crypto/ecdh does not expose the coordinates
+		if x == nil {
+			return nil, errors.New("invalid EC point")
+		}
+		return &ecdsa.PublicKey{Curve: c.curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported curve %s", oid)
+}
+
+// digestInfoPrefixes are the DER encoded DigestInfo prefixes CKM_RSA_PKCS
+// expects in front of the digest.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// keySigner is a crypto.Signer signing with a private key of a token.
+type keySigner struct {
+	token  *token
+	key    pkcs11.ObjectHandle
+	public crypto.PublicKey
+}
+
+func (s *keySigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *keySigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	switch s.public.(type) {
+	case *rsa.PublicKey:
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash %s", opts.HashFunc())
+		}
+		data := make([]byte, 0, len(prefix)+len(digest))
+		data = append(append(data, prefix...), digest...)
+		return s.sign(pkcs11.CKM_RSA_PKCS, data)
+	case *ecdsa.PublicKey:
+		sig, err := s.sign(pkcs11.CKM_ECDSA, digest)
+		if err != nil {
+			return nil, err
+		}
+		// CKM_ECDSA returns r and s concatenated, ssh expects ASN.1.
+		n := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(sig[:n]),
+			S: new(big.Int).SetBytes(sig[n:]),
+		})
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+}
+
+func (s *keySigner) sign(mechanism uint, data []byte) ([]byte, error) {
+	s.token.mu.Lock()
+	defer s.token.mu.Unlock()
+
+	if err := s.token.ctx.SignInit(s.token.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, s.key); err != nil {
+		return nil, fmt.Errorf("unable to sign with the token: %w", err)
+	}
+	sig, err := s.token.ctx.Sign(s.token.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign with the token: %w", err)
+	}
+	return sig, nil
+}
@@ -0,0 +1,19 @@
+//go:build !cgo
+
+package pkcs11key
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Supported reports whether this build supports PKCS#11 tokens.
+const Supported = false
+
+// Signers returns signers for the RSA and ECDSA private keys of the token
+// with the given label, logging in with pin if it is not empty.
+func Signers(module, tokenLabel, pin string) ([]ssh.Signer, error) {
+	return nil, errors.New("this build of the provider does not support PKCS#11, it was built without cgo. " +
+		"Load the token into the SSH agent instead, e.g. with ssh-add -s " + module)
+}
@@ -0,0 +1,6 @@
+// Package pkcs11key signs with private keys held by a PKCS#11 token, e.g. a
+// smartcard or an HSM, without the keys ever leaving the token.
+//
+// PKCS#11 modules are shared libraries, so signing requires a build with cgo.
+// Builds without cgo, like the release binaries, return an error instead.
+package pkcs11key
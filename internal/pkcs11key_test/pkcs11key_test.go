@@ -0,0 +1,44 @@
+package pkcs11key_test
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/pkcs11key"
+)
+
+func TestSignersMissingModule(t *testing.T) {
+	module := filepath.Join(t.TempDir(), "missing.so")
+
+	_, err := pkcs11key.Signers(module, "", "")
+	if err == nil {
+		t.Fatal("Expected an error for a missing module")
+	}
+}
+
+// TestSigners signs with the keys of a real token, e.g. initialized with
+// softhsm2-util and populated with pkcs11-tool.
+func TestSigners(t *testing.T) {
+	module := os.Getenv("PKCS11_TEST_MODULE")
+	if module == "" {
+		t.Skip("PKCS11_TEST_MODULE not set")
+	}
+
+	signers, err := pkcs11key.Signers(module, os.Getenv("PKCS11_TEST_TOKEN_LABEL"), os.Getenv("PKCS11_TEST_PIN"))
+	if err != nil {
+		t.Fatalf("Failed to list the signers: %v", err)
+	}
+
+	data := []byte("test data")
+	for _, signer := range signers {
+		sig, err := signer.Sign(rand.Reader, data)
+		if err != nil {
+			t.Fatalf("Failed to sign with %s key: %v", signer.PublicKey().Type(), err)
+		}
+		if err := signer.PublicKey().Verify(data, sig); err != nil {
+			t.Errorf("Failed to verify the %s signature: %v", signer.PublicKey().Type(), err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/sshconfig"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentAuthProvider authenticates using the keys of the local SSH agent
+// reachable via auth.agent_socket, SSH_AUTH_SOCK or, on Windows, the OpenSSH
+// agent service.
+type agentAuthProvider struct{}
+
+func (p *agentAuthProvider) Name() string {
+	return "agent"
+}
+
+func (p *agentAuthProvider) Configured(auth ConnectionEphemeralResourceModelAuth) bool {
+	return auth.Agent.IsUnknown() || auth.Agent.ValueBool()
+}
+
+func (p *agentAuthProvider) ValidateConfig(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) diag.Diagnostics {
+	diags := diag.Diagnostics{}
+
+	if !auth.SecurityKeyTouchTimeout.IsNull() && !auth.SecurityKeyTouchTimeout.IsUnknown() {
+		if timeout, err := time.ParseDuration(auth.SecurityKeyTouchTimeout.ValueString()); err != nil {
+			diags.AddError("Auth Error", fmt.Sprintf("Invalid security_key_touch_timeout: %s", err))
+		} else if timeout <= 0 {
+			diags.AddError("Auth Error", "security_key_touch_timeout must be positive")
+		}
+	}
+
+	for name, value := range map[string]types.String{"agent_socket": auth.AgentSocket, "agent_identity": auth.AgentIdentity} {
+		if !value.IsNull() && !value.IsUnknown() && value.ValueString() == "" {
+			diags.AddError("Auth Error", fmt.Sprintf("%s must not be empty", name))
+		}
+	}
+
+	return diags
+}
+
+func (p *agentAuthProvider) AuthMethods(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.AuthMethod, diag.Diagnostics) {
+	return signerAuthMethods(p.Signers(ctx, auth))
+}
+
+func (p *agentAuthProvider) Signers(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.Signer, diag.Diagnostics) {
+	diags := diag.Diagnostics{}
+
+	socket := sshconfig.ExpandPath(auth.AgentSocket.ValueString())
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socket == "" {
+		socket = defaultAgentSocket
+	}
+	if socket == "" {
+		diags.AddError("SSH Agent Error", "SSH_AUTH_SOCK is not set, unable to connect to the SSH agent")
+		return nil, diags
+	}
+
+	touchTimeout := defaultSecurityKeyTouchTimeout
+	if !auth.SecurityKeyTouchTimeout.IsNull() {
+		touchTimeout, _ = time.ParseDuration(auth.SecurityKeyTouchTimeout.ValueString())
+	}
+
+	signers, err := agentSigners(socket, auth.AgentIdentity.ValueString(), touchTimeout)
+	if err != nil {
+		diags.AddError("SSH Agent Error", fmt.Sprintf("Unable to list the keys of the SSH agent at %s, got error: %s", socket, err))
+		return nil, diags
+	}
+	if len(signers) == 0 {
+		diags.AddError("SSH Agent Error", fmt.Sprintf("The SSH agent at %s holds no keys", socket))
+		return nil, diags
+	}
+	tflog.Debug(ctx, "Using SSH agent keys", map[string]interface{}{"keys": len(signers)})
+	for _, signer := range signers {
+		if isSecurityKey(signer.PublicKey()) {
+			tflog.Info(ctx, "Touch the security key when it blinks to authenticate", map[string]interface{}{
+				"key":     ssh.FingerprintSHA256(signer.PublicKey()),
+				"timeout": touchTimeout.String(),
+			})
+		}
+	}
+
+	return signers, diags
+}
+
+// defaultSecurityKeyTouchTimeout is the time to wait for a security key to be
+// touched, ssh-agent itself waits indefinitely.
+const defaultSecurityKeyTouchTimeout = 30 * time.Second
+
+// isSecurityKey reports whether key is a FIDO2 security key (sk-*) key, the
+// agent waits for the key to be touched before signing with it.
+func isSecurityKey(key ssh.PublicKey) bool {
+	return strings.HasPrefix(key.Type(), "sk-")
+}
+
+// agentSigners returns signers for all keys held by the agent at socket or,
+// if identity isn't empty, the key with the comment or SHA256 fingerprint
+// identity. The agent is dialed again for each signature, so no connection
+// to the agent is kept open after authentication. Signing with a security
+// key fails once it wasn't touched within touchTimeout.
+func agentSigners(socket, identity string, touchTimeout time.Duration) ([]ssh.Signer, error) {
+	keys, err := withAgent(socket, 0, func(a agent.ExtendedAgent) ([]*agent.Key, error) {
+		return a.List()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if identity != "" {
+		if keys, err = selectAgentKey(keys, identity); err != nil {
+			return nil, err
+		}
+	}
+
+	signers := make([]ssh.Signer, 0, len(keys))
+	for _, key := range keys {
+		signer := &agentSigner{socket: socket, key: key}
+		if isSecurityKey(key) {
+			signer.timeout = touchTimeout
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+// selectAgentKey returns the key of keys with the comment or SHA256
+// fingerprint identity.
+func selectAgentKey(keys []*agent.Key, identity string) ([]*agent.Key, error) {
+	available := make([]string, 0, len(keys))
+	for _, key := range keys {
+		fingerprint := ssh.FingerprintSHA256(key)
+		if key.Comment == identity || fingerprint == identity {
+			return []*agent.Key{key}, nil
+		}
+		if key.Comment != "" {
+			available = append(available, fmt.Sprintf("%s (%s)", key.Comment, fingerprint))
+		} else {
+			available = append(available, fingerprint)
+		}
+	}
+	return nil, fmt.Errorf("no key with the comment or fingerprint %q, the agent holds [%s]", identity, strings.Join(available, ", "))
+}
+
+// errAgentTimeout is returned by withAgent if f didn't return in time.
+var errAgentTimeout = errors.New("timed out waiting for the SSH agent")
+
+// withAgent calls f with a client of the agent at socket. With a timeout, the
+// connection is closed once it expires, as the agent protocol has no
+// cancellation.
+func withAgent[T any](socket string, timeout time.Duration, f func(a agent.ExtendedAgent) (T, error)) (T, error) {
+	var zero T
+
+	conn, err := dialAgent(socket)
+	if err != nil {
+		return zero, err
+	}
+	defer conn.Close()
+
+	var timedOut atomic.Bool
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			timedOut.Store(true)
+			conn.Close()
+		})
+		defer timer.Stop()
+	}
+
+	result, err := f(agent.NewClient(conn))
+	if err != nil && timedOut.Load() {
+		return zero, errAgentTimeout
+	}
+	return result, err
+}
+
+// agentSigner signs using a key held by the SSH agent.
+type agentSigner struct {
+	socket  string
+	key     ssh.PublicKey
+	timeout time.Duration
+}
+
+func (s *agentSigner) PublicKey() ssh.PublicKey {
+	return s.key
+}
+
+func (s *agentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+func (s *agentSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case "", s.key.Type():
+	case ssh.KeyAlgoRSASHA256:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512:
+		flags = agent.SignatureFlagRsaSha512
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %s for key type %s", algorithm, s.key.Type())
+	}
+
+	signature, err := withAgent(s.socket, s.timeout, func(a agent.ExtendedAgent) (*ssh.Signature, error) {
+		return a.SignWithFlags(s.key, data, flags)
+	})
+	if errors.Is(err, errAgentTimeout) {
+		return nil, fmt.Errorf("the security key %s was not touched within %s, tap the key when it blinks while the tunnel is opened "+
+			"or increase auth.security_key_touch_timeout", ssh.FingerprintSHA256(s.key), s.timeout)
+	}
+	return signature, err
+}
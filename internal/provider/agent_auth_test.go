@@ -0,0 +1,232 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgentAuthProvider(t *testing.T) {
+	ctx := context.Background()
+	p := &agentAuthProvider{}
+	auth := ConnectionEphemeralResourceModelAuth{Agent: types.BoolValue(true)}
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, diags := p.AuthMethods(ctx, auth); defaultAgentSocket == "" && !diags.HasError() {
+		t.Error("Expected an error without SSH_AUTH_SOCK")
+	}
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: privateKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	if _, diags := p.AuthMethods(ctx, auth); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+
+	signers, err := agentSigners(socket, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 1 {
+		t.Fatalf("Expected 1 signer, got %d", len(signers))
+	}
+
+	data := []byte("session")
+	signature, err := signers[0].Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signers[0].PublicKey().Verify(data, signature); err != nil {
+		t.Errorf("Invalid signature: %s", err)
+	}
+
+	if !p.Configured(ConnectionEphemeralResourceModelAuth{Agent: types.BoolUnknown()}) {
+		t.Error("Expected an unknown agent to be configured")
+	}
+	if p.Configured(ConnectionEphemeralResourceModelAuth{Agent: types.BoolValue(false)}) {
+		t.Error("Expected a disabled agent not to be configured")
+	}
+}
+
+// untouchedSecurityKeyAgent holds a security key, which is never touched.
+type untouchedSecurityKeyAgent struct {
+	agent.ExtendedAgent
+	key     ssh.PublicKey
+	release chan struct{}
+}
+
+func (a *untouchedSecurityKeyAgent) List() ([]*agent.Key, error) {
+	return []*agent.Key{{Format: a.key.Type(), Blob: a.key.Marshal()}}, nil
+}
+
+func (a *untouchedSecurityKeyAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	<-a.release
+	return nil, errors.New("not touched")
+}
+
+func testSecurityKey(t *testing.T) ssh.PublicKey {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.ParsePublicKey(ssh.Marshal(struct {
+		Type        string
+		Key         []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, publicKey, "ssh:"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestAgentSignersSecurityKeyTouchTimeout(t *testing.T) {
+	a := &untouchedSecurityKeyAgent{ExtendedAgent: agent.NewKeyring().(agent.ExtendedAgent), key: testSecurityKey(t), release: make(chan struct{})}
+	defer close(a.release)
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(a, conn)
+			}()
+		}
+	}()
+
+	signers, err := agentSigners(socket, "", 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 1 || !isSecurityKey(signers[0].PublicKey()) {
+		t.Fatalf("Expected a security key signer, got %v", signers)
+	}
+
+	_, err = signers[0].Sign(rand.Reader, []byte("session"))
+	if err == nil || !strings.Contains(err.Error(), "tap the key") {
+		t.Errorf("Expected a touch timeout error, got %v", err)
+	}
+}
+
+func TestAgentAuthProviderValidateSecurityKeyTouchTimeout(t *testing.T) {
+	p := &agentAuthProvider{}
+
+	for value, valid := range map[string]bool{"10s": true, "0s": false, "soon": false} {
+		auth := ConnectionEphemeralResourceModelAuth{Agent: types.BoolValue(true), SecurityKeyTouchTimeout: types.StringValue(value)}
+		if diags := p.ValidateConfig(context.Background(), auth); diags.HasError() == valid {
+			t.Errorf("Expected %q valid=%t, got %v", value, valid, diags)
+		}
+	}
+}
+
+func TestAgentAuthProviderSocketAndIdentity(t *testing.T) {
+	keyring := agent.NewKeyring()
+	var keys []ssh.PublicKey
+	for _, comment := range []string{"deploy", ""} {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: privateKey, Comment: comment}); err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, signer.PublicKey())
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	// The socket takes precedence over SSH_AUTH_SOCK.
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "missing.sock"))
+
+	ctx := context.Background()
+	p := &agentAuthProvider{}
+	for identity, want := range map[string]ssh.PublicKey{"deploy": keys[0], ssh.FingerprintSHA256(keys[1]): keys[1]} {
+		auth := ConnectionEphemeralResourceModelAuth{
+			Agent:         types.BoolValue(true),
+			AgentSocket:   types.StringValue(socket),
+			AgentIdentity: types.StringValue(identity),
+		}
+		signers, diags := p.Signers(ctx, auth)
+		if diags.HasError() {
+			t.Fatalf("Unexpected error: %v", diags)
+		}
+		if len(signers) != 1 || !bytes.Equal(signers[0].PublicKey().Marshal(), want.Marshal()) {
+			t.Errorf("Expected only the key %s for identity %q, got %v", ssh.FingerprintSHA256(want), identity, signers)
+		}
+	}
+
+	auth := ConnectionEphemeralResourceModelAuth{
+		Agent:         types.BoolValue(true),
+		AgentSocket:   types.StringValue(socket),
+		AgentIdentity: types.StringValue("missing"),
+	}
+	if _, diags := p.Signers(ctx, auth); !diags.HasError() || !strings.Contains(diags.Errors()[0].Detail(), "deploy (SHA256:") {
+		t.Errorf("Expected an error listing the keys of the agent, got %v", diags)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package provider
+
+import (
+	"io"
+	"net"
+)
+
+// defaultAgentSocket is empty, agents are only found via SSH_AUTH_SOCK.
+const defaultAgentSocket = ""
+
+// dialAgent connects to the SSH agent listening on the Unix socket.
+func dialAgent(socket string) (io.ReadWriteCloser, error) {
+	return net.Dial("unix", socket)
+}
@@ -0,0 +1,21 @@
+package provider
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// defaultAgentSocket is the named pipe of the Windows OpenSSH agent service,
+// used if SSH_AUTH_SOCK isn't set.
+const defaultAgentSocket = `\\.\pipe\openssh-ssh-agent`
+
+// dialAgent connects to the SSH agent at socket, either a named pipe or a
+// Unix socket, e.g. of an agent forwarded into WSL.
+func dialAgent(socket string) (io.ReadWriteCloser, error) {
+	if strings.HasPrefix(socket, `\\.\pipe\`) {
+		return os.OpenFile(socket, os.O_RDWR, 0)
+	}
+	return net.Dial("unix", socket)
+}
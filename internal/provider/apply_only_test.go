@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestOpenApplyOnly(t *testing.T) {
+	ctx := context.Background()
+	r := &ConnectionEphemeralResource{}
+	configureResp := ephemeral.ConfigureResponse{}
+	r.Configure(ctx, ephemeral.ConfigureRequest{ProviderData: &ProviderConfigData{Tracker: NewTunnelTracker(), SkipOpen: true}}, &configureResp)
+	if configureResp.Diagnostics.HasError() {
+		t.Fatalf("Unexpected error: %v", configureResp.Diagnostics)
+	}
+	schemaResp := ephemeral.SchemaResponse{}
+	r.Schema(ctx, ephemeral.SchemaRequest{}, &schemaResp)
+
+	typ := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	forwardingsType := typ.AttributeTypes["local_port_forwardings"].(tftypes.List)
+	forwardingType := forwardingsType.ElementType.(tftypes.Object)
+	dynamicsType := typ.AttributeTypes["dynamic_port_forwardings"].(tftypes.List)
+
+	// Nothing listens on the port, connecting would fail.
+	config := tfsdk.Config{Schema: schemaResp.Schema, Raw: nullObject(typ, map[string]tftypes.Value{
+		"host": tftypes.NewValue(tftypes.String, "127.0.0.1"),
+		"port": tftypes.NewValue(tftypes.Number, 1),
+		"user": tftypes.NewValue(tftypes.String, "deploy"),
+		"auth": nullObject(typ.AttributeTypes["auth"].(tftypes.Object), map[string]tftypes.Value{
+			"password": tftypes.NewValue(tftypes.String, "secret"),
+		}),
+		"local_port_forwardings": tftypes.NewValue(forwardingsType, []tftypes.Value{
+			nullObject(forwardingType, map[string]tftypes.Value{
+				"local_port":  tftypes.NewValue(tftypes.Number, 5432),
+				"remote_host": tftypes.NewValue(tftypes.String, "db.internal"),
+				"remote_port": tftypes.NewValue(tftypes.Number, 5432),
+			}),
+			nullObject(forwardingType, map[string]tftypes.Value{
+				"remote_host": tftypes.NewValue(tftypes.String, "cache.internal"),
+				"remote_port": tftypes.NewValue(tftypes.Number, 6379),
+			}),
+			nullObject(forwardingType, map[string]tftypes.Value{
+				"local_port_seed": tftypes.NewValue(tftypes.String, "api"),
+				"remote_host":     tftypes.NewValue(tftypes.String, "api.internal"),
+				"remote_port":     tftypes.NewValue(tftypes.Number, 443),
+			}),
+		}),
+		"dynamic_port_forwardings": tftypes.NewValue(dynamicsType, []tftypes.Value{
+			nullObject(dynamicsType.ElementType.(tftypes.Object), nil),
+		}),
+	})}
+
+	t.Run("deferral", func(t *testing.T) {
+		resp := ephemeral.OpenResponse{Result: tfsdk.EphemeralResultData{Schema: schemaResp.Schema}}
+		r.Open(ctx, ephemeral.OpenRequest{Config: config, ClientCapabilities: ephemeral.OpenClientCapabilities{DeferralAllowed: true}}, &resp)
+		if resp.Diagnostics.HasError() {
+			t.Fatalf("Unexpected error: %v", resp.Diagnostics)
+		}
+
+		if resp.Deferred == nil || resp.Deferred.Reason != ephemeral.DeferredReasonAbsentPrereq {
+			t.Errorf("Expected the open to be deferred for an absent prerequisite, got %+v", resp.Deferred)
+		}
+		if tunnels := r.tunnelTracker.List(); len(tunnels) != 0 {
+			t.Errorf("Expected no tunnel to be opened, got %+v", tunnels)
+		}
+	})
+
+	t.Run("placeholders", func(t *testing.T) {
+		resp := ephemeral.OpenResponse{Result: tfsdk.EphemeralResultData{Schema: schemaResp.Schema}}
+		r.Open(ctx, ephemeral.OpenRequest{Config: config}, &resp)
+		if resp.Diagnostics.HasError() {
+			t.Fatalf("Unexpected error: %v", resp.Diagnostics)
+		}
+		if resp.Deferred != nil {
+			t.Errorf("Expected no deferral without client support, got %+v", resp.Deferred)
+		}
+		if tunnels := r.tunnelTracker.List(); len(tunnels) != 0 {
+			t.Errorf("Expected no tunnel to be opened, got %+v", tunnels)
+		}
+
+		var data ConnectionEphemeralResourceModel
+		if diags := resp.Result.Get(ctx, &data); diags.HasError() {
+			t.Fatalf("Unexpected error: %v", diags)
+		}
+		for i, want := range []int32{5432, 0, seededPort("api", 0)} {
+			if got := data.LocalPortForwardings[i].LocalPort.ValueInt32(); got != want {
+				t.Errorf("local_port_forwardings[%d]: got local_port %d, want %d", i, got, want)
+			}
+		}
+		if got := data.DynamicPortForwardings[0]; got.LocalPort.ValueInt32() != 0 || !got.ProxyURL.IsNull() {
+			t.Errorf("Expected a placeholder dynamic port forwarding, got local_port %s and proxy_url %s", got.LocalPort, got.ProxyURL)
+		}
+	})
+}
@@ -0,0 +1,560 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/encryptedkey"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/secretref"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/sshconfig"
+	"golang.org/x/crypto/ssh"
+)
+
+// AuthProvider builds SSH authentication methods from the auth block of a
+// connection. Providers are registered on the provider and consulted in
+// order, unless the auth block orders them with methods, so new
+// authentication methods can be added without touching the ephemeral
+// resource.
+type AuthProvider interface {
+	// Name identifies the provider in the methods of the auth block.
+	Name() string
+
+	// Configured reports whether the auth block contains settings handled by
+	// this provider.
+	Configured(auth ConnectionEphemeralResourceModelAuth) bool
+
+	// ValidateConfig validates the settings handled by this provider. It is
+	// only called when the provider is configured, values may be unknown.
+	ValidateConfig(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) diag.Diagnostics
+
+	// AuthMethods returns the authentication methods to offer to the server.
+	AuthMethods(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.AuthMethod, diag.Diagnostics)
+}
+
+// SignerAuthProvider is an AuthProvider authenticating with public keys. The
+// SSH client tries every method only once, so the signers of all configured
+// SignerAuthProviders are offered in a single publickey method, at the
+// position of the first of them.
+type SignerAuthProvider interface {
+	AuthProvider
+
+	// Signers returns the signers to offer to the server.
+	Signers(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.Signer, diag.Diagnostics)
+}
+
+func defaultAuthProviders() []AuthProvider {
+	return []AuthProvider{
+		&privateKeyAuthProvider{},
+		&agentAuthProvider{},
+		&vaultSSHAuthProvider{},
+		&gcpOSLoginAuthProvider{},
+		&pkcs11AuthProvider{},
+		&passwordAuthProvider{},
+		&keyboardInteractiveAuthProvider{},
+	}
+}
+
+// validateAuthConfig validates the auth block against the given providers,
+// at least one of them has to be configured.
+func validateAuthConfig(ctx context.Context, providers []AuthProvider, auth ConnectionEphemeralResourceModelAuth) diag.Diagnostics {
+	diags := diag.Diagnostics{}
+
+	configured := false
+	for _, p := range providers {
+		if !p.Configured(auth) {
+			continue
+		}
+		configured = true
+		diags.Append(p.ValidateConfig(ctx, auth)...)
+	}
+
+	if !configured {
+		diags.AddError("Auth Error", "No authentication method configured")
+	}
+
+	if knownStrings(auth.Methods) {
+		if _, err := orderAuthProviders(providers, auth); err != nil {
+			diags.AddError("Auth Error", err.Error())
+		}
+	}
+
+	return diags
+}
+
+func knownStrings(values []types.String) bool {
+	for _, v := range values {
+		if v.IsUnknown() {
+			return false
+		}
+	}
+	return true
+}
+
+// orderAuthProviders returns the configured providers in the order of the
+// methods of the auth block, by default in the order of providers.
+func orderAuthProviders(providers []AuthProvider, auth ConnectionEphemeralResourceModelAuth) ([]AuthProvider, error) {
+	if auth.Methods == nil {
+		configured := []AuthProvider{}
+		for _, p := range providers {
+			if p.Configured(auth) {
+				configured = append(configured, p)
+			}
+		}
+		return configured, nil
+	}
+
+	names := make([]string, 0, len(providers))
+	byName := map[string]AuthProvider{}
+	for _, p := range providers {
+		names = append(names, p.Name())
+		byName[p.Name()] = p
+	}
+
+	ordered := []AuthProvider{}
+	listed := map[string]bool{}
+	for _, method := range auth.Methods {
+		name := method.ValueString()
+		p, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown auth method %q in methods, expected one of %s", name, strings.Join(names, ", "))
+		}
+		if listed[name] {
+			return nil, fmt.Errorf("auth method %q is listed in methods more than once", name)
+		}
+		if !p.Configured(auth) {
+			return nil, fmt.Errorf("auth method %q is listed in methods, but not configured", name)
+		}
+		listed[name] = true
+		ordered = append(ordered, p)
+	}
+
+	for _, p := range providers {
+		if p.Configured(auth) && !listed[p.Name()] {
+			return nil, fmt.Errorf("auth method %q is configured, but not listed in methods", p.Name())
+		}
+	}
+
+	return ordered, nil
+}
+
+// authMethods collects the authentication methods of all configured providers
+// in order.
+func authMethods(ctx context.Context, providers []AuthProvider, auth ConnectionEphemeralResourceModelAuth) ([]ssh.AuthMethod, diag.Diagnostics) {
+	diags := diag.Diagnostics{}
+	methods := []ssh.AuthMethod{}
+
+	ordered, err := orderAuthProviders(providers, auth)
+	if err != nil {
+		diags.AddError("Auth Error", err.Error())
+		return nil, diags
+	}
+
+	signers := []ssh.Signer{}
+	publicKeys := -1
+	for _, p := range ordered {
+		if sp, ok := p.(SignerAuthProvider); ok {
+			s, d := sp.Signers(ctx, auth)
+			diags.Append(d...)
+			if d.HasError() {
+				return nil, diags
+			}
+			if publicKeys < 0 {
+				publicKeys = len(methods)
+				methods = append(methods, nil)
+			}
+			signers = append(signers, s...)
+			continue
+		}
+
+		m, d := p.AuthMethods(ctx, auth)
+		diags.Append(d...)
+		if d.HasError() {
+			return nil, diags
+		}
+		methods = append(methods, m...)
+	}
+	if publicKeys >= 0 {
+		methods[publicKeys] = ssh.PublicKeys(signers...)
+	}
+
+	if len(methods) == 0 {
+		diags.AddError("Auth Error", "No authentication method configured")
+	}
+
+	return methods, diags
+}
+
+// signerAuthMethods offers signers in a publickey method.
+func signerAuthMethods(signers []ssh.Signer, diags diag.Diagnostics) ([]ssh.AuthMethod, diag.Diagnostics) {
+	if diags.HasError() {
+		return nil, diags
+	}
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, diags
+}
+
+// privateKeyAuthProvider authenticates using a private key, either given
+// inline, read from a file, fetched from a secret store or decrypted with
+// age.
+type privateKeyAuthProvider struct{}
+
+func (p *privateKeyAuthProvider) Name() string {
+	return "private_key"
+}
+
+func (p *privateKeyAuthProvider) keySources(auth ConnectionEphemeralResourceModelAuth) []types.String {
+	return []types.String{auth.PrivateKey, auth.PrivateKeyPath, auth.PrivateKeyRef, auth.EncryptedPrivateKey}
+}
+
+func (p *privateKeyAuthProvider) Configured(auth ConnectionEphemeralResourceModelAuth) bool {
+	for _, v := range p.keySources(auth) {
+		if !v.IsNull() {
+			return true
+		}
+	}
+	return auth.PrivateKeys != nil || auth.Vault != nil || auth.AWS != nil || auth.GCPSecret != nil || auth.AzureKeyVault != nil || !auth.AgeIdentity.IsNull() || !auth.Passphrase.IsNull() || !auth.Certificate.IsNull()
+}
+
+func (p *privateKeyAuthProvider) ValidateConfig(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) diag.Diagnostics {
+	diags := diag.Diagnostics{}
+
+	keySources := 0
+	for _, v := range p.keySources(auth) {
+		if !v.IsNull() {
+			keySources++
+		}
+	}
+	if auth.PrivateKeys != nil {
+		keySources++
+		if len(auth.PrivateKeys) == 0 {
+			diags.AddError("Auth Error", "private_keys must not be empty")
+		}
+	}
+	if auth.Vault != nil {
+		keySources++
+		diags.Append(validateVaultKey(auth.Vault)...)
+	}
+	if auth.AWS != nil {
+		keySources++
+		diags.Append(validateAWSKey(auth.AWS)...)
+	}
+	if auth.GCPSecret != nil {
+		keySources++
+		diags.Append(validateGCPKey(auth.GCPSecret)...)
+	}
+	if auth.AzureKeyVault != nil {
+		keySources++
+		diags.Append(validateAzureKey(auth.AzureKeyVault)...)
+	}
+	if keySources != 1 {
+		diags.AddError("Auth Error", "Exactly one of private_key, private_keys, private_key_path, private_key_ref, vault, aws, gcp_secret, azure_key_vault or encrypted_private_key must be set")
+	}
+
+	if !auth.AgeIdentity.IsNull() && auth.EncryptedPrivateKey.IsNull() {
+		diags.AddError("Auth Error", "age_identity can only be used together with encrypted_private_key")
+	}
+
+	if !auth.PrivateKeyRef.IsNull() && !auth.PrivateKeyRef.IsUnknown() {
+		if _, err := secretref.Parse(auth.PrivateKeyRef.ValueString()); err != nil {
+			diags.AddError("Auth Error", fmt.Sprintf("Invalid private key reference: %s", err))
+		}
+	}
+
+	if !auth.Certificate.IsNull() && !auth.Certificate.IsUnknown() {
+		if _, err := parseCertificate(auth.Certificate.ValueString()); err != nil {
+			diags.AddError("Auth Error", fmt.Sprintf("Invalid certificate: %s", err))
+		}
+	}
+
+	return diags
+}
+
+func (p *privateKeyAuthProvider) AuthMethods(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.AuthMethod, diag.Diagnostics) {
+	return signerAuthMethods(p.Signers(ctx, auth))
+}
+
+// privateKeyRef returns the secret reference of private_key_ref, or of the
+// auth.vault and auth.aws blocks, which are references with explicit access
+// configuration, and the options to resolve it with.
+func privateKeyRef(auth ConnectionEphemeralResourceModelAuth) (*secretref.Ref, secretref.Options, error) {
+	switch {
+	case auth.Vault != nil:
+		return vaultKeyRef(auth.Vault)
+	case auth.AWS != nil:
+		ref, opts := awsKeyRef(auth.AWS)
+		return ref, opts, nil
+	default:
+		ref, err := secretref.Parse(auth.PrivateKeyRef.ValueString())
+		return ref, secretref.Options{}, err
+	}
+}
+
+func (p *privateKeyAuthProvider) Signers(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.Signer, diag.Diagnostics) {
+	diags := diag.Diagnostics{}
+
+	privateKeys := [][]byte{[]byte(auth.PrivateKey.ValueString())}
+	name := "private key"
+	switch {
+	case auth.PrivateKeys != nil:
+		privateKeys = [][]byte{}
+		for _, privateKey := range auth.PrivateKeys {
+			privateKeys = append(privateKeys, []byte(privateKey.ValueString()))
+		}
+	case !auth.PrivateKeyPath.IsNull():
+		path := sshconfig.ExpandPath(auth.PrivateKeyPath.ValueString())
+		privateKey, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			diags.AddError("Private Key Error", fmt.Sprintf("The private key file %s does not exist", path))
+			return nil, diags
+		case errors.Is(err, fs.ErrPermission):
+			diags.AddError("Private Key Error", fmt.Sprintf("Permission denied reading the private key file %s, it must be readable by the user running Terraform", path))
+			return nil, diags
+		case err != nil:
+			diags.AddError("Private Key Error", fmt.Sprintf("Unable to read the private key file %s, got error: %s", path, err))
+			return nil, diags
+		}
+		privateKeys = [][]byte{privateKey}
+		name = "private key " + path
+	case !auth.PrivateKeyRef.IsNull() || auth.Vault != nil || auth.AWS != nil:
+		ref, opts, err := privateKeyRef(auth)
+		if err != nil {
+			diags.AddError("Private Key Error", fmt.Sprintf("Invalid private key reference: %s", err))
+			return nil, diags
+		}
+		privateKey, err := secretref.ResolveRef(ctx, ref, opts)
+		if err != nil {
+			diags.AddError("Private Key Error", fmt.Sprintf("Unable to fetch private key, got error: %s", err))
+			return nil, diags
+		}
+		privateKeys = [][]byte{privateKey}
+		name = "private key " + ref.String()
+	case auth.GCPSecret != nil:
+		privateKey, err := readGCPKey(ctx, auth.GCPSecret)
+		if err != nil {
+			diags.AddError("Private Key Error", fmt.Sprintf("Unable to read the private key from GCP Secret Manager, got error: %s", err))
+			return nil, diags
+		}
+		privateKeys = [][]byte{privateKey}
+		name = "private key from GCP Secret Manager"
+	case auth.AzureKeyVault != nil:
+		privateKey, err := readAzureKey(ctx, auth.AzureKeyVault)
+		if err != nil {
+			diags.AddError("Private Key Error", fmt.Sprintf("Unable to read the private key from Azure Key Vault, got error: %s", err))
+			return nil, diags
+		}
+		privateKeys = [][]byte{privateKey}
+		name = "private key from Azure Key Vault"
+	case !auth.EncryptedPrivateKey.IsNull():
+		privateKey, err := encryptedkey.Decrypt([]byte(auth.EncryptedPrivateKey.ValueString()), auth.AgeIdentity.ValueString())
+		if err != nil {
+			diags.AddError("Private Key Error", fmt.Sprintf("Unable to decrypt private key, got error: %s", err))
+			return nil, diags
+		}
+		privateKeys = [][]byte{privateKey}
+	}
+
+	signers := make([]ssh.Signer, 0, len(privateKeys))
+	for i, privateKey := range privateKeys {
+		name := name
+		if auth.PrivateKeys != nil {
+			name = fmt.Sprintf("private key %d of private_keys", i)
+		}
+
+		signer, err := parsePrivateKey(privateKey, auth.Passphrase)
+		var passphraseMissingErr *ssh.PassphraseMissingError
+		switch {
+		case err == nil:
+			signers = append(signers, signer)
+			continue
+		case errors.As(err, &passphraseMissingErr):
+			diags.AddError("Private Key Error", fmt.Sprintf("The %s is protected by a passphrase, set passphrase to decrypt it", name))
+		case errors.Is(err, x509.IncorrectPasswordError):
+			diags.AddError("Private Key Error", fmt.Sprintf("Unable to decrypt the %s, the passphrase is wrong", name))
+		default:
+			diags.AddError("Private Key Error", fmt.Sprintf("Unable to parse the %s, the key is malformed: %s", name, err))
+		}
+		return nil, diags
+	}
+
+	if !auth.Certificate.IsNull() {
+		cert, err := parseCertificate(auth.Certificate.ValueString())
+		if err != nil {
+			diags.AddError("Certificate Error", fmt.Sprintf("Unable to parse certificate, got error: %s", err))
+			return nil, diags
+		}
+		if validBefore := cert.ValidBefore; validBefore != ssh.CertTimeInfinity && time.Now().After(time.Unix(int64(validBefore), 0)) {
+			diags.AddError("Certificate Error", fmt.Sprintf("Certificate expired at %s", time.Unix(int64(validBefore), 0).UTC().Format(time.RFC3339)))
+			return nil, diags
+		}
+
+		// The certificate is used with the first key it matches, with a
+		// single key a mismatch is reported by NewCertSigner.
+		i := 0
+		for j, signer := range signers {
+			if bytes.Equal(signer.PublicKey().Marshal(), cert.Key.Marshal()) {
+				i = j
+				break
+			}
+		}
+		signers[i], err = ssh.NewCertSigner(cert, signers[i])
+		if err != nil {
+			diags.AddError("Certificate Error", fmt.Sprintf("Unable to use certificate with the private key, got error: %s", err))
+			return nil, diags
+		}
+	}
+
+	// The signers are offered in order until the server accepts one.
+	return signers, diags
+}
+
+// parseCertificate parses an OpenSSH certificate in authorized_keys format,
+// e.g. the contents of an id_ed25519-cert.pub file.
+func parseCertificate(certificate string) (*ssh.Certificate, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate))
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s key is not a certificate", key.Type())
+	}
+	return cert, nil
+}
+
+// parsePrivateKey parses privateKey in OpenSSH, PEM or PuTTY format,
+// decrypting it with passphrase if it is protected by one. Unprotected keys
+// are accepted with a passphrase too.
+func parsePrivateKey(privateKey []byte, passphrase types.String) (ssh.Signer, error) {
+	if isPuTTYPrivateKey(privateKey) {
+		return parsePuTTYPrivateKey(privateKey, passphrase)
+	}
+	if keyType := openSSHPrivateKeyType(privateKey); strings.HasPrefix(keyType, "sk-") {
+		return nil, fmt.Errorf("%s is a FIDO2 security key, which can only be used through the SSH agent. Add the key with ssh-add and set auth.agent", keyType)
+	}
+
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	var passphraseMissingErr *ssh.PassphraseMissingError
+	if errors.As(err, &passphraseMissingErr) && !passphrase.IsNull() {
+		return ssh.ParsePrivateKeyWithPassphrase(privateKey, []byte(passphrase.ValueString()))
+	}
+	return signer, err
+}
+
+// openSSHPrivateKeyType returns the type of an OpenSSH private key, read from
+// the public key stored unencrypted alongside it, or "" for other formats.
+func openSSHPrivateKeyType(privateKey []byte) string {
+	block, _ := pem.Decode(privateKey)
+	if block == nil || block.Type != "OPENSSH PRIVATE KEY" {
+		return ""
+	}
+
+	magic := append([]byte("openssh-key-v1"), 0)
+	if !bytes.HasPrefix(block.Bytes, magic) {
+		return ""
+	}
+
+	var envelope struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}
+	if err := ssh.Unmarshal(block.Bytes[len(magic):], &envelope); err != nil {
+		return ""
+	}
+
+	var pub struct {
+		KeyType string
+		Rest    []byte `ssh:"rest"`
+	}
+	if err := ssh.Unmarshal(envelope.PubKey, &pub); err != nil {
+		return ""
+	}
+	return pub.KeyType
+}
+
+// passwordAttempts is the number of times the password is offered, servers
+// may reject the first attempts e.g. while PAM modules are still warming up.
+const passwordAttempts = 3
+
+// passwordAuthProvider authenticates using a password.
+type passwordAuthProvider struct{}
+
+func (p *passwordAuthProvider) Name() string {
+	return "password"
+}
+
+func (p *passwordAuthProvider) Configured(auth ConnectionEphemeralResourceModelAuth) bool {
+	return !auth.Password.IsNull()
+}
+
+func (p *passwordAuthProvider) ValidateConfig(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) diag.Diagnostics {
+	diags := diag.Diagnostics{}
+
+	if !auth.Password.IsUnknown() && auth.Password.ValueString() == "" {
+		diags.AddError("Auth Error", "password must not be empty")
+	}
+
+	return diags
+}
+
+func (p *passwordAuthProvider) AuthMethods(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.AuthMethod, diag.Diagnostics) {
+	return []ssh.AuthMethod{
+		ssh.RetryableAuthMethod(ssh.Password(auth.Password.ValueString()), passwordAttempts),
+	}, nil
+}
+
+// keyboardInteractiveAuthProvider answers the password prompts of
+// keyboard-interactive authentication, e.g. of sshd configured with PAM,
+// with the password.
+type keyboardInteractiveAuthProvider struct{}
+
+func (p *keyboardInteractiveAuthProvider) Name() string {
+	return "keyboard_interactive"
+}
+
+func (p *keyboardInteractiveAuthProvider) Configured(auth ConnectionEphemeralResourceModelAuth) bool {
+	return auth.KeyboardInteractive.IsUnknown() || auth.KeyboardInteractive.ValueBool()
+}
+
+func (p *keyboardInteractiveAuthProvider) ValidateConfig(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) diag.Diagnostics {
+	diags := diag.Diagnostics{}
+
+	if auth.Password.IsNull() {
+		diags.AddError("Auth Error", "keyboard_interactive requires password to answer the password prompts")
+	}
+
+	return diags
+}
+
+func (p *keyboardInteractiveAuthProvider) AuthMethods(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.AuthMethod, diag.Diagnostics) {
+	password := auth.Password.ValueString()
+
+	challenge := func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			// Prompts echoing the answer don't ask for secrets.
+			if echos[i] {
+				return nil, fmt.Errorf("unable to answer keyboard-interactive prompt %q", question)
+			}
+			answers[i] = password
+		}
+		return answers, nil
+	}
+
+	return []ssh.AuthMethod{
+		ssh.RetryableAuthMethod(ssh.KeyboardInteractive(challenge), passwordAttempts),
+	}, nil
+}
@@ -0,0 +1,656 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+)
+
+type stubAuthProvider struct {
+	name       string
+	configured bool
+	methods    []ssh.AuthMethod
+}
+
+func (p *stubAuthProvider) Name() string {
+	return p.name
+}
+
+func (p *stubAuthProvider) Configured(auth ConnectionEphemeralResourceModelAuth) bool {
+	return p.configured
+}
+
+func (p *stubAuthProvider) ValidateConfig(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) diag.Diagnostics {
+	return nil
+}
+
+func (p *stubAuthProvider) AuthMethods(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.AuthMethod, diag.Diagnostics) {
+	return p.methods, nil
+}
+
+func TestAuthMethods(t *testing.T) {
+	ctx := context.Background()
+	password := ssh.Password("secret")
+
+	providers := []AuthProvider{
+		&stubAuthProvider{configured: false, methods: []ssh.AuthMethod{ssh.Password("unused")}},
+		&stubAuthProvider{configured: true, methods: []ssh.AuthMethod{password}},
+	}
+
+	methods, diags := authMethods(ctx, providers, ConnectionEphemeralResourceModelAuth{})
+	if diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	if len(methods) != 1 {
+		t.Fatalf("Expected 1 auth method, got %d", len(methods))
+	}
+
+	if diags := validateAuthConfig(ctx, providers[:1], ConnectionEphemeralResourceModelAuth{}); !diags.HasError() {
+		t.Error("Expected an error when no auth provider is configured")
+	}
+}
+
+func TestPrivateKeyAuthProviderValidateConfig(t *testing.T) {
+	ctx := context.Background()
+	p := &privateKeyAuthProvider{}
+
+	auth := ConnectionEphemeralResourceModelAuth{
+		PrivateKey:          types.StringValue("key"),
+		PrivateKeyRef:       types.StringNull(),
+		EncryptedPrivateKey: types.StringValue("encrypted"),
+		AgeIdentity:         types.StringNull(),
+	}
+	if diags := p.ValidateConfig(ctx, auth); !diags.HasError() {
+		t.Error("Expected an error when multiple key sources are set")
+	}
+
+	auth.EncryptedPrivateKey = types.StringNull()
+	if diags := p.ValidateConfig(ctx, auth); diags.HasError() {
+		t.Errorf("Unexpected error: %v", diags)
+	}
+}
+
+func TestPasswordAuthProvider(t *testing.T) {
+	ctx := context.Background()
+	p := &passwordAuthProvider{}
+
+	if p.Configured(ConnectionEphemeralResourceModelAuth{Password: types.StringNull()}) {
+		t.Error("Expected no password not to be configured")
+	}
+
+	auth := ConnectionEphemeralResourceModelAuth{Password: types.StringValue("")}
+	if diags := p.ValidateConfig(ctx, auth); !diags.HasError() {
+		t.Error("Expected an error for an empty password")
+	}
+
+	auth.Password = types.StringValue("secret")
+	if diags := p.ValidateConfig(ctx, auth); diags.HasError() {
+		t.Errorf("Unexpected error: %v", diags)
+	}
+	methods, diags := authMethods(ctx, defaultAuthProviders(), auth)
+	if diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	if len(methods) != 1 {
+		t.Fatalf("Expected 1 auth method, got %d", len(methods))
+	}
+}
+
+func TestPrivateKeyAuthProviderPassphrase(t *testing.T) {
+	ctx := context.Background()
+	p := &privateKeyAuthProvider{}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := string(pem.EncodeToMemory(block))
+
+	tests := []struct {
+		name       string
+		privateKey string
+		passphrase types.String
+		wantError  string
+	}{
+		{"passphrase", encrypted, types.StringValue("secret"), ""},
+		{"missing passphrase", encrypted, types.StringNull(), "protected by a passphrase"},
+		{"wrong passphrase", encrypted, types.StringValue("wrong"), "passphrase is wrong"},
+		{"malformed key", "not a key", types.StringValue("secret"), "key is malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := ConnectionEphemeralResourceModelAuth{
+				PrivateKey: types.StringValue(tt.privateKey),
+				Passphrase: tt.passphrase,
+			}
+			_, diags := p.AuthMethods(ctx, auth)
+			if tt.wantError == "" {
+				if diags.HasError() {
+					t.Errorf("Unexpected error: %v", diags)
+				}
+				return
+			}
+			if !diags.HasError() || !strings.Contains(diags[0].Detail(), tt.wantError) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantError, diags)
+			}
+		})
+	}
+}
+
+func TestPrivateKeyAuthProviderPath(t *testing.T) {
+	ctx := context.Background()
+	p := &privateKeyAuthProvider{}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, "id_ed25519"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, "id_unreadable"), pem.EncodeToMemory(block), 0o000); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		wantError string
+	}{
+		{"absolute", filepath.Join(home, "id_ed25519"), ""},
+		{"home", "~/id_ed25519", ""},
+		{"missing", "~/id_missing", "does not exist"},
+		{"unreadable", "~/id_unreadable", "Permission denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "unreadable" && (runtime.GOOS == "windows" || os.Geteuid() == 0) {
+				t.Skip("File permissions are not enforced")
+			}
+
+			auth := ConnectionEphemeralResourceModelAuth{PrivateKeyPath: types.StringValue(tt.path)}
+			if diags := p.ValidateConfig(ctx, auth); diags.HasError() {
+				t.Fatalf("Unexpected error: %v", diags)
+			}
+			signers, diags := p.Signers(ctx, auth)
+			if tt.wantError == "" {
+				if diags.HasError() || len(signers) != 1 {
+					t.Errorf("Expected 1 signer, got %d: %v", len(signers), diags)
+				}
+				return
+			}
+			if !diags.HasError() || !strings.Contains(diags[0].Detail(), tt.wantError) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantError, diags)
+			}
+		})
+	}
+}
+
+func TestPrivateKeyAuthProviderVault(t *testing.T) {
+	ctx := context.Background()
+	p := &privateKeyAuthProvider{}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := json.Marshal(map[string]interface{}{
+		"data": map[string]interface{}{"data": map[string]string{"private_key": string(pem.EncodeToMemory(block))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" || r.URL.Path != "/v1/secret/data/ssh/bastion" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write(secret)
+	}))
+	defer server.Close()
+
+	auth := ConnectionEphemeralResourceModelAuth{Vault: &ConnectionEphemeralResourceModelAuthVault{
+		Address: types.StringValue(server.URL),
+		Path:    types.StringValue("ssh/bastion"),
+		Token:   types.StringValue("test-token"),
+	}}
+	if diags := p.ValidateConfig(ctx, auth); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	signers, diags := p.Signers(ctx, auth)
+	if diags.HasError() || len(signers) != 1 {
+		t.Fatalf("Expected 1 signer, got %d: %v", len(signers), diags)
+	}
+
+	auth.Vault.Token = types.StringValue("wrong-token")
+	if _, diags := p.Signers(ctx, auth); !diags.HasError() {
+		t.Error("Expected an error with a wrong token")
+	}
+
+	auth.Vault.AppRole = &ConnectionEphemeralResourceModelAuthVaultAppRole{RoleID: types.StringValue("role"), SecretID: types.StringValue("secret")}
+	auth.Vault.KVVersion = types.Int32Value(3)
+	if diags := p.ValidateConfig(ctx, auth); len(diags.Errors()) != 2 {
+		t.Errorf("Expected errors for the KV version and both token and approle, got %v", diags)
+	}
+}
+
+func TestPrivateKeyAuthProviderAWSValidation(t *testing.T) {
+	ctx := context.Background()
+	p := &privateKeyAuthProvider{}
+
+	tests := []struct {
+		name      string
+		auth      ConnectionEphemeralResourceModelAuth
+		wantError string
+	}{
+		{"secret", ConnectionEphemeralResourceModelAuth{AWS: &ConnectionEphemeralResourceModelAuthAWS{SecretID: types.StringValue("ssh/bastion")}}, ""},
+		{"parameter", ConnectionEphemeralResourceModelAuth{AWS: &ConnectionEphemeralResourceModelAuthAWS{ParameterName: types.StringValue("/ssh/bastion")}}, ""},
+		{"neither", ConnectionEphemeralResourceModelAuth{AWS: &ConnectionEphemeralResourceModelAuthAWS{}}, "Exactly one of auth.aws.secret_id or auth.aws.parameter_name"},
+		{"both", ConnectionEphemeralResourceModelAuth{AWS: &ConnectionEphemeralResourceModelAuthAWS{SecretID: types.StringValue("ssh/bastion"), ParameterName: types.StringValue("/ssh/bastion")}}, "Exactly one of auth.aws.secret_id or auth.aws.parameter_name"},
+		{"with private key", ConnectionEphemeralResourceModelAuth{PrivateKey: types.StringValue("key"), AWS: &ConnectionEphemeralResourceModelAuthAWS{SecretID: types.StringValue("ssh/bastion")}}, "Exactly one of private_key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := p.ValidateConfig(ctx, tt.auth)
+			if tt.wantError == "" {
+				if diags.HasError() {
+					t.Errorf("Unexpected error: %v", diags)
+				}
+				return
+			}
+			if !diags.HasError() || !strings.Contains(diags[0].Detail(), tt.wantError) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantError, diags)
+			}
+		})
+	}
+}
+
+func TestPrivateKeyAuthProviderGCPSecretValidation(t *testing.T) {
+	ctx := context.Background()
+	p := &privateKeyAuthProvider{}
+
+	tests := []struct {
+		name      string
+		secret    ConnectionEphemeralResourceModelAuthGCPSecret
+		wantError string
+	}{
+		{"secret id", ConnectionEphemeralResourceModelAuthGCPSecret{Secret: types.StringValue("bastion"), Project: types.StringValue("ops"), Version: types.StringValue("3")}, ""},
+		{"resource name", ConnectionEphemeralResourceModelAuthGCPSecret{Secret: types.StringValue("projects/ops/secrets/bastion/versions/3")}, ""},
+		{"unknown", ConnectionEphemeralResourceModelAuthGCPSecret{Secret: types.StringUnknown(), Project: types.StringValue("ops")}, ""},
+		{"empty", ConnectionEphemeralResourceModelAuthGCPSecret{Secret: types.StringValue("")}, "must not be empty"},
+		{"project with resource name", ConnectionEphemeralResourceModelAuthGCPSecret{Secret: types.StringValue("projects/ops/secrets/bastion"), Project: types.StringValue("ops")}, "auth.gcp_secret.project"},
+		{"version twice", ConnectionEphemeralResourceModelAuthGCPSecret{Secret: types.StringValue("projects/ops/secrets/bastion/versions/3"), Version: types.StringValue("3")}, "auth.gcp_secret.version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := p.ValidateConfig(ctx, ConnectionEphemeralResourceModelAuth{GCPSecret: &tt.secret})
+			if tt.wantError == "" {
+				if diags.HasError() {
+					t.Errorf("Unexpected error: %v", diags)
+				}
+				return
+			}
+			if !diags.HasError() || !strings.Contains(diags[0].Detail(), tt.wantError) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantError, diags)
+			}
+		})
+	}
+}
+
+func TestPrivateKeyAuthProviderAzureKeyVaultValidation(t *testing.T) {
+	ctx := context.Background()
+	p := &privateKeyAuthProvider{}
+
+	tests := []struct {
+		name      string
+		vault     ConnectionEphemeralResourceModelAuthAzureKeyVault
+		wantError string
+	}{
+		{"valid", ConnectionEphemeralResourceModelAuthAzureKeyVault{VaultURI: types.StringValue("https://ops.vault.azure.net"), SecretName: types.StringValue("bastion-key")}, ""},
+		{"unknown", ConnectionEphemeralResourceModelAuthAzureKeyVault{VaultURI: types.StringUnknown(), SecretName: types.StringUnknown()}, ""},
+		{"http", ConnectionEphemeralResourceModelAuthAzureKeyVault{VaultURI: types.StringValue("http://ops.vault.azure.net"), SecretName: types.StringValue("bastion-key")}, "auth.azure_key_vault.vault_uri"},
+		{"vault name", ConnectionEphemeralResourceModelAuthAzureKeyVault{VaultURI: types.StringValue("ops"), SecretName: types.StringValue("bastion-key")}, "auth.azure_key_vault.vault_uri"},
+		{"secret name", ConnectionEphemeralResourceModelAuthAzureKeyVault{VaultURI: types.StringValue("https://ops.vault.azure.net"), SecretName: types.StringValue("bastion_key")}, "auth.azure_key_vault.secret_name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := p.ValidateConfig(ctx, ConnectionEphemeralResourceModelAuth{AzureKeyVault: &tt.vault})
+			if tt.wantError == "" {
+				if diags.HasError() {
+					t.Errorf("Unexpected error: %v", diags)
+				}
+				return
+			}
+			if !diags.HasError() || !strings.Contains(diags[0].Detail(), tt.wantError) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantError, diags)
+			}
+		})
+	}
+}
+
+func TestParsePrivateKeySecurityKey(t *testing.T) {
+	envelope := ssh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{"none", "none", "", 1, testSecurityKey(t).Marshal(), []byte("key handle")})
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: append([]byte("openssh-key-v1\x00"), envelope...)})
+
+	_, err := parsePrivateKey(privateKey, types.StringNull())
+	if err == nil || !strings.Contains(err.Error(), "SSH agent") {
+		t.Errorf("Expected an error pointing to the SSH agent, got %v", err)
+	}
+}
+
+func TestPrivateKeyAuthProviderCertificate(t *testing.T) {
+	ctx := context.Background()
+	p := &privateKeyAuthProvider{}
+
+	newKey := func() (ssh.Signer, string) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := ssh.MarshalPrivateKey(key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer, string(pem.EncodeToMemory(block))
+	}
+	ca, _ := newKey()
+	user, privateKey := newKey()
+	_, otherPrivateKey := newKey()
+
+	newCert := func(validBefore time.Time) string {
+		cert := &ssh.Certificate{
+			Key:             user.PublicKey(),
+			CertType:        ssh.UserCert,
+			ValidPrincipals: []string{"terraform"},
+			ValidBefore:     uint64(validBefore.Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		return string(ssh.MarshalAuthorizedKey(cert))
+	}
+
+	tests := []struct {
+		name        string
+		privateKey  string
+		certificate string
+		wantError   string
+	}{
+		{"certificate", privateKey, newCert(time.Now().Add(time.Hour)), ""},
+		{"expired", privateKey, newCert(time.Now().Add(-time.Hour)), "Certificate expired"},
+		{"other key", otherPrivateKey, newCert(time.Now().Add(time.Hour)), "Unable to use certificate"},
+		{"not a certificate", privateKey, string(ssh.MarshalAuthorizedKey(user.PublicKey())), "not a certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := ConnectionEphemeralResourceModelAuth{
+				PrivateKey:  types.StringValue(tt.privateKey),
+				Certificate: types.StringValue(tt.certificate),
+			}
+			_, diags := p.AuthMethods(ctx, auth)
+			if tt.wantError == "" {
+				if diags.HasError() {
+					t.Errorf("Unexpected error: %v", diags)
+				}
+				return
+			}
+			if !diags.HasError() || !strings.Contains(diags[0].Detail(), tt.wantError) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantError, diags)
+			}
+		})
+	}
+
+	auth := ConnectionEphemeralResourceModelAuth{Certificate: types.StringValue(newCert(time.Now().Add(time.Hour)))}
+	if diags := p.ValidateConfig(ctx, auth); !diags.HasError() {
+		t.Error("Expected an error for a certificate without a private key")
+	}
+}
+
+func TestPrivateKeyAuthProviderPrivateKeys(t *testing.T) {
+	ctx := context.Background()
+	p := &privateKeyAuthProvider{}
+
+	privateKeys := []types.String{}
+	var accepted ssh.PublicKey
+	for i := 0; i < 2; i++ {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := ssh.MarshalPrivateKey(key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		privateKeys = append(privateKeys, types.StringValue(string(pem.EncodeToMemory(block))))
+
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted = signer.PublicKey()
+	}
+
+	// Only the last key is accepted by the server.
+	addr := startTestSSHServer(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), accepted.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}, func(newChannel ssh.NewChannel) {
+		_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+	})
+
+	auth := ConnectionEphemeralResourceModelAuth{PrivateKeys: privateKeys}
+	if diags := p.ValidateConfig(ctx, auth); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	methods, diags := p.AuthMethods(ctx, auth)
+	if diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+
+	client, _, err := dial(ctx, addr, &ssh.ClientConfig{
+		User:            "test",
+		Auth:            methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Expected the second key to be accepted, got %v", err)
+	}
+	client.Close()
+
+	auth.PrivateKey = privateKeys[0]
+	if diags := p.ValidateConfig(ctx, auth); !diags.HasError() {
+		t.Error("Expected an error when private_key and private_keys are set")
+	}
+	if diags := p.ValidateConfig(ctx, ConnectionEphemeralResourceModelAuth{PrivateKeys: []types.String{}}); !diags.HasError() {
+		t.Error("Expected an error for empty private_keys")
+	}
+}
+
+func TestOrderAuthProviders(t *testing.T) {
+	a := &stubAuthProvider{name: "a", configured: true}
+	b := &stubAuthProvider{name: "b", configured: true}
+	c := &stubAuthProvider{name: "c"}
+	providers := []AuthProvider{a, b, c}
+
+	methods := func(names ...string) ConnectionEphemeralResourceModelAuth {
+		auth := ConnectionEphemeralResourceModelAuth{Methods: []types.String{}}
+		for _, name := range names {
+			auth.Methods = append(auth.Methods, types.StringValue(name))
+		}
+		return auth
+	}
+
+	ordered, err := orderAuthProviders(providers, ConnectionEphemeralResourceModelAuth{})
+	if err != nil || len(ordered) != 2 || ordered[0] != a || ordered[1] != b {
+		t.Errorf("Expected the configured providers in registration order, got %v, %v", ordered, err)
+	}
+
+	ordered, err = orderAuthProviders(providers, methods("b", "a"))
+	if err != nil || len(ordered) != 2 || ordered[0] != b || ordered[1] != a {
+		t.Errorf("Expected the providers in the order of methods, got %v, %v", ordered, err)
+	}
+
+	for _, tt := range []struct {
+		auth      ConnectionEphemeralResourceModelAuth
+		wantError string
+	}{
+		{methods("a", "d"), `unknown auth method "d"`},
+		{methods("a", "a", "b"), "more than once"},
+		{methods("a", "b", "c"), `"c" is listed in methods, but not configured`},
+		{methods("a"), `"b" is configured, but not listed in methods`},
+	} {
+		if _, err := orderAuthProviders(providers, tt.auth); err == nil || !strings.Contains(err.Error(), tt.wantError) {
+			t.Errorf("Expected an error containing %q, got %v", tt.wantError, err)
+		}
+	}
+}
+
+func TestAuthMethodsChain(t *testing.T) {
+	ctx := context.Background()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server rejects the key and only accepts keyboard-interactive
+	// authentication.
+	addr := startTestSSHServer(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, errors.New("unknown key")
+		},
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "", []string{"Password: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 || answers[0] != "secret" {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+	}, func(newChannel ssh.NewChannel) {
+		_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+	})
+
+	auth := ConnectionEphemeralResourceModelAuth{
+		PrivateKey:          types.StringValue(string(pem.EncodeToMemory(block))),
+		Password:            types.StringValue("secret"),
+		KeyboardInteractive: types.BoolValue(true),
+		Methods: []types.String{
+			types.StringValue("private_key"),
+			types.StringValue("keyboard_interactive"),
+			types.StringValue("password"),
+		},
+	}
+	if diags := validateAuthConfig(ctx, defaultAuthProviders(), auth); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	methods, diags := authMethods(ctx, defaultAuthProviders(), auth)
+	if diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	if len(methods) != 3 {
+		t.Fatalf("Expected 3 auth methods, got %d", len(methods))
+	}
+
+	client, _, err := dial(ctx, addr, &ssh.ClientConfig{
+		User:            "test",
+		Auth:            methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Expected keyboard-interactive authentication after the key, got %v", err)
+	}
+	client.Close()
+}
+
+func TestAuthMethodsSigners(t *testing.T) {
+	ctx := context.Background()
+	signer := func() ssh.Signer {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	providers := []AuthProvider{
+		&stubSignerAuthProvider{stubAuthProvider{name: "a", configured: true}, []ssh.Signer{signer()}},
+		&stubAuthProvider{name: "b", configured: true, methods: []ssh.AuthMethod{ssh.Password("secret")}},
+		&stubSignerAuthProvider{stubAuthProvider{name: "c", configured: true}, []ssh.Signer{signer()}},
+	}
+
+	methods, diags := authMethods(ctx, providers, ConnectionEphemeralResourceModelAuth{})
+	if diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	if len(methods) != 2 {
+		t.Errorf("Expected the signers to be offered in a single method, got %d methods", len(methods))
+	}
+}
+
+type stubSignerAuthProvider struct {
+	stubAuthProvider
+	signers []ssh.Signer
+}
+
+func (p *stubSignerAuthProvider) Signers(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.Signer, diag.Diagnostics) {
+	return p.signers, nil
+}
@@ -0,0 +1,155 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const (
+	defaultAvailabilityInterval = 10 * time.Second
+	defaultAvailabilityTimeout  = 5 * time.Second
+)
+
+// keepaliveConn is the part of *ssh.Client used to watch the availability
+// of the server.
+type keepaliveConn interface {
+	SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error)
+	Wait() error
+}
+
+var errKeepaliveTimeout = errors.New("keepalive timed out")
+
+// availabilityWatcher records flaps of an SSH connection, periods in which
+// the server didn't answer keepalive requests, so unstable bastions can be
+// reported when the tunnel is closed.
+type availabilityWatcher struct {
+	mu sync.Mutex
+	// flaps are the durations of past outages.
+	flaps []time.Duration
+	// downSince is the start of the current outage, zero if the server is
+	// available.
+	downSince time.Time
+	// lostAt is when the connection was lost, zero if it is open.
+	lostAt time.Time
+	// pending receives the result of a keepalive that timed out.
+	pending chan error
+}
+
+// run sends a keepalive every interval until ctx is done, a keepalive
+// without a response within timeout starts an outage.
+func (w *availabilityWatcher) run(ctx context.Context, conn keepaliveConn, interval, timeout time.Duration) {
+	go func() {
+		_ = conn.Wait()
+		if ctx.Err() == nil {
+			w.lost(ctx, time.Now())
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.record(ctx, w.probe(conn, timeout), time.Now())
+		}
+	}
+}
+
+// probe sends a keepalive and waits up to timeout for the response. Servers
+// reply with a failure to the unknown request, which still proves they are
+// responsive. Only one keepalive is outstanding at a time.
+func (w *availabilityWatcher) probe(conn keepaliveConn, timeout time.Duration) error {
+	result := w.pending
+	if result == nil {
+		result = make(chan error, 1)
+		go func() {
+			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+			result <- err
+		}()
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-result:
+		w.pending = nil
+		return err
+	case <-timer.C:
+		w.pending = result
+		return errKeepaliveTimeout
+	}
+}
+
+func (w *availabilityWatcher) record(ctx context.Context, err error, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.lostAt.IsZero() {
+		return
+	}
+
+	if err != nil {
+		if w.downSince.IsZero() {
+			tflog.Warn(ctx, "SSH server unresponsive", map[string]interface{}{"err": err})
+			w.downSince = now
+		}
+		return
+	}
+
+	if !w.downSince.IsZero() {
+		outage := now.Sub(w.downSince)
+		tflog.Info(ctx, "SSH server responsive again", map[string]interface{}{"outage": outage.String()})
+		w.flaps = append(w.flaps, outage)
+		w.downSince = time.Time{}
+	}
+}
+
+func (w *availabilityWatcher) lost(ctx context.Context, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tflog.Error(ctx, "SSH connection lost")
+	w.lostAt = now
+}
+
+// summary describes the flaps of the connection, empty if it was stable.
+func (w *availabilityWatcher) summary(now time.Time) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	summary := ""
+	if len(w.flaps) > 0 {
+		var total, longest time.Duration
+		for _, flap := range w.flaps {
+			total += flap
+			longest = max(longest, flap)
+		}
+		summary = fmt.Sprintf("was unresponsive %d times for %s in total (longest %s)", len(w.flaps), total, longest)
+	}
+
+	var ongoing string
+	switch {
+	case !w.lostAt.IsZero():
+		ongoing = fmt.Sprintf("was lost at %s", w.lostAt.UTC().Format(time.RFC3339))
+	case !w.downSince.IsZero():
+		ongoing = fmt.Sprintf("was unresponsive for the last %s", now.Sub(w.downSince).Round(time.Second))
+	}
+
+	switch {
+	case summary == "":
+		return ongoing
+	case ongoing == "":
+		return summary
+	default:
+		return summary + " and " + ongoing
+	}
+}
@@ -0,0 +1,88 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type stubKeepaliveConn struct {
+	replies chan error
+	closed  chan struct{}
+}
+
+func (c *stubKeepaliveConn) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	return false, nil, <-c.replies
+}
+
+func (c *stubKeepaliveConn) Wait() error {
+	<-c.closed
+	return nil
+}
+
+func TestAvailabilityWatcher(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	w := &availabilityWatcher{}
+
+	w.record(ctx, nil, start)
+	if summary := w.summary(start); summary != "" {
+		t.Errorf("Expected no summary for a stable connection, got %q", summary)
+	}
+
+	w.record(ctx, errKeepaliveTimeout, start.Add(10*time.Second))
+	w.record(ctx, errKeepaliveTimeout, start.Add(20*time.Second))
+	w.record(ctx, nil, start.Add(40*time.Second))
+	w.record(ctx, errKeepaliveTimeout, start.Add(50*time.Second))
+	w.record(ctx, nil, start.Add(60*time.Second))
+	w.record(ctx, errKeepaliveTimeout, start.Add(70*time.Second))
+
+	want := "was unresponsive 2 times for 40s in total (longest 30s) and was unresponsive for the last 20s"
+	if summary := w.summary(start.Add(90 * time.Second)); summary != want {
+		t.Errorf("got %q, want %q", summary, want)
+	}
+
+	w.lost(ctx, start.Add(100*time.Second))
+	want = "was unresponsive 2 times for 40s in total (longest 30s) and was lost at 2024-01-01T12:01:40Z"
+	if summary := w.summary(start.Add(110 * time.Second)); summary != want {
+		t.Errorf("got %q, want %q", summary, want)
+	}
+}
+
+func TestAvailabilityWatcherProbe(t *testing.T) {
+	conn := &stubKeepaliveConn{replies: make(chan error, 1), closed: make(chan struct{})}
+	w := &availabilityWatcher{}
+
+	conn.replies <- nil
+	if err := w.probe(conn, time.Second); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := w.probe(conn, 10*time.Millisecond); err != errKeepaliveTimeout {
+		t.Errorf("got %v, want %v", err, errKeepaliveTimeout)
+	}
+
+	// The response to the keepalive that timed out ends the outage.
+	conn.replies <- nil
+	if err := w.probe(conn, time.Second); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestAvailabilityWatcherLost(t *testing.T) {
+	conn := &stubKeepaliveConn{replies: make(chan error), closed: make(chan struct{})}
+	w := &availabilityWatcher{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.run(ctx, conn, time.Hour, time.Second)
+
+	close(conn.closed)
+	deadline := time.Now().Add(5 * time.Second)
+	for w.summary(time.Now()) == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the lost connection to be recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
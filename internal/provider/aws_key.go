@@ -0,0 +1,33 @@
+package provider
+
+import (
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/secretref"
+)
+
+// validateAWSKey validates the auth.aws block, values may be unknown.
+func validateAWSKey(a *ConnectionEphemeralResourceModelAuthAWS) diag.Diagnostics {
+	diags := diag.Diagnostics{}
+
+	if a.SecretID.IsNull() == a.ParameterName.IsNull() {
+		diags.AddError("Auth Error", "Exactly one of auth.aws.secret_id or auth.aws.parameter_name must be set")
+	}
+
+	return diags
+}
+
+// awsKeyRef returns the reference of the private key in the AWS Secrets
+// Manager secret or SSM parameter of the auth.aws block and the options to
+// resolve it with.
+func awsKeyRef(a *ConnectionEphemeralResourceModelAuthAWS) (*secretref.Ref, secretref.Options) {
+	ref := &secretref.Ref{Scheme: secretref.SchemeAWSSecretsManager, Name: a.SecretID.ValueString(), Field: a.Field.ValueString()}
+	if !a.ParameterName.IsNull() {
+		ref = &secretref.Ref{Scheme: secretref.SchemeAWSSSM, Name: a.ParameterName.ValueString(), Field: a.Field.ValueString()}
+	}
+
+	return ref, secretref.Options{AWS: secretref.AWSConfig{
+		Region:  a.Region.ValueString(),
+		Profile: a.Profile.ValueString(),
+		RoleARN: a.RoleARN.ValueString(),
+	}}
+}
@@ -0,0 +1,47 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/secretref"
+)
+
+// azureKeyVaultSecretName matches the names Key Vault allows for secrets.
+var azureKeyVaultSecretName = regexp.MustCompile(`^[0-9a-zA-Z-]{1,127}$`)
+
+// validateAzureKey validates the auth.azure_key_vault block, values may be
+// unknown.
+func validateAzureKey(a *ConnectionEphemeralResourceModelAuthAzureKeyVault) diag.Diagnostics {
+	diags := diag.Diagnostics{}
+
+	if !a.VaultURI.IsUnknown() {
+		u, err := url.Parse(a.VaultURI.ValueString())
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			diags.AddError("Auth Error", fmt.Sprintf("auth.azure_key_vault.vault_uri must be an https URI like https://<vault>.vault.azure.net, got %q", a.VaultURI.ValueString()))
+		}
+	}
+	if !a.SecretName.IsUnknown() && !azureKeyVaultSecretName.MatchString(a.SecretName.ValueString()) {
+		diags.AddError("Auth Error", fmt.Sprintf("auth.azure_key_vault.secret_name must consist of 1 to 127 letters, digits and dashes, got %q", a.SecretName.ValueString()))
+	}
+
+	return diags
+}
+
+// readAzureKey reads the private key from the Key Vault secret of the
+// auth.azure_key_vault block using the default Azure credential chain.
+func readAzureKey(ctx context.Context, a *ConnectionEphemeralResourceModelAuthAzureKeyVault) ([]byte, error) {
+	cred, err := secretref.DefaultAzureCredential()
+	if err != nil {
+		return nil, err
+	}
+
+	return secretref.ReadAzureKeyVaultSecret(ctx, cred, secretref.AzureKeyVaultRequest{
+		VaultURI: a.VaultURI.ValueString(),
+		Name:     a.SecretName.ValueString(),
+		Version:  a.Version.ValueString(),
+	})
+}
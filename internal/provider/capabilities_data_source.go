@@ -0,0 +1,158 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/pkcs11key"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ datasource.DataSource = &CapabilitiesDataSource{}
+var _ datasource.DataSourceWithConfigure = &CapabilitiesDataSource{}
+
+// capabilityTransports are the ways tunnels reach their targets through the
+// SSH server, supported by every build.
+var capabilityTransports = []string{
+	// direct-tcpip channels of local port forwardings.
+	"tcp",
+	// Relaying through the stdio of a command with exec_fallback.
+	"exec",
+	// streamlocal channels of remote_socket_path and
+	// remote_socket_forwardings.
+	"streamlocal",
+	// SOCKS5 proxies of dynamic_port_forwardings.
+	"socks5",
+	// HTTP CONNECT proxies of dynamic_port_forwardings with protocol http.
+	"http_connect",
+	// Datagrams of udp_forwardings relayed through a command.
+	"udp",
+}
+
+// availableAuthProvider is implemented by AuthProviders depending on the
+// build or platform of the provider.
+type availableAuthProvider interface {
+	Available() bool
+}
+
+func NewCapabilitiesDataSource() datasource.DataSource {
+	return &CapabilitiesDataSource{}
+}
+
+// CapabilitiesDataSource reports the features of the provider binary, so
+// shared modules can branch on them instead of failing mid-apply.
+type CapabilitiesDataSource struct {
+	version       string
+	authProviders []AuthProvider
+}
+
+// CapabilitiesDataSourceModel describes the data source data model.
+type CapabilitiesDataSourceModel struct {
+	Version            types.String   `tfsdk:"version"`
+	OS                 types.String   `tfsdk:"os"`
+	Arch               types.String   `tfsdk:"arch"`
+	AuthMethods        []types.String `tfsdk:"auth_methods"`
+	Transports         []types.String `tfsdk:"transports"`
+	PKCS11             types.Bool     `tfsdk:"pkcs11"`
+	AgentNamedPipes    types.Bool     `tfsdk:"agent_named_pipes"`
+	PrivilegedPortsCap types.Bool     `tfsdk:"privileged_ports_setcap"`
+}
+
+func (d *CapabilitiesDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_capabilities"
+}
+
+func (d *CapabilitiesDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "Reports the features compiled into the provider binary and supported on its platform, so shared modules can branch on them, " +
+			"e.g. fall back to the SSH agent if `pkcs11` isn't supported, instead of failing mid-apply.",
+
+		Attributes: map[string]schema.Attribute{
+			"version": schema.StringAttribute{
+				MarkdownDescription: "Version of the provider",
+				Computed:            true,
+			},
+			"os": schema.StringAttribute{
+				MarkdownDescription: "Operating system the provider runs on, e.g. `linux`, `darwin` or `windows`",
+				Computed:            true,
+			},
+			"arch": schema.StringAttribute{
+				MarkdownDescription: "Architecture the provider runs on, e.g. `amd64` or `arm64`",
+				Computed:            true,
+			},
+			"auth_methods": schema.ListAttribute{
+				MarkdownDescription: "Authentication methods supported by this build, as accepted by `auth.methods` of `sshtunnel_connection`",
+				ElementType:         types.StringType,
+				Computed:            true,
+			},
+			"transports": schema.ListAttribute{
+				MarkdownDescription: "Ways tunnels reach their targets through the SSH server: `tcp` (local port forwardings), `exec` (`exec_fallback`), `streamlocal` (`remote_socket_path` and `remote_socket_forwardings`), `socks5` and `http_connect` (`dynamic_port_forwardings`) and `udp` (`udp_forwardings`)",
+				ElementType:         types.StringType,
+				Computed:            true,
+			},
+			"pkcs11": schema.BoolAttribute{
+				MarkdownDescription: "Whether `auth.pkcs11` is supported, which requires a provider built with cgo",
+				Computed:            true,
+			},
+			"agent_named_pipes": schema.BoolAttribute{
+				MarkdownDescription: "Whether `auth.agent_socket` accepts Windows named pipes",
+				Computed:            true,
+			},
+			"privileged_ports_setcap": schema.BoolAttribute{
+				MarkdownDescription: "Whether the `setcap` subcommand can allow binding local ports below 1024, only on Linux",
+				Computed:            true,
+			},
+		},
+	}
+}
+
+func (d *CapabilitiesDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+
+	configData, ok := req.ProviderData.(*ProviderConfigData)
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Data Source Configure Type",
+			fmt.Sprintf("Expected *ProviderConfigData, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+		)
+		return
+	}
+
+	d.version = configData.Version
+	d.authProviders = configData.AuthProviders
+}
+
+func (d *CapabilitiesDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	authProviders := d.authProviders
+	if authProviders == nil {
+		authProviders = defaultAuthProviders()
+	}
+
+	data := CapabilitiesDataSourceModel{
+		Version:            types.StringValue(d.version),
+		OS:                 types.StringValue(runtime.GOOS),
+		Arch:               types.StringValue(runtime.GOARCH),
+		AuthMethods:        []types.String{},
+		Transports:         []types.String{},
+		PKCS11:             types.BoolValue(pkcs11key.Supported),
+		AgentNamedPipes:    types.BoolValue(runtime.GOOS == "windows"),
+		PrivilegedPortsCap: types.BoolValue(runtime.GOOS == "linux"),
+	}
+	for _, p := range authProviders {
+		if a, ok := p.(availableAuthProvider); ok && !a.Available() {
+			continue
+		}
+		data.AuthMethods = append(data.AuthMethods, types.StringValue(p.Name()))
+	}
+	for _, transport := range capabilityTransports {
+		data.Transports = append(data.Transports, types.StringValue(transport))
+	}
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/pkcs11key"
+)
+
+func TestCapabilitiesDataSource(t *testing.T) {
+	ctx := context.Background()
+	d := &CapabilitiesDataSource{}
+	d.Configure(ctx, datasource.ConfigureRequest{ProviderData: &ProviderConfigData{Version: "1.2.3", AuthProviders: defaultAuthProviders()}}, &datasource.ConfigureResponse{})
+
+	schemaResp := datasource.SchemaResponse{}
+	d.Schema(ctx, datasource.SchemaRequest{}, &schemaResp)
+	typ := schemaResp.Schema.Type().TerraformType(ctx)
+
+	resp := datasource.ReadResponse{State: tfsdk.State{Raw: tftypes.NewValue(typ, nil), Schema: schemaResp.Schema}}
+	d.Read(ctx, datasource.ReadRequest{}, &resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("Unexpected error: %v", resp.Diagnostics)
+	}
+
+	var data CapabilitiesDataSourceModel
+	if diags := resp.State.Get(ctx, &data); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	if data.Version.ValueString() != "1.2.3" || data.OS.ValueString() != runtime.GOOS {
+		t.Errorf("got version %s on %s, want 1.2.3 on %s", data.Version, data.OS, runtime.GOOS)
+	}
+
+	methods := []string{}
+	for _, method := range data.AuthMethods {
+		methods = append(methods, method.ValueString())
+	}
+	if !slices.Contains(methods, "private_key") || !slices.Contains(methods, "agent") {
+		t.Errorf("Expected the key based methods, got %v", methods)
+	}
+	if slices.Contains(methods, "pkcs11") != pkcs11key.Supported || data.PKCS11.ValueBool() != pkcs11key.Supported {
+		t.Errorf("Expected pkcs11 only if supported by the build (%t), got %v", pkcs11key.Supported, methods)
+	}
+	if len(data.Transports) != len(capabilityTransports) {
+		t.Errorf("got transports %v, want %v", data.Transports, capabilityTransports)
+	}
+}
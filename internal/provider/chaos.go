@@ -0,0 +1,47 @@
+package provider
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+const (
+	// chaosLatencyEnv delays every chunk of forwarded data, e.g. `200ms`.
+	chaosLatencyEnv = "SSHTUNNEL_CHAOS_LATENCY"
+	// chaosDropRateEnv is the probability between 0 and 1 that a chunk of
+	// forwarded data drops its connection, e.g. `0.01`.
+	chaosDropRateEnv = "SSHTUNNEL_CHAOS_DROP_RATE"
+)
+
+// chaosFromEnv returns the faults injected into all forwardings as set by
+// the SSHTUNNEL_CHAOS_* environment variables, nil if none are set. They
+// are used to rehearse Terraform runs over a degraded bastion link.
+func chaosFromEnv() (*portforward.Faults, error) {
+	latency, hasLatency := os.LookupEnv(chaosLatencyEnv)
+	dropRate, hasDropRate := os.LookupEnv(chaosDropRateEnv)
+	if !hasLatency && !hasDropRate {
+		return nil, nil
+	}
+
+	faults := &portforward.Faults{}
+	if hasLatency {
+		d, err := time.ParseDuration(latency)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative duration, got %q", chaosLatencyEnv, latency)
+		}
+		faults.Latency = d
+	}
+	if hasDropRate {
+		rate, err := strconv.ParseFloat(dropRate, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("%s must be a number between 0 and 1, got %q", chaosDropRateEnv, dropRate)
+		}
+		faults.DropRate = rate
+	}
+
+	return faults, nil
+}
@@ -0,0 +1,55 @@
+package provider
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestChaosFromEnv(t *testing.T) {
+	tests := []struct {
+		name         string
+		latency      string
+		dropRate     string
+		want         bool
+		wantLatency  time.Duration
+		wantDropRate float64
+		wantErr      bool
+	}{
+		{name: "unset"},
+		{name: "latency", latency: "200ms", want: true, wantLatency: 200 * time.Millisecond},
+		{name: "drop rate", dropRate: "0.05", want: true, wantDropRate: 0.05},
+		{name: "both", latency: "1s", dropRate: "1", want: true, wantLatency: time.Second, wantDropRate: 1},
+		{name: "invalid latency", latency: "slow", wantErr: true},
+		{name: "negative latency", latency: "-1s", wantErr: true},
+		{name: "drop rate above one", dropRate: "1.5", wantErr: true},
+		{name: "invalid drop rate", dropRate: "often", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for env, value := range map[string]string{chaosLatencyEnv: tt.latency, chaosDropRateEnv: tt.dropRate} {
+				t.Setenv(env, value)
+				if value == "" {
+					os.Unsetenv(env)
+				}
+			}
+
+			faults, err := chaosFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %t, got %v", tt.wantErr, err)
+			}
+			if (faults != nil) != tt.want {
+				t.Fatalf("Expected faults %t, got %v", tt.want, faults)
+			}
+			if faults == nil {
+				return
+			}
+			if faults.Latency != tt.wantLatency {
+				t.Errorf("Expected latency %s, got %s", tt.wantLatency, faults.Latency)
+			}
+			if faults.DropRate != tt.wantDropRate {
+				t.Errorf("Expected drop rate %g, got %g", tt.wantDropRate, faults.DropRate)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+)
+
+// combinationRule restricts which attributes of an object can be set
+// together.
+type combinationRule int
+
+const (
+	// conflictingRule allows at most one of the attributes.
+	conflictingRule combinationRule = iota
+	// exactlyOneOfRule requires exactly one of the attributes.
+	exactlyOneOfRule
+	// requiredTogetherRule requires all or none of the attributes.
+	requiredTogetherRule
+	// requiresRule requires the other attributes if the first one is set.
+	requiresRule
+)
+
+// attributeCombination validates a combinationRule on the attributes names
+// of every object matching objects, e.g. of every local port forwarding, or
+// of the root object. Errors are reported at the attribute paths, so invalid
+// combinations fail at plan instead of when the tunnel is opened. Unknown
+// values are checked once known.
+type attributeCombination struct {
+	rule    combinationRule
+	objects *path.Expression
+	names   []string
+}
+
+var _ ephemeral.ConfigValidator = attributeCombination{}
+
+func conflictingAttributes(names ...string) attributeCombination {
+	return attributeCombination{rule: conflictingRule, names: names}
+}
+
+func exactlyOneOfAttributes(names ...string) attributeCombination {
+	return attributeCombination{rule: exactlyOneOfRule, names: names}
+}
+
+func requiredTogetherAttributes(names ...string) attributeCombination {
+	return attributeCombination{rule: requiredTogetherRule, names: names}
+}
+
+func attributeRequires(name string, required ...string) attributeCombination {
+	return attributeCombination{rule: requiresRule, names: append([]string{name}, required...)}
+}
+
+// within validates the attributes of the objects matching objects instead
+// of the root object.
+func (v attributeCombination) within(objects path.Expression) attributeCombination {
+	v.objects = &objects
+	return v
+}
+
+func (v attributeCombination) Description(ctx context.Context) string {
+	names := quotedNames(v.names)
+	var desc string
+	switch v.rule {
+	case conflictingRule:
+		desc = "At most one of " + names + " can be set"
+	case exactlyOneOfRule:
+		desc = "Exactly one of " + names + " must be set"
+	case requiredTogetherRule:
+		desc = names + " must be set together"
+	case requiresRule:
+		desc = fmt.Sprintf("%q requires %s", v.names[0], quotedNames(v.names[1:]))
+	}
+	if v.objects != nil {
+		desc += " in " + v.objects.String()
+	}
+	return desc
+}
+
+func (v attributeCombination) MarkdownDescription(ctx context.Context) string {
+	return v.Description(ctx)
+}
+
+func (v attributeCombination) ValidateEphemeralResource(ctx context.Context, req ephemeral.ValidateConfigRequest, resp *ephemeral.ValidateConfigResponse) {
+	objects := path.Paths{path.Empty()}
+	if v.objects != nil {
+		matches, diags := req.Config.PathMatches(ctx, *v.objects)
+		resp.Diagnostics.Append(diags...)
+		objects = matches
+	}
+
+	for _, object := range objects {
+		if !object.Equal(path.Empty()) {
+			var value attr.Value
+			resp.Diagnostics.Append(req.Config.GetAttribute(ctx, object, &value)...)
+			if value == nil || value.IsNull() || value.IsUnknown() {
+				continue
+			}
+		}
+
+		set := []path.Path{}
+		unset := []path.Path{}
+		unknown := false
+		for _, name := range v.names {
+			p := object.AtName(name)
+			var value attr.Value
+			diags := req.Config.GetAttribute(ctx, p, &value)
+			resp.Diagnostics.Append(diags...)
+			if diags.HasError() {
+				return
+			}
+			switch {
+			case value == nil || value.IsNull():
+				unset = append(unset, p)
+			case value.IsUnknown():
+				unknown = true
+			default:
+				set = append(set, p)
+			}
+		}
+
+		switch v.rule {
+		case conflictingRule:
+			if len(set) > 1 {
+				resp.Diagnostics.AddAttributeError(set[1], "Invalid Attribute Combination",
+					fmt.Sprintf("%s can't be set together with %s, at most one of %s can be set", set[1], set[0], quotedNames(v.names)))
+			}
+		case exactlyOneOfRule:
+			if len(set) > 1 {
+				resp.Diagnostics.AddAttributeError(set[1], "Invalid Attribute Combination",
+					fmt.Sprintf("%s can't be set together with %s, exactly one of %s must be set", set[1], set[0], quotedNames(v.names)))
+			} else if len(set) == 0 && !unknown {
+				resp.Diagnostics.AddAttributeError(object, "Missing Attribute Configuration",
+					fmt.Sprintf("Exactly one of %s must be set", quotedNames(v.names)))
+			}
+		case requiredTogetherRule:
+			if len(set) > 0 && len(unset) > 0 {
+				resp.Diagnostics.AddAttributeError(unset[0], "Missing Attribute Configuration",
+					fmt.Sprintf("%s is required with %s, %s must be set together", unset[0], set[0], quotedNames(v.names)))
+			}
+		case requiresRule:
+			first := object.AtName(v.names[0])
+			if len(set) > 0 && set[0].Equal(first) {
+				for _, p := range unset {
+					resp.Diagnostics.AddAttributeError(p, "Missing Attribute Configuration",
+						fmt.Sprintf("%s is required with %s", p, first))
+				}
+			}
+		}
+	}
+}
+
+func quotedNames(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+	return strings.Join(quoted, ", ")
+}
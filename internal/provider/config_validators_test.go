@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+// nullObject returns an object of typ with values set and all other
+// attributes null.
+func nullObject(typ tftypes.Object, values map[string]tftypes.Value) tftypes.Value {
+	attrs := map[string]tftypes.Value{}
+	for name, attrType := range typ.AttributeTypes {
+		attrs[name] = tftypes.NewValue(attrType, nil)
+	}
+	for name, value := range values {
+		attrs[name] = value
+	}
+	return tftypes.NewValue(typ, attrs)
+}
+
+func TestConnectionConfigValidators(t *testing.T) {
+	ctx := context.Background()
+	r := &ConnectionEphemeralResource{}
+	schemaResp := ephemeral.SchemaResponse{}
+	r.Schema(ctx, ephemeral.SchemaRequest{}, &schemaResp)
+
+	typ := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+	forwardingsType := typ.AttributeTypes["local_port_forwardings"].(tftypes.List)
+	forwardingType := forwardingsType.ElementType.(tftypes.Object)
+	authType := typ.AttributeTypes["auth"].(tftypes.Object)
+
+	forwardings := func(forwardings ...map[string]tftypes.Value) tftypes.Value {
+		values := []tftypes.Value{}
+		for _, f := range forwardings {
+			values = append(values, nullObject(forwardingType, f))
+		}
+		return tftypes.NewValue(forwardingsType, values)
+	}
+	host := tftypes.NewValue(tftypes.String, "bastion.example.com")
+	srv := tftypes.NewValue(tftypes.String, "_ssh._tcp.example.com")
+	port := tftypes.NewValue(tftypes.Number, 22)
+
+	tests := []struct {
+		name   string
+		values map[string]tftypes.Value
+		errors path.Paths
+	}{
+		{"host and port", map[string]tftypes.Value{"host": host, "port": port}, nil},
+		{"srv", map[string]tftypes.Value{"srv": srv}, nil},
+		{"unknown host", map[string]tftypes.Value{"host": tftypes.NewValue(tftypes.String, tftypes.UnknownValue), "port": port}, nil},
+		{"host and srv", map[string]tftypes.Value{"host": host, "port": port, "srv": srv}, path.Paths{path.Root("srv"), path.Root("port")}},
+		{"neither host nor srv", map[string]tftypes.Value{}, path.Paths{path.Empty()}},
+		{"host without port", map[string]tftypes.Value{"host": host}, path.Paths{path.Root("port")}},
+		{"srv with port", map[string]tftypes.Value{"srv": srv, "port": port}, path.Paths{path.Root("port")}},
+		{"wait without forwardings", map[string]tftypes.Value{"srv": srv, "wait_for_first_connection": tftypes.NewValue(tftypes.String, "5m")}, path.Paths{path.Root("local_port_forwardings")}},
+		{"local port and seed", map[string]tftypes.Value{"srv": srv, "local_port_forwardings": forwardings(
+			map[string]tftypes.Value{"local_port": tftypes.NewValue(tftypes.Number, 8080)},
+			map[string]tftypes.Value{"local_port": tftypes.NewValue(tftypes.Number, 8081), "local_port_seed": tftypes.NewValue(tftypes.String, "db")},
+		)}, path.Paths{path.Root("local_port_forwardings").AtListIndex(1).AtName("local_port_seed")}},
+		{"agent socket without agent", map[string]tftypes.Value{"srv": srv, "auth": nullObject(authType, map[string]tftypes.Value{
+			"agent_socket": tftypes.NewValue(tftypes.String, "~/.1password/agent.sock"),
+		})}, path.Paths{path.Root("auth").AtName("agent")}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := ephemeral.ValidateConfigRequest{Config: tfsdk.Config{
+				Raw:    nullObject(typ, test.values),
+				Schema: schemaResp.Schema,
+			}}
+			resp := ephemeral.ValidateConfigResponse{}
+			for _, v := range r.ConfigValidators(ctx) {
+				v.ValidateEphemeralResource(ctx, req, &resp)
+			}
+
+			errors := path.Paths{}
+			for _, d := range resp.Diagnostics.Errors() {
+				withPath, ok := d.(interface{ Path() path.Path })
+				if !ok {
+					t.Fatalf("Expected an attribute error, got %s: %s", d.Summary(), d.Detail())
+				}
+				errors = append(errors, withPath.Path())
+			}
+			if len(errors) != len(test.errors) {
+				t.Fatalf("Expected errors at %v, got %v", test.errors, resp.Diagnostics.Errors())
+			}
+			for i := range errors {
+				if !errors[i].Equal(test.errors[i]) {
+					t.Errorf("Expected an error at %s, got %s", test.errors[i], errors[i])
+				}
+			}
+		})
+	}
+}
@@ -3,18 +3,27 @@ package provider
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
 	"github.com/hashicorp/terraform-plugin-framework/ephemeral/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
-	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/portforward"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/consul"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/endpoints"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/filelock"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/sshconfig"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -23,6 +32,7 @@ var _ ephemeral.EphemeralResource = &ConnectionEphemeralResource{}
 var _ ephemeral.EphemeralResourceWithConfigure = &ConnectionEphemeralResource{}
 var _ ephemeral.EphemeralResourceWithClose = &ConnectionEphemeralResource{}
 var _ ephemeral.EphemeralResourceWithValidateConfig = &ConnectionEphemeralResource{}
+var _ ephemeral.EphemeralResourceWithConfigValidators = &ConnectionEphemeralResource{}
 
 func NewConnectionEphemeralResource() ephemeral.EphemeralResource {
 	return &ConnectionEphemeralResource{}
@@ -31,27 +41,269 @@ func NewConnectionEphemeralResource() ephemeral.EphemeralResource {
 // ConnectionEphemeralResource defines the resource implementation.
 type ConnectionEphemeralResource struct {
 	tunnelTracker *TunnelTracker
+	authProviders []AuthProvider
+	// protocolExtensions handle vendor-specific global requests and channel
+	// types.
+	protocolExtensions []ProtocolExtension
+	lockDir            *filelock.Dir
+	lockTimeout        time.Duration
+
+	systemSSHConfig  bool
+	systemKnownHosts bool
+	skipOpen         bool
+	planSummary      bool
+	policy           *accessPolicy
+	listenerPool     *ListenerPool
+	reclaimedPorts   *reclaimedPorts
+	// handshakes limits the connections established concurrently per
+	// server, nil if unlimited, e.g. before the provider is configured.
+	handshakes *handshakeLimiter
+
+	// forwardingProfiles is nil until the provider is configured.
+	forwardingProfiles map[string]SSHTunnelProviderModelForwardingProfile
+	budget             *portforward.Budget
+	faults             *portforward.Faults
 }
 
 type ConnectionEphemeralResourceModelLocalPortForwarding struct {
-	LocalPort     types.Int32  `tfsdk:"local_port"`
-	RemoteHost    types.String `tfsdk:"remote_host"`
-	RemotePort    types.Int32  `tfsdk:"remote_port"`
-	RetryAttempts types.Int32  `tfsdk:"retry_attempts"`
-	RetryDelay    types.String `tfsdk:"retry_delay"`
+	Name                types.String            `tfsdk:"name"`
+	LocalPort           types.Int32             `tfsdk:"local_port"`
+	LocalSocketPath     types.String            `tfsdk:"local_socket_path"`
+	RemoteHost          types.String            `tfsdk:"remote_host"`
+	RemotePort          types.Int32             `tfsdk:"remote_port"`
+	RemoteSocketPath    types.String            `tfsdk:"remote_socket_path"`
+	RetryAttempts       types.Int32             `tfsdk:"retry_attempts"`
+	RetryDelay          types.String            `tfsdk:"retry_delay"`
+	ListenBacklog       types.Int32             `tfsdk:"listen_backlog"`
+	MaxConnections      types.Int32             `tfsdk:"max_connections"`
+	AcceptWorkers       types.Int32             `tfsdk:"accept_workers"`
+	LocalPortSeed       types.String            `tfsdk:"local_port_seed"`
+	MaxBytes            types.Int64             `tfsdk:"max_bytes"`
+	StallTimeout        types.String            `tfsdk:"stall_timeout"`
+	HealthCheckInterval types.String            `tfsdk:"health_check_interval"`
+	Profile             types.String            `tfsdk:"profile"`
+	SNIRoutes           map[string]types.String `tfsdk:"sni_routes"`
+	WarnWindowStalls    types.Bool              `tfsdk:"warn_window_stalls"`
+	PriorityClass       types.String            `tfsdk:"priority_class"`
+}
+
+type ConnectionEphemeralResourceModelDynamicPortForwarding struct {
+	Name           types.String                                 `tfsdk:"name"`
+	LocalPort      types.Int32                                  `tfsdk:"local_port"`
+	Protocol       types.String                                 `tfsdk:"protocol"`
+	MaxConnections types.Int32                                  `tfsdk:"max_connections"`
+	AcceptWorkers  types.Int32                                  `tfsdk:"accept_workers"`
+	FanOutGuard    *ConnectionEphemeralResourceModelFanOutGuard `tfsdk:"fan_out_guard"`
+	ProxyURL       types.String                                 `tfsdk:"proxy_url"`
+}
+
+type ConnectionEphemeralResourceModelFanOutGuard struct {
+	Window      types.String `tfsdk:"window"`
+	MaxRequests types.Int32  `tfsdk:"max_requests"`
+	MaxHosts    types.Int32  `tfsdk:"max_hosts"`
+	MaxPorts    types.Int32  `tfsdk:"max_ports"`
+}
+
+type ConnectionEphemeralResourceModelUDPForwarding struct {
+	LocalPort    types.Int32  `tfsdk:"local_port"`
+	RemoteHost   types.String `tfsdk:"remote_host"`
+	RemotePort   types.Int32  `tfsdk:"remote_port"`
+	RelayCommand types.String `tfsdk:"relay_command"`
+	IdleTimeout  types.String `tfsdk:"idle_timeout"`
+}
+
+type ConnectionEphemeralResourceModelRemotePortForwarding struct {
+	BindAddress    types.String                                   `tfsdk:"bind_address"`
+	RemotePort     types.Int32                                    `tfsdk:"remote_port"`
+	LocalHost      types.String                                   `tfsdk:"local_host"`
+	LocalPort      types.Int32                                    `tfsdk:"local_port"`
+	GrantedAddress types.String                                   `tfsdk:"granted_address"`
+	ConsulService  *ConnectionEphemeralResourceModelConsulService `tfsdk:"consul_service"`
+}
+
+type ConnectionEphemeralResourceModelConsulService struct {
+	Name          types.String   `tfsdk:"name"`
+	Address       types.String   `tfsdk:"address"`
+	Tags          []types.String `tfsdk:"tags"`
+	ConsulAddress types.String   `tfsdk:"consul_address"`
+	ConsulToken   types.String   `tfsdk:"consul_token"`
+}
+
+type ConnectionEphemeralResourceModelRemoteSocketForwarding struct {
+	RemoteSocketPath types.String `tfsdk:"remote_socket_path"`
+	LocalHost        types.String `tfsdk:"local_host"`
+	LocalPort        types.Int32  `tfsdk:"local_port"`
+	LocalSocketPath  types.String `tfsdk:"local_socket_path"`
+}
+
+type ConnectionEphemeralResourceModelHostKey struct {
+	Mode types.String `tfsdk:"mode"`
+}
+
+type ConnectionEphemeralResourceModelGlobalRequest struct {
+	Type     types.String `tfsdk:"type"`
+	Reply    types.Bool   `tfsdk:"reply"`
+	Interval types.String `tfsdk:"interval"`
+}
+
+type ConnectionEphemeralResourceModelAvailabilityWatch struct {
+	Interval types.String `tfsdk:"interval"`
+	Timeout  types.String `tfsdk:"timeout"`
+}
+
+type ConnectionEphemeralResourceModelHeartbeat struct {
+	Command  types.String `tfsdk:"command"`
+	Interval types.String `tfsdk:"interval"`
+}
+
+type ConnectionEphemeralResourceModelPTYSession struct {
+	Command types.String `tfsdk:"command"`
+	Term    types.String `tfsdk:"term"`
 }
 
 type ConnectionEphemeralResourceModelAuth struct {
-	PrivateKey types.String `tfsdk:"private_key"`
+	PrivateKey              types.String                                       `tfsdk:"private_key"`
+	PrivateKeys             []types.String                                     `tfsdk:"private_keys"`
+	PrivateKeyPath          types.String                                       `tfsdk:"private_key_path"`
+	Vault                   *ConnectionEphemeralResourceModelAuthVault         `tfsdk:"vault"`
+	AWS                     *ConnectionEphemeralResourceModelAuthAWS           `tfsdk:"aws"`
+	GCPSecret               *ConnectionEphemeralResourceModelAuthGCPSecret     `tfsdk:"gcp_secret"`
+	AzureKeyVault           *ConnectionEphemeralResourceModelAuthAzureKeyVault `tfsdk:"azure_key_vault"`
+	PrivateKeyRef           types.String                                       `tfsdk:"private_key_ref"`
+	EncryptedPrivateKey     types.String                                       `tfsdk:"encrypted_private_key"`
+	AgeIdentity             types.String                                       `tfsdk:"age_identity"`
+	Passphrase              types.String                                       `tfsdk:"passphrase"`
+	Certificate             types.String                                       `tfsdk:"certificate"`
+	Agent                   types.Bool                                         `tfsdk:"agent"`
+	AgentSocket             types.String                                       `tfsdk:"agent_socket"`
+	AgentIdentity           types.String                                       `tfsdk:"agent_identity"`
+	SecurityKeyTouchTimeout types.String                                       `tfsdk:"security_key_touch_timeout"`
+	VaultSSH                *ConnectionEphemeralResourceModelAuthVaultSSH      `tfsdk:"vault_ssh"`
+	GCPOSLogin              *ConnectionEphemeralResourceModelAuthGCPOSLogin    `tfsdk:"gcp_os_login"`
+	PKCS11                  *ConnectionEphemeralResourceModelAuthPKCS11        `tfsdk:"pkcs11"`
+	Password                types.String                                       `tfsdk:"password"`
+	KeyboardInteractive     types.Bool                                         `tfsdk:"keyboard_interactive"`
+	Methods                 []types.String                                     `tfsdk:"methods"`
+}
+
+type ConnectionEphemeralResourceModelAuthPKCS11 struct {
+	Module     types.String `tfsdk:"module"`
+	TokenLabel types.String `tfsdk:"token_label"`
+	PIN        types.String `tfsdk:"pin"`
+}
+
+type ConnectionEphemeralResourceModelAuthVault struct {
+	Address    types.String                                         `tfsdk:"address"`
+	Namespace  types.String                                         `tfsdk:"namespace"`
+	Mount      types.String                                         `tfsdk:"mount"`
+	Path       types.String                                         `tfsdk:"path"`
+	Field      types.String                                         `tfsdk:"field"`
+	KVVersion  types.Int32                                          `tfsdk:"kv_version"`
+	Token      types.String                                         `tfsdk:"token"`
+	AppRole    *ConnectionEphemeralResourceModelAuthVaultAppRole    `tfsdk:"approle"`
+	Kubernetes *ConnectionEphemeralResourceModelAuthVaultKubernetes `tfsdk:"kubernetes"`
+}
+
+type ConnectionEphemeralResourceModelAuthVaultAppRole struct {
+	Mount    types.String `tfsdk:"mount"`
+	RoleID   types.String `tfsdk:"role_id"`
+	SecretID types.String `tfsdk:"secret_id"`
+}
+
+type ConnectionEphemeralResourceModelAuthVaultKubernetes struct {
+	Mount   types.String `tfsdk:"mount"`
+	Role    types.String `tfsdk:"role"`
+	JWTPath types.String `tfsdk:"jwt_path"`
+}
+
+type ConnectionEphemeralResourceModelAuthAWS struct {
+	SecretID      types.String `tfsdk:"secret_id"`
+	ParameterName types.String `tfsdk:"parameter_name"`
+	Field         types.String `tfsdk:"field"`
+	Region        types.String `tfsdk:"region"`
+	Profile       types.String `tfsdk:"profile"`
+	RoleARN       types.String `tfsdk:"role_arn"`
+}
+
+type ConnectionEphemeralResourceModelAuthGCPSecret struct {
+	Secret   types.String `tfsdk:"secret"`
+	Project  types.String `tfsdk:"project"`
+	Version  types.String `tfsdk:"version"`
+	Field    types.String `tfsdk:"field"`
+	Endpoint types.String `tfsdk:"endpoint"`
+}
+
+type ConnectionEphemeralResourceModelAuthAzureKeyVault struct {
+	VaultURI   types.String `tfsdk:"vault_uri"`
+	SecretName types.String `tfsdk:"secret_name"`
+	Version    types.String `tfsdk:"version"`
+}
+
+type ConnectionEphemeralResourceModelAuthVaultSSH struct {
+	Role            types.String   `tfsdk:"role"`
+	Mount           types.String   `tfsdk:"mount"`
+	ValidPrincipals []types.String `tfsdk:"valid_principals"`
+	TTL             types.String   `tfsdk:"ttl"`
+}
+
+type ConnectionEphemeralResourceModelAuthGCPOSLogin struct {
+	Account  types.String `tfsdk:"account"`
+	Project  types.String `tfsdk:"project"`
+	TTL      types.String `tfsdk:"ttl"`
+	Endpoint types.String `tfsdk:"endpoint"`
+}
+
+type ConnectionEphemeralResourceModelTimings struct {
+	DNS                  types.String   `tfsdk:"dns"`
+	Connect              types.String   `tfsdk:"connect"`
+	Handshake            types.String   `tfsdk:"handshake"`
+	Auth                 types.String   `tfsdk:"auth"`
+	LocalPortForwardings []types.String `tfsdk:"local_port_forwardings"`
+}
+
+type ConnectionEphemeralResourceModelServerHostKey struct {
+	Type              types.String `tfsdk:"type"`
+	PublicKey         types.String `tfsdk:"public_key"`
+	FingerprintSHA256 types.String `tfsdk:"fingerprint_sha256"`
 }
 
 // ConnectionEphemeralResourceModel describes the resource data model.
 type ConnectionEphemeralResourceModel struct {
-	Host                 types.String                                          `tfsdk:"host"`
-	Port                 types.Int32                                           `tfsdk:"port"`
-	User                 types.String                                          `tfsdk:"user"`
-	Auth                 ConnectionEphemeralResourceModelAuth                  `tfsdk:"auth"`
-	LocalPortForwardings []ConnectionEphemeralResourceModelLocalPortForwarding `tfsdk:"local_port_forwardings"`
+	Host                       types.String                                             `tfsdk:"host"`
+	Port                       types.Int32                                              `tfsdk:"port"`
+	SRV                        types.String                                             `tfsdk:"srv"`
+	User                       types.String                                             `tfsdk:"user"`
+	Auth                       ConnectionEphemeralResourceModelAuth                     `tfsdk:"auth"`
+	LocalPortForwardings       []ConnectionEphemeralResourceModelLocalPortForwarding    `tfsdk:"local_port_forwardings"`
+	RemotePortForwardings      []ConnectionEphemeralResourceModelRemotePortForwarding   `tfsdk:"remote_port_forwardings"`
+	RemoteSocketForwardings    []ConnectionEphemeralResourceModelRemoteSocketForwarding `tfsdk:"remote_socket_forwardings"`
+	DynamicPortForwardings     []ConnectionEphemeralResourceModelDynamicPortForwarding  `tfsdk:"dynamic_port_forwardings"`
+	UDPForwardings             []ConnectionEphemeralResourceModelUDPForwarding          `tfsdk:"udp_forwardings"`
+	MaxBytes                   types.Int64                                              `tfsdk:"max_bytes"`
+	Labels                     map[string]types.String                                  `tfsdk:"labels"`
+	ExitOnForwardFailure       types.Bool                                               `tfsdk:"exit_on_forward_failure"`
+	Group                      types.String                                             `tfsdk:"group"`
+	OnFailure                  types.String                                             `tfsdk:"on_failure"`
+	WaitForFirstConnection     types.String                                             `tfsdk:"wait_for_first_connection"`
+	ExecFallback               types.String                                             `tfsdk:"exec_fallback"`
+	Heartbeat                  *ConnectionEphemeralResourceModelHeartbeat               `tfsdk:"heartbeat"`
+	AvailabilityWatch          *ConnectionEphemeralResourceModelAvailabilityWatch       `tfsdk:"availability_watch"`
+	PTYSession                 *ConnectionEphemeralResourceModelPTYSession              `tfsdk:"pty_session"`
+	ReportTimings              types.Bool                                               `tfsdk:"report_timings"`
+	Timings                    *ConnectionEphemeralResourceModelTimings                 `tfsdk:"timings"`
+	SSHConfig                  types.String                                             `tfsdk:"ssh_config"`
+	ConnectionID               types.String                                             `tfsdk:"connection_id"`
+	KnownHostsFile             types.String                                             `tfsdk:"known_hosts_file"`
+	HostKeyFingerprint         types.String                                             `tfsdk:"host_key_fingerprint"`
+	HostCertificateAuthorities []types.String                                           `tfsdk:"host_certificate_authorities"`
+	Definition                 types.Dynamic                                            `tfsdk:"definition"`
+	HostKey                    *ConnectionEphemeralResourceModelHostKey                 `tfsdk:"host_key"`
+	HostKeyAlgorithms          []types.String                                           `tfsdk:"host_key_algorithms"`
+	RevokedHostKeysFile        types.String                                             `tfsdk:"revoked_host_keys_file"`
+	PriorityClasses            map[string]types.Int32                                   `tfsdk:"priority_classes"`
+	GlobalRequests             []ConnectionEphemeralResourceModelGlobalRequest          `tfsdk:"global_requests"`
+	AcceptChannelTypes         []types.String                                           `tfsdk:"accept_channel_types"`
+	ServerHostKey              *ConnectionEphemeralResourceModelServerHostKey           `tfsdk:"server_host_key"`
 }
 
 const (
@@ -60,6 +312,11 @@ const (
 
 type ConnectionPrivateData struct {
 	ID string
+	// Key identifies the tunnel across opens, see tunnelKey.
+	Key string `json:",omitempty"`
+	// LocalPorts are the ports of the local port forwardings, reclaimed when
+	// the tunnel is opened again.
+	LocalPorts []int32 `json:",omitempty"`
 }
 
 func (r *ConnectionEphemeralResource) Metadata(ctx context.Context, req ephemeral.MetadataRequest, resp *ephemeral.MetadataResponse) {
@@ -72,25 +329,332 @@ func (r *ConnectionEphemeralResource) Schema(ctx context.Context, req ephemeral.
 		MarkdownDescription: "The SSH Tunnel connection resource allows creating ephemeral SSH tunnels.",
 
 		Attributes: map[string]schema.Attribute{
+			"definition": schema.DynamicAttribute{
+				MarkdownDescription: "Tunnel definition providing `host`, `port`, `user` and `local_port_forwardings`, e.g. `each.value` of `for_each = provider::sshtunnel::tunnels(var.databases)`, validated like the definitions of the `tunnels` function. " +
+					"An object with `host`, `port` (defaults to `22`), `user` and a non-empty list of `local_port_forwardings` with `remote_host`, `remote_port`, `name` and `local_port`. " +
+					"Conflicts with `host`, `srv`, `port` and `local_port_forwardings`, `user` overrides the user of the definition",
+				Optional: true,
+			},
 			"host": schema.StringAttribute{
-				MarkdownDescription: "Host to connect to",
-				Required:            true,
+				MarkdownDescription: "Host to connect to, internationalized names are converted to punycode. Required unless `srv` or `definition` is set",
+				Optional:            true,
 			},
 			"port": schema.Int32Attribute{
-				MarkdownDescription: "Port to connect to",
-				Required:            true,
+				MarkdownDescription: "Port to connect to, required with `host`",
+				Optional:            true,
+			},
+			"srv": schema.StringAttribute{
+				MarkdownDescription: "DNS SRV name to discover the SSH server from instead of `host` and `port`, e.g. `_ssh._tcp.bastions.example.com`. " +
+					"The targets of the records are tried ordered by priority and randomly by weight within a priority, " +
+					"failing over to the next one if a connection can't be established",
+				Optional: true,
 			},
 			"user": schema.StringAttribute{
-				MarkdownDescription: "User to connect as",
-				Required:            true,
-				Sensitive:           true,
+				MarkdownDescription: "User to connect as. Placeholders are replaced for bastions routing tenants by username, e.g. `deploy-{workspace}`: " +
+					"`{name}` with the label `name` of the connection and `{env.NAME}` with the environment variable `NAME`. " +
+					"Required unless `auth.gcp_os_login` is set, which defaults it to the username of the OS Login profile",
+				Optional:  true,
+				Sensitive: true,
 			},
 			"auth": schema.SingleNestedAttribute{
 				MarkdownDescription: "Authentication details",
 				Attributes: map[string]schema.Attribute{
 					"private_key": schema.StringAttribute{
-						MarkdownDescription: "Private key to use for authentication",
-						Required:            true,
+						MarkdownDescription: "Private key to use for authentication, in OpenSSH, PEM or PuTTY (`.ppk` version 2 or 3) format",
+						Optional:            true,
+					},
+					"private_keys": schema.ListAttribute{
+						MarkdownDescription: "Private keys to use for authentication, offered in order until the server accepts one, e.g. per-environment keys",
+						ElementType:         types.StringType,
+						Optional:            true,
+					},
+					"private_key_path": schema.StringAttribute{
+						MarkdownDescription: "Path of a file containing the private key, read when the tunnel is opened so the key isn't part of the configuration. " +
+							"A leading `~` is expanded to the home directory. `%h`, `%p` and `%r` are replaced by the host (the SRV name with `srv`), port and user of the connection, `%C` by a hash of them and the local host name, like OpenSSH, e.g. `~/.ssh/%h_%r` for per-server keys",
+						Optional: true,
+					},
+					"private_key_ref": schema.StringAttribute{
+						MarkdownDescription: "Reference to a secret containing the private key, fetched using ambient credentials when the tunnel is opened. " +
+							"Supported are `vault:<path>#<field>` (Vault KV, e.g. `vault:secret/data/ssh#private_key`), " +
+							"`aws-secretsmanager:<secret-id>[#<field>]` and `aws-ssm:<parameter-name>[#<field>]`",
+						Optional: true,
+					},
+					"vault": schema.SingleNestedAttribute{
+						MarkdownDescription: "Read the private key from a secret of a Vault KV secrets engine when the tunnel is opened. " +
+							"Vault is accessed using `VAULT_ADDR`, `VAULT_TOKEN` (or `~/.vault-token`) and `VAULT_NAMESPACE` unless set here. " +
+							"Resolved like a `vault:` `private_key_ref` with explicit access configuration",
+						Attributes: map[string]schema.Attribute{
+							"address": schema.StringAttribute{
+								MarkdownDescription: "Address of Vault (defaults to `VAULT_ADDR`)",
+								Optional:            true,
+							},
+							"namespace": schema.StringAttribute{
+								MarkdownDescription: "Vault Enterprise namespace (defaults to `VAULT_NAMESPACE`)",
+								Optional:            true,
+							},
+							"mount": schema.StringAttribute{
+								MarkdownDescription: "Path the KV secrets engine is mounted at (defaults to `secret`)",
+								Optional:            true,
+							},
+							"path": schema.StringAttribute{
+								MarkdownDescription: "Path of the secret within the KV secrets engine, e.g. `ssh/bastion`",
+								Required:            true,
+							},
+							"field": schema.StringAttribute{
+								MarkdownDescription: "Field of the secret containing the private key (defaults to `private_key`)",
+								Optional:            true,
+							},
+							"kv_version": schema.Int32Attribute{
+								MarkdownDescription: "Version of the KV secrets engine, `1` or `2` (defaults to `2`)",
+								Optional:            true,
+							},
+							"token": schema.StringAttribute{
+								MarkdownDescription: "Vault token (defaults to `VAULT_TOKEN` or `~/.vault-token`)",
+								Optional:            true,
+								Sensitive:           true,
+							},
+							"approle": schema.SingleNestedAttribute{
+								MarkdownDescription: "Log in to Vault using the AppRole auth method instead of a token",
+								Attributes: map[string]schema.Attribute{
+									"mount": schema.StringAttribute{
+										MarkdownDescription: "Path the auth method is mounted at (defaults to `approle`)",
+										Optional:            true,
+									},
+									"role_id": schema.StringAttribute{
+										MarkdownDescription: "Role ID",
+										Required:            true,
+									},
+									"secret_id": schema.StringAttribute{
+										MarkdownDescription: "Secret ID",
+										Required:            true,
+										Sensitive:           true,
+									},
+								},
+								Optional: true,
+							},
+							"kubernetes": schema.SingleNestedAttribute{
+								MarkdownDescription: "Log in to Vault using the Kubernetes auth method with the service account token of the pod instead of a token",
+								Attributes: map[string]schema.Attribute{
+									"mount": schema.StringAttribute{
+										MarkdownDescription: "Path the auth method is mounted at (defaults to `kubernetes`)",
+										Optional:            true,
+									},
+									"role": schema.StringAttribute{
+										MarkdownDescription: "Role to log in with",
+										Required:            true,
+									},
+									"jwt_path": schema.StringAttribute{
+										MarkdownDescription: "Path of the service account token (defaults to `/var/run/secrets/kubernetes.io/serviceaccount/token`)",
+										Optional:            true,
+									},
+								},
+								Optional: true,
+							},
+						},
+						Optional: true,
+					},
+					"aws": schema.SingleNestedAttribute{
+						MarkdownDescription: "Read the private key from AWS Secrets Manager or an SSM Parameter Store (SecureString) parameter when the tunnel is opened. " +
+							"AWS is accessed using the ambient credential chain, e.g. `AWS_PROFILE`, environment credentials or an instance role. " +
+							"Resolved like an `aws-secretsmanager:` or `aws-ssm:` `private_key_ref` with explicit access configuration",
+						Attributes: map[string]schema.Attribute{
+							"secret_id": schema.StringAttribute{
+								MarkdownDescription: "Name or ARN of the Secrets Manager secret, conflicts with `parameter_name`",
+								Optional:            true,
+							},
+							"parameter_name": schema.StringAttribute{
+								MarkdownDescription: "Name or ARN of the SSM parameter, conflicts with `secret_id`",
+								Optional:            true,
+							},
+							"field": schema.StringAttribute{
+								MarkdownDescription: "Key of a JSON secret containing the private key (defaults to the whole secret)",
+								Optional:            true,
+							},
+							"region": schema.StringAttribute{
+								MarkdownDescription: "AWS region (defaults to `AWS_REGION` or the region of the profile)",
+								Optional:            true,
+							},
+							"profile": schema.StringAttribute{
+								MarkdownDescription: "Shared config profile (defaults to `AWS_PROFILE`)",
+								Optional:            true,
+							},
+							"role_arn": schema.StringAttribute{
+								MarkdownDescription: "ARN of a role to assume with the ambient credentials to read the secret",
+								Optional:            true,
+							},
+						},
+						Optional: true,
+					},
+					"gcp_secret": schema.SingleNestedAttribute{
+						MarkdownDescription: "Read the private key from a secret version of GCP Secret Manager when the tunnel is opened. " +
+							"GCP is accessed using the application default credentials, e.g. `GOOGLE_APPLICATION_CREDENTIALS` or the service account of a GCE instance",
+						Attributes: map[string]schema.Attribute{
+							"secret": schema.StringAttribute{
+								MarkdownDescription: "Secret ID or resource name of the secret, e.g. `projects/<project>/secrets/<secret>`, optionally including `/versions/<version>`",
+								Required:            true,
+							},
+							"project": schema.StringAttribute{
+								MarkdownDescription: "Project of a secret ID (defaults to the project of the application default credentials)",
+								Optional:            true,
+							},
+							"version": schema.StringAttribute{
+								MarkdownDescription: "Version of the secret (defaults to `latest`)",
+								Optional:            true,
+							},
+							"field": schema.StringAttribute{
+								MarkdownDescription: "Key of a JSON secret containing the private key (defaults to the whole secret)",
+								Optional:            true,
+							},
+							"endpoint": schema.StringAttribute{
+								MarkdownDescription: "Secret Manager API endpoint, e.g. a Private Service Connect endpoint (defaults to the global endpoint or the regional endpoint of regional secrets)",
+								Optional:            true,
+							},
+						},
+						Optional: true,
+					},
+					"azure_key_vault": schema.SingleNestedAttribute{
+						MarkdownDescription: "Read the private key from an Azure Key Vault secret when the tunnel is opened. " +
+							"Azure is accessed using the default credential chain, e.g. `AZURE_CLIENT_ID`, a managed identity or the Azure CLI login",
+						Attributes: map[string]schema.Attribute{
+							"vault_uri": schema.StringAttribute{
+								MarkdownDescription: "URI of the vault, e.g. `https://<vault>.vault.azure.net`",
+								Required:            true,
+							},
+							"secret_name": schema.StringAttribute{
+								MarkdownDescription: "Name of the secret",
+								Required:            true,
+							},
+							"version": schema.StringAttribute{
+								MarkdownDescription: "Version of the secret (defaults to the current version)",
+								Optional:            true,
+							},
+						},
+						Optional: true,
+					},
+					"encrypted_private_key": schema.StringAttribute{
+						MarkdownDescription: "Private key encrypted with [age](https://age-encryption.org) (binary or armored) or a SOPS encrypted JSON document " +
+							"in binary format, decrypted in memory when the tunnel is opened",
+						Optional: true,
+					},
+					"age_identity": schema.StringAttribute{
+						MarkdownDescription: "age identities used to decrypt `encrypted_private_key` " +
+							"(defaults to `SOPS_AGE_KEY`, `SOPS_AGE_KEY_FILE` or the default SOPS age key file)",
+						Optional: true,
+					},
+					"passphrase": schema.StringAttribute{
+						MarkdownDescription: "Passphrase of the private key, if it is protected by one",
+						Optional:            true,
+					},
+					"certificate": schema.StringAttribute{
+						MarkdownDescription: "OpenSSH certificate signed by a CA trusted by the server (the contents of a `-cert.pub` file), used together with the private key",
+						Optional:            true,
+					},
+					"agent": schema.BoolAttribute{
+						MarkdownDescription: "Use the keys of the local SSH agent reachable via `SSH_AUTH_SOCK`, on Windows defaulting to the OpenSSH agent service, e.g. for keys on hardware tokens. " +
+							"Can be combined with a private key, which is offered first. " +
+							"FIDO2 security keys (`sk-ssh-ed25519@openssh.com` and `sk-ecdsa-sha2-nistp256@openssh.com`) are supported through the agent only, add them with `ssh-add`",
+						Optional: true,
+					},
+					"agent_socket": schema.StringAttribute{
+						MarkdownDescription: "Socket of the SSH agent to use instead of `SSH_AUTH_SOCK`, e.g. of 1Password (`~/.1password/agent.sock`) or gpg-agent, like OpenSSH's `IdentityAgent`. " +
+							"On Windows, named pipes (`\\\\.\\pipe\\...`) are supported as well. " +
+							"A leading `~` is expanded to the home directory, `%h`, `%p`, `%r` and `%C` are replaced like in `private_key_path`. Requires `agent`",
+						Optional: true,
+					},
+					"agent_identity": schema.StringAttribute{
+						MarkdownDescription: "Only offer the key of the SSH agent with this comment or SHA256 fingerprint (`SHA256:...`) instead of all its keys, " +
+							"e.g. for agents holding more keys than the server allows authentication attempts. Requires `agent`",
+						Optional: true,
+					},
+					"security_key_touch_timeout": schema.StringAttribute{
+						MarkdownDescription: "Time to wait for a FIDO2 security key of the agent to be touched before authentication fails (defaults to `30s`). " +
+							"Set `TF_LOG=info` to be reminded to tap the key",
+						Optional: true,
+					},
+					"vault_ssh": schema.SingleNestedAttribute{
+						MarkdownDescription: "Generate a key when the tunnel is opened and authenticate with a certificate for it signed by the " +
+							"[SSH secrets engine](https://developer.hashicorp.com/vault/docs/secrets/ssh/signed-ssh-certificates) of Vault, so no long-lived key is needed. " +
+							"Vault is accessed using `VAULT_ADDR`, `VAULT_TOKEN` (or `~/.vault-token`) and `VAULT_NAMESPACE`",
+						Attributes: map[string]schema.Attribute{
+							"role": schema.StringAttribute{
+								MarkdownDescription: "Role to sign the key with, it has to allow `ed25519` keys",
+								Required:            true,
+							},
+							"mount": schema.StringAttribute{
+								MarkdownDescription: "Path the SSH secrets engine is mounted at (defaults to `ssh`)",
+								Optional:            true,
+							},
+							"valid_principals": schema.ListAttribute{
+								MarkdownDescription: "Users the certificate is valid for (defaults to the default user of the role)",
+								ElementType:         types.StringType,
+								Optional:            true,
+							},
+							"ttl": schema.StringAttribute{
+								MarkdownDescription: "Validity of the certificate, e.g. `5m` (defaults to the TTL of the role)",
+								Optional:            true,
+							},
+						},
+						Optional: true,
+					},
+					"gcp_os_login": schema.SingleNestedAttribute{
+						MarkdownDescription: "Generate a key when the tunnel is opened and add it with an expiry to the " +
+							"[OS Login](https://cloud.google.com/compute/docs/oslogin) profile of a Google account, for GCE instances enforcing OS Login. " +
+							"The OS Login API is accessed using the application default credentials, which need `roles/compute.osAdminLogin` or `roles/compute.osLogin` on the instance",
+						Attributes: map[string]schema.Attribute{
+							"account": schema.StringAttribute{
+								MarkdownDescription: "Email address of the user or service account to add the key to (defaults to the account of the application default credentials)",
+								Optional:            true,
+							},
+							"project": schema.StringAttribute{
+								MarkdownDescription: "Project of the instances, selecting the POSIX account (defaults to the project of the application default credentials)",
+								Optional:            true,
+							},
+							"ttl": schema.StringAttribute{
+								MarkdownDescription: "Time until the key is removed from the profile again, e.g. `10m` (defaults to `1h`)",
+								Optional:            true,
+							},
+							"endpoint": schema.StringAttribute{
+								MarkdownDescription: "OS Login API endpoint, e.g. a Private Service Connect endpoint (defaults to `https://oslogin.googleapis.com`)",
+								Optional:            true,
+							},
+						},
+						Optional: true,
+					},
+					"pkcs11": schema.SingleNestedAttribute{
+						MarkdownDescription: "Use the RSA and ECDSA private keys of a PKCS#11 token, e.g. a smartcard or an HSM, without the keys leaving the token. " +
+							"Requires a provider built with cgo, the release binaries are not, use `agent` with `ssh-add -s <module>` instead",
+						Attributes: map[string]schema.Attribute{
+							"module": schema.StringAttribute{
+								MarkdownDescription: "Path of the PKCS#11 module, e.g. `/usr/lib/softhsm/libsofthsm2.so`. A leading `~` is expanded to the home directory",
+								Required:            true,
+							},
+							"token_label": schema.StringAttribute{
+								MarkdownDescription: "Label of the token to use, required if the module provides more than one token",
+								Optional:            true,
+							},
+							"pin": schema.StringAttribute{
+								MarkdownDescription: "User PIN of the token",
+								Optional:            true,
+								Sensitive:           true,
+							},
+						},
+						Optional: true,
+					},
+					"password": schema.StringAttribute{
+						MarkdownDescription: "Password to use for authentication, e.g. for appliances and bastions only allowing password logins. " +
+							"By default offered after any key based authentication method",
+						Optional: true,
+					},
+					"keyboard_interactive": schema.BoolAttribute{
+						MarkdownDescription: "Answer the prompts of keyboard-interactive authentication, e.g. of sshd using PAM, with `password`",
+						Optional:            true,
+					},
+					"methods": schema.ListAttribute{
+						MarkdownDescription: "Order in which the configured authentication methods are offered, e.g. `[\"agent\", \"private_key\", \"password\"]`. " +
+							"Supported are `private_key`, `agent`, `vault_ssh`, `gcp_os_login`, `pkcs11`, `password` and `keyboard_interactive`, all configured methods have to be listed. " +
+							"Defaults to the order given here. The keys of `private_key`, `agent`, `vault_ssh`, `gcp_os_login` and `pkcs11` are offered together at the position of the first of them",
+						ElementType: types.StringType,
+						Optional:    true,
 					},
 				},
 				Required:  true,
@@ -100,18 +664,40 @@ func (r *ConnectionEphemeralResource) Schema(ctx context.Context, req ephemeral.
 				MarkdownDescription: "Local port forwardings",
 				NestedObject: schema.NestedAttributeObject{
 					Attributes: map[string]schema.Attribute{
-						"local_port": schema.Int32Attribute{
-							MarkdownDescription: "Local port to forward to (random if not specified)",
+						"name": schema.StringAttribute{
+							MarkdownDescription: "Name the local address of the forwarding, or its `local_socket_path`, is published under, resolved with `provider::sshtunnel::endpoint(connection_id, name)`. Unique within the connection",
 							Optional:            true,
-							Computed:            true,
+						},
+						"local_port": schema.Int32Attribute{
+							MarkdownDescription: "Local port to forward to (random if not specified). Random ports differ between each open, e.g. plan and apply, use `local_port_seed` for stable ports. " +
+								"Within the same provider process, e.g. when Terraform retries opening a tunnel, the previous random port is reused if it is still free. " +
+								"Ports below 1024 require privileges, on Linux granted to the provider binary with `sudo terraform-provider-sshtunnel setcap`",
+							Optional: true,
+							Computed: true,
+						},
+						"local_socket_path": schema.StringAttribute{
+							MarkdownDescription: "Listen on a Unix socket at this path instead of a local port, e.g. for `host = \"unix://...\"` of the Docker provider, so forwardings on shared runners don't compete for ports. " +
+								"The socket is only accessible by the user running Terraform and removed when the tunnel is closed, a socket left behind by a crashed run is replaced. " +
+								"A leading `~` is expanded to the home directory, `%h`, `%p`, `%r` and `%C` are replaced like in `auth.private_key_path`",
+							Optional: true,
 						},
 						"remote_host": schema.StringAttribute{
-							MarkdownDescription: "Remote host to forward to",
-							Required:            true,
+							MarkdownDescription: "Remote host to forward to, required unless set by the `profile` or `remote_socket_path` is set. Internationalized names are converted to punycode. " +
+								"The SSH server resolves and connects to the host, so the zone of an IPv6 link-local address, e.g. `fe80::1%eth0`, names an interface of the SSH server",
+							Optional: true,
 						},
 						"remote_port": schema.Int32Attribute{
-							MarkdownDescription: "Remote port to forward to",
-							Required:            true,
+							MarkdownDescription: "Remote port to forward to, required unless set by the `profile` or `remote_socket_path` is set",
+							Optional:            true,
+						},
+						"remote_socket_path": schema.StringAttribute{
+							MarkdownDescription: "Unix socket on the SSH server to forward to instead of `remote_host` and `remote_port`, e.g. `/var/run/docker.sock` or `/var/run/postgresql/.s.PGSQL.5432` (`direct-streamlocal@openssh.com`). " +
+								"`%h`, `%p`, `%r` and `%C` are replaced like in `auth.private_key_path`. Not allowed with the provider `policy.target_allow_list`",
+							Optional: true,
+						},
+						"profile": schema.StringAttribute{
+							MarkdownDescription: "Name of a provider `forwarding_profiles` entry providing defaults for the forwarding",
+							Optional:            true,
 						},
 						"retry_attempts": schema.Int32Attribute{
 							MarkdownDescription: "Number of attempts to establish the connection",
@@ -121,9 +707,454 @@ func (r *ConnectionEphemeralResource) Schema(ctx context.Context, req ephemeral.
 							MarkdownDescription: "Delay between connection attempts",
 							Optional:            true,
 						},
+						"accept_workers": schema.Int32Attribute{
+							MarkdownDescription: "Number of workers accepting local connections concurrently, e.g. for highly parallel clients opening many connections at once (1 if not specified)",
+							Optional:            true,
+						},
+						"listen_backlog": schema.Int32Attribute{
+							MarkdownDescription: "Size of the queue of pending local connections (operating system default if not specified)",
+							Optional:            true,
+						},
+						"local_port_seed": schema.StringAttribute{
+							MarkdownDescription: "Seed to deterministically derive the local port from instead of picking a random one, " +
+								"the first free port of a fixed sequence between 10000 and 32767 is used",
+							Optional: true,
+						},
+						"max_connections": schema.Int32Attribute{
+							MarkdownDescription: "Maximum number of connections forwarded concurrently, further connections wait in the listen backlog (unlimited if not specified)",
+							Optional:            true,
+						},
+						"max_bytes": schema.Int64Attribute{
+							MarkdownDescription: "Maximum number of bytes forwarded in both directions, the whole tunnel is closed with an error once exceeded (unlimited if not specified)",
+							Optional:            true,
+						},
+						"stall_timeout": schema.StringAttribute{
+							MarkdownDescription: "Close forwarded connections making no progress for this long while data sent to the remote awaits a response or a write is blocked, " +
+								"e.g. because the remote is black-holed, so hung operations fail instead of hanging forever. " +
+								"Has to exceed the longest expected response time. Idle connections are not affected (disabled if not specified)",
+							Optional: true,
+						},
+						"health_check_interval": schema.StringAttribute{
+							MarkdownDescription: "Periodically open a new channel to the remote host, so the SSH server resolves its name again, " +
+								"and report a warning naming the remote host when it stopped resolving or refused connections " +
+								fmt.Sprintf("%d times in a row, e.g. an internal name removed mid-apply (disabled if not specified)", targetFailureThreshold),
+							Optional: true,
+						},
+						"sni_routes": schema.MapAttribute{
+							MarkdownDescription: "Route TLS connections by the server name (SNI) of their ClientHello to other remote targets as `host:port`, " +
+								"e.g. `{\"api.internal\" = \"10.0.0.5:443\"}`, so one local port serves many TLS services through the same SSH connection. " +
+								"`*.example.com` matches any direct subdomain. Connections matching no server name are forwarded to `remote_host` and `remote_port`. " +
+								"The TLS handshake is passed through, clients verify the certificates of the remote targets",
+							ElementType: types.StringType,
+							Optional:    true,
+						},
+						"warn_window_stalls": schema.BoolAttribute{
+							MarkdownDescription: "Report a warning when the tunnel is closed if the forwarded connections spent most of their time waiting for the SSH server to accept more data (SSH channel window), " +
+								"i.e. the transfer was throttled by the SSH server or the link to it rather than the remote target",
+							Optional: true,
+						},
+						"priority_class": schema.StringAttribute{
+							MarkdownDescription: "Priority class sharing the SSH connection with the other forwardings, `interactive` or `bulk` or one of `priority_classes`. " +
+								"Once any forwarding sets a class, forwardings without one are `interactive`",
+							Optional: true,
+						},
+					},
+				},
+				Optional: true,
+			},
+			"dynamic_port_forwardings": schema.ListNestedAttribute{
+				MarkdownDescription: "Local SOCKS5 or HTTP CONNECT proxies forwarding connections to any destination through the SSH server, like `ssh -D`, e.g. for providers accepting a proxy URL rather than a fixed address. " +
+					"They only listen on `127.0.0.1`, as clients can't authenticate. Host names are resolved by the SSH server. Not allowed with the provider `policy.target_allow_list`, as the destinations aren't known in advance",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"name": schema.StringAttribute{
+							MarkdownDescription: "Name the local address of the proxy is published under, resolved with `provider::sshtunnel::endpoint(connection_id, name)`. Unique within the connection, also among `local_port_forwardings`",
+							Optional:            true,
+						},
+						"local_port": schema.Int32Attribute{
+							MarkdownDescription: "Local port of the proxy (random if not specified)",
+							Optional:            true,
+							Computed:            true,
+						},
+						"protocol": schema.StringAttribute{
+							MarkdownDescription: "Protocol of the proxy, `" + dynamicProtocolSOCKS5 + "` (default) or `" + dynamicProtocolHTTP + "` for an HTTP proxy only supporting the CONNECT method, e.g. for tools only accepting `http://` URLs in `HTTPS_PROXY`",
+							Optional:            true,
+						},
+						"accept_workers": schema.Int32Attribute{
+							MarkdownDescription: "Number of workers accepting local connections concurrently, e.g. for highly parallel clients opening many connections at once (1 if not specified)",
+							Optional:            true,
+						},
+						"max_connections": schema.Int32Attribute{
+							MarkdownDescription: "Maximum number of connections forwarded concurrently, further connections wait in the listen backlog (unlimited if not specified)",
+							Optional:            true,
+						},
+						"fan_out_guard": schema.SingleNestedAttribute{
+							MarkdownDescription: "Close the tunnel with an error once a client of the proxy fans out across too many destinations within `window`, e.g. a compromised tool scanning the private network. " +
+								"The request exceeding a limit is refused. Requested destinations are logged at the `INFO` level either way",
+							Attributes: map[string]schema.Attribute{
+								"window": schema.StringAttribute{
+									MarkdownDescription: "Duration requested destinations are counted for (defaults to `1m`)",
+									Optional:            true,
+								},
+								"max_requests": schema.Int32Attribute{
+									MarkdownDescription: "Maximum number of connections requested within `window` (unlimited if not specified)",
+									Optional:            true,
+								},
+								"max_hosts": schema.Int32Attribute{
+									MarkdownDescription: "Maximum number of distinct hosts requested within `window` (unlimited if not specified)",
+									Optional:            true,
+								},
+								"max_ports": schema.Int32Attribute{
+									MarkdownDescription: "Maximum number of distinct ports requested within `window`, across all hosts (unlimited if not specified)",
+									Optional:            true,
+								},
+							},
+							Optional: true,
+						},
+						"proxy_url": schema.StringAttribute{
+							MarkdownDescription: "URL of the proxy, e.g. `socks5h://127.0.0.1:1080` or with `protocol` `http` `http://127.0.0.1:3128`, for `proxy_url` of the Kubernetes provider or `HTTPS_PROXY`",
+							Computed:            true,
+						},
+					},
+				},
+				Optional: true,
+			},
+			"udp_forwardings": schema.ListNestedAttribute{
+				MarkdownDescription: "Local UDP ports forwarding datagrams through a relay command run on the SSH server, e.g. to query DNS or syslog servers of the private network during apply, as SSH only forwards TCP. " +
+					"Every local client gets its own relay, its datagrams are written to the stdin of the command and its stdout is sent back as datagrams. " +
+					"Datagrams arriving back to back may be merged by the relay. They only listen on `" + udpListenHost + "`",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"local_port": schema.Int32Attribute{
+							MarkdownDescription: "Local UDP port (random if not specified)",
+							Optional:            true,
+							Computed:            true,
+						},
+						"remote_host": schema.StringAttribute{
+							MarkdownDescription: "Remote host to forward datagrams to, as resolved by the SSH server",
+							Required:            true,
+						},
+						"remote_port": schema.Int32Attribute{
+							MarkdownDescription: "Remote UDP port to forward datagrams to",
+							Required:            true,
+						},
+						"relay_command": schema.StringAttribute{
+							MarkdownDescription: "Command run on the SSH server relaying between its stdio and the remote address (defaults to `" + defaultUDPRelayCommand + "`). " +
+								"`%h` is replaced by the shell quoted remote host, `%p` by the remote port, like in `exec_fallback`",
+							Optional: true,
+						},
+						"idle_timeout": schema.StringAttribute{
+							MarkdownDescription: "Time after which the relay of a local client without datagrams in either direction is stopped (defaults to `" + portforward.DefaultUDPIdleTimeout.String() + "`)",
+							Optional:            true,
+						},
+					},
+				},
+				Optional: true,
+			},
+			"remote_port_forwardings": schema.ListNestedAttribute{
+				MarkdownDescription: "Ports opened on the SSH server forwarding connections back to a local address, like `ssh -R`, e.g. to expose a service of the machine running Terraform to the private network during apply (`tcpip-forward`)",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"bind_address": schema.StringAttribute{
+							MarkdownDescription: "IP address to listen on on the SSH server (defaults to `" + defaultRemoteBindAddress + "`). `0.0.0.0` or `::` listen on all interfaces, " +
+								"which requires `GatewayPorts clientspecified` in the sshd_config of the server, with `GatewayPorts no` it listens on the loopback interface regardless",
+							Optional: true,
+						},
+						"remote_port": schema.Int32Attribute{
+							MarkdownDescription: "Port to listen on on the SSH server (allocated by the server if not specified or `0`)",
+							Optional:            true,
+							Computed:            true,
+						},
+						"granted_address": schema.StringAttribute{
+							MarkdownDescription: "Address the SSH server granted the forwarding, `bind_address` with the port of the `tcpip-forward` reply, e.g. `127.0.0.1:43817`, to hand to clients on the private network. A wildcard `bind_address` (empty, `0.0.0.0` or `::`) is replaced by the IP address the SSH server was connected to. " +
+								"The reply carries no address, with `GatewayPorts no` or `yes` the server listens on the loopback interface or all interfaces regardless of `bind_address`",
+							Computed: true,
+						},
+						"consul_service": schema.SingleNestedAttribute{
+							MarkdownDescription: "Register the forwarding as a service with a Consul agent while the tunnel is open, so services of the private network discover it through the Consul catalog or DNS, e.g. `name.service.consul`. " +
+								"The service is deregistered when the tunnel is closed",
+							Attributes: map[string]schema.Attribute{
+								"name": schema.StringAttribute{
+									MarkdownDescription: "Name of the service",
+									Required:            true,
+								},
+								"address": schema.StringAttribute{
+									MarkdownDescription: "Address of the service (defaults to the IP address of `granted_address`)",
+									Optional:            true,
+								},
+								"tags": schema.ListAttribute{
+									MarkdownDescription: "Tags of the service",
+									ElementType:         types.StringType,
+									Optional:            true,
+								},
+								"consul_address": schema.StringAttribute{
+									MarkdownDescription: "Address of the HTTP API of the Consul agent (defaults to the `" + consul.AddrEnv + "` environment variable, or `" + consul.DefaultAddr + "`)",
+									Optional:            true,
+								},
+								"consul_token": schema.StringAttribute{
+									MarkdownDescription: "ACL token to register the service with (defaults to the `" + consul.TokenEnv + "` environment variable)",
+									Optional:            true,
+									Sensitive:           true,
+								},
+							},
+							Optional: true,
+						},
+						"local_host": schema.StringAttribute{
+							MarkdownDescription: "Local host to forward to",
+							Required:            true,
+						},
+						"local_port": schema.Int32Attribute{
+							MarkdownDescription: "Local port to forward to",
+							Required:            true,
+						},
+					},
+				},
+				Optional: true,
+			},
+			"remote_socket_forwardings": schema.ListNestedAttribute{
+				MarkdownDescription: "Unix sockets created on the SSH server forwarding connections back to a local address (`streamlocal-forward@openssh.com`)",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"remote_socket_path": schema.StringAttribute{
+							MarkdownDescription: "Path of the Unix socket to create on the SSH server. `%h`, `%p` and `%r` are replaced by the host (the SRV name with `srv`), port and user of the connection, `%C` by a hash of them and the local host name",
+							Required:            true,
+						},
+						"local_host": schema.StringAttribute{
+							MarkdownDescription: "Local host to forward to, required unless `local_socket_path` is set",
+							Optional:            true,
+						},
+						"local_port": schema.Int32Attribute{
+							MarkdownDescription: "Local port to forward to, required unless `local_socket_path` is set",
+							Optional:            true,
+						},
+						"local_socket_path": schema.StringAttribute{
+							MarkdownDescription: "Local Unix socket to forward to instead of `local_host` and `local_port`, e.g. to expose an agent of the machine running Terraform on the SSH server for the duration of the run. " +
+								"A leading `~` is expanded to the home directory, `%h`, `%p`, `%r` and `%C` are replaced like in `auth.private_key_path`",
+							Optional: true,
+						},
+					},
+				},
+				Optional: true,
+			},
+			"labels": schema.MapAttribute{
+				MarkdownDescription: "Labels describing the connection, e.g. a change ticket required by the provider `policy`",
+				ElementType:         types.StringType,
+				Optional:            true,
+			},
+			"known_hosts_file": schema.StringAttribute{
+				MarkdownDescription: "Verify the host key of the SSH server against this OpenSSH known_hosts file instead of `~/.ssh/known_hosts`, in addition to the system-wide one with the provider `system_known_hosts`. It has to exist in `host_key.mode` `strict`. " +
+					"Hashed host names and `[host]:port` entries for non-standard ports are supported. Connections to unknown hosts or hosts presenting a different key fail, naming the fingerprint the server presented. " +
+					"A leading `~` is expanded to the home directory, `%h`, `%p`, `%r` and `%C` are replaced like in `auth.private_key_path`",
+				Optional: true,
+			},
+			"host_certificate_authorities": schema.ListAttribute{
+				MarkdownDescription: "Public keys of CAs in OpenSSH format, e.g. `ssh-ed25519 AAAA...`, trusted to sign host certificates, like `@cert-authority` entries of known_hosts. " +
+					"Host certificates have to be signed by one of them, list the host name connected to as a principal and be valid at the time of connecting. " +
+					"Servers presenting plain host keys are verified by `host_key.mode` instead",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"host_key": schema.SingleNestedAttribute{
+				MarkdownDescription: "How the host key of the SSH server is verified",
+				Attributes: map[string]schema.Attribute{
+					"mode": schema.StringAttribute{
+						MarkdownDescription: "`strict` only accepts host keys in known_hosts: `known_hosts_file` (defaults to `~/.ssh/known_hosts`) and, with the provider `system_known_hosts`, the system-wide ones. " +
+							"`accept-new` additionally adds the keys of unknown hosts to `known_hosts_file` or `~/.ssh/known_hosts`, but rejects changed keys, like OpenSSH's `StrictHostKeyChecking accept-new`. " +
+							"`fingerprint` only accepts the key of `host_key_fingerprint`. `insecure` accepts any host key. " +
+							"Defaults to `fingerprint` with `host_key_fingerprint`, otherwise `strict` (before 0.1.0 any host key was accepted, see the [upgrade guide](../guides/upgrading-host-key-verification)). Host certificates signed by `host_certificate_authorities` are accepted in all modes but `insecure`",
+						Optional: true,
+					},
+				},
+				Optional: true,
+			},
+			"host_key_algorithms": schema.ListAttribute{
+				MarkdownDescription: "Host key algorithms accepted from the SSH server, in order of preference, like OpenSSH's `HostKeyAlgorithms`, e.g. `[\"ssh-ed25519-cert-v01@openssh.com\", \"ssh-ed25519\"]` to require ed25519 keys or `[\"ssh-rsa\"]` for appliances only presenting legacy keys. " +
+					"Supported are `" + strings.Join(supportedHostKeyAlgorithms, "`, `") + "`. Defaults to all of them",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"revoked_host_keys_file": schema.StringAttribute{
+				MarkdownDescription: "Refuse the host keys listed in this file, one public key per line in the `authorized_keys` format, like OpenSSH's `RevokedHostKeys`, even if they are in known_hosts or match `host_key_fingerprint`. " +
+					"Host certificates are refused if their key or the CA that signed it is listed. Connecting fails if the file can't be read. A leading `~` is expanded to the home directory. " +
+					"`@revoked` entries of known_hosts are always honored",
+				Optional: true,
+			},
+			"host_key_fingerprint": schema.StringAttribute{
+				MarkdownDescription: "Only accept the SSH server presenting the host key with this SHA256 fingerprint, e.g. `SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s` as printed by `ssh-keygen -lf`, instead of verifying it against known_hosts. " +
+					"Conflicts with `known_hosts_file`",
+				Optional: true,
+			},
+			"global_requests": schema.ListNestedAttribute{
+				MarkdownDescription: "Vendor-specific global requests, e.g. proprietary keepalives of appliances that close connections whose requests are rejected. " +
+					"Requests of other types sent by the server are rejected",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"type": schema.StringAttribute{
+							MarkdownDescription: "Type of the request, e.g. `keepalive@vendor.example.com`",
+							Required:            true,
+						},
+						"reply": schema.BoolAttribute{
+							MarkdownDescription: "Whether requests of this type sent by the server succeed (default `true`)",
+							Optional:            true,
+						},
+						"interval": schema.StringAttribute{
+							MarkdownDescription: "Also send requests of this type to the server at this interval, e.g. `30s`. Rejected requests are ignored",
+							Optional:            true,
+						},
+					},
+				},
+				Optional: true,
+			},
+			"accept_channel_types": schema.ListAttribute{
+				MarkdownDescription: "Vendor-specific channel types opened by the server that are accepted, their data is discarded. Channels of other types are rejected",
+				ElementType:         types.StringType,
+				Optional:            true,
+			},
+			"priority_classes": schema.MapAttribute{
+				MarkdownDescription: "Weights of the priority classes of forwardings, overriding or extending the default classes `interactive` (weight `8`) and `bulk` (weight `1`). " +
+					"While the SSH connection is saturated, forwardings take turns by weighted fair queuing, so a class with weight 8 forwards eight times the bytes of a class with weight 1, " +
+					"e.g. a bulk data copy doesn't starve the Kubernetes API used by the same apply. Idle classes don't take anything away",
+				ElementType: types.Int32Type,
+				Optional:    true,
+			},
+			"max_bytes": schema.Int64Attribute{
+				MarkdownDescription: "Maximum number of bytes forwarded in both directions by all forwardings, the tunnel is closed with an error once exceeded (unlimited if not specified). " +
+					"A guardrail against runaway transfers, e.g. accidental full-table dumps",
+				Optional: true,
+			},
+			"exit_on_forward_failure": schema.BoolAttribute{
+				MarkdownDescription: "Whether a single failed forwarding fails opening the tunnel (default `true`). " +
+					"When disabled, failed forwardings are reported as warnings and left out, like OpenSSH's `ExitOnForwardFailure=no`",
+				Optional: true,
+			},
+			"on_failure": schema.StringAttribute{
+				MarkdownDescription: "What to do if the tunnel can't be established: `error` (default) fails the run, `warn` reports a warning and returns placeholder values " +
+					"(the configured or seeded `local_port`, otherwise `0`), e.g. for optional observability tunnels that shouldn't block applies. Policy violations always fail",
+				Optional: true,
+			},
+			"wait_for_first_connection": schema.StringAttribute{
+				MarkdownDescription: "Wait up to this duration (e.g. `5m`) for the first connection to any local port forwarding before returning, " +
+					"for tunnels existing solely for an external process started next. Opening proceeds with a warning once the duration passed",
+				Optional: true,
+			},
+			"exec_fallback": schema.StringAttribute{
+				MarkdownDescription: "Command run on the SSH server to relay the connections of local port forwardings through its stdio, if the server prohibits " +
+					"port forwarding (e.g. OpenSSH's `AllowTcpForwarding no`) but allows exec, e.g. `nc %h %p`. `%h` is replaced by the shell quoted remote host, " +
+					"`%p` by the remote port. Falling back is reported as a warning",
+				Optional: true,
+			},
+			"group": schema.StringAttribute{
+				MarkdownDescription: "Name of a group of connections opened all-or-nothing: once a connection of the group fails to open, " +
+					"all open connections of the group are closed and the remaining ones fail to open, so consumers never see a partial set of endpoints. " +
+					"Requires `exit_on_forward_failure`",
+				Optional: true,
+			},
+			"connection_id": schema.StringAttribute{
+				MarkdownDescription: "Identifier the named `local_port_forwardings` are published under while the tunnel is open, resolved with `provider::sshtunnel::endpoint(connection_id, name)`, " +
+					"e.g. by other configurations reaching a tunnel kept open by the `daemon` subcommand. Only letters, digits, `.`, `_` and `-` are allowed. " +
+					"Opening a second tunnel with the same identifier fails while the first one is open. Defaults to a random identifier",
+				Optional: true,
+				Computed: true,
+			},
+			"heartbeat": schema.SingleNestedAttribute{
+				MarkdownDescription: "Periodically run a command over the connection as an application-level heartbeat, " +
+					"for bastions that ignore protocol keepalives but close sessions without command activity",
+				Attributes: map[string]schema.Attribute{
+					"command": schema.StringAttribute{
+						MarkdownDescription: "Command to run (defaults to `true`)",
+						Optional:            true,
+					},
+					"interval": schema.StringAttribute{
+						MarkdownDescription: "Interval between heartbeats (defaults to `1m`)",
+						Optional:            true,
+					},
+				},
+				Optional: true,
+			},
+			"availability_watch": schema.SingleNestedAttribute{
+				MarkdownDescription: "Send keepalives while the tunnel is open and report a warning summarizing the periods the SSH server was unresponsive when the tunnel is closed, " +
+					"so intermittently failing runs can be attributed to an unstable bastion",
+				Attributes: map[string]schema.Attribute{
+					"interval": schema.StringAttribute{
+						MarkdownDescription: "Interval between keepalives (defaults to `10s`)",
+						Optional:            true,
+					},
+					"timeout": schema.StringAttribute{
+						MarkdownDescription: "Time to wait for the response to a keepalive before the server is considered unresponsive (defaults to `5s`)",
+						Optional:            true,
+					},
+				},
+				Optional: true,
+			},
+			"pty_session": schema.SingleNestedAttribute{
+				MarkdownDescription: "Keep an interactive session with a pseudo terminal open alongside the forwardings, " +
+					"for bastions that close connections without an active shell. The session is restarted if it ends",
+				Attributes: map[string]schema.Attribute{
+					"command": schema.StringAttribute{
+						MarkdownDescription: "Command to run in the session (defaults to the login shell)",
+						Optional:            true,
+					},
+					"term": schema.StringAttribute{
+						MarkdownDescription: "Terminal type of the pseudo terminal (defaults to `xterm`)",
+						Optional:            true,
+					},
+				},
+				Optional: true,
+			},
+			"report_timings": schema.BoolAttribute{
+				MarkdownDescription: "Populate `timings`. Disabled by default, as timings differ between each open and so the result would change between plan and apply",
+				Optional:            true,
+			},
+			"timings": schema.SingleNestedAttribute{
+				MarkdownDescription: "Time spent in each phase of opening the tunnel, only set with `report_timings`",
+				Attributes: map[string]schema.Attribute{
+					"dns": schema.StringAttribute{
+						MarkdownDescription: "Duration of the DNS lookup of the host",
+						Computed:            true,
+					},
+					"connect": schema.StringAttribute{
+						MarkdownDescription: "Duration of the TCP connect to the host",
+						Computed:            true,
+					},
+					"handshake": schema.StringAttribute{
+						MarkdownDescription: "Duration of the SSH key exchange",
+						Computed:            true,
+					},
+					"auth": schema.StringAttribute{
+						MarkdownDescription: "Duration of the SSH authentication",
+						Computed:            true,
+					},
+					"local_port_forwardings": schema.ListAttribute{
+						MarkdownDescription: "Setup duration of each local port forwarding",
+						ElementType:         types.StringType,
+						Computed:            true,
 					},
 				},
-				Required: true,
+				Computed: true,
+			},
+			"server_host_key": schema.SingleNestedAttribute{
+				MarkdownDescription: "Host key presented by the SSH server, e.g. to record it or assert on it in a `check` block",
+				Attributes: map[string]schema.Attribute{
+					"type": schema.StringAttribute{
+						MarkdownDescription: "Type of the key or certificate, e.g. `ssh-ed25519` or `ssh-ed25519-cert-v01@openssh.com`",
+						Computed:            true,
+					},
+					"public_key": schema.StringAttribute{
+						MarkdownDescription: "Public key or certificate in the `authorized_keys` format",
+						Computed:            true,
+					},
+					"fingerprint_sha256": schema.StringAttribute{
+						MarkdownDescription: "SHA256 fingerprint of the key, of certificates that of the signed key, as accepted by `host_key_fingerprint`",
+						Computed:            true,
+					},
+				},
+				Computed: true,
+			},
+			"ssh_config": schema.StringAttribute{
+				MarkdownDescription: "OpenSSH client config `Host` block opening the same tunnel with `ssh -N`, e.g. to replicate it interactively when debugging. " +
+					"Keys not read from a file and Vault signed certificates are left as comments",
+				Computed:  true,
+				Sensitive: true,
 			},
 		},
 	}
@@ -148,140 +1179,973 @@ func (r *ConnectionEphemeralResource) Configure(ctx context.Context, req ephemer
 	}
 
 	r.tunnelTracker = configData.Tracker
+	r.authProviders = configData.AuthProviders
+	r.protocolExtensions = configData.ProtocolExtensions
+	r.lockDir = configData.LockDir
+	r.lockTimeout = configData.LockTimeout
+	r.systemSSHConfig = configData.SystemSSHConfig
+	r.systemKnownHosts = configData.SystemKnownHosts
+	r.skipOpen = configData.SkipOpen
+	r.planSummary = configData.PlanSummary
+	r.policy = configData.Policy
+	r.listenerPool = configData.ListenerPool
+	r.reclaimedPorts = configData.ReclaimedPorts
+	r.handshakes = configData.Handshakes
+	r.forwardingProfiles = configData.ForwardingProfiles
+	r.budget = configData.Budget
+	r.faults = configData.Faults
 }
 
-func (r *ConnectionEphemeralResource) ValidateConfig(ctx context.Context, req ephemeral.ValidateConfigRequest, resp *ephemeral.ValidateConfigResponse) {
-	var data ConnectionEphemeralResourceModel
-	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
-
-	if resp.Diagnostics.HasError() {
-		return
+// getAuthProviders returns the registered auth providers, falling back to
+// the defaults as configs can be validated before the provider is configured.
+func (r *ConnectionEphemeralResource) getAuthProviders() []AuthProvider {
+	if r.authProviders == nil {
+		return defaultAuthProviders()
 	}
+	return r.authProviders
+}
 
-	for _, localPortForwarding := range data.LocalPortForwardings {
-		if !localPortForwarding.RetryDelay.IsNull() {
-			if _, err := time.ParseDuration(localPortForwarding.RetryDelay.ValueString()); err != nil {
-				resp.Diagnostics.AddError("Local Port Forwarding Error", fmt.Sprintf("Invalid retry delay: %s", err))
-			}
-		}
+// ConfigValidators reject invalid combinations of attributes, checks
+// depending on their values are part of ValidateConfig.
+func (r *ConnectionEphemeralResource) ConfigValidators(ctx context.Context) []ephemeral.ConfigValidator {
+	localPortForwardings := path.MatchRoot("local_port_forwardings").AtAnyListIndex()
+	remoteSocketForwardings := path.MatchRoot("remote_socket_forwardings").AtAnyListIndex()
+
+	return []ephemeral.ConfigValidator{
+		exactlyOneOfAttributes("host", "srv", "definition"),
+		attributeRequires("host", "port"),
+		// The port of the SRV records is used.
+		conflictingAttributes("srv", "port"),
+		conflictingAttributes("definition", "port"),
+		conflictingAttributes("definition", "local_port_forwardings"),
+		attributeRequires("wait_for_first_connection", "local_port_forwardings"),
+		conflictingAttributes("local_port", "local_port_seed", "local_socket_path").within(localPortForwardings),
+		conflictingAttributes("remote_socket_path", "remote_host").within(localPortForwardings),
+		conflictingAttributes("remote_socket_path", "remote_port").within(localPortForwardings),
+		exactlyOneOfAttributes("local_host", "local_socket_path").within(remoteSocketForwardings),
+		requiredTogetherAttributes("local_host", "local_port").within(remoteSocketForwardings),
+		// Both dial TCP addresses.
+		conflictingAttributes("remote_socket_path", "sni_routes").within(localPortForwardings),
+		conflictingAttributes("remote_socket_path", "health_check_interval").within(localPortForwardings),
+		attributeRequires("agent_socket", "agent").within(path.MatchRoot("auth")),
+		attributeRequires("agent_identity", "agent").within(path.MatchRoot("auth")),
 	}
 }
 
-func (r *ConnectionEphemeralResource) Open(ctx context.Context, req ephemeral.OpenRequest, resp *ephemeral.OpenResponse) {
+func (r *ConnectionEphemeralResource) ValidateConfig(ctx context.Context, req ephemeral.ValidateConfigRequest, resp *ephemeral.ValidateConfigResponse) {
 	var data ConnectionEphemeralResourceModel
-
 	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
 
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	id := randSeq(8)
-	tunnelInfo := &TunnelInfo{}
+	resp.Diagnostics.Append(validateAuthConfig(ctx, r.getAuthProviders(), data.Auth)...)
+	resp.Diagnostics.Append(applyTunnelDefinition(&data)...)
+	resp.Diagnostics.Append(r.applyForwardingProfiles(&data)...)
+	resp.Diagnostics.Append(expandUser(&data)...)
+	resp.Diagnostics.Append(expandPaths(&data)...)
 
-	b, err := json.Marshal(&ConnectionPrivateData{ID: id})
-	if err != nil {
-		resp.Diagnostics.AddError("Private Data Error", fmt.Sprintf("Unable to marshal private data, got error: %s", err))
-		return
+	if data.Heartbeat != nil && !data.Heartbeat.Interval.IsNull() && !data.Heartbeat.Interval.IsUnknown() {
+		if interval, err := time.ParseDuration(data.Heartbeat.Interval.ValueString()); err != nil {
+			resp.Diagnostics.AddError("Heartbeat Error", fmt.Sprintf("Invalid interval: %s", err))
+		} else if interval <= 0 {
+			resp.Diagnostics.AddError("Heartbeat Error", "Interval must be positive")
+		}
 	}
-	resp.Private.SetKey(ctx, connectionPrivateDataKey, b)
-	r.tunnelTracker.Add(id, tunnelInfo)
 
-	// Setup SSH connection
+	if data.User.IsNull() && data.Auth.GCPOSLogin == nil {
+		resp.Diagnostics.AddError("User Error", "user is required unless auth.gcp_os_login is set")
+	}
 
-	signer, err := ssh.ParsePrivateKey([]byte(data.Auth.PrivateKey.ValueString()))
-	if err != nil {
-		resp.Diagnostics.AddError("Private Key Error", fmt.Sprintf("Unable to parse private key, got error: %s", err))
+	if !data.Host.IsNull() && !data.Host.IsUnknown() {
+		if _, err := hostToASCII(data.Host.ValueString()); err != nil {
+			resp.Diagnostics.AddError("Host Error", fmt.Sprintf("Invalid host %q: %s", data.Host.ValueString(), err))
+		}
+	}
+
+	if data.AvailabilityWatch != nil {
+		for _, v := range []struct {
+			name  string
+			value types.String
+		}{{"interval", data.AvailabilityWatch.Interval}, {"timeout", data.AvailabilityWatch.Timeout}} {
+			if v.value.IsNull() || v.value.IsUnknown() {
+				continue
+			}
+			if d, err := time.ParseDuration(v.value.ValueString()); err != nil {
+				resp.Diagnostics.AddError("Availability Watch Error", fmt.Sprintf("Invalid %s: %s", v.name, err))
+			} else if d <= 0 {
+				resp.Diagnostics.AddError("Availability Watch Error", fmt.Sprintf("%s must be positive", v.name))
+			}
+		}
+	}
+
+	if !data.HostKeyFingerprint.IsNull() && !data.HostKeyFingerprint.IsUnknown() {
+		if _, err := parseHostKeyFingerprint(data.HostKeyFingerprint.ValueString()); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("host_key_fingerprint"), "Host Key Error", err.Error())
+		}
+		if !data.KnownHostsFile.IsNull() {
+			resp.Diagnostics.AddAttributeError(path.Root("host_key_fingerprint"), "Host Key Error", "host_key_fingerprint conflicts with known_hosts_file")
+		}
+	}
+	resp.Diagnostics.Append(validateHostKeyMode(&data)...)
+
+	if data.HostKeyAlgorithms != nil && len(data.HostKeyAlgorithms) == 0 {
+		resp.Diagnostics.AddAttributeError(path.Root("host_key_algorithms"), "Host Key Error", "host_key_algorithms must not be empty")
+	}
+	seenAlgorithms := map[string]bool{}
+	for i, algorithm := range data.HostKeyAlgorithms {
+		if algorithm.IsUnknown() {
+			continue
+		}
+		if err := validateHostKeyAlgorithm(algorithm.ValueString()); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("host_key_algorithms").AtListIndex(i), "Host Key Error", err.Error())
+		} else if seenAlgorithms[algorithm.ValueString()] {
+			resp.Diagnostics.AddAttributeError(path.Root("host_key_algorithms").AtListIndex(i), "Host Key Error", fmt.Sprintf("Duplicate host key algorithm %q", algorithm.ValueString()))
+		}
+		seenAlgorithms[algorithm.ValueString()] = true
+	}
+
+	for i, authority := range data.HostCertificateAuthorities {
+		if authority.IsUnknown() {
+			continue
+		}
+		if _, err := parseCertificateAuthority(authority.ValueString()); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("host_certificate_authorities").AtListIndex(i), "Host Key Error", err.Error())
+		}
+	}
+
+	if !data.ConnectionID.IsNull() && !data.ConnectionID.IsUnknown() {
+		if err := endpoints.ValidateID(data.ConnectionID.ValueString()); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("connection_id"), "Connection ID Error", err.Error())
+		}
+	}
+
+	names := map[string]bool{}
+	for i, localPortForwarding := range data.LocalPortForwardings {
+		if !localPortForwarding.Name.IsNull() && !localPortForwarding.Name.IsUnknown() {
+			name := localPortForwarding.Name.ValueString()
+			namePath := path.Root("local_port_forwardings").AtListIndex(i).AtName("name")
+			if name == "" {
+				resp.Diagnostics.AddAttributeError(namePath, "Local Port Forwarding Error", "name must not be empty")
+			} else if names[name] {
+				resp.Diagnostics.AddAttributeError(namePath, "Local Port Forwarding Error", fmt.Sprintf("Duplicate name %q, names must be unique within the connection", name))
+			}
+			names[name] = true
+		}
+
+		if !localPortForwarding.RemoteHost.IsNull() && !localPortForwarding.RemoteHost.IsUnknown() {
+			if _, err := hostToASCII(localPortForwarding.RemoteHost.ValueString()); err != nil {
+				resp.Diagnostics.AddError("Local Port Forwarding Error", fmt.Sprintf("Invalid remote_host %q: %s", localPortForwarding.RemoteHost.ValueString(), err))
+			}
+		}
+
+		// Profiles are only known once the provider is configured.
+		if localPortForwarding.RemoteSocketPath.IsNull() && (localPortForwarding.Profile.IsNull() || (r.forwardingProfiles != nil && !localPortForwarding.Profile.IsUnknown())) {
+			if localPortForwarding.RemoteHost.IsNull() {
+				resp.Diagnostics.AddError("Local Port Forwarding Error", "remote_host is required unless set by the profile or remote_socket_path is set")
+			}
+			if localPortForwarding.RemotePort.IsNull() {
+				resp.Diagnostics.AddError("Local Port Forwarding Error", "remote_port is required unless set by the profile or remote_socket_path is set")
+			}
+		}
+
+		if !localPortForwarding.RetryDelay.IsNull() {
+			if _, err := time.ParseDuration(localPortForwarding.RetryDelay.ValueString()); err != nil {
+				resp.Diagnostics.AddError("Local Port Forwarding Error", fmt.Sprintf("Invalid retry delay: %s", err))
+			}
+		}
+
+		if localPortForwarding.ListenBacklog.ValueInt32() < 0 {
+			resp.Diagnostics.AddError("Local Port Forwarding Error", "Listen backlog must not be negative")
+		}
+
+		if localPortForwarding.MaxConnections.ValueInt32() < 0 {
+			resp.Diagnostics.AddError("Local Port Forwarding Error", "Max connections must not be negative")
+		}
+
+		if localPortForwarding.AcceptWorkers.ValueInt32() < 0 {
+			resp.Diagnostics.AddError("Local Port Forwarding Error", "Accept workers must not be negative")
+		}
+
+		if !localPortForwarding.MaxBytes.IsNull() && !localPortForwarding.MaxBytes.IsUnknown() && localPortForwarding.MaxBytes.ValueInt64() <= 0 {
+			resp.Diagnostics.AddError("Local Port Forwarding Error", "Max bytes must be positive")
+		}
+
+		if !localPortForwarding.StallTimeout.IsNull() && !localPortForwarding.StallTimeout.IsUnknown() {
+			if stallTimeout, err := time.ParseDuration(localPortForwarding.StallTimeout.ValueString()); err != nil {
+				resp.Diagnostics.AddError("Local Port Forwarding Error", fmt.Sprintf("Invalid stall timeout: %s", err))
+			} else if stallTimeout <= 0 {
+				resp.Diagnostics.AddError("Local Port Forwarding Error", "Stall timeout must be positive")
+			}
+		}
+
+		if !localPortForwarding.HealthCheckInterval.IsNull() && !localPortForwarding.HealthCheckInterval.IsUnknown() {
+			if interval, err := time.ParseDuration(localPortForwarding.HealthCheckInterval.ValueString()); err != nil {
+				resp.Diagnostics.AddError("Local Port Forwarding Error", fmt.Sprintf("Invalid health check interval: %s", err))
+			} else if interval <= 0 {
+				resp.Diagnostics.AddError("Local Port Forwarding Error", "Health check interval must be positive")
+			}
+		}
+
+		if _, err := sniRoutes(localPortForwarding.SNIRoutes); err != nil {
+			resp.Diagnostics.AddError("Local Port Forwarding Error", fmt.Sprintf("Invalid sni_routes: %s", err))
+		}
+	}
+
+	for i, dynamicPortForwarding := range data.DynamicPortForwardings {
+		if !dynamicPortForwarding.Name.IsNull() && !dynamicPortForwarding.Name.IsUnknown() {
+			name := dynamicPortForwarding.Name.ValueString()
+			namePath := path.Root("dynamic_port_forwardings").AtListIndex(i).AtName("name")
+			if name == "" {
+				resp.Diagnostics.AddAttributeError(namePath, "Dynamic Port Forwarding Error", "name must not be empty")
+			} else if names[name] {
+				resp.Diagnostics.AddAttributeError(namePath, "Dynamic Port Forwarding Error", fmt.Sprintf("Duplicate name %q, names must be unique within the connection", name))
+			}
+			names[name] = true
+		}
+
+		if !dynamicPortForwarding.Protocol.IsNull() && !dynamicPortForwarding.Protocol.IsUnknown() {
+			switch dynamicPortForwarding.Protocol.ValueString() {
+			case dynamicProtocolSOCKS5, dynamicProtocolHTTP:
+			default:
+				resp.Diagnostics.AddAttributeError(path.Root("dynamic_port_forwardings").AtListIndex(i).AtName("protocol"), "Dynamic Port Forwarding Error", fmt.Sprintf("Invalid protocol %q, expected %s or %s", dynamicPortForwarding.Protocol.ValueString(), dynamicProtocolSOCKS5, dynamicProtocolHTTP))
+			}
+		}
+
+		if dynamicPortForwarding.MaxConnections.ValueInt32() < 0 {
+			resp.Diagnostics.AddError("Dynamic Port Forwarding Error", "Max connections must not be negative")
+		}
+
+		if dynamicPortForwarding.AcceptWorkers.ValueInt32() < 0 {
+			resp.Diagnostics.AddError("Dynamic Port Forwarding Error", "Accept workers must not be negative")
+		}
+
+		if guard := dynamicPortForwarding.FanOutGuard; guard != nil {
+			guardPath := path.Root("dynamic_port_forwardings").AtListIndex(i).AtName("fan_out_guard")
+			if !guard.Window.IsNull() && !guard.Window.IsUnknown() {
+				if window, err := time.ParseDuration(guard.Window.ValueString()); err != nil || window <= 0 {
+					resp.Diagnostics.AddAttributeError(guardPath.AtName("window"), "Dynamic Port Forwarding Error", fmt.Sprintf("Invalid window %q, expected a positive duration", guard.Window.ValueString()))
+				}
+			}
+			if guard.MaxRequests.ValueInt32() < 0 || guard.MaxHosts.ValueInt32() < 0 || guard.MaxPorts.ValueInt32() < 0 {
+				resp.Diagnostics.AddAttributeError(guardPath, "Dynamic Port Forwarding Error", "Fan-out limits must not be negative")
+			}
+		}
+	}
+
+	for i, udpForwarding := range data.UDPForwardings {
+		if !udpForwarding.RemoteHost.IsNull() && !udpForwarding.RemoteHost.IsUnknown() {
+			if _, err := hostToASCII(udpForwarding.RemoteHost.ValueString()); err != nil {
+				resp.Diagnostics.AddAttributeError(path.Root("udp_forwardings").AtListIndex(i).AtName("remote_host"), "UDP Forwarding Error", fmt.Sprintf("Invalid remote_host %q: %s", udpForwarding.RemoteHost.ValueString(), err))
+			}
+		}
+		if !udpForwarding.IdleTimeout.IsNull() && !udpForwarding.IdleTimeout.IsUnknown() {
+			if idleTimeout, err := time.ParseDuration(udpForwarding.IdleTimeout.ValueString()); err != nil || idleTimeout <= 0 {
+				resp.Diagnostics.AddAttributeError(path.Root("udp_forwardings").AtListIndex(i).AtName("idle_timeout"), "UDP Forwarding Error", fmt.Sprintf("Invalid idle_timeout %q, expected a positive duration", udpForwarding.IdleTimeout.ValueString()))
+			}
+		}
+	}
+
+	for i, remotePortForwarding := range data.RemotePortForwardings {
+		if !remotePortForwarding.BindAddress.IsNull() && !remotePortForwarding.BindAddress.IsUnknown() {
+			if net.ParseIP(remotePortForwarding.BindAddress.ValueString()) == nil {
+				resp.Diagnostics.AddAttributeError(path.Root("remote_port_forwardings").AtListIndex(i).AtName("bind_address"), "Remote Port Forwarding Error", fmt.Sprintf("Invalid bind_address %q, expected an IP address", remotePortForwarding.BindAddress.ValueString()))
+			}
+		}
+		if !remotePortForwarding.RemotePort.IsNull() && !remotePortForwarding.RemotePort.IsUnknown() {
+			if port := remotePortForwarding.RemotePort.ValueInt32(); port < 0 || port > 65535 {
+				resp.Diagnostics.AddAttributeError(path.Root("remote_port_forwardings").AtListIndex(i).AtName("remote_port"), "Remote Port Forwarding Error", fmt.Sprintf("Invalid remote_port %d, expected 0-65535", port))
+			}
+		}
+		if service := remotePortForwarding.ConsulService; service != nil && !service.Name.IsUnknown() && service.Name.ValueString() == "" {
+			resp.Diagnostics.AddAttributeError(path.Root("remote_port_forwardings").AtListIndex(i).AtName("consul_service").AtName("name"), "Remote Port Forwarding Error", "name must not be empty")
+		}
+	}
+
+	if !data.MaxBytes.IsNull() && !data.MaxBytes.IsUnknown() && data.MaxBytes.ValueInt64() <= 0 {
+		resp.Diagnostics.AddError("Max Bytes Error", "Max bytes must be positive")
+	}
+
+	_, hookDiags := r.protocolHooks(&data)
+	resp.Diagnostics.Append(hookDiags...)
+
+	if weights, err := priorityWeights(data.PriorityClasses); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("priority_classes"), "Priority Class Error", fmt.Sprintf("Invalid priority_classes: %s", err))
+	} else {
+		for i, localPortForwarding := range data.LocalPortForwardings {
+			if localPortForwarding.PriorityClass.IsUnknown() {
+				continue
+			}
+			if _, ok := weights[priorityClassName(localPortForwarding.PriorityClass)]; !ok {
+				resp.Diagnostics.AddAttributeError(path.Root("local_port_forwardings").AtListIndex(i).AtName("priority_class"), "Priority Class Error",
+					fmt.Sprintf("Unknown priority class %q, expected interactive, bulk or one of priority_classes", localPortForwarding.PriorityClass.ValueString()))
+			}
+		}
+	}
+
+	if !data.OnFailure.IsNull() && !data.OnFailure.IsUnknown() {
+		switch data.OnFailure.ValueString() {
+		case onFailureError:
+		case onFailureWarn:
+			if !data.Group.IsNull() {
+				resp.Diagnostics.AddError("On Failure Error", "on_failure can't be warn for connections in a group")
+			}
+		default:
+			resp.Diagnostics.AddError("On Failure Error", fmt.Sprintf("Invalid on_failure %q, expected error or warn", data.OnFailure.ValueString()))
+		}
+	}
+
+	if !data.WaitForFirstConnection.IsNull() && !data.WaitForFirstConnection.IsUnknown() {
+		if timeout, err := time.ParseDuration(data.WaitForFirstConnection.ValueString()); err != nil {
+			resp.Diagnostics.AddError("Wait For First Connection Error", fmt.Sprintf("Invalid duration: %s", err))
+		} else if timeout <= 0 {
+			resp.Diagnostics.AddError("Wait For First Connection Error", "Duration must be positive")
+		}
+	}
+
+	if !data.Group.IsNull() && !data.ExitOnForwardFailure.IsNull() && !data.ExitOnForwardFailure.IsUnknown() && !data.ExitOnForwardFailure.ValueBool() {
+		resp.Diagnostics.AddError("Tunnel Group Error", "exit_on_forward_failure can't be disabled for connections in a group")
+	}
+
+	// The policy is only known once the provider is configured.
+	if r.policy != nil && knownLabels(data.Labels) {
+		if err := r.policy.checkLabels(labelValues(data.Labels)); err != nil {
+			resp.Diagnostics.AddError("Policy Error", fmt.Sprintf("Connection violates the provider policy: %s", err))
+		}
+	}
+}
+
+const (
+	onFailureError = "error"
+	onFailureWarn  = "warn"
+)
+
+// setPlaceholderLocalPorts sets the local port of forwardings without a
+// configured one to the first seeded port, otherwise 0, for results of
+// tunnels that aren't open.
+func setPlaceholderLocalPorts(data *ConnectionEphemeralResourceModel) {
+	for i, localPortForwarding := range data.LocalPortForwardings {
+		if !localPortForwarding.LocalPort.IsNull() || !localPortForwarding.LocalSocketPath.IsNull() {
+			continue
+		}
+		var port int32
+		if !localPortForwarding.LocalPortSeed.IsNull() {
+			port = seededPort(localPortForwarding.LocalPortSeed.ValueString(), 0)
+		}
+		data.LocalPortForwardings[i].LocalPort = basetypes.NewInt32Value(port)
+	}
+	for i, dynamicPortForwarding := range data.DynamicPortForwardings {
+		if dynamicPortForwarding.LocalPort.IsNull() {
+			data.DynamicPortForwardings[i].LocalPort = basetypes.NewInt32Value(0)
+		}
+		data.DynamicPortForwardings[i].ProxyURL = types.StringNull()
+	}
+	for i, udpForwarding := range data.UDPForwardings {
+		if udpForwarding.LocalPort.IsNull() {
+			data.UDPForwardings[i].LocalPort = basetypes.NewInt32Value(0)
+		}
+	}
+	for i, remotePortForwarding := range data.RemotePortForwardings {
+		if remotePortForwarding.RemotePort.IsNull() {
+			data.RemotePortForwardings[i].RemotePort = basetypes.NewInt32Value(0)
+		}
+	}
+}
+
+// softFail turns the errors of opening the tunnel id into warnings, closes
+// what was opened and returns placeholder values instead.
+func (r *ConnectionEphemeralResource) softFail(ctx context.Context, req ephemeral.OpenRequest, resp *ephemeral.OpenResponse, id, owner string) {
+	diags := diag.Diagnostics{}
+	for _, d := range resp.Diagnostics {
+		if d.Severity() == diag.SeverityError {
+			diags.AddWarning(d.Summary(), d.Detail())
+			continue
+		}
+		diags.Append(d)
+	}
+	for _, d := range r.closeByConnectionID(id) {
+		diags.AddWarning(d.Summary(), d.Detail())
+	}
+	diags.AddWarning("Tunnel Not Established", fmt.Sprintf("Unable to open the %s, returning placeholder values as on_failure is warn", owner))
+
+	var data ConnectionEphemeralResourceModel
+	diags.Append(req.Config.Get(ctx, &data)...)
+	diags.Append(applyTunnelDefinition(&data)...)
+	diags.Append(r.applyForwardingProfiles(&data)...)
+	diags.Append(expandUser(&data)...)
+	diags.Append(expandPaths(&data)...)
+	if !diags.HasError() {
+		setPlaceholderLocalPorts(&data)
+		diags.Append(resp.Result.Set(ctx, data)...)
+	}
+	resp.Diagnostics = diags
+}
+
+func knownLabels(labels map[string]types.String) bool {
+	for _, v := range labels {
+		if v.IsUnknown() {
+			return false
+		}
+	}
+	return true
+}
+
+func labelValues(labels map[string]types.String) map[string]string {
+	values := make(map[string]string, len(labels))
+	for k, v := range labels {
+		values[k] = v.ValueString()
+	}
+	return values
+}
+
+func (r *ConnectionEphemeralResource) Open(ctx context.Context, req ephemeral.OpenRequest, resp *ephemeral.OpenResponse) {
+	var data ConnectionEphemeralResourceModel
+
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	resp.Diagnostics.Append(applyTunnelDefinition(&data)...)
+	resp.Diagnostics.Append(r.applyForwardingProfiles(&data)...)
+	resp.Diagnostics.Append(expandUser(&data)...)
+	resp.Diagnostics.Append(expandPaths(&data)...)
+
+	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	conn, err := ssh.Dial("tcp", hostAddr(data.Host, data.Port), &ssh.ClientConfig{
-		User: data.User.ValueString(),
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	})
+	if r.planSummary {
+		var authMethods []string
+		if providers, err := orderAuthProviders(r.getAuthProviders(), data.Auth); err == nil {
+			for _, p := range providers {
+				authMethods = append(authMethods, p.Name())
+			}
+		}
+		resp.Diagnostics.AddWarning("Tunnel Plan", planSummary(&data, authMethods))
+	}
+
+	if r.skipOpen {
+		tflog.Info(ctx, "Not opening tunnel as apply_only is enabled and Terraform is not applying")
+
+		if req.ClientCapabilities.DeferralAllowed {
+			resp.Deferred = &ephemeral.Deferred{Reason: ephemeral.DeferredReasonAbsentPrereq}
+			return
+		}
+
+		setPlaceholderLocalPorts(&data)
+		resp.Diagnostics.Append(resp.Result.Set(ctx, data)...)
+		return
+	}
+
+	owner := "connection to " + serverName(&data)
+	group := data.Group.ValueString()
+	if group != "" {
+		if failed := r.tunnelTracker.GroupFailure(group); failed != "" {
+			resp.Diagnostics.AddError("Tunnel Group Error", fmt.Sprintf("Not opening the %s, as the %s of group %q failed to open", owner, failed, group))
+			return
+		}
+
+		// Roll back the whole group if this tunnel fails to open.
+		defer func() {
+			if resp.Diagnostics.HasError() {
+				r.tunnelTracker.FailGroup(ctx, group, owner)
+			}
+		}()
+	}
+
+	if r.policy != nil {
+		if err := r.policy.checkLabels(labelValues(data.Labels)); err != nil {
+			resp.Diagnostics.AddError("Policy Error", fmt.Sprintf("Connection violates the provider policy: %s", err))
+			return
+		}
+		if err := r.policy.checkTime(time.Now()); err != nil {
+			resp.Diagnostics.AddError("Policy Error", fmt.Sprintf("Opening tunnels is not allowed at this time: %s", err))
+			return
+		}
+		targets, err := forwardingTargets(data.LocalPortForwardings)
+		if err != nil {
+			resp.Diagnostics.AddError("Local Port Forwarding Error", err.Error())
+			return
+		}
+		for _, udpForwarding := range data.UDPForwardings {
+			host, err := hostToASCII(udpForwarding.RemoteHost.ValueString())
+			if err != nil {
+				resp.Diagnostics.AddError("UDP Forwarding Error", fmt.Sprintf("Invalid remote_host %q: %s", udpForwarding.RemoteHost.ValueString(), err))
+				return
+			}
+			targets = append(targets, hostAddr(types.StringValue(host), udpForwarding.RemotePort))
+		}
+		if err := r.policy.checkTargets(ctx, targets); err != nil {
+			resp.Diagnostics.AddError("Policy Error", fmt.Sprintf("Connection violates the provider policy: %s", err))
+			return
+		}
+		if len(data.DynamicPortForwardings) > 0 && r.policy.restrictsTargets() {
+			resp.Diagnostics.AddError("Policy Error", "Connection violates the provider policy: dynamic_port_forwardings reach any target, but the targets are restricted by target_allow_list")
+			return
+		}
+		for _, localPortForwarding := range data.LocalPortForwardings {
+			if !localPortForwarding.RemoteSocketPath.IsNull() && r.policy.restrictsTargets() {
+				resp.Diagnostics.AddError("Policy Error", fmt.Sprintf("Connection violates the provider policy: remote socket %s isn't covered by target_allow_list", localPortForwarding.RemoteSocketPath.ValueString()))
+				return
+			}
+		}
+	}
+
+	id := randSeq(8)
+
+	if data.OnFailure.ValueString() == onFailureWarn {
+		defer func() {
+			if resp.Diagnostics.HasError() {
+				r.softFail(ctx, req, resp, id, owner)
+			}
+		}()
+	}
+	// Background tasks of the tunnel outlive this request, so only keep the
+	// logging context.
+	tunnelCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	tunnelInfo := &TunnelInfo{
+		Owner:  owner,
+		Group:  group,
+		budget: r.budget,
+		cancel: cancel,
+	}
+
+	privateData := &ConnectionPrivateData{ID: id, Key: tunnelKey(&data)}
+	b, err := json.Marshal(privateData)
 	if err != nil {
-		resp.Diagnostics.AddError("Connection Error", fmt.Sprintf("Unable to connect to host %s, got error: %s", data.Host.ValueString(), err))
+		resp.Diagnostics.AddError("Private Data Error", fmt.Sprintf("Unable to marshal private data, got error: %s", err))
+		return
+	}
+	resp.Private.SetKey(ctx, connectionPrivateDataKey, b)
+	r.tunnelTracker.Add(id, tunnelInfo)
+
+	// The group may have failed since it was checked, before the tunnel was
+	// tracked and so closed with the group.
+	if group != "" {
+		if failed := r.tunnelTracker.GroupFailure(group); failed != "" {
+			resp.Diagnostics.AddError("Tunnel Group Error", fmt.Sprintf("Not opening the %s, as the %s of group %q failed to open", owner, failed, group))
+			resp.Diagnostics.Append(r.closeByConnectionID(id)...)
+			return
+		}
+	}
+
+	// Setup SSH connection
+
+	conn, timings, diags := r.connect(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		resp.Diagnostics.Append(r.closeByConnectionID(id)...)
 		return
 	}
 
-	tunnelInfo.conn = conn
+	// o sets up the forwardings, closing the tunnel on errors.
+	o := &tunnelOpener{
+		r:                    r,
+		ctx:                  ctx,
+		tunnelCtx:            tunnelCtx,
+		resp:                 resp,
+		id:                   id,
+		owner:                owner,
+		data:                 &data,
+		info:                 tunnelInfo,
+		conn:                 conn,
+		privateData:          privateData,
+		exitOnForwardFailure: data.ExitOnForwardFailure.IsNull() || data.ExitOnForwardFailure.ValueBool(),
+		localPorts:           make([]int32, len(data.LocalPortForwardings)),
+		namedAddrs:           map[string]string{},
+	}
 
-	// Setup local port forwardings
+	if err := tunnelInfo.setConn(conn); err != nil {
+		o.tunnelClosed(err)
+		return
+	}
 
-	for i, localPortForwarding := range data.LocalPortForwardings {
-		conf := &portforward.Config{
-			LocalPort:  localPortForwarding.LocalPort.ValueInt32Pointer(),
-			RemoteAddr: hostAddr(localPortForwarding.RemoteHost, localPortForwarding.RemotePort),
+	hooks, hookDiags := r.protocolHooks(&data)
+	resp.Diagnostics.Append(hookDiags...)
+	if resp.Diagnostics.HasError() {
+		resp.Diagnostics.Append(r.closeByConnectionID(id)...)
+		return
+	}
+	hooks.attach(tunnelCtx, conn)
+
+	data.Timings = &ConnectionEphemeralResourceModelTimings{
+		DNS:                  basetypes.NewStringValue(timings.DNS.String()),
+		Connect:              basetypes.NewStringValue(timings.Connect.String()),
+		Handshake:            basetypes.NewStringValue(timings.Handshake.String()),
+		Auth:                 basetypes.NewStringValue(timings.Auth.String()),
+		LocalPortForwardings: []types.String{},
+	}
+
+	if data.Heartbeat != nil {
+		command := defaultHeartbeatCommand
+		if !data.Heartbeat.Command.IsNull() {
+			command = data.Heartbeat.Command.ValueString()
 		}
 
-		if !localPortForwarding.RetryDelay.IsNull() {
-			retryDelay, err := time.ParseDuration(localPortForwarding.RetryDelay.ValueString())
+		interval := defaultHeartbeatInterval
+		if !data.Heartbeat.Interval.IsNull() {
+			interval, err = time.ParseDuration(data.Heartbeat.Interval.ValueString())
 			if err != nil {
-				resp.Diagnostics.AddError("Local Port Forwarding Error", fmt.Sprintf("Invalid retry delay: %s", err))
+				o.fail("Heartbeat Error", fmt.Sprintf("Invalid interval: %s", err))
 				return
 			}
-			conf.RetryDelay = retryDelay
 		}
 
-		if !localPortForwarding.RetryAttempts.IsNull() {
-			conf.RetryAttempts = localPortForwarding.RetryAttempts.ValueInt32()
+		go runHeartbeat(tunnelCtx, conn, command, interval)
+	}
+
+	if data.AvailabilityWatch != nil {
+		interval, timeout := defaultAvailabilityInterval, defaultAvailabilityTimeout
+		for _, d := range []struct {
+			value types.String
+			dst   *time.Duration
+		}{{data.AvailabilityWatch.Interval, &interval}, {data.AvailabilityWatch.Timeout, &timeout}} {
+			if d.value.IsNull() {
+				continue
+			}
+			*d.dst, err = time.ParseDuration(d.value.ValueString())
+			if err != nil {
+				o.fail("Availability Watch Error", fmt.Sprintf("Invalid duration: %s", err))
+				return
+			}
+		}
+
+		watcher := &availabilityWatcher{}
+		tunnelInfo.setAvailabilityWatcher(watcher)
+		go watcher.run(tunnelCtx, conn, interval, timeout)
+	}
+
+	if data.PTYSession != nil {
+		command := data.PTYSession.Command.ValueString()
+		term := defaultPTYSessionTerm
+		if !data.PTYSession.Term.IsNull() {
+			term = data.PTYSession.Term.ValueString()
 		}
 
-		listener, err := portforward.New(ctx, conn, conf)
+		session, err := startPTYSession(conn, command, term)
 		if err != nil {
-			resp.Diagnostics.AddError("Port Forwarding Error", fmt.Sprintf("Unable to create port forwarding, got error: %s", err))
-			resp.Diagnostics.Append(r.closeByConnectionID(id)...)
+			o.fail("PTY Session Error", fmt.Sprintf("Unable to start PTY session, got error: %s", err))
 			return
 		}
-		tunnelInfo.listeners = append(tunnelInfo.listeners, listener)
 
-		tcpAddr, ok := listener.Addr().(*net.TCPAddr)
-		if !ok {
-			resp.Diagnostics.AddError("Port Forwarding Error", "Listener address is not a TCP address")
-			resp.Diagnostics.Append(r.closeByConnectionID(id)...)
+		go runPTYSession(tunnelCtx, conn, session, command, term, ptySessionRestartDelay)
+	}
+
+	if o.limits() != nil {
+		return
+	}
+	// Ports of a failed open are reclaimed when Terraform retries.
+	defer func() {
+		if resp.Diagnostics.HasError() {
+			r.reclaimedPorts.record(privateData.Key, o.localPorts)
+		}
+	}()
+
+	if !o.openLocalPortForwardings() || !o.openDynamicPortForwardings() || !o.openUDPForwardings() {
+		return
+	}
+
+	privateData.LocalPorts = o.localPorts
+	b, err = json.Marshal(privateData)
+	if err != nil {
+		o.fail("Private Data Error", fmt.Sprintf("Unable to marshal private data, got error: %s", err))
+		return
+	}
+	resp.Private.SetKey(ctx, connectionPrivateDataKey, b)
+
+	connectionID := id
+	if !data.ConnectionID.IsNull() {
+		connectionID = data.ConnectionID.ValueString()
+	}
+	data.ConnectionID = types.StringValue(connectionID)
+	if len(o.namedAddrs) > 0 {
+		if err := tunnelInfo.publishEndpoints(endpoints.Dir(), connectionID, o.namedAddrs); errors.Is(err, errTunnelClosed) {
+			o.tunnelClosed(err)
+			return
+		} else if err != nil {
+			o.fail("Endpoint Error", fmt.Sprintf("Unable to publish the named local port forwardings of the %s, got error: %s", owner, err))
 			return
 		}
+	}
 
-		tflog.Info(ctx, "Port forwarding created", map[string]interface{}{
-			"local_port": tcpAddr.Port,
-		})
+	if !o.openRemotePortForwardings() || !o.openRemoteSocketForwardings() {
+		return
+	}
 
-		data.LocalPortForwardings[i].LocalPort = basetypes.NewInt32Value(int32(tcpAddr.Port))
+	for _, q := range o.quotas {
+		tunnelInfo.watchQuota(tunnelCtx, q.quota, q.name)
 	}
 
+	if !data.WaitForFirstConnection.IsNull() {
+		timeout, err := time.ParseDuration(data.WaitForFirstConnection.ValueString())
+		if err != nil {
+			o.fail("Wait For First Connection Error", fmt.Sprintf("Invalid duration: %s", err))
+			return
+		}
+
+		tflog.Info(ctx, "Waiting for the first connection", map[string]interface{}{"timeout": timeout.String()})
+		connected, err := waitForFirstConnection(ctx, o.localListeners, timeout)
+		if err != nil {
+			o.fail("Wait For First Connection Error", fmt.Sprintf("Stopped waiting for the first connection, got error: %s", err))
+			return
+		}
+		if !connected {
+			resp.Diagnostics.AddWarning("No Connection", fmt.Sprintf("No connection to the local port forwardings of the %s within %s, proceeding", owner, timeout))
+		}
+	}
+
+	if !data.ReportTimings.ValueBool() {
+		data.Timings = nil
+	}
+	data.SSHConfig = types.StringValue(r.sshConfigSnippet(&data, conn.RemoteAddr().String(), o.localPorts))
+
 	resp.Diagnostics.Append(resp.Result.Set(ctx, data)...)
 }
 
-func (r *ConnectionEphemeralResource) closeByConnectionID(id string) diag.Diagnostics {
+// connect establishes the authenticated SSH connection of data.
+func (r *ConnectionEphemeralResource) connect(ctx context.Context, data *ConnectionEphemeralResourceModel) (*ssh.Client, *DialTimings, diag.Diagnostics) {
 	diags := diag.Diagnostics{}
 
-	tunnelInfo := r.tunnelTracker.Get(id)
-	if tunnelInfo == nil {
-		return diags
+	auth, authDiags := authMethods(ctx, r.getAuthProviders(), data.Auth)
+	diags.Append(authDiags...)
+	hooks, hookDiags := r.protocolHooks(data)
+	diags.Append(hookDiags...)
+	if diags.HasError() {
+		return nil, nil, diags
 	}
 
-	for _, listener := range tunnelInfo.listeners {
-		if err := listener.Close(); err != nil {
-			diags.AddError("Failed to close listener", fmt.Sprintf("Failed to close listener: %v", err))
+	if data.User.IsNull() && data.Auth.GCPOSLogin != nil {
+		user, err := gcpOSLoginUser(ctx, data.Auth.GCPOSLogin)
+		if err != nil {
+			diags.AddError("GCP OS Login Error", fmt.Sprintf("Unable to read the username of the OS Login profile, got error: %s", err))
+			return nil, nil, diags
 		}
+		data.User = types.StringValue(user)
 	}
 
-	if tunnelInfo.conn != nil {
-		if err := tunnelInfo.conn.Close(); err != nil {
-			diags.AddError("Failed to close connection", fmt.Sprintf("Failed to close connection: %v", err))
+	servers, err := sshServers(ctx, data)
+	if err != nil {
+		diags.AddError("Host Resolution Error", fmt.Sprintf("Unable to look up the SRV records of %s, got error: %s", data.SRV.ValueString(), err))
+		return nil, nil, diags
+	}
+
+	// Fail over to the next server, but not on authentication errors, which
+	// are unlikely to differ between the servers of an SRV record.
+	for i, server := range servers {
+		conn, timings, serverDiags, failover := r.connectServer(ctx, data, server, auth, hooks.requestHandlers())
+		if failover && i < len(servers)-1 {
+			tflog.Warn(ctx, "Unable to connect to SSH server, trying the next one", map[string]interface{}{
+				"host":  server.host,
+				"port":  server.port,
+				"error": serverDiags.Errors()[0].Detail(),
+			})
+			continue
 		}
+		diags.Append(serverDiags...)
+		return conn, timings, diags
+	}
+
+	return nil, nil, diags
+}
+
+// connectServer establishes the authenticated SSH connection to server,
+// answering the global requests of requestHandlers, and records the host key
+// it presented in data. On errors, failover reports whether another server
+// could be tried.
+func (r *ConnectionEphemeralResource) connectServer(ctx context.Context, data *ConnectionEphemeralResourceModel, server sshServer, auth []ssh.AuthMethod, requestHandlers map[string]GlobalRequestHandler) (*ssh.Client, *DialTimings, diag.Diagnostics, bool) {
+	diags := diag.Diagnostics{}
+
+	addr, hostKeyCallback, err := r.resolveHost(ctx, server.host, server.port, data.User.ValueString(), newHostKeyConfig(data))
+	if err != nil {
+		diags.AddError("Host Resolution Error", fmt.Sprintf("Unable to resolve host %s, got error: %s", server.host, err))
+		return nil, nil, diags, true
 	}
 
+	var serverHostKey ssh.PublicKey
+	clientConfig := &ssh.ClientConfig{
+		User: data.User.ValueString(),
+		Auth: auth,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			serverHostKey = key
+			return hostKeyCallback(hostname, remote, key)
+		},
+		HostKeyAlgorithms: hostKeyAlgorithms(data),
+	}
+
+	release, err := r.handshakes.acquire(ctx, addr)
+	if err != nil {
+		diags.AddError("Connection Error", fmt.Sprintf("Stopped waiting to connect to host %s, got error: %s", server.host, err))
+		return nil, nil, diags, false
+	}
+	conn, timings, err := dial(ctx, addr, clientConfig, requestHandlers)
+	release()
+	tflog.Debug(ctx, "SSH connection timings", map[string]interface{}{
+		"dns":       timings.DNS.String(),
+		"connect":   timings.Connect.String(),
+		"handshake": timings.Handshake.String(),
+		"auth":      timings.Auth.String(),
+	})
+	if isAuthError(err) {
+		detail := fmt.Sprintf("Unable to authenticate to host %s, got error: %s", server.host, err)
+		methods, probeErr := r.probeAuthMethods(ctx, addr, clientConfig)
+		if probeErr != nil {
+			tflog.Debug(ctx, "Unable to probe authentication methods", map[string]interface{}{"error": probeErr.Error()})
+		} else if len(methods) > 0 {
+			detail += fmt.Sprintf(" (server accepts: %s)", strings.Join(methods, ","))
+		} else {
+			detail += " (server accepts none of publickey, password or keyboard-interactive)"
+		}
+		diags.AddError("Authentication Error", detail)
+		return nil, timings, diags, false
+	}
+	if err != nil {
+		diags.AddError("Connection Error", fmt.Sprintf("Unable to connect to host %s, got error: %s", server.host, err))
+		return nil, timings, diags, true
+	}
+	data.ServerHostKey = newServerHostKey(serverHostKey)
+
+	return conn, timings, diags, false
+}
+
+// probeAuthMethods probes the authentication methods accepted by the server
+// at addr, counting the probe connection against the handshake limit.
+func (r *ConnectionEphemeralResource) probeAuthMethods(ctx context.Context, addr string, config *ssh.ClientConfig) ([]string, error) {
+	release, err := r.handshakes.acquire(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return probeAuthMethods(ctx, addr, config)
+}
+
+func (r *ConnectionEphemeralResource) closeByConnectionID(id string) diag.Diagnostics {
+	tunnelInfo := r.tunnelTracker.Get(id)
+	if tunnelInfo == nil {
+		return diag.Diagnostics{}
+	}
+
+	diags := tunnelInfo.close()
 	r.tunnelTracker.Remove(id)
 
 	return diags
 }
 
+// lockLocalPort waits for concurrent runs sharing the lock directory to
+// release the given local port.
+func (r *ConnectionEphemeralResource) lockLocalPort(ctx context.Context, port int32, owner string) (*filelock.Lock, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.lockTimeout)
+	defer cancel()
+
+	tflog.Debug(ctx, "Locking local port", map[string]interface{}{"local_port": port, "lock_dir": r.lockDir.Path()})
+
+	return r.lockDir.Lock(ctx, fmt.Sprintf("port-%d", port), owner)
+}
+
+// resolveHost returns the address to connect to and the host key callback
+// to use, applying the system-wide OpenSSH config and known_hosts if enabled
+// and the host key verification of the connection. The percent tokens of the
+// known_hosts files of the system-wide config are expanded for user.
+func (r *ConnectionEphemeralResource) resolveHost(ctx context.Context, host string, port int32, user string, hostKeys hostKeyConfig) (string, ssh.HostKeyCallback, error) {
+	host, err := hostToASCII(host)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid host name: %w", err)
+	}
+	tokens := sshconfig.Tokens{Host: host, Port: strconv.Itoa(int(port)), User: user}
+
+	settings := &sshconfig.Settings{}
+	if r.systemSSHConfig {
+		var err error
+		settings, err = sshconfig.Resolve(host, sshconfig.SystemConfigFile())
+		if err != nil {
+			return "", nil, err
+		}
+		if settings.HostName != "" {
+			tflog.Debug(ctx, "Resolved host from system ssh_config", map[string]interface{}{"host": host, "hostname": settings.HostName})
+			host = settings.HostName
+		}
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	hostKeyCallback, err := r.hostKeyCallback(ctx, settings, tokens, hostKeys)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(hostKeys.certificateAuthorities) > 0 {
+		authorities := make([]ssh.PublicKey, 0, len(hostKeys.certificateAuthorities))
+		for _, authority := range hostKeys.certificateAuthorities {
+			key, err := parseCertificateAuthority(authority)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid host_certificate_authorities: %w", err)
+			}
+			authorities = append(authorities, key)
+		}
+		hostKeyCallback = certificateHostKeyCallback(authorities, hostKeyCallback)
+	}
+	if hostKeyCallback == nil {
+		return addr, ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	if hostKeys.revokedHostKeysFile != "" {
+		file := sshconfig.ExpandPath(hostKeys.revokedHostKeysFile)
+		revoked, err := loadRevokedHostKeys(file)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid revoked_host_keys_file: %w", err)
+		}
+		hostKeyCallback = revokedHostKeyCallback(revoked, hostKeyCallback)
+	}
+	return addr, hostKeyCallback, nil
+}
+
+// hostKeyCallback returns the callback verifying plain host keys by the
+// host_key mode, nil if they aren't verified.
+func (r *ConnectionEphemeralResource) hostKeyCallback(ctx context.Context, settings *sshconfig.Settings, tokens sshconfig.Tokens, hostKeys hostKeyConfig) (ssh.HostKeyCallback, error) {
+	mode := hostKeys.effectiveMode()
+	switch mode {
+	case hostKeyModeInsecure:
+		return nil, nil
+	case hostKeyModeFingerprint:
+		fingerprint, err := parseHostKeyFingerprint(hostKeys.fingerprint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host_key_fingerprint: %w", err)
+		}
+		return pinnedHostKeyCallback(fingerprint), nil
+	}
+
+	knownHostsFiles := []string{}
+	if r.systemKnownHosts {
+		knownHostsFiles = append(knownHostsFiles, sshconfig.SystemKnownHostsFile())
+		for _, file := range settings.GlobalKnownHostsFiles {
+			file, err := sshconfig.ExpandTokens(file, tokens)
+			if err != nil {
+				return nil, fmt.Errorf("invalid GlobalKnownHostsFile: %w", err)
+			}
+			knownHostsFiles = append(knownHostsFiles, file)
+		}
+	}
+	knownHostsFile := sshconfig.ExpandPath(hostKeys.knownHostsFile)
+	if knownHostsFile == "" {
+		knownHostsFile = sshconfig.ExpandPath(defaultKnownHostsFile)
+	} else if _, err := os.Stat(knownHostsFile); err != nil && mode == hostKeyModeStrict {
+		// Unlike the other files, the configured file has to exist, unless
+		// new hosts are added to it.
+		return nil, fmt.Errorf("invalid known_hosts_file: %w", err)
+	}
+	knownHostsFiles = append(knownHostsFiles, knownHostsFile)
+
+	hostKeyCallback, err := knownHostsCallback(knownHostsFiles)
+	if err != nil {
+		return nil, err
+	}
+	if mode == hostKeyModeAcceptNew {
+		hostKeyCallback = acceptNewHostKeyCallback(ctx, hostKeyCallback, knownHostsFile)
+	}
+
+	return describedHostKeyCallback(hostKeyCallback), nil
+}
+
 func hostAddr(host basetypes.StringValue, port basetypes.Int32Value) string {
-	return fmt.Sprintf("%s:%d", host.ValueString(), port.ValueInt32())
+	return net.JoinHostPort(host.ValueString(), strconv.Itoa(int(port.ValueInt32())))
 }
 
 func (r *ConnectionEphemeralResource) Close(ctx context.Context, req ephemeral.CloseRequest, resp *ephemeral.CloseResponse) {
@@ -291,6 +2155,11 @@ func (r *ConnectionEphemeralResource) Close(ctx context.Context, req ephemeral.C
 		return
 	}
 
+	// No tunnel was opened, e.g. outside of apply with apply_only
+	if b == nil {
+		return
+	}
+
 	var privateData ConnectionPrivateData
 	if err := json.Unmarshal(b, &privateData); err != nil {
 		resp.Diagnostics.AddError("Private Data Error", fmt.Sprintf("Unable to unmarshal private data, got error: %s", err))
@@ -298,6 +2167,7 @@ func (r *ConnectionEphemeralResource) Close(ctx context.Context, req ephemeral.C
 	}
 
 	resp.Diagnostics.Append(r.closeByConnectionID(privateData.ID)...)
+	r.reclaimedPorts.record(privateData.Key, privateData.LocalPorts)
 }
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
@@ -31,6 +31,12 @@ ephemeral "sshtunnel_connection" "test" {
 		private_key = %[4]q
 	}
 
+	host_key = {
+		mode = "insecure"
+	}
+
+	report_timings = true
+
 	local_port_forwardings = [{
 		local_port = 15432
 		remote_host = %[5]q
@@ -54,6 +60,8 @@ resource "echo" "test" {}
 				Config: config,
 				Check: resource.ComposeAggregateTestCheckFunc(
 					resource.TestCheckResourceAttr("echo.test", "data.local_port_forwardings.0.local_port", "15432"),
+					resource.TestCheckResourceAttrSet("echo.test", "data.timings.handshake"),
+					resource.TestCheckResourceAttr("echo.test", "data.timings.local_port_forwardings.#", "1"),
 				),
 			},
 		},
@@ -83,6 +91,10 @@ ephemeral "sshtunnel_connection" "test" {
 		private_key = %[4]q
 	}
 
+	host_key = {
+		mode = "insecure"
+	}
+
 	local_port_forwardings = [{
 		remote_host = %[5]q
 		remote_port = %[6]d
@@ -116,6 +128,58 @@ resource "echo" "test" {}
 	})
 }
 
+func TestAccEphemeralConnection_DynamicPortForwarding(t *testing.T) {
+	sshHost := "localhost"
+	sshPort := 23333
+	key, err := os.ReadFile("../../testing/test-key")
+	if err != nil {
+		t.Fatalf("Error reading test-key: %s", err)
+	}
+	sshUser := "terraform"
+	sshPrivateKey := string(key)
+
+	config := fmt.Sprintf(`
+ephemeral "sshtunnel_connection" "test" {
+	host = %[1]q
+	port = %[2]d
+	user = %[3]q
+
+	auth = {
+		private_key = %[4]q
+	}
+
+	host_key = {
+		mode = "insecure"
+	}
+
+	dynamic_port_forwardings = [{
+		local_port = 11080
+	}]
+}
+
+provider "echo" {
+	data = ephemeral.sshtunnel_connection.test
+}
+
+resource "echo" "test" {}
+`, sshHost, sshPort, sshUser, sshPrivateKey)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Read testing
+			{
+				Config: config,
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("echo.test", "data.dynamic_port_forwardings.0.local_port", "11080"),
+					resource.TestCheckResourceAttr("echo.test", "data.dynamic_port_forwardings.0.proxy_url", "socks5h://127.0.0.1:11080"),
+				),
+			},
+		},
+	})
+}
+
 func TestAccEphemeralConnection_Invalid(t *testing.T) {
 	sshHost := "localhost"
 	sshPort := 23333
@@ -139,6 +203,10 @@ ephemeral "sshtunnel_connection" "test" {
 		private_key = %[4]q
 	}
 
+	host_key = {
+		mode = "insecure"
+	}
+
 	local_port_forwardings = [{
 		remote_host = %[5]q
 		remote_port = %[6]d
@@ -171,3 +239,122 @@ resource "echo" "test" {}
 		},
 	})
 }
+
+func TestAccEphemeralConnection_ExitOnForwardFailureDisabled(t *testing.T) {
+	sshHost := "localhost"
+	sshPort := 23333
+	key, err := os.ReadFile("../../testing/test-key")
+	if err != nil {
+		t.Fatalf("Error reading test-key: %s", err)
+	}
+	sshUser := "terraform"
+	sshPrivateKey := string(key)
+
+	remoteHost := "postgresbehindsshtunnel"
+	remotePort := 5432
+
+	// The second forwarding fails as the local port is already in use
+	config := fmt.Sprintf(`
+ephemeral "sshtunnel_connection" "test" {
+	host = %[1]q
+	port = %[2]d
+	user = %[3]q
+
+	auth = {
+		private_key = %[4]q
+	}
+
+	host_key = {
+		mode = "insecure"
+	}
+
+	exit_on_forward_failure = false
+
+	local_port_forwardings = [{
+		local_port = 15433
+		remote_host = %[5]q
+		remote_port = %[6]d
+	}, {
+		local_port = 15433
+		remote_host = %[5]q
+		remote_port = %[6]d
+	}]
+}
+
+provider "echo" {
+	data = ephemeral.sshtunnel_connection.test
+}
+
+resource "echo" "test" {}
+`, sshHost, sshPort, sshUser, sshPrivateKey, remoteHost, remotePort)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// Read testing
+			{
+				Config: config,
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("echo.test", "data.local_port_forwardings.0.local_port", "15433"),
+					resource.TestCheckResourceAttr("echo.test", "data.timings.local_port_forwardings.#", "2"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccEphemeralConnection_OnFailureWarn(t *testing.T) {
+	key, err := os.ReadFile("../../testing/test-key")
+	if err != nil {
+		t.Fatalf("Error reading test-key: %s", err)
+	}
+
+	// Nothing listens on port 1, so the tunnel can't be established.
+	config := fmt.Sprintf(`
+ephemeral "sshtunnel_connection" "test" {
+	host = "localhost"
+	port = 1
+	user = "terraform"
+
+	auth = {
+		private_key = %[1]q
+	}
+
+	host_key = {
+		mode = "insecure"
+	}
+
+	on_failure = "warn"
+
+	local_port_forwardings = [{
+		local_port = 15434
+		remote_host = "postgresbehindsshtunnel"
+		remote_port = 5432
+	}, {
+		remote_host = "postgresbehindsshtunnel"
+		remote_port = 5432
+	}]
+}
+
+provider "echo" {
+	data = ephemeral.sshtunnel_connection.test
+}
+
+resource "echo" "test" {}
+`, string(key))
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("echo.test", "data.local_port_forwardings.0.local_port", "15434"),
+					resource.TestCheckResourceAttr("echo.test", "data.local_port_forwardings.1.local_port", "0"),
+				),
+			},
+		},
+	})
+}
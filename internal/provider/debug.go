@@ -0,0 +1,182 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"golang.org/x/crypto/ssh"
+)
+
+// ConfigValueFunc returns a configuration value of the given schema type.
+type ConfigValueFunc func(typ tftypes.Type) (tftypes.Value, error)
+
+// Debugger connects like an sshtunnel_connection outside of Terraform, so
+// the debugging subcommands of the provider binary share the code paths of
+// the ephemeral resource.
+type Debugger struct {
+	resource *ConnectionEphemeralResource
+	data     ConnectionEphemeralResourceModel
+}
+
+// NewDebugger configures the provider and validates a connection like
+// Terraform does before opening it.
+func NewDebugger(ctx context.Context, version string, providerConfig, connectionConfig ConfigValueFunc) (*Debugger, error) {
+	p, ok := New(version)().(*SSHTunnelProvider)
+	if !ok {
+		return nil, errors.New("unexpected provider type")
+	}
+
+	providerSchema := provider.SchemaResponse{}
+	p.Schema(ctx, provider.SchemaRequest{}, &providerSchema)
+	providerValue, err := providerConfig(providerSchema.Schema.Type().TerraformType(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("provider: %w", err)
+	}
+
+	configureResp := provider.ConfigureResponse{}
+	p.Configure(ctx, provider.ConfigureRequest{
+		Config: tfsdk.Config{Raw: providerValue, Schema: providerSchema.Schema},
+	}, &configureResp)
+	if err := diagnosticsError(configureResp.Diagnostics); err != nil {
+		return nil, err
+	}
+
+	r := &ConnectionEphemeralResource{}
+	configureResourceResp := ephemeral.ConfigureResponse{}
+	r.Configure(ctx, ephemeral.ConfigureRequest{ProviderData: configureResp.EphemeralResourceData}, &configureResourceResp)
+	if err := diagnosticsError(configureResourceResp.Diagnostics); err != nil {
+		return nil, err
+	}
+
+	resourceSchema := ephemeral.SchemaResponse{}
+	r.Schema(ctx, ephemeral.SchemaRequest{}, &resourceSchema)
+	connectionValue, err := connectionConfig(resourceSchema.Schema.Type().TerraformType(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("connection: %w", err)
+	}
+	config := tfsdk.Config{Raw: connectionValue, Schema: resourceSchema.Schema}
+
+	validateResp := ephemeral.ValidateConfigResponse{}
+	for _, v := range r.ConfigValidators(ctx) {
+		v.ValidateEphemeralResource(ctx, ephemeral.ValidateConfigRequest{Config: config}, &validateResp)
+	}
+	r.ValidateConfig(ctx, ephemeral.ValidateConfigRequest{Config: config}, &validateResp)
+	if err := diagnosticsError(validateResp.Diagnostics); err != nil {
+		return nil, err
+	}
+
+	d := &Debugger{resource: r}
+	if err := diagnosticsError(config.Get(ctx, &d.data)); err != nil {
+		return nil, err
+	}
+	if err := diagnosticsError(applyTunnelDefinition(&d.data)); err != nil {
+		return nil, err
+	}
+	if err := diagnosticsError(r.applyForwardingProfiles(&d.data)); err != nil {
+		return nil, err
+	}
+	if err := diagnosticsError(expandUser(&d.data)); err != nil {
+		return nil, err
+	}
+	if err := diagnosticsError(expandPaths(&d.data)); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Connect establishes the authenticated SSH connection.
+func (d *Debugger) Connect(ctx context.Context) (*ssh.Client, *DialTimings, error) {
+	conn, timings, diags := d.resource.connect(ctx, &d.data)
+	return conn, timings, diagnosticsError(diags)
+}
+
+// Addr returns the address connected to, with srv of the first server, after
+// applying the system-wide OpenSSH config if enabled.
+func (d *Debugger) Addr(ctx context.Context) (string, error) {
+	servers, err := sshServers(ctx, &d.data)
+	if err != nil {
+		return "", err
+	}
+	addr, _, err := d.resource.resolveHost(ctx, servers[0].host, servers[0].port, d.data.User.ValueString(), newHostKeyConfig(&d.data))
+	return addr, err
+}
+
+// User returns the user to authenticate as.
+func (d *Debugger) User() string {
+	return d.data.User.ValueString()
+}
+
+// RemoteAddrs returns the remote TCP addresses of the local port
+// forwardings, forwardings to remote sockets are skipped.
+func (d *Debugger) RemoteAddrs() []string {
+	addrs := make([]string, 0, len(d.data.LocalPortForwardings))
+	for _, f := range d.data.LocalPortForwardings {
+		if !f.RemoteSocketPath.IsNull() {
+			continue
+		}
+		host, err := hostToASCII(f.RemoteHost.ValueString())
+		if err != nil {
+			host = f.RemoteHost.ValueString()
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(f.RemotePort.ValueInt32()))))
+	}
+	return addrs
+}
+
+var errKeyscan = errors.New("keyscan")
+
+// KeyscanAlgorithms are the host key algorithms scanned by default.
+var KeyscanAlgorithms = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512,
+}
+
+// ScanHostKey returns the host key of the SSH server at addr offered for the
+// given host key algorithm, the server's preference if empty, without
+// authenticating.
+func ScanHostKey(ctx context.Context, addr, algorithm string) (ssh.PublicKey, error) {
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: "keyscan",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errKeyscan
+		},
+	}
+	if algorithm != "" {
+		config.HostKeyAlgorithms = []string{algorithm}
+	}
+
+	client, _, err := dial(ctx, addr, config, nil)
+	if err == nil {
+		client.Close()
+	}
+	if hostKey == nil {
+		if err == nil {
+			err = errors.New("no host key received")
+		}
+		return nil, err
+	}
+
+	return hostKey, nil
+}
+
+func diagnosticsError(diags diag.Diagnostics) error {
+	var errs []error
+	for _, d := range diags.Errors() {
+		errs = append(errs, fmt.Errorf("%s: %s", d.Summary(), d.Detail()))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DialTimings records how long each phase of establishing an SSH connection took.
+type DialTimings struct {
+	DNS       time.Duration
+	Connect   time.Duration
+	Handshake time.Duration
+	Auth      time.Duration
+}
+
+// dial establishes an SSH connection to addr, recording the duration of the
+// DNS lookup, TCP connect, key exchange and authentication phases. The
+// timings of completed phases are returned even if dialing fails. Global
+// requests of the server are answered by requestHandlers by type, others
+// are rejected.
+func dial(ctx context.Context, addr string, config *ssh.ClientConfig, requestHandlers map[string]GlobalRequestHandler) (*ssh.Client, *DialTimings, error) {
+	timings := &DialTimings{}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, timings, err
+	}
+
+	start := time.Now()
+	ips := []string{host}
+	// IP addresses, including zone-indexed IPv6 addresses, aren't looked up.
+	if _, err := netip.ParseAddr(host); err != nil {
+		ipAddrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		timings.DNS = time.Since(start)
+		if err != nil {
+			return nil, timings, err
+		}
+
+		ips = ips[:0]
+		for _, ipAddr := range ipAddrs {
+			ips = append(ips, ipAddr.String())
+		}
+	}
+
+	start = time.Now()
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	var conn net.Conn
+	var dialErr error
+	for _, ip := range ips {
+		conn, dialErr = dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+		if dialErr == nil {
+			break
+		}
+	}
+	timings.Connect = time.Since(start)
+	if conn == nil {
+		if dialErr == nil {
+			dialErr = errors.New("no addresses found")
+		}
+		return nil, timings, fmt.Errorf("dial tcp %s: %w", addr, dialErr)
+	}
+
+	// The host key is verified right after the key exchange, everything
+	// after that is attributed to authentication.
+	start = time.Now()
+	var handshakeDone time.Time
+	wrappedConfig := *config
+	wrappedConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if handshakeDone.IsZero() {
+			handshakeDone = time.Now()
+		}
+		return config.HostKeyCallback(hostname, remote, key)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, &wrappedConfig)
+	if handshakeDone.IsZero() {
+		timings.Handshake = time.Since(start)
+	} else {
+		timings.Handshake = handshakeDone.Sub(start)
+		timings.Auth = time.Since(handshakeDone)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, timings, err
+	}
+
+	if len(requestHandlers) > 0 {
+		reqs = handleGlobalRequests(reqs, requestHandlers)
+	}
+	return ssh.NewClient(c, chans, reqs), timings, nil
+}
+
+var errAuthProbe = errors.New("auth probe")
+
+// probeAuthMethods opens a throwaway connection to addr that offers no
+// credentials and reports which of the authentication methods supported by
+// this provider the server is willing to accept. The client only tries
+// methods the server advertised in its response to the initial "none"
+// request, so every callback invoked marks an accepted method. Methods this
+// provider cannot perform (e.g. hostbased) are not detected.
+func probeAuthMethods(ctx context.Context, addr string, config *ssh.ClientConfig) ([]string, error) {
+	var accepted []string
+	record := func(method string) {
+		for _, m := range accepted {
+			if m == method {
+				return
+			}
+		}
+		accepted = append(accepted, method)
+	}
+
+	probeConfig := *config
+	probeConfig.Auth = []ssh.AuthMethod{
+		ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			record("publickey")
+			return nil, errAuthProbe
+		}),
+		ssh.PasswordCallback(func() (string, error) {
+			record("password")
+			return "", errAuthProbe
+		}),
+		ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			record("keyboard-interactive")
+			return nil, errAuthProbe
+		}),
+	}
+
+	client, _, err := dial(ctx, addr, &probeConfig, nil)
+	if err == nil {
+		client.Close()
+		return []string{"none"}, nil
+	}
+	if !errors.Is(err, errAuthProbe) && !isAuthError(err) {
+		return nil, err
+	}
+
+	return accepted, nil
+}
+
+// isAuthError reports whether err was caused by the server rejecting all
+// offered authentication methods.
+func isAuthError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "ssh: unable to authenticate")
+}
@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestSSHServer starts an SSH server passing new channels to
+// handleChannel, or rejecting them if it is nil.
+func startTestSSHServer(t *testing.T, config *ssh.ServerConfig, handleChannel func(ssh.NewChannel)) string {
+	t.Helper()
+
+	return startTestSSHServerWithRequests(t, config, nil, handleChannel)
+}
+
+// startTestSSHServerWithRequests starts an SSH server like
+// startTestSSHServer, passing global requests to handleRequest, or
+// discarding them if it is nil.
+func startTestSSHServerWithRequests(t *testing.T, config *ssh.ServerConfig, handleRequest func(*ssh.Request), handleChannel func(ssh.NewChannel)) string {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start SSH server: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				defer sshConn.Close()
+				if handleRequest == nil {
+					go ssh.DiscardRequests(reqs)
+				} else {
+					go func() {
+						for req := range reqs {
+							handleRequest(req)
+						}
+					}()
+				}
+				for newChannel := range chans {
+					if handleChannel == nil {
+						_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+						continue
+					}
+					go handleChannel(newChannel)
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestDialTimings(t *testing.T) {
+	addr := startTestSSHServer(t, &ssh.ServerConfig{NoClientAuth: true}, nil)
+
+	client, timings, err := dial(context.Background(), addr, &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	if timings.Connect <= 0 || timings.Handshake <= 0 || timings.Auth <= 0 {
+		t.Errorf("Expected connect, handshake and auth timings to be recorded, got %+v", timings)
+	}
+}
+
+func TestProbeAuthMethods(t *testing.T) {
+	addr := startTestSSHServer(t, &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, errors.New("denied")
+		},
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			if _, err := client("", "", []string{"Password: "}, []bool{false}); err != nil {
+				return nil, err
+			}
+			return nil, errors.New("denied")
+		},
+	}, nil)
+
+	config := &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+
+	_, _, err := dial(context.Background(), addr, config, nil)
+	if !isAuthError(err) {
+		t.Fatalf("Expected authentication error, got %v", err)
+	}
+
+	methods, err := probeAuthMethods(context.Background(), addr, config)
+	if err != nil {
+		t.Fatalf("Failed to probe auth methods: %v", err)
+	}
+
+	if want := []string{"password", "keyboard-interactive"}; !reflect.DeepEqual(methods, want) {
+		t.Errorf("got %v, want %v", methods, want)
+	}
+}
@@ -0,0 +1,109 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+// dynamicListenHost is the address dynamic port forwardings listen on, their
+// SOCKS5 clients can't authenticate.
+const dynamicListenHost = "127.0.0.1"
+
+const (
+	dynamicProtocolSOCKS5 = "socks5"
+	dynamicProtocolHTTP   = "http"
+)
+
+func dynamicProtocol(f ConnectionEphemeralResourceModelDynamicPortForwarding) string {
+	if f.Protocol.IsNull() {
+		return dynamicProtocolSOCKS5
+	}
+	return f.Protocol.ValueString()
+}
+
+// fanOutGuard returns the guard of f, nil without fan_out_guard.
+func fanOutGuard(f ConnectionEphemeralResourceModelDynamicPortForwarding) (*portforward.FanOutGuard, error) {
+	if f.FanOutGuard == nil {
+		return nil, nil
+	}
+
+	limits := portforward.FanOutLimits{
+		MaxRequests: int(f.FanOutGuard.MaxRequests.ValueInt32()),
+		MaxHosts:    int(f.FanOutGuard.MaxHosts.ValueInt32()),
+		MaxPorts:    int(f.FanOutGuard.MaxPorts.ValueInt32()),
+	}
+	if !f.FanOutGuard.Window.IsNull() {
+		window, err := time.ParseDuration(f.FanOutGuard.Window.ValueString())
+		if err != nil {
+			return nil, fmt.Errorf("invalid window: %w", err)
+		}
+		limits.Window = window
+	}
+	return portforward.NewFanOutGuard(limits), nil
+}
+
+func (o *tunnelOpener) openDynamicPortForwardings() bool {
+	return o.each(len(o.data.DynamicPortForwardings), o.openDynamicPortForwarding)
+}
+
+func (o *tunnelOpener) openDynamicPortForwarding(i int) error {
+	dynamicPortForwarding := o.data.DynamicPortForwardings[i]
+
+	conf := o.config(priorityClassInteractive)
+	conf.LocalPort = dynamicPortForwarding.LocalPort.ValueInt32Pointer()
+	conf.ListenHost = dynamicListenHost
+	conf.SOCKS5 = dynamicProtocol(dynamicPortForwarding) == dynamicProtocolSOCKS5
+	conf.HTTPConnect = dynamicProtocol(dynamicPortForwarding) == dynamicProtocolHTTP
+	conf.MaxConnections = dynamicPortForwarding.MaxConnections.ValueInt32()
+	conf.AcceptWorkers = dynamicPortForwarding.AcceptWorkers.ValueInt32()
+	conf.Budget = o.r.budget
+
+	guard, err := fanOutGuard(dynamicPortForwarding)
+	if err != nil {
+		return o.fail("Dynamic Port Forwarding Error", fmt.Sprintf("Invalid fan_out_guard: %s", err))
+	}
+	conf.FanOut = guard
+
+	if err := o.lockLocalPort(conf.LocalPort); err != nil {
+		return err
+	}
+
+	listener, err := portforward.New(context.WithoutCancel(o.ctx), o.conn, conf)
+	if err != nil {
+		return listenFailure(err, fmt.Sprintf("Unable to create dynamic port forwarding, got error: %s", err))
+	}
+	if err := o.addListener(listener, true); err != nil {
+		return err
+	}
+	if guard != nil {
+		o.info.watchFanOut(o.tunnelCtx, guard, "fan_out_guard of the dynamic port forwarding on "+listener.Addr().String())
+	}
+
+	tcpAddr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		return o.fail("Port Forwarding Error", "Listener address is not a TCP address")
+	}
+
+	tflog.Info(o.ctx, "Dynamic port forwarding created", map[string]interface{}{
+		"local_port": tcpAddr.Port,
+		"protocol":   dynamicProtocol(dynamicPortForwarding),
+	})
+
+	proxyURL := "socks5h://" + tcpAddr.String()
+	if conf.HTTPConnect {
+		proxyURL = "http://" + tcpAddr.String()
+	}
+	o.data.DynamicPortForwardings[i].LocalPort = basetypes.NewInt32Value(int32(tcpAddr.Port))
+	o.data.DynamicPortForwardings[i].ProxyURL = types.StringValue(proxyURL)
+	if !dynamicPortForwarding.Name.IsNull() {
+		o.namedAddrs[dynamicPortForwarding.Name.ValueString()] = tcpAddr.String()
+	}
+	return nil
+}
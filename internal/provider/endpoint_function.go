@@ -0,0 +1,70 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/endpoints"
+)
+
+var _ function.Function = &EndpointFunction{}
+
+// EndpointFunction resolves the local address of a named local port
+// forwarding. Terraform calls functions on provider instances that aren't
+// configured and didn't open the tunnel, so the address is read from the
+// endpoints published by the process that did.
+type EndpointFunction struct{}
+
+func NewEndpointFunction() function.Function {
+	return &EndpointFunction{}
+}
+
+func (f *EndpointFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "endpoint"
+}
+
+func (f *EndpointFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary: "Resolves the local address of a named local port forwarding",
+		MarkdownDescription: "Returns the current local address, e.g. `127.0.0.1:41234`, of the local port forwarding `name` of the open connection `connection_id`, " +
+			"so consumers reference the forwarding instead of copying its port around. The address is resolved when the function is evaluated, " +
+			"including tunnels opened by other processes, e.g. kept open by the `daemon` subcommand and reopened on another port. " +
+			"Returns null if `connection_id` is null, e.g. for the placeholder results of tunnels that aren't opened during plan with `apply_only`",
+		Parameters: []function.Parameter{
+			function.StringParameter{
+				Name:                "connection_id",
+				MarkdownDescription: "The `connection_id` of the connection",
+				AllowNullValue:      true,
+			},
+			function.StringParameter{
+				Name:                "name",
+				MarkdownDescription: "The `name` of the local port forwarding",
+			},
+		},
+		Return: function.StringReturn{},
+	}
+}
+
+func (f *EndpointFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var connectionID types.String
+	var name string
+
+	resp.Error = function.ConcatFuncErrors(resp.Error, req.Arguments.Get(ctx, &connectionID, &name))
+	if resp.Error != nil {
+		return
+	}
+
+	if connectionID.IsNull() {
+		resp.Error = function.ConcatFuncErrors(resp.Error, resp.Result.Set(ctx, types.StringNull()))
+		return
+	}
+
+	addr, err := endpoints.Lookup(endpoints.Dir(), connectionID.ValueString(), name)
+	if err != nil {
+		resp.Error = function.ConcatFuncErrors(resp.Error, function.NewFuncError(err.Error()))
+		return
+	}
+
+	resp.Error = function.ConcatFuncErrors(resp.Error, resp.Result.Set(ctx, addr))
+}
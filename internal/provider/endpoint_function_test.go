@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/endpoints"
+)
+
+func TestEndpointFunction(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(endpoints.DirEnv, dir)
+
+	info := &TunnelInfo{Owner: "connection to bastion"}
+	if err := info.publishEndpoints(dir, "db", map[string]string{"primary": "127.0.0.1:15432"}); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	run := func(connectionID types.String, name string) function.RunResponse {
+		resp := function.RunResponse{Result: function.NewResultData(types.StringUnknown())}
+		NewEndpointFunction().Run(context.Background(), function.RunRequest{
+			Arguments: function.NewArgumentsData([]attr.Value{connectionID, types.StringValue(name)}),
+		}, &resp)
+		return resp
+	}
+
+	resp := run(types.StringValue("db"), "primary")
+	if resp.Error != nil {
+		t.Fatalf("Unexpected error: %s", resp.Error)
+	}
+	if got := resp.Result.Value(); !got.Equal(types.StringValue("127.0.0.1:15432")) {
+		t.Errorf("got %s, want 127.0.0.1:15432", got)
+	}
+
+	if resp := run(types.StringValue("db"), "replica"); resp.Error == nil {
+		t.Error("Expected an error for an unknown name")
+	}
+
+	if resp := run(types.StringNull(), "primary"); resp.Error != nil || !resp.Result.Value().IsNull() {
+		t.Errorf("Expected null for a null connection_id, got %s, %v", resp.Result.Value(), resp.Error)
+	}
+
+	// Closing the tunnel withdraws its endpoints.
+	if diags := info.close(); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	if resp := run(types.StringValue("db"), "primary"); resp.Error == nil {
+		t.Error("Expected an error after closing the tunnel")
+	}
+}
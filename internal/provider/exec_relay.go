@@ -0,0 +1,142 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+	"golang.org/x/crypto/ssh"
+)
+
+var errDeadlineNotSupported = errors.New("exec relay: deadline not supported")
+
+// execDialer relays connections through the stdio of a command run on the
+// SSH server, e.g. `nc %h %p`, for servers prohibiting direct-tcpip channels
+// but allowing exec.
+type execDialer struct {
+	conn    *ssh.Client
+	command string
+}
+
+func (d *execDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := d.conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.Start(relayCommand(d.command, host, port)); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("exec relay: %w", err)
+	}
+
+	return &execConn{session: session, stdin: stdin, stdout: stdout, remoteAddr: addr}, nil
+}
+
+// relayCommand expands the %h (shell quoted) and %p tokens of command to the
+// host and port to relay to.
+func relayCommand(command, host, port string) string {
+	quoted := "'" + strings.ReplaceAll(host, "'", `'\''`) + "'"
+	return strings.NewReplacer("%h", quoted, "%p", port, "%%", "%").Replace(command)
+}
+
+// execConn is a connection relayed through the stdio of a session.
+type execConn struct {
+	session    *ssh.Session
+	stdin      io.WriteCloser
+	stdout     io.Reader
+	remoteAddr string
+}
+
+func (c *execConn) Read(b []byte) (int, error) {
+	return c.stdout.Read(b)
+}
+
+func (c *execConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+// CloseWrite sends EOF to the relay command, so half-closes are forwarded.
+func (c *execConn) CloseWrite() error {
+	return c.stdin.Close()
+}
+
+func (c *execConn) Close() error {
+	return c.session.Close()
+}
+
+func (c *execConn) LocalAddr() net.Addr {
+	return execAddr("exec")
+}
+
+func (c *execConn) RemoteAddr() net.Addr {
+	return execAddr(c.remoteAddr)
+}
+
+func (c *execConn) SetDeadline(t time.Time) error {
+	return errDeadlineNotSupported
+}
+
+func (c *execConn) SetReadDeadline(t time.Time) error {
+	return errDeadlineNotSupported
+}
+
+func (c *execConn) SetWriteDeadline(t time.Time) error {
+	return errDeadlineNotSupported
+}
+
+type execAddr string
+
+func (a execAddr) Network() string {
+	return "exec"
+}
+
+func (a execAddr) String() string {
+	return string(a)
+}
+
+// isForwardingProhibited reports whether err is the rejection of a
+// direct-tcpip channel by a server not allowing forwardings, e.g. OpenSSH
+// with AllowTcpForwarding no.
+func isForwardingProhibited(err error) bool {
+	var openErr *ssh.OpenChannelError
+	return errors.As(err, &openErr) && openErr.Reason == ssh.Prohibited
+}
+
+// forwardingDialer returns the dialer for connections to remoteAddr: conn
+// itself, unless fallbackCommand is set and the server prohibits direct-tcpip
+// channels, then an execDialer running fallbackCommand. Whether it fell back
+// is reported.
+func forwardingDialer(ctx context.Context, conn *ssh.Client, remoteAddr, fallbackCommand string) (portforward.Dialer, bool) {
+	if fallbackCommand == "" {
+		return conn, false
+	}
+
+	probe, err := conn.DialContext(ctx, "tcp", remoteAddr)
+	if err == nil {
+		probe.Close()
+	}
+	if !isForwardingProhibited(err) {
+		return conn, false
+	}
+
+	return &execDialer{conn: conn, command: fallbackCommand}, true
+}
@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRelayCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		host    string
+		want    string
+	}{
+		{"nc %h %p", "db.internal", "nc 'db.internal' 5432"},
+		{"nc %h %p", "it's", `nc 'it'\''s' 5432`},
+		{"socat - TCP:%h:%p,100%%", "::1", "socat - TCP:'::1':5432,100%"},
+	}
+	for _, tt := range tests {
+		if got := relayCommand(tt.command, tt.host, "5432"); got != tt.want {
+			t.Errorf("relayCommand(%q, %q) = %q, want %q", tt.command, tt.host, got, tt.want)
+		}
+	}
+}
+
+// startProhibitingSSHServer starts an SSH server prohibiting direct-tcpip
+// channels and echoing the stdin of exec sessions, sending the commands run
+// to commands.
+func startProhibitingSSHServer(t *testing.T, commands chan<- string) *ssh.Client {
+	addr := startTestSSHServer(t, &ssh.ServerConfig{NoClientAuth: true}, func(newChannel ssh.NewChannel) {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.Prohibited, "port forwarding disabled")
+			return
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		defer channel.Close()
+
+		for req := range requests {
+			if req.Type != "exec" {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			var exec struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &exec); err != nil {
+				_ = req.Reply(false, nil)
+				return
+			}
+			_ = req.Reply(true, nil)
+			commands <- exec.Command
+
+			_, _ = io.Copy(channel, channel)
+			_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			return
+		}
+	})
+
+	client, _, err := dial(context.Background(), addr, &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestForwardingDialer(t *testing.T) {
+	ctx := context.Background()
+	commands := make(chan string, 1)
+	client := startProhibitingSSHServer(t, commands)
+
+	if dialer, fellBack := forwardingDialer(ctx, client, "db.internal:5432", ""); fellBack || dialer != client {
+		t.Error("Expected no fallback without a command")
+	}
+
+	dialer, fellBack := forwardingDialer(ctx, client, "db.internal:5432", "nc %h %p")
+	if !fellBack {
+		t.Fatal("Expected a fallback as port forwarding is prohibited")
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", "db.internal:5432")
+	if err != nil {
+		t.Fatalf("Failed to dial through exec: %v", err)
+	}
+	defer conn.Close()
+
+	if got, want := <-commands, "nc 'db.internal' 5432"; got != want {
+		t.Errorf("got command %q, want %q", got, want)
+	}
+
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if err := conn.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+		t.Fatalf("Failed to half-close: %v", err)
+	}
+	buf, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if got := string(buf); got != "ping" {
+		t.Errorf("got %q, want %q", got, "ping")
+	}
+}
+
+func TestForwardingDialerAllowed(t *testing.T) {
+	ctx := context.Background()
+	addr := startTestSSHServer(t, &ssh.ServerConfig{NoClientAuth: true}, func(newChannel ssh.NewChannel) {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(requests)
+		channel.Close()
+	})
+	client, _, err := dial(ctx, addr, &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	if _, fellBack := forwardingDialer(ctx, client, net.JoinHostPort("db.internal", "5432"), "nc %h %p"); fellBack {
+		t.Error("Expected no fallback as port forwarding is allowed")
+	}
+}
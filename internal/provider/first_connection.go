@@ -0,0 +1,39 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+// waitForFirstConnection waits until any of the listeners accepted a
+// connection. It reports false if none did within timeout and returns an
+// error if ctx is done first.
+func waitForFirstConnection(ctx context.Context, listeners []*portforward.Listener, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	connected := make(chan struct{}, len(listeners))
+	for _, listener := range listeners {
+		go func() {
+			select {
+			case <-listener.FirstConnection():
+				connected <- struct{}{}
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-connected:
+		return true, nil
+	case <-timer.C:
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
@@ -0,0 +1,47 @@
+package provider
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+func TestWaitForFirstConnection(t *testing.T) {
+	ctx := context.Background()
+
+	newListener := func() *portforward.Listener {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener := portforward.Serve(ctx, l, &net.Dialer{}, &portforward.Config{RemoteAddr: "127.0.0.1:1"})
+		t.Cleanup(func() { listener.Close() })
+		return listener
+	}
+	listeners := []*portforward.Listener{newListener(), newListener()}
+
+	connected, err := waitForFirstConnection(ctx, listeners, 10*time.Millisecond)
+	if err != nil || connected {
+		t.Errorf("Expected a timeout without connections, got %v, %v", connected, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := waitForFirstConnection(cancelled, listeners, time.Minute); err == nil {
+		t.Error("Expected an error for a cancelled context")
+	}
+
+	conn, err := net.Dial("tcp", listeners[1].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	connected, err = waitForFirstConnection(ctx, listeners, time.Minute)
+	if err != nil || !connected {
+		t.Errorf("Expected a connection, got %v, %v", connected, err)
+	}
+}
@@ -0,0 +1,42 @@
+package provider
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/secretref"
+)
+
+// validateGCPKey validates the auth.gcp_secret block, values may be unknown.
+func validateGCPKey(g *ConnectionEphemeralResourceModelAuthGCPSecret) diag.Diagnostics {
+	diags := diag.Diagnostics{}
+
+	if g.Secret.IsUnknown() {
+		return diags
+	}
+	secret := g.Secret.ValueString()
+	if secret == "" {
+		diags.AddError("Auth Error", "auth.gcp_secret.secret must not be empty")
+	}
+	if strings.HasPrefix(secret, "projects/") && !g.Project.IsNull() {
+		diags.AddError("Auth Error", "auth.gcp_secret.project can only be set with a secret ID, the resource name includes the project")
+	}
+	if strings.Contains(secret, "/versions/") && !g.Version.IsNull() {
+		diags.AddError("Auth Error", "auth.gcp_secret.version can only be set if the secret doesn't include the version")
+	}
+
+	return diags
+}
+
+// readGCPKey reads the private key from the Secret Manager secret version of
+// the auth.gcp_secret block.
+func readGCPKey(ctx context.Context, g *ConnectionEphemeralResourceModelAuthGCPSecret) ([]byte, error) {
+	return secretref.ReadGCPSecret(ctx, secretref.GCPSecretRequest{
+		Secret:   g.Secret.ValueString(),
+		Project:  g.Project.ValueString(),
+		Version:  g.Version.ValueString(),
+		Field:    g.Field.ValueString(),
+		Endpoint: g.Endpoint.ValueString(),
+	})
+}
@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/secretref"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultGCPOSLoginTTL is the default time the key stays in the OS Login
+// profile, it is only needed to authenticate.
+const defaultGCPOSLoginTTL = time.Hour
+
+// gcpOSLoginAuthProvider authenticates with an ephemeral key, generated when
+// the tunnel is opened and added with an expiry to the OS Login profile of
+// the Google account, for GCE instances enforcing OS Login.
+type gcpOSLoginAuthProvider struct{}
+
+func (p *gcpOSLoginAuthProvider) Name() string {
+	return "gcp_os_login"
+}
+
+func (p *gcpOSLoginAuthProvider) Configured(auth ConnectionEphemeralResourceModelAuth) bool {
+	return auth.GCPOSLogin != nil
+}
+
+func (p *gcpOSLoginAuthProvider) ValidateConfig(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) diag.Diagnostics {
+	diags := diag.Diagnostics{}
+
+	if _, err := gcpOSLoginTTL(auth.GCPOSLogin); err != nil {
+		diags.AddError("GCP OS Login Error", err.Error())
+	}
+	if !auth.GCPOSLogin.Account.IsNull() && !auth.GCPOSLogin.Account.IsUnknown() && auth.GCPOSLogin.Account.ValueString() == "" {
+		diags.AddError("GCP OS Login Error", "auth.gcp_os_login.account must not be empty")
+	}
+	if !auth.GCPOSLogin.Project.IsNull() && !auth.GCPOSLogin.Project.IsUnknown() && auth.GCPOSLogin.Project.ValueString() == "" {
+		diags.AddError("GCP OS Login Error", "auth.gcp_os_login.project must not be empty")
+	}
+
+	return diags
+}
+
+func (p *gcpOSLoginAuthProvider) AuthMethods(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.AuthMethod, diag.Diagnostics) {
+	return signerAuthMethods(p.Signers(ctx, auth))
+}
+
+func (p *gcpOSLoginAuthProvider) Signers(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.Signer, diag.Diagnostics) {
+	diags := diag.Diagnostics{}
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		diags.AddError("GCP OS Login Error", fmt.Sprintf("Unable to generate a key, got error: %s", err))
+		return nil, diags
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		diags.AddError("GCP OS Login Error", fmt.Sprintf("Unable to generate a key, got error: %s", err))
+		return nil, diags
+	}
+
+	ttl, err := gcpOSLoginTTL(auth.GCPOSLogin)
+	if err != nil {
+		diags.AddError("GCP OS Login Error", err.Error())
+		return nil, diags
+	}
+	expiry := time.Now().Add(ttl)
+
+	if err := secretref.ImportGCPOSLoginKey(ctx, secretref.GCPOSLoginKeyRequest{
+		GCPOSLoginRequest: gcpOSLoginRequest(auth.GCPOSLogin),
+		PublicKey:         ssh.MarshalAuthorizedKey(signer.PublicKey()),
+		Expiry:            expiry,
+	}); err != nil {
+		diags.AddError("GCP OS Login Error", fmt.Sprintf("Unable to add the key to the OS Login profile, got error: %s", err))
+		return nil, diags
+	}
+	tflog.Debug(ctx, "Added key to the OS Login profile", map[string]interface{}{
+		"fingerprint": ssh.FingerprintSHA256(signer.PublicKey()),
+		"expiry":      expiry.UTC().Format(time.RFC3339),
+	})
+
+	return []ssh.Signer{signer}, diags
+}
+
+// gcpOSLoginUser returns the username of the POSIX account of the OS Login
+// profile, used if the connection doesn't set a user.
+func gcpOSLoginUser(ctx context.Context, g *ConnectionEphemeralResourceModelAuthGCPOSLogin) (string, error) {
+	return secretref.GCPOSLoginUser(ctx, gcpOSLoginRequest(g))
+}
+
+func gcpOSLoginRequest(g *ConnectionEphemeralResourceModelAuthGCPOSLogin) secretref.GCPOSLoginRequest {
+	return secretref.GCPOSLoginRequest{
+		Account:  g.Account.ValueString(),
+		Project:  g.Project.ValueString(),
+		Endpoint: g.Endpoint.ValueString(),
+	}
+}
+
+// gcpOSLoginTTL returns the time the key stays in the OS Login profile,
+// defaultGCPOSLoginTTL if unset or unknown.
+func gcpOSLoginTTL(g *ConnectionEphemeralResourceModelAuthGCPOSLogin) (time.Duration, error) {
+	if g.TTL.IsNull() || g.TTL.IsUnknown() {
+		return defaultGCPOSLoginTTL, nil
+	}
+	ttl, err := time.ParseDuration(g.TTL.ValueString())
+	if err != nil {
+		return 0, fmt.Errorf("invalid auth.gcp_os_login.ttl: %s", err)
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("auth.gcp_os_login.ttl must be positive")
+	}
+	return ttl, nil
+}
@@ -0,0 +1,144 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/secretref"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGCPOSLoginAuthProvider(t *testing.T) {
+	var imported struct {
+		Key                string `json:"key"`
+		ExpirationTimeUsec string `json:"expirationTimeUsec"`
+	}
+	var projects []string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/users/deploy@example.iam.gserviceaccount.com:importSshPublicKey", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		projects = append(projects, r.URL.Query().Get("projectId"))
+		if err := json.NewDecoder(r.Body).Decode(&imported); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"loginProfile":{}}`))
+	})
+	mux.HandleFunc("/v1/users/deploy@example.iam.gserviceaccount.com/loginProfile", func(w http.ResponseWriter, r *http.Request) {
+		projects = append(projects, r.URL.Query().Get("projectId"))
+		_, _ = w.Write([]byte(`{"posixAccounts":[{"username":"other"},{"primary":true,"username":"sa_1234"}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// The account is read from the service account key.
+	secretref.RegisterSDK(gcpTestSDK{
+		projectID: "example",
+		json:      []byte(`{"type":"service_account","client_email":"deploy@example.iam.gserviceaccount.com"}`),
+		token:     "test-token",
+	})
+	defer secretref.RegisterSDK(nil)
+
+	ctx := context.Background()
+	p := &gcpOSLoginAuthProvider{}
+	auth := ConnectionEphemeralResourceModelAuth{GCPOSLogin: &ConnectionEphemeralResourceModelAuthGCPOSLogin{
+		Account:  types.StringNull(),
+		Project:  types.StringNull(),
+		TTL:      types.StringValue("10m"),
+		Endpoint: types.StringValue(server.URL),
+	}}
+	if diags := p.ValidateConfig(ctx, auth); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+
+	signers, diags := p.Signers(ctx, auth)
+	if diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	if got, want := imported.Key, string(ssh.MarshalAuthorizedKey(signers[0].PublicKey())); got+"\n" != want {
+		t.Errorf("Expected key %q to be imported, got %q", want, got)
+	}
+	usec, err := strconv.ParseInt(imported.ExpirationTimeUsec, 10, 64)
+	if err != nil {
+		t.Fatalf("Invalid expiration %q: %s", imported.ExpirationTimeUsec, err)
+	}
+	if expiry := time.UnixMicro(usec); expiry.Before(time.Now().Add(9*time.Minute)) || expiry.After(time.Now().Add(11*time.Minute)) {
+		t.Errorf("Expected the key to expire in 10m, got %s", expiry)
+	}
+
+	user, err := gcpOSLoginUser(ctx, auth.GCPOSLogin)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if user != "sa_1234" {
+		t.Errorf("Expected the primary POSIX account sa_1234, got %q", user)
+	}
+
+	for _, project := range projects {
+		if project != "example" {
+			t.Errorf("Expected the project of the credentials, got %q", project)
+		}
+	}
+}
+
+func TestGCPOSLoginAuthProviderValidateConfig(t *testing.T) {
+	ctx := context.Background()
+	p := &gcpOSLoginAuthProvider{}
+
+	for _, ttl := range []string{"soon", "0s"} {
+		auth := ConnectionEphemeralResourceModelAuth{GCPOSLogin: &ConnectionEphemeralResourceModelAuthGCPOSLogin{
+			Account: types.StringNull(),
+			Project: types.StringNull(),
+			TTL:     types.StringValue(ttl),
+		}}
+		if diags := p.ValidateConfig(ctx, auth); !diags.HasError() {
+			t.Errorf("Expected an error for ttl %q", ttl)
+		}
+	}
+
+	auth := ConnectionEphemeralResourceModelAuth{GCPOSLogin: &ConnectionEphemeralResourceModelAuthGCPOSLogin{
+		Account: types.StringValue(""),
+		Project: types.StringNull(),
+		TTL:     types.StringNull(),
+	}}
+	if diags := p.ValidateConfig(ctx, auth); !diags.HasError() {
+		t.Error("Expected an error for an empty account")
+	}
+}
+
+// gcpTestSDK implements the GCP credentials of secretref.SDK, sending token
+// with every request.
+type gcpTestSDK struct {
+	secretref.SDK
+	projectID string
+	json      []byte
+	token     string
+}
+
+func (s gcpTestSDK) GCPCredentials(context.Context, ...string) (*secretref.GCPCredentials, error) {
+	return &secretref.GCPCredentials{
+		ProjectID: s.projectID,
+		JSON:      s.json,
+		Client:    &http.Client{Transport: bearerTransport(s.token)},
+		Token:     func() (string, error) { return s.token, nil },
+	}, nil
+}
+
+// bearerTransport authenticates requests with the token.
+type bearerTransport string
+
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+string(t))
+	return http.DefaultTransport.RoundTrip(req)
+}
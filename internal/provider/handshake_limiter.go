@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultMaxConcurrentHandshakes matches the first MaxStartups threshold of
+// OpenSSH's sshd, beyond which it starts dropping unauthenticated
+// connections.
+const defaultMaxConcurrentHandshakes = 10
+
+// handshakeLimiter bounds the SSH connections being established to the same
+// server concurrently, so opening many tunnels at once, e.g. with
+// `terraform apply -parallelism=50`, doesn't exceed the MaxStartups of the
+// server and fail randomly. Connections to different servers don't wait for
+// each other.
+type handshakeLimiter struct {
+	max int
+
+	mu      sync.Mutex
+	servers map[string]chan struct{}
+}
+
+func newHandshakeLimiter(max int) *handshakeLimiter {
+	return &handshakeLimiter{max: max, servers: map[string]chan struct{}{}}
+}
+
+// acquire waits until a connection to addr can be established, the returned
+// function has to be called once it is authenticated or failed. A nil
+// limiter doesn't wait.
+func (l *handshakeLimiter) acquire(ctx context.Context, addr string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	slots, ok := l.servers[addr]
+	if !ok {
+		slots = make(chan struct{}, l.max)
+		l.servers[addr] = slots
+	}
+	l.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandshakeLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := newHandshakeLimiter(3)
+
+	var active, peak atomic.Int32
+	var otherWaited atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < stressParallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			release, err := l.acquire(ctx, "bastion:22")
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			defer release()
+
+			n := active.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			active.Add(-1)
+		}()
+	}
+
+	// Connections to other servers don't wait for the busy one.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		release, err := l.acquire(ctx, "other:22")
+		if err != nil {
+			otherWaited.Store(true)
+			return
+		}
+		release()
+	}()
+	wg.Wait()
+
+	if got := peak.Load(); got > 3 {
+		t.Errorf("Expected at most 3 concurrent handshakes, got %d", got)
+	}
+	if otherWaited.Load() {
+		t.Error("Expected connections to another server not to wait")
+	}
+}
+
+func TestHandshakeLimiterCancel(t *testing.T) {
+	l := newHandshakeLimiter(1)
+	release, err := l.acquire(context.Background(), "bastion:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, "bastion:22"); err == nil {
+		t.Error("Expected waiting to be cancelled")
+	}
+
+	var unlimited *handshakeLimiter
+	for i := 0; i < 3; i++ {
+		if _, err := unlimited.acquire(ctx, fmt.Sprintf("bastion:%d", i)); err != nil {
+			t.Errorf("Unexpected error without a limiter: %v", err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultHeartbeatCommand  = "true"
+	defaultHeartbeatInterval = time.Minute
+)
+
+// runHeartbeat runs command in a new session every interval until ctx is
+// done, keeping the connection active on bastions that close sessions
+// without command activity regardless of protocol keepalives.
+func runHeartbeat(ctx context.Context, conn *ssh.Client, command string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := execHeartbeat(conn, command); err != nil {
+				tflog.Warn(ctx, "Heartbeat command failed", map[string]interface{}{"command": command, "err": err})
+			}
+		}
+	}
+}
+
+func execHeartbeat(conn *ssh.Client, command string) error {
+	session, err := conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	return session.Run(command)
+}
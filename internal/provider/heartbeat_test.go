@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRunHeartbeat(t *testing.T) {
+	commands := make(chan string, 10)
+	addr := startTestSSHServer(t, &ssh.ServerConfig{NoClientAuth: true}, func(newChannel ssh.NewChannel) {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			return
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		defer channel.Close()
+
+		for req := range requests {
+			if req.Type != "exec" {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			commands <- payload.Command
+			_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+			return
+		}
+	})
+
+	client, _, err := dial(context.Background(), addr, &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runHeartbeat(ctx, client, "true", 10*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case command := <-commands:
+			if command != "true" {
+				t.Errorf("got command %q, want %q", command, "true")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for heartbeat")
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ datasource.DataSource = &HostKeyDataSource{}
+var _ datasource.DataSourceWithValidateConfig = &HostKeyDataSource{}
+
+const defaultKeyscanTimeout = 10 * time.Second
+
+func NewHostKeyDataSource() datasource.DataSource {
+	return &HostKeyDataSource{}
+}
+
+// HostKeyDataSource reads the host keys of an SSH server like ssh-keyscan,
+// without authenticating.
+type HostKeyDataSource struct{}
+
+// HostKeyDataSourceModel describes the data source data model.
+type HostKeyDataSourceModel struct {
+	Host              types.String                `tfsdk:"host"`
+	Port              types.Int32                 `tfsdk:"port"`
+	Algorithms        []types.String              `tfsdk:"algorithms"`
+	Timeout           types.String                `tfsdk:"timeout"`
+	Keys              []HostKeyDataSourceModelKey `tfsdk:"keys"`
+	FingerprintSHA256 types.String                `tfsdk:"fingerprint_sha256"`
+	KnownHosts        types.String                `tfsdk:"known_hosts"`
+}
+
+type HostKeyDataSourceModelKey struct {
+	Type              types.String `tfsdk:"type"`
+	PublicKey         types.String `tfsdk:"public_key"`
+	FingerprintSHA256 types.String `tfsdk:"fingerprint_sha256"`
+}
+
+func (d *HostKeyDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_host_key"
+}
+
+func (d *HostKeyDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "Reads the host keys of an SSH server like `ssh-keyscan`, without authenticating, e.g. to pin them with `host_key_fingerprint` of an `sshtunnel_connection` or write them to a known_hosts file. " +
+			"The keys are trusted on first use, compare them with the keys of the server on first read.",
+
+		Attributes: map[string]schema.Attribute{
+			"host": schema.StringAttribute{
+				MarkdownDescription: "Host of the SSH server, internationalized names are converted to punycode",
+				Required:            true,
+			},
+			"port": schema.Int32Attribute{
+				MarkdownDescription: "Port of the SSH server (defaults to `22`)",
+				Optional:            true,
+			},
+			"algorithms": schema.ListAttribute{
+				MarkdownDescription: "Host key algorithms to scan, in order (defaults to `" + strings.Join(KeyscanAlgorithms, "`, `") + "`). Algorithms not offered by the server are skipped",
+				ElementType:         types.StringType,
+				Optional:            true,
+			},
+			"timeout": schema.StringAttribute{
+				MarkdownDescription: "Time to wait for all keys to be read (defaults to `10s`)",
+				Optional:            true,
+			},
+			"keys": schema.ListNestedAttribute{
+				MarkdownDescription: "Host keys offered by the server, in the order of `algorithms`",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"type": schema.StringAttribute{
+							MarkdownDescription: "Type of the key, e.g. `ssh-ed25519`",
+							Computed:            true,
+						},
+						"public_key": schema.StringAttribute{
+							MarkdownDescription: "Public key in the `authorized_keys` format",
+							Computed:            true,
+						},
+						"fingerprint_sha256": schema.StringAttribute{
+							MarkdownDescription: "SHA256 fingerprint of the key, e.g. `SHA256:...`",
+							Computed:            true,
+						},
+					},
+				},
+				Computed: true,
+			},
+			"fingerprint_sha256": schema.StringAttribute{
+				MarkdownDescription: "SHA256 fingerprint of the first key, e.g. for `host_key_fingerprint` of an `sshtunnel_connection`",
+				Computed:            true,
+			},
+			"known_hosts": schema.StringAttribute{
+				MarkdownDescription: "Lines of a known_hosts file with all keys, e.g. for `known_hosts_file` of an `sshtunnel_connection`",
+				Computed:            true,
+			},
+		},
+	}
+}
+
+func (d *HostKeyDataSource) ValidateConfig(ctx context.Context, req datasource.ValidateConfigRequest, resp *datasource.ValidateConfigResponse) {
+	var data HostKeyDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if !data.Host.IsNull() && !data.Host.IsUnknown() {
+		if _, err := hostToASCII(data.Host.ValueString()); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("host"), "Host Key Error", fmt.Sprintf("Invalid host %q: %s", data.Host.ValueString(), err))
+		}
+	}
+	if !data.Timeout.IsNull() && !data.Timeout.IsUnknown() {
+		if timeout, err := time.ParseDuration(data.Timeout.ValueString()); err != nil || timeout <= 0 {
+			resp.Diagnostics.AddAttributeError(path.Root("timeout"), "Host Key Error", fmt.Sprintf("Invalid timeout %q, expected a positive duration", data.Timeout.ValueString()))
+		}
+	}
+}
+
+func (d *HostKeyDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var data HostKeyDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	host, err := hostToASCII(data.Host.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddError("Host Key Error", fmt.Sprintf("Invalid host %q: %s", data.Host.ValueString(), err))
+		return
+	}
+	port := int32(22)
+	if !data.Port.IsNull() {
+		port = data.Port.ValueInt32()
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+	algorithms := KeyscanAlgorithms
+	if data.Algorithms != nil {
+		algorithms = make([]string, 0, len(data.Algorithms))
+		for _, algorithm := range data.Algorithms {
+			algorithms = append(algorithms, algorithm.ValueString())
+		}
+	}
+
+	timeout := defaultKeyscanTimeout
+	if !data.Timeout.IsNull() {
+		if timeout, err = time.ParseDuration(data.Timeout.ValueString()); err != nil {
+			resp.Diagnostics.AddError("Host Key Error", fmt.Sprintf("Invalid timeout: %s", err))
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	keys, err := scanHostKeys(ctx, addr, algorithms)
+	if err != nil {
+		resp.Diagnostics.AddError("Host Key Error", fmt.Sprintf("Unable to read the host keys of %s: %s", addr, err))
+		return
+	}
+
+	data.Keys = make([]HostKeyDataSourceModelKey, 0, len(keys))
+	var knownHosts strings.Builder
+	for _, key := range keys {
+		data.Keys = append(data.Keys, HostKeyDataSourceModelKey{
+			Type:              types.StringValue(key.Type()),
+			PublicKey:         types.StringValue(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))),
+			FingerprintSHA256: types.StringValue(ssh.FingerprintSHA256(key)),
+		})
+		knownHosts.WriteString(knownhosts.Line([]string{knownhosts.Normalize(addr)}, key) + "\n")
+	}
+	data.FingerprintSHA256 = data.Keys[0].FingerprintSHA256
+	data.KnownHosts = types.StringValue(knownHosts.String())
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+// scanHostKeys returns the distinct host keys of the SSH server at addr for
+// algorithms, skipping those the server doesn't offer. It fails if no key
+// was read.
+func scanHostKeys(ctx context.Context, addr string, algorithms []string) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	var errs []error
+	for _, algorithm := range algorithms {
+		key, err := ScanHostKey(ctx, addr, algorithm)
+		if err != nil {
+			tflog.Debug(ctx, "Host key algorithm not offered", map[string]interface{}{"addr": addr, "algorithm": algorithm, "err": err})
+			errs = append(errs, fmt.Errorf("%s: %w", algorithm, err))
+			continue
+		}
+		// rsa-sha2-256 and rsa-sha2-512 return the same key.
+		duplicate := false
+		for _, k := range keys {
+			if bytes.Equal(k.Marshal(), key.Marshal()) {
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		if len(errs) == 0 {
+			return nil, errors.New("no host key algorithms")
+		}
+		return nil, errors.Join(errs...)
+	}
+	return keys, nil
+}
@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHostKeyDataSource(t *testing.T) {
+	addr := startTestSSHServer(t, &ssh.ServerConfig{NoClientAuth: true}, nil)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	d := &HostKeyDataSource{}
+	schemaResp := datasource.SchemaResponse{}
+	d.Schema(ctx, datasource.SchemaRequest{}, &schemaResp)
+	typ := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+
+	config := tfsdk.Config{Raw: nullObject(typ, map[string]tftypes.Value{
+		"host": tftypes.NewValue(tftypes.String, host),
+		"port": tftypes.NewValue(tftypes.Number, portNumber),
+	}), Schema: schemaResp.Schema}
+	resp := datasource.ReadResponse{State: tfsdk.State{Raw: tftypes.NewValue(typ, nil), Schema: schemaResp.Schema}}
+	d.Read(ctx, datasource.ReadRequest{Config: config}, &resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatalf("Unexpected error: %v", resp.Diagnostics)
+	}
+
+	var data HostKeyDataSourceModel
+	if diags := resp.State.Get(ctx, &data); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	// The test server only has an Ed25519 key, the other algorithms are
+	// skipped.
+	if len(data.Keys) != 1 || data.Keys[0].Type.ValueString() != ssh.KeyAlgoED25519 {
+		t.Fatalf("got keys %v, want a single ssh-ed25519 key", data.Keys)
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(data.Keys[0].PublicKey.ValueString()))
+	if err != nil {
+		t.Fatalf("Invalid public key: %v", err)
+	}
+	if data.FingerprintSHA256.ValueString() != ssh.FingerprintSHA256(key) {
+		t.Errorf("got fingerprint %s, want %s", data.FingerprintSHA256.ValueString(), ssh.FingerprintSHA256(key))
+	}
+	if !strings.HasPrefix(data.KnownHosts.ValueString(), "[127.0.0.1]:"+port+" ssh-ed25519 ") {
+		t.Errorf("Unexpected known_hosts %q", data.KnownHosts.ValueString())
+	}
+
+	config = tfsdk.Config{Raw: nullObject(typ, map[string]tftypes.Value{
+		"host":       tftypes.NewValue(tftypes.String, host),
+		"port":       tftypes.NewValue(tftypes.Number, portNumber),
+		"algorithms": tftypes.NewValue(tftypes.List{ElementType: tftypes.String}, []tftypes.Value{tftypes.NewValue(tftypes.String, ssh.KeyAlgoECDSA256)}),
+	}), Schema: schemaResp.Schema}
+	resp = datasource.ReadResponse{State: tfsdk.State{Raw: tftypes.NewValue(typ, nil), Schema: schemaResp.Schema}}
+	d.Read(ctx, datasource.ReadRequest{Config: config}, &resp)
+	if !resp.Diagnostics.HasError() {
+		t.Error("Expected an error if the server offers none of the algorithms")
+	}
+}
@@ -0,0 +1,401 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/sshconfig"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// hostKeyModeStrict only accepts host keys in known_hosts.
+	hostKeyModeStrict = "strict"
+	// hostKeyModeAcceptNew adds the keys of unknown hosts to known_hosts.
+	hostKeyModeAcceptNew = "accept-new"
+	// hostKeyModeFingerprint only accepts the pinned host key.
+	hostKeyModeFingerprint = "fingerprint"
+	// hostKeyModeInsecure accepts any host key.
+	hostKeyModeInsecure = "insecure"
+
+	// defaultKnownHostsFile is the known_hosts file of the user, like
+	// OpenSSH's default UserKnownHostsFile.
+	defaultKnownHostsFile = "~/.ssh/known_hosts"
+)
+
+var hostKeyModes = []string{hostKeyModeStrict, hostKeyModeAcceptNew, hostKeyModeFingerprint, hostKeyModeInsecure}
+
+// supportedHostKeyAlgorithms are the host key algorithms accepted by
+// host_key_algorithms, in the default order of preference.
+var supportedHostKeyAlgorithms = []string{
+	ssh.CertAlgoED25519v01,
+	ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+	ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01,
+	ssh.CertAlgoDSAv01,
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA,
+	ssh.KeyAlgoDSA,
+}
+
+// knownHostsMu serializes adding host keys to known_hosts files.
+var knownHostsMu sync.Mutex
+
+// hostKeyConfig is the host key verification configured for a connection.
+type hostKeyConfig struct {
+	// mode is the host_key mode, empty for the default.
+	mode string
+	// knownHostsFile is verified in addition to the system-wide known_hosts,
+	// empty if not configured.
+	knownHostsFile string
+	// fingerprint pins the host key instead of verifying it against
+	// known_hosts, empty if not configured.
+	fingerprint string
+	// certificateAuthorities are trusted to sign host certificates.
+	certificateAuthorities []string
+	// revokedHostKeysFile lists refused host keys, empty if not configured.
+	revokedHostKeysFile string
+}
+
+func newHostKeyConfig(data *ConnectionEphemeralResourceModel) hostKeyConfig {
+	config := hostKeyConfig{
+		mode:           data.hostKeyMode().ValueString(),
+		knownHostsFile: data.KnownHostsFile.ValueString(),
+		fingerprint:    data.HostKeyFingerprint.ValueString(),
+
+		revokedHostKeysFile: data.RevokedHostKeysFile.ValueString(),
+	}
+	for _, authority := range data.HostCertificateAuthorities {
+		config.certificateAuthorities = append(config.certificateAuthorities, authority.ValueString())
+	}
+	return config
+}
+
+// effectiveMode returns the configured mode, defaulting to fingerprint if
+// one is pinned and strict otherwise.
+func (c hostKeyConfig) effectiveMode() string {
+	switch {
+	case c.mode != "":
+		return c.mode
+	case c.fingerprint != "":
+		return hostKeyModeFingerprint
+	default:
+		return hostKeyModeStrict
+	}
+}
+
+// hostKeyMode returns the configured host_key mode, null if not set.
+func (data *ConnectionEphemeralResourceModel) hostKeyMode() types.String {
+	if data.HostKey == nil {
+		return types.StringNull()
+	}
+	return data.HostKey.Mode
+}
+
+// validateHostKeyMode checks the host key attributes required by or
+// conflicting with the host_key mode.
+func validateHostKeyMode(data *ConnectionEphemeralResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	mode := data.hostKeyMode()
+	if mode.IsNull() || mode.IsUnknown() {
+		return diags
+	}
+
+	modePath := path.Root("host_key").AtName("mode")
+	switch mode.ValueString() {
+	case hostKeyModeStrict, hostKeyModeAcceptNew:
+		if !data.HostKeyFingerprint.IsNull() {
+			diags.AddAttributeError(modePath, "Host Key Error", "host_key_fingerprint requires mode fingerprint")
+		}
+	case hostKeyModeFingerprint:
+		if data.HostKeyFingerprint.IsNull() {
+			diags.AddAttributeError(modePath, "Host Key Error", "Mode fingerprint requires host_key_fingerprint")
+		}
+	case hostKeyModeInsecure:
+		for _, attribute := range []struct {
+			name string
+			set  bool
+		}{
+			{"known_hosts_file", !data.KnownHostsFile.IsNull()},
+			{"host_key_fingerprint", !data.HostKeyFingerprint.IsNull()},
+			{"host_certificate_authorities", data.HostCertificateAuthorities != nil},
+			{"revoked_host_keys_file", !data.RevokedHostKeysFile.IsNull()},
+		} {
+			if attribute.set {
+				diags.AddAttributeError(modePath, "Host Key Error", fmt.Sprintf("Mode insecure doesn't verify host keys, remove %s", attribute.name))
+			}
+		}
+	default:
+		diags.AddAttributeError(modePath, "Host Key Error", fmt.Sprintf("Invalid mode %q, expected one of %s", mode.ValueString(), strings.Join(hostKeyModes, ", ")))
+	}
+	return diags
+}
+
+// validateHostKeyAlgorithm checks that algorithm is supported.
+func validateHostKeyAlgorithm(algorithm string) error {
+	for _, supported := range supportedHostKeyAlgorithms {
+		if algorithm == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported host key algorithm %q, expected one of %s", algorithm, strings.Join(supportedHostKeyAlgorithms, ", "))
+}
+
+// hostKeyAlgorithms returns the configured host_key_algorithms, nil for the
+// defaults of x/crypto/ssh.
+func hostKeyAlgorithms(data *ConnectionEphemeralResourceModel) []string {
+	if len(data.HostKeyAlgorithms) == 0 {
+		return nil
+	}
+	algorithms := make([]string, 0, len(data.HostKeyAlgorithms))
+	for _, algorithm := range data.HostKeyAlgorithms {
+		algorithms = append(algorithms, algorithm.ValueString())
+	}
+	return algorithms
+}
+
+// parseHostKeyFingerprint validates a SHA256 fingerprint as printed by
+// ssh-keygen and returns it in the format of ssh.FingerprintSHA256, without
+// base64 padding.
+func parseHostKeyFingerprint(s string) (string, error) {
+	encoded, ok := strings.CutPrefix(s, "SHA256:")
+	if !ok {
+		return "", fmt.Errorf("invalid fingerprint %q, expected SHA256:<base64>", s)
+	}
+	encoded = strings.TrimRight(encoded, "=")
+	hash, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(hash) != sha256.Size {
+		return "", fmt.Errorf("invalid fingerprint %q, expected SHA256:<base64> of a %d byte hash", s, sha256.Size)
+	}
+	return "SHA256:" + encoded, nil
+}
+
+// pinnedHostKeyCallback only accepts the host key with fingerprint.
+func pinnedHostKeyCallback(fingerprint string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		presented := ssh.FingerprintSHA256(key)
+		if presented == fingerprint {
+			return nil
+		}
+		return fmt.Errorf("host key verification failed: the server %s presented %s %s, but host_key_fingerprint is %s. "+
+			"The host key changed or the connection is intercepted", knownhosts.Normalize(hostname), key.Type(), presented, fingerprint)
+	}
+}
+
+// parseCertificateAuthority parses the public key of a CA in OpenSSH
+// format.
+func parseCertificateAuthority(s string) (ssh.PublicKey, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA public key: %w", err)
+	}
+	if _, ok := key.(*ssh.Certificate); ok {
+		return nil, fmt.Errorf("invalid CA public key, expected a public key instead of a certificate")
+	}
+	return key, nil
+}
+
+// certificateHostKeyCallback accepts host certificates signed by one of
+// authorities that list the host connected to as a principal and are
+// currently valid. Plain host keys are verified by fallback, rejected if it
+// is nil.
+func certificateHostKeyCallback(authorities []ssh.PublicKey, fallback ssh.HostKeyCallback) ssh.HostKeyCallback {
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			for _, authority := range authorities {
+				if bytes.Equal(auth.Marshal(), authority.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		HostKeyFallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if fallback == nil {
+				return fmt.Errorf("host key verification failed: the server %s presented the plain host key %s %s instead of a certificate and neither host_key_fingerprint nor known_hosts are configured",
+					knownhosts.Normalize(hostname), key.Type(), ssh.FingerprintSHA256(key))
+			}
+			return fallback(hostname, remote, key)
+		},
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := checker.CheckHostKey(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		// Plain host keys were verified by the fallback.
+		cert, ok := key.(*ssh.Certificate)
+		if !ok {
+			return err
+		}
+		return fmt.Errorf("host certificate verification failed: the server %s presented a certificate (key ID %q, signed by %s %s): %w",
+			knownhosts.Normalize(hostname), cert.KeyId, cert.SignatureKey.Type(), ssh.FingerprintSHA256(cert.SignatureKey), err)
+	}
+}
+
+// revokedHostKey is a host key listed in a revoked keys file.
+type revokedHostKey struct {
+	key      ssh.PublicKey
+	filename string
+	line     int
+}
+
+// loadRevokedHostKeys reads the public keys listed in file, one per line in
+// the authorized_keys format. Empty lines and comments are skipped.
+func loadRevokedHostKeys(file string) ([]revokedHostKey, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked []revokedHostKey
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err == nil && len(options) > 0 {
+			err = errors.New("expected a public key without options")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, i+1, err)
+		}
+		revoked = append(revoked, revokedHostKey{key: key, filename: file, line: i + 1})
+	}
+	return revoked, nil
+}
+
+// revokedHostKeyCallback refuses the revoked keys before verifying the
+// others with callback. Like OpenSSH, certificates are refused if either
+// their key or the CA that signed it is revoked.
+func revokedHostKeyCallback(revoked []revokedHostKey, callback ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		keys := []ssh.PublicKey{key}
+		if cert, ok := key.(*ssh.Certificate); ok {
+			keys = []ssh.PublicKey{cert.Key, cert.SignatureKey}
+		}
+		for _, k := range keys {
+			for _, r := range revoked {
+				if bytes.Equal(k.Marshal(), r.key.Marshal()) {
+					return fmt.Errorf("host key verification failed: the server %s presented %s, but %s %s is revoked (%s:%d)",
+						knownhosts.Normalize(hostname), ssh.FingerprintSHA256(key), k.Type(), ssh.FingerprintSHA256(k), r.filename, r.line)
+				}
+			}
+		}
+		return callback(hostname, remote, key)
+	}
+}
+
+// newServerHostKey describes the host key presented by a server. Like
+// OpenSSH, certificates are identified by the fingerprint of the signed key.
+func newServerHostKey(key ssh.PublicKey) *ConnectionEphemeralResourceModelServerHostKey {
+	fingerprinted := key
+	if cert, ok := key.(*ssh.Certificate); ok {
+		fingerprinted = cert.Key
+	}
+	return &ConnectionEphemeralResourceModelServerHostKey{
+		Type:              types.StringValue(key.Type()),
+		PublicKey:         types.StringValue(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))),
+		FingerprintSHA256: types.StringValue(ssh.FingerprintSHA256(fingerprinted)),
+	}
+}
+
+// knownHostsCallback verifies host keys against the existing files, all
+// hosts are unknown if none exists.
+func knownHostsCallback(files []string) (ssh.HostKeyCallback, error) {
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil || !errors.Is(err, os.ErrNotExist) {
+			return sshconfig.KnownHostsCallback(files...)
+		}
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return &knownhosts.KeyError{}
+	}, nil
+}
+
+// acceptNewHostKeyCallback adds the keys of hosts unknown to callback to
+// file, creating it if needed. Changed keys are still rejected.
+func acceptNewHostKeyCallback(ctx context.Context, callback ssh.HostKeyCallback, file string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return err
+		}
+
+		if err := appendKnownHost(file, hostname, key); err != nil {
+			return fmt.Errorf("unable to add the host key of %s to %s: %w", knownhosts.Normalize(hostname), file, err)
+		}
+		tflog.Warn(ctx, "Added the host key of a new host to known_hosts", map[string]interface{}{
+			"host": knownhosts.Normalize(hostname), "fingerprint": ssh.FingerprintSHA256(key), "file": file,
+		})
+		return nil
+	}
+}
+
+func appendKnownHost(file, hostname string, key ssh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// describedHostKeyCallback wraps a known_hosts callback, so failed
+// verifications name the fingerprint presented by the server and the
+// known_hosts entries it was compared to.
+func describedHostKeyCallback(callback ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		host := knownhosts.Normalize(hostname)
+		presented := fmt.Sprintf("%s %s", key.Type(), ssh.FingerprintSHA256(key))
+
+		var revokedErr *knownhosts.RevokedError
+		if errors.As(err, &revokedErr) {
+			return fmt.Errorf("host key verification failed: the server %s presented %s, which is revoked by an @revoked entry (%s:%d)",
+				host, presented, revokedErr.Revoked.Filename, revokedErr.Revoked.Line)
+		}
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		if len(keyErr.Want) == 0 {
+			return fmt.Errorf("host key verification failed: %s is not in known_hosts, the server presented %s. "+
+				"Add its key to known_hosts, e.g. from the sshtunnel_host_key data source, or set host_key.mode to accept-new. "+
+				"Host keys are verified by default since 0.1.0, see the guide on upgrading to strict host key verification", host, presented)
+		}
+
+		known := make([]string, 0, len(keyErr.Want))
+		for _, want := range keyErr.Want {
+			known = append(known, fmt.Sprintf("%s %s (%s:%d)", want.Key.Type(), ssh.FingerprintSHA256(want.Key), want.Filename, want.Line))
+		}
+		return fmt.Errorf("host key verification failed: the server %s presented %s, but known_hosts has %s. "+
+			"The host key changed or the connection is intercepted", host, presented, strings.Join(known, ", "))
+	}
+}
@@ -0,0 +1,457 @@
+package provider
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func generateHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKnownHostsFile(t *testing.T) {
+	defaultPortKey := generateHostKey(t)
+	customPortKey := generateHostKey(t)
+
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	lines := knownhosts.Line([]string{knownhosts.HashHostname("bastion.example.com")}, defaultPortKey) + "\n" +
+		knownhosts.Line([]string{"[bastion.example.com]:2222"}, customPortKey) + "\n"
+	if err := os.WriteFile(file, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	r := &ConnectionEphemeralResource{}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	addr, callback, err := r.resolveHost(ctx, "bastion.example.com", 22, "ubuntu", hostKeyConfig{knownHostsFile: file})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := callback(addr, remote, defaultPortKey); err != nil {
+		t.Errorf("Expected the hashed entry to match, got %v", err)
+	}
+
+	err = callback(addr, remote, customPortKey)
+	if err == nil {
+		t.Fatal("Expected a host key mismatch")
+	}
+	for _, want := range []string{ssh.FingerprintSHA256(customPortKey), ssh.FingerprintSHA256(defaultPortKey), file + ":1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to contain %s, got %v", want, err)
+		}
+	}
+
+	addr, callback, err = r.resolveHost(ctx, "bastion.example.com", 2222, "ubuntu", hostKeyConfig{knownHostsFile: file})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := callback(addr, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2222}, customPortKey); err != nil {
+		t.Errorf("Expected the [host]:port entry to match, got %v", err)
+	}
+
+	addr, callback, err = r.resolveHost(ctx, "other.example.com", 22, "ubuntu", hostKeyConfig{knownHostsFile: file})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = callback(addr, remote, defaultPortKey)
+	if err == nil || !strings.Contains(err.Error(), "not in known_hosts") || !strings.Contains(err.Error(), ssh.FingerprintSHA256(defaultPortKey)) {
+		t.Errorf("Expected an unknown host naming the fingerprint, got %v", err)
+	}
+
+	if _, _, err := r.resolveHost(ctx, "bastion.example.com", 22, "ubuntu", hostKeyConfig{knownHostsFile: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("Expected an error for a missing known_hosts_file")
+	}
+}
+
+func TestHostKeyFingerprint(t *testing.T) {
+	key := generateHostKey(t)
+	otherKey := generateHostKey(t)
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	ctx := context.Background()
+	// Pinning replaces the system-wide known_hosts.
+	r := &ConnectionEphemeralResource{systemKnownHosts: true}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	for _, pinned := range []string{fingerprint, fingerprint + "="} {
+		addr, callback, err := r.resolveHost(ctx, "bastion.example.com", 22, "ubuntu", hostKeyConfig{fingerprint: pinned})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := callback(addr, remote, key); err != nil {
+			t.Errorf("Expected the pinned key to be accepted, got %v", err)
+		}
+		err = callback(addr, remote, otherKey)
+		if err == nil || !strings.Contains(err.Error(), ssh.FingerprintSHA256(otherKey)) || !strings.Contains(err.Error(), fingerprint) {
+			t.Errorf("Expected a mismatch naming both fingerprints, got %v", err)
+		}
+	}
+
+	for _, invalid := range []string{"", "MD5:16:27:ac:a5:76:28:2d:36:63:1b:56:4d:eb:df:a6:48", "SHA256:not-base64", "SHA256:AAAA"} {
+		if _, err := parseHostKeyFingerprint(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestHostCertificateAuthorities(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer
+	}
+	ca, otherCA := newSigner(), newSigner()
+	hostKey := generateHostKey(t)
+
+	now := time.Now()
+	newCert := func(signer ssh.Signer, principal string, validBefore time.Time) *ssh.Certificate {
+		cert := &ssh.Certificate{
+			Key:             hostKey,
+			CertType:        ssh.HostCert,
+			KeyId:           "bastion",
+			ValidPrincipals: []string{principal},
+			ValidAfter:      uint64(now.Add(-time.Hour).Unix()),
+			ValidBefore:     uint64(validBefore.Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, signer); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	ctx := context.Background()
+	r := &ConnectionEphemeralResource{}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+	authority := string(ssh.MarshalAuthorizedKey(ca.PublicKey()))
+
+	addr, callback, err := r.resolveHost(ctx, "bastion.example.com", 22, "ubuntu", hostKeyConfig{certificateAuthorities: []string{authority}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := callback(addr, remote, newCert(ca, "bastion.example.com", now.Add(time.Hour))); err != nil {
+		t.Errorf("Expected a valid certificate to be accepted, got %v", err)
+	}
+	for name, key := range map[string]ssh.PublicKey{
+		"other principal": newCert(ca, "other.example.com", now.Add(time.Hour)),
+		"expired":         newCert(ca, "bastion.example.com", now.Add(-time.Minute)),
+		"other CA":        newCert(otherCA, "bastion.example.com", now.Add(time.Hour)),
+		"plain key":       hostKey,
+	} {
+		if err := callback(addr, remote, key); err == nil || !strings.Contains(err.Error(), "verification failed") {
+			t.Errorf("Expected %s to be rejected, got %v", name, err)
+		}
+	}
+
+	addr, callback, err = r.resolveHost(ctx, "bastion.example.com", 22, "ubuntu", hostKeyConfig{
+		certificateAuthorities: []string{authority},
+		fingerprint:            ssh.FingerprintSHA256(hostKey),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := callback(addr, remote, hostKey); err != nil {
+		t.Errorf("Expected the plain key to be verified by host_key_fingerprint, got %v", err)
+	}
+
+	for _, invalid := range []string{"", "not a key", string(ssh.MarshalAuthorizedKey(newCert(ca, "bastion.example.com", now)))} {
+		if _, err := parseCertificateAuthority(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestHostKeyModes(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	key := generateHostKey(t)
+	otherKey := generateHostKey(t)
+
+	ctx := context.Background()
+	r := &ConnectionEphemeralResource{}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2222}
+
+	// Unknown hosts are rejected by default, even without a known_hosts file.
+	addr, callback, err := r.resolveHost(ctx, "bastion.example.com", 2222, "ubuntu", hostKeyConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := callback(addr, remote, key); err == nil || !strings.Contains(err.Error(), "not in known_hosts") || !strings.Contains(err.Error(), "accept-new") {
+		t.Errorf("Expected an unknown host to be rejected, got %v", err)
+	}
+
+	addr, callback, err = r.resolveHost(ctx, "bastion.example.com", 2222, "ubuntu", hostKeyConfig{mode: hostKeyModeAcceptNew})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := callback(addr, remote, key); err != nil {
+		t.Fatalf("Expected a new host to be accepted, got %v", err)
+	}
+	knownHosts, err := os.ReadFile(filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := knownhosts.Line([]string{"[bastion.example.com]:2222"}, key) + "\n"; string(knownHosts) != want {
+		t.Errorf("got known_hosts %q, want %q", knownHosts, want)
+	}
+
+	// The added key is now known, also in strict mode, and can't change.
+	for _, mode := range []string{hostKeyModeAcceptNew, hostKeyModeStrict} {
+		addr, callback, err = r.resolveHost(ctx, "bastion.example.com", 2222, "ubuntu", hostKeyConfig{mode: mode})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := callback(addr, remote, key); err != nil {
+			t.Errorf("Expected the added key to be accepted in mode %s, got %v", mode, err)
+		}
+		if err := callback(addr, remote, otherKey); err == nil || !strings.Contains(err.Error(), ssh.FingerprintSHA256(key)) {
+			t.Errorf("Expected a changed key to be rejected in mode %s, got %v", mode, err)
+		}
+	}
+
+	addr, callback, err = r.resolveHost(ctx, "other.example.com", 22, "ubuntu", hostKeyConfig{mode: hostKeyModeInsecure})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := callback(addr, remote, otherKey); err != nil {
+		t.Errorf("Expected any key to be accepted in mode insecure, got %v", err)
+	}
+}
+
+func TestValidateHostKeyMode(t *testing.T) {
+	hostKey := func(mode string) *ConnectionEphemeralResourceModelHostKey {
+		return &ConnectionEphemeralResourceModelHostKey{Mode: types.StringValue(mode)}
+	}
+	fingerprint := types.StringValue("SHA256:" + strings.Repeat("A", 43))
+
+	for name, test := range map[string]struct {
+		data    ConnectionEphemeralResourceModel
+		wantErr bool
+	}{
+		"default":                 {ConnectionEphemeralResourceModel{HostKeyFingerprint: fingerprint}, false},
+		"fingerprint":             {ConnectionEphemeralResourceModel{HostKey: hostKey("fingerprint"), HostKeyFingerprint: fingerprint}, false},
+		"fingerprint missing":     {ConnectionEphemeralResourceModel{HostKey: hostKey("fingerprint"), HostKeyFingerprint: types.StringNull()}, true},
+		"strict with fingerprint": {ConnectionEphemeralResourceModel{HostKey: hostKey("strict"), HostKeyFingerprint: fingerprint}, true},
+		"accept-new":              {ConnectionEphemeralResourceModel{HostKey: hostKey("accept-new"), HostKeyFingerprint: types.StringNull(), KnownHostsFile: types.StringValue("known_hosts")}, false},
+		"insecure":                {ConnectionEphemeralResourceModel{HostKey: hostKey("insecure"), HostKeyFingerprint: types.StringNull(), KnownHostsFile: types.StringNull()}, false},
+		"insecure with file":      {ConnectionEphemeralResourceModel{HostKey: hostKey("insecure"), HostKeyFingerprint: types.StringNull(), KnownHostsFile: types.StringValue("known_hosts")}, true},
+		"unknown mode":            {ConnectionEphemeralResourceModel{HostKey: hostKey("tofu")}, true},
+	} {
+		if diags := validateHostKeyMode(&test.data); diags.HasError() != test.wantErr {
+			t.Errorf("%s: got %v, want error %t", name, diags, test.wantErr)
+		}
+	}
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	addr := startTestSSHServer(t, &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}, nil)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverPort, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connect := func(algorithms ...string) diag.Diagnostics {
+		data := &ConnectionEphemeralResourceModel{
+			Host:    types.StringValue(host),
+			Port:    types.Int32Value(int32(serverPort)),
+			User:    types.StringValue("test"),
+			Auth:    ConnectionEphemeralResourceModelAuth{Password: types.StringValue("secret")},
+			HostKey: &ConnectionEphemeralResourceModelHostKey{Mode: types.StringValue(hostKeyModeInsecure)},
+		}
+		for _, algorithm := range algorithms {
+			data.HostKeyAlgorithms = append(data.HostKeyAlgorithms, types.StringValue(algorithm))
+		}
+		conn, _, diags := (&ConnectionEphemeralResource{}).connect(context.Background(), data)
+		if conn != nil {
+			conn.Close()
+		}
+		return diags
+	}
+
+	// The test server presents an ed25519 key.
+	if diags := connect(ssh.CertAlgoED25519v01, ssh.KeyAlgoED25519); diags.HasError() {
+		t.Errorf("Expected ed25519 to be accepted, got %v", diags)
+	}
+	if diags := connect(ssh.KeyAlgoRSA, ssh.KeyAlgoECDSA256); !diags.HasError() {
+		t.Error("Expected the ed25519 host key to be rejected")
+	}
+
+	for _, invalid := range []string{"", "ssh-ed448", "SSH-ED25519"} {
+		if err := validateHostKeyAlgorithm(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestServerHostKey(t *testing.T) {
+	addr := startTestSSHServer(t, &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}, nil)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverPort, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := &ConnectionEphemeralResourceModel{
+		Host:    types.StringValue(host),
+		Port:    types.Int32Value(int32(serverPort)),
+		User:    types.StringValue("test"),
+		Auth:    ConnectionEphemeralResourceModelAuth{Password: types.StringValue("secret")},
+		HostKey: &ConnectionEphemeralResourceModelHostKey{Mode: types.StringValue(hostKeyModeInsecure)},
+	}
+	conn, _, diags := (&ConnectionEphemeralResource{}).connect(context.Background(), data)
+	if diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	conn.Close()
+
+	scanned, err := ScanHostKey(context.Background(), addr, ssh.KeyAlgoED25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := newServerHostKey(scanned)
+	if data.ServerHostKey == nil || *data.ServerHostKey != *want {
+		t.Errorf("got server host key %v, want %v", data.ServerHostKey, want)
+	}
+	if data.ServerHostKey.Type.ValueString() != ssh.KeyAlgoED25519 || data.ServerHostKey.FingerprintSHA256.ValueString() != ssh.FingerprintSHA256(scanned) {
+		t.Errorf("Unexpected server host key %v", data.ServerHostKey)
+	}
+
+	// Certificates are identified by the fingerprint of the signed key.
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{Key: scanned, CertType: ssh.HostCert, ValidBefore: ssh.CertTimeInfinity}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	certKey := newServerHostKey(cert)
+	if certKey.Type.ValueString() != ssh.CertAlgoED25519v01 || certKey.FingerprintSHA256.ValueString() != ssh.FingerprintSHA256(scanned) {
+		t.Errorf("Unexpected certificate host key %v", certKey)
+	}
+}
+
+func TestRevokedHostKeys(t *testing.T) {
+	key := generateHostKey(t)
+	revokedKey := generateHostKey(t)
+	fingerprint := ssh.FingerprintSHA256(revokedKey)
+
+	dir := t.TempDir()
+	revokedFile := filepath.Join(dir, "revoked_keys")
+	if err := os.WriteFile(revokedFile, append([]byte("# compromised\n\n"), ssh.MarshalAuthorizedKey(revokedKey)...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	lines := knownhosts.Line([]string{"bastion.example.com"}, key) + "\n" +
+		knownhosts.Line([]string{"bastion.example.com"}, revokedKey) + "\n" +
+		"@revoked * " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + "\n"
+	if err := os.WriteFile(knownHostsFile, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	r := &ConnectionEphemeralResource{}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	// The revoked key is refused even though it is known and pinned.
+	for _, hostKeys := range []hostKeyConfig{
+		{knownHostsFile: knownHostsFile, revokedHostKeysFile: revokedFile},
+		{fingerprint: fingerprint, revokedHostKeysFile: revokedFile},
+	} {
+		addr, callback, err := r.resolveHost(ctx, "bastion.example.com", 22, "ubuntu", hostKeys)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := callback(addr, remote, revokedKey); err == nil || !strings.Contains(err.Error(), revokedFile+":3") {
+			t.Errorf("Expected the revoked key to be refused, got %v", err)
+		}
+	}
+
+	addr, callback, err := r.resolveHost(ctx, "bastion.example.com", 22, "ubuntu", hostKeyConfig{knownHostsFile: knownHostsFile})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := callback(addr, remote, key); err == nil || !strings.Contains(err.Error(), "@revoked") {
+		t.Errorf("Expected the @revoked key to be refused, got %v", err)
+	}
+
+	// Certificates signed by a revoked CA are refused.
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(revokedFile, ssh.MarshalAuthorizedKey(ca.PublicKey()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{Key: generateHostKey(t), CertType: ssh.HostCert, ValidPrincipals: []string{"bastion.example.com"}, ValidBefore: ssh.CertTimeInfinity}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	addr, callback, err = r.resolveHost(ctx, "bastion.example.com", 22, "ubuntu", hostKeyConfig{
+		certificateAuthorities: []string{string(ssh.MarshalAuthorizedKey(ca.PublicKey()))},
+		revokedHostKeysFile:    revokedFile,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := callback(addr, remote, cert); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Errorf("Expected the certificate of a revoked CA to be refused, got %v", err)
+	}
+
+	for name, file := range map[string]string{"missing": filepath.Join(dir, "missing"), "invalid": knownHostsFile} {
+		if _, _, err := r.resolveHost(ctx, "bastion.example.com", 22, "ubuntu", hostKeyConfig{revokedHostKeysFile: file}); err == nil {
+			t.Errorf("Expected an error for a %s revoked_host_keys_file", name)
+		}
+	}
+}
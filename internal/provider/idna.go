@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"net"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// idnaProfile converts host names for lookups. Underscores are allowed, as
+// they are common in internal DNS names.
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.StrictDomainName(false),
+	idna.BidiRule(),
+)
+
+// hostToASCII converts an internationalized host name to its ASCII form
+// (punycode), e.g. bücher.example.com to xn--bcher-kva.example.com, to be
+// sent to DNS and SSH servers. ASCII names are returned unchanged, punycode
+// labels are validated. IPv6 addresses are normalized by ipLiteral.
+func hostToASCII(host string) (string, error) {
+	if strings.ContainsAny(host, ":%[") {
+		return ipLiteral(host)
+	}
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+
+	ascii := isASCII(host)
+	if ascii && !strings.Contains(strings.ToLower(host), "xn--") {
+		return host, nil
+	}
+
+	converted, err := idnaProfile.ToASCII(host)
+	if err != nil {
+		return "", err
+	}
+	if ascii {
+		return host, nil
+	}
+	return converted, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package provider
+
+import (
+	"net"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestHostToASCII(t *testing.T) {
+	tests := []struct {
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{host: "bastion.example.com", want: "bastion.example.com"},
+		{host: "db_1.internal", want: "db_1.internal"},
+		{host: "10.0.0.1", want: "10.0.0.1"},
+		{host: "fe80::1%eth0", want: "fe80::1%eth0"},
+		{host: "[fe80::1%eth0]", want: "fe80::1%eth0"},
+		{host: "fe80::1%25eth0", want: "fe80::1%eth0"},
+		{host: "fe80::1%25", want: "fe80::1%25"},
+		{host: "2001:db8::1", want: "2001:db8::1"},
+		{host: "fe80::1%", wantErr: true},
+		{host: "10.0.0.1%eth0", wantErr: true},
+		{host: "bastion:22", wantErr: true},
+		{host: "bücher.example.com", want: "xn--bcher-kva.example.com"},
+		{host: "BÜCHER.example.com", want: "xn--bcher-kva.example.com"},
+		{host: "datenbank.münchen.intern", want: "datenbank.xn--mnchen-3ya.intern"},
+		{host: "xn--bcher-kva.example.com", want: "xn--bcher-kva.example.com"},
+		{host: "xn--a.example.com", wantErr: true},
+		{host: "bad\u200d.example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got, err := hostToASCII(tt.host)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostAddr(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "bastion.example.com", want: "bastion.example.com:22"},
+		{host: "2001:db8::1", want: "[2001:db8::1]:22"},
+		{host: "fe80::1%eth0", want: "[fe80::1%eth0]:22"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			addr := hostAddr(types.StringValue(tt.host), types.Int32Value(22))
+			if addr != tt.want {
+				t.Errorf("got %q, want %q", addr, tt.want)
+			}
+			// The SSH client splits the address again to request forwarding.
+			if host, _, err := net.SplitHostPort(addr); err != nil || host != tt.host {
+				t.Errorf("SplitHostPort(%q) = %q, %v, want %q", addr, host, err, tt.host)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package provider
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ipLiteral normalizes an IP address, e.g. a zone-indexed IPv6 link-local
+// address like fe80::1%eth0, to the form sent to SSH servers and passed to
+// net.JoinHostPort. Brackets and the %25 zone separator of URIs (RFC 6874)
+// are accepted and removed.
+func ipLiteral(host string) (string, error) {
+	literal := strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	// A zone named 25 is kept, "fe80::1%25" isn't a URI encoded zone.
+	if i := strings.Index(literal, "%25"); i >= 0 && len(literal) > i+3 {
+		literal = literal[:i+1] + literal[i+3:]
+	}
+
+	addr, err := netip.ParseAddr(literal)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP address: %w", err)
+	}
+	if addr.Zone() != "" && !addr.Is6() {
+		return "", fmt.Errorf("zones are only supported for IPv6 addresses")
+	}
+	return literal, nil
+}
@@ -0,0 +1,176 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ ephemeral.EphemeralResource = &KeypairEphemeralResource{}
+var _ ephemeral.EphemeralResourceWithValidateConfig = &KeypairEphemeralResource{}
+var _ ephemeral.EphemeralResourceWithConfigValidators = &KeypairEphemeralResource{}
+
+const (
+	keypairAlgorithmEd25519 = "ed25519"
+	keypairAlgorithmRSA     = "rsa"
+
+	defaultKeypairRSABits = 4096
+	minKeypairRSABits     = 2048
+)
+
+func NewKeypairEphemeralResource() ephemeral.EphemeralResource {
+	return &KeypairEphemeralResource{}
+}
+
+// KeypairEphemeralResource generates an SSH keypair in memory, e.g. to push
+// the public key with a cloud API and authenticate a connection with the
+// private key, without persisting the key anywhere.
+type KeypairEphemeralResource struct{}
+
+// KeypairEphemeralResourceModel describes the resource data model.
+type KeypairEphemeralResourceModel struct {
+	Algorithm         types.String `tfsdk:"algorithm"`
+	RSABits           types.Int32  `tfsdk:"rsa_bits"`
+	Comment           types.String `tfsdk:"comment"`
+	PrivateKey        types.String `tfsdk:"private_key"`
+	PublicKey         types.String `tfsdk:"public_key"`
+	FingerprintSHA256 types.String `tfsdk:"fingerprint_sha256"`
+}
+
+func (r *KeypairEphemeralResource) Metadata(ctx context.Context, req ephemeral.MetadataRequest, resp *ephemeral.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_keypair"
+}
+
+func (r *KeypairEphemeralResource) Schema(ctx context.Context, req ephemeral.SchemaRequest, resp *ephemeral.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "Generates an SSH keypair in memory, e.g. to add the public key with a cloud API and authenticate an `sshtunnel_connection` with the private key, " +
+			"without persisting the key anywhere. A new keypair is generated each time the resource is opened, e.g. during plan and apply.",
+
+		Attributes: map[string]schema.Attribute{
+			"algorithm": schema.StringAttribute{
+				MarkdownDescription: "Algorithm of the key, `ed25519` (default) or `rsa`",
+				Optional:            true,
+			},
+			"rsa_bits": schema.Int32Attribute{
+				MarkdownDescription: fmt.Sprintf("Size of RSA keys in bits (defaults to `%d`, at least `%d`). Requires `algorithm` `rsa`", defaultKeypairRSABits, minKeypairRSABits),
+				Optional:            true,
+			},
+			"comment": schema.StringAttribute{
+				MarkdownDescription: "Comment of the key, appended to `public_key` and stored in `private_key`",
+				Optional:            true,
+			},
+			"private_key": schema.StringAttribute{
+				MarkdownDescription: "Private key in the OpenSSH format, e.g. for `auth.private_key` of an `sshtunnel_connection`",
+				Computed:            true,
+				Sensitive:           true,
+			},
+			"public_key": schema.StringAttribute{
+				MarkdownDescription: "Public key in the `authorized_keys` format",
+				Computed:            true,
+			},
+			"fingerprint_sha256": schema.StringAttribute{
+				MarkdownDescription: "SHA256 fingerprint of the public key, e.g. `SHA256:...`",
+				Computed:            true,
+			},
+		},
+	}
+}
+
+func (r *KeypairEphemeralResource) ConfigValidators(ctx context.Context) []ephemeral.ConfigValidator {
+	return []ephemeral.ConfigValidator{
+		attributeRequires("rsa_bits", "algorithm"),
+	}
+}
+
+func (r *KeypairEphemeralResource) ValidateConfig(ctx context.Context, req ephemeral.ValidateConfigRequest, resp *ephemeral.ValidateConfigResponse) {
+	var data KeypairEphemeralResourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if !data.Algorithm.IsNull() && !data.Algorithm.IsUnknown() {
+		switch data.Algorithm.ValueString() {
+		case keypairAlgorithmEd25519:
+			if !data.RSABits.IsNull() {
+				resp.Diagnostics.AddAttributeError(path.Root("rsa_bits"), "Keypair Error", "rsa_bits requires algorithm rsa")
+			}
+		case keypairAlgorithmRSA:
+		default:
+			resp.Diagnostics.AddAttributeError(path.Root("algorithm"), "Keypair Error", fmt.Sprintf("Invalid algorithm %q, expected ed25519 or rsa", data.Algorithm.ValueString()))
+		}
+	}
+
+	if !data.RSABits.IsNull() && !data.RSABits.IsUnknown() && data.RSABits.ValueInt32() < minKeypairRSABits {
+		resp.Diagnostics.AddAttributeError(path.Root("rsa_bits"), "Keypair Error", fmt.Sprintf("rsa_bits must be at least %d", minKeypairRSABits))
+	}
+}
+
+func (r *KeypairEphemeralResource) Open(ctx context.Context, req ephemeral.OpenRequest, resp *ephemeral.OpenResponse) {
+	var data KeypairEphemeralResourceModel
+
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	bits := defaultKeypairRSABits
+	if !data.RSABits.IsNull() {
+		bits = int(data.RSABits.ValueInt32())
+	}
+	privateKey, err := generateKeypair(data.Algorithm.ValueString(), bits)
+	if err != nil {
+		resp.Diagnostics.AddError("Keypair Error", fmt.Sprintf("Unable to generate a keypair, got error: %s", err))
+		return
+	}
+
+	comment := data.Comment.ValueString()
+	block, err := ssh.MarshalPrivateKey(privateKey, comment)
+	if err != nil {
+		resp.Diagnostics.AddError("Keypair Error", fmt.Sprintf("Unable to encode the private key, got error: %s", err))
+		return
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		resp.Diagnostics.AddError("Keypair Error", fmt.Sprintf("Unable to encode the public key, got error: %s", err))
+		return
+	}
+
+	publicKey := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(signer.PublicKey())), "\n")
+	if comment != "" {
+		publicKey += " " + comment
+	}
+
+	data.PrivateKey = types.StringValue(string(pem.EncodeToMemory(block)))
+	data.PublicKey = types.StringValue(publicKey)
+	data.FingerprintSHA256 = types.StringValue(ssh.FingerprintSHA256(signer.PublicKey()))
+
+	resp.Diagnostics.Append(resp.Result.Set(ctx, &data)...)
+}
+
+// generateKeypair generates a private key of algorithm, ed25519 if empty.
+// bits is the size of RSA keys.
+func generateKeypair(algorithm string, bits int) (crypto.PrivateKey, error) {
+	switch algorithm {
+	case "", keypairAlgorithmEd25519:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	case keypairAlgorithmRSA:
+		return rsa.GenerateKey(rand.Reader, bits)
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+}
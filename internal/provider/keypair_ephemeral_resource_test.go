@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKeypairEphemeralResource(t *testing.T) {
+	ctx := context.Background()
+	r := &KeypairEphemeralResource{}
+	schemaResp := ephemeral.SchemaResponse{}
+	r.Schema(ctx, ephemeral.SchemaRequest{}, &schemaResp)
+	typ := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+
+	tests := []struct {
+		name    string
+		values  map[string]tftypes.Value
+		keyType string
+	}{
+		{"default", map[string]tftypes.Value{}, ssh.KeyAlgoED25519},
+		{"rsa", map[string]tftypes.Value{
+			"algorithm": tftypes.NewValue(tftypes.String, "rsa"),
+			"rsa_bits":  tftypes.NewValue(tftypes.Number, 2048),
+			"comment":   tftypes.NewValue(tftypes.String, "deploy@ci"),
+		}, ssh.KeyAlgoRSA},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := tfsdk.Config{Raw: nullObject(typ, test.values), Schema: schemaResp.Schema}
+
+			validateResp := ephemeral.ValidateConfigResponse{}
+			r.ValidateConfig(ctx, ephemeral.ValidateConfigRequest{Config: config}, &validateResp)
+			if validateResp.Diagnostics.HasError() {
+				t.Fatalf("Unexpected error: %v", validateResp.Diagnostics)
+			}
+
+			resp := ephemeral.OpenResponse{Result: tfsdk.EphemeralResultData{Schema: schemaResp.Schema}}
+			r.Open(ctx, ephemeral.OpenRequest{Config: config}, &resp)
+			if resp.Diagnostics.HasError() {
+				t.Fatalf("Unexpected error: %v", resp.Diagnostics)
+			}
+			var data KeypairEphemeralResourceModel
+			if diags := resp.Result.Get(ctx, &data); diags.HasError() {
+				t.Fatalf("Unexpected error: %v", diags)
+			}
+
+			signer, err := ssh.ParsePrivateKey([]byte(data.PrivateKey.ValueString()))
+			if err != nil {
+				t.Fatalf("Invalid private key: %v", err)
+			}
+			publicKey, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(data.PublicKey.ValueString()))
+			if err != nil {
+				t.Fatalf("Invalid public key: %v", err)
+			}
+			if publicKey.Type() != test.keyType {
+				t.Errorf("Expected a %s key, got %s", test.keyType, publicKey.Type())
+			}
+			if !bytes.Equal(signer.PublicKey().Marshal(), publicKey.Marshal()) {
+				t.Error("Expected the public key of the private key")
+			}
+			if _, ok := test.values["comment"]; ok && comment != "deploy@ci" {
+				t.Errorf("Expected the comment in the public key, got %q", data.PublicKey.ValueString())
+			}
+			if data.FingerprintSHA256.ValueString() != ssh.FingerprintSHA256(publicKey) {
+				t.Errorf("got fingerprint %s, want %s", data.FingerprintSHA256.ValueString(), ssh.FingerprintSHA256(publicKey))
+			}
+		})
+	}
+}
+
+func TestKeypairEphemeralResourceValidateConfig(t *testing.T) {
+	ctx := context.Background()
+	r := &KeypairEphemeralResource{}
+	schemaResp := ephemeral.SchemaResponse{}
+	r.Schema(ctx, ephemeral.SchemaRequest{}, &schemaResp)
+	typ := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+
+	for name, values := range map[string]map[string]tftypes.Value{
+		"unknown algorithm": {"algorithm": tftypes.NewValue(tftypes.String, "dsa")},
+		"small rsa key":     {"algorithm": tftypes.NewValue(tftypes.String, "rsa"), "rsa_bits": tftypes.NewValue(tftypes.Number, 1024)},
+		"ed25519 rsa_bits":  {"algorithm": tftypes.NewValue(tftypes.String, "ed25519"), "rsa_bits": tftypes.NewValue(tftypes.Number, 4096)},
+	} {
+		resp := ephemeral.ValidateConfigResponse{}
+		r.ValidateConfig(ctx, ephemeral.ValidateConfigRequest{Config: tfsdk.Config{Raw: nullObject(typ, values), Schema: schemaResp.Schema}}, &resp)
+		if !resp.Diagnostics.HasError() {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ ephemeral.EphemeralResource = &KubeconfigEphemeralResource{}
+var _ ephemeral.EphemeralResourceWithValidateConfig = &KubeconfigEphemeralResource{}
+var _ ephemeral.EphemeralResourceWithConfigValidators = &KubeconfigEphemeralResource{}
+
+const (
+	defaultKubeconfigName      = "tunnel"
+	defaultKubeconfigLocalHost = "127.0.0.1"
+)
+
+func NewKubeconfigEphemeralResource() ephemeral.EphemeralResource {
+	return &KubeconfigEphemeralResource{}
+}
+
+// KubeconfigEphemeralResource renders a kubeconfig for a Kubernetes API
+// server reached through a local port forwarding.
+type KubeconfigEphemeralResource struct{}
+
+// KubeconfigEphemeralResourceModel describes the resource data model.
+type KubeconfigEphemeralResourceModel struct {
+	Server               types.String `tfsdk:"server"`
+	LocalHost            types.String `tfsdk:"local_host"`
+	LocalPort            types.Int32  `tfsdk:"local_port"`
+	Name                 types.String `tfsdk:"name"`
+	Namespace            types.String `tfsdk:"namespace"`
+	ClusterCACertificate types.String `tfsdk:"cluster_ca_certificate"`
+	Token                types.String `tfsdk:"token"`
+	ClientCertificate    types.String `tfsdk:"client_certificate"`
+	ClientKey            types.String `tfsdk:"client_key"`
+	Host                 types.String `tfsdk:"host"`
+	TLSServerName        types.String `tfsdk:"tls_server_name"`
+	Kubeconfig           types.String `tfsdk:"kubeconfig"`
+}
+
+func (r *KubeconfigEphemeralResource) Metadata(ctx context.Context, req ephemeral.MetadataRequest, resp *ephemeral.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_kubeconfig"
+}
+
+func (r *KubeconfigEphemeralResource) Schema(ctx context.Context, req ephemeral.SchemaRequest, resp *ephemeral.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "Renders a kubeconfig for a Kubernetes API server reached through a local port forwarding of an `sshtunnel_connection`. " +
+			"The server URL targets the local endpoint, while the TLS server name stays the one of the API server, so its certificate is still verified.",
+
+		Attributes: map[string]schema.Attribute{
+			"server": schema.StringAttribute{
+				MarkdownDescription: "URL of the API server as reachable from the SSH server, e.g. `https://api.internal:6443`",
+				Required:            true,
+			},
+			"local_host": schema.StringAttribute{
+				MarkdownDescription: "Local host of the port forwarding (defaults to `127.0.0.1`)",
+				Optional:            true,
+			},
+			"local_port": schema.Int32Attribute{
+				MarkdownDescription: "Local port of the port forwarding to the API server",
+				Required:            true,
+			},
+			"name": schema.StringAttribute{
+				MarkdownDescription: "Name of the cluster, user and context in the kubeconfig (defaults to `tunnel`)",
+				Optional:            true,
+			},
+			"namespace": schema.StringAttribute{
+				MarkdownDescription: "Default namespace of the context",
+				Optional:            true,
+			},
+			"cluster_ca_certificate": schema.StringAttribute{
+				MarkdownDescription: "PEM-encoded CA certificate of the API server (the system roots if not specified)",
+				Optional:            true,
+			},
+			"token": schema.StringAttribute{
+				MarkdownDescription: "Bearer token to authenticate with",
+				Optional:            true,
+				Sensitive:           true,
+			},
+			"client_certificate": schema.StringAttribute{
+				MarkdownDescription: "PEM-encoded client certificate to authenticate with",
+				Optional:            true,
+			},
+			"client_key": schema.StringAttribute{
+				MarkdownDescription: "PEM-encoded private key of `client_certificate`",
+				Optional:            true,
+				Sensitive:           true,
+			},
+			"host": schema.StringAttribute{
+				MarkdownDescription: "URL of the local endpoint, e.g. for the `host` of the kubernetes provider",
+				Computed:            true,
+			},
+			"tls_server_name": schema.StringAttribute{
+				MarkdownDescription: "Host name the API server certificate is verified against, e.g. for the `tls_server_name` of the kubernetes provider",
+				Computed:            true,
+			},
+			"kubeconfig": schema.StringAttribute{
+				MarkdownDescription: "Rendered kubeconfig",
+				Computed:            true,
+				Sensitive:           true,
+			},
+		},
+	}
+}
+
+func (r *KubeconfigEphemeralResource) ConfigValidators(ctx context.Context) []ephemeral.ConfigValidator {
+	return []ephemeral.ConfigValidator{
+		requiredTogetherAttributes("client_certificate", "client_key"),
+	}
+}
+
+func (r *KubeconfigEphemeralResource) ValidateConfig(ctx context.Context, req ephemeral.ValidateConfigRequest, resp *ephemeral.ValidateConfigResponse) {
+	var data KubeconfigEphemeralResourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if !data.Server.IsUnknown() {
+		if _, err := parseKubernetesServer(data.Server.ValueString()); err != nil {
+			resp.Diagnostics.AddError("Server Error", err.Error())
+		}
+	}
+}
+
+func (r *KubeconfigEphemeralResource) Open(ctx context.Context, req ephemeral.OpenRequest, resp *ephemeral.OpenResponse) {
+	var data KubeconfigEphemeralResourceModel
+
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	server, err := parseKubernetesServer(data.Server.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddError("Server Error", err.Error())
+		return
+	}
+
+	localHost := defaultKubeconfigLocalHost
+	if !data.LocalHost.IsNull() {
+		localHost = data.LocalHost.ValueString()
+	}
+	host := (&url.URL{
+		Scheme: "https",
+		Host:   net.JoinHostPort(localHost, strconv.Itoa(int(data.LocalPort.ValueInt32()))),
+		Path:   server.Path,
+	}).String()
+
+	name := defaultKubeconfigName
+	if !data.Name.IsNull() {
+		name = data.Name.ValueString()
+	}
+
+	kubeconfig, err := renderKubeconfig(name, host, server.Hostname(), &data)
+	if err != nil {
+		resp.Diagnostics.AddError("Kubeconfig Error", fmt.Sprintf("Unable to render kubeconfig, got error: %s", err))
+		return
+	}
+
+	data.Host = types.StringValue(host)
+	data.TLSServerName = types.StringValue(server.Hostname())
+	data.Kubeconfig = types.StringValue(kubeconfig)
+
+	resp.Diagnostics.Append(resp.Result.Set(ctx, &data)...)
+}
+
+func parseKubernetesServer(server string) (*url.URL, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid server URL: %s", err)
+	}
+	if u.Scheme != "https" || u.Hostname() == "" {
+		return nil, fmt.Errorf("Invalid server URL %q, expected https://host[:port]", server)
+	}
+	return u, nil
+}
+
+type kubeconfigCluster struct {
+	Server                   string `yaml:"server"`
+	TLSServerName            string `yaml:"tls-server-name"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data,omitempty"`
+}
+
+type kubeconfigUser struct {
+	Token                 string `yaml:"token,omitempty"`
+	ClientCertificateData string `yaml:"client-certificate-data,omitempty"`
+	ClientKeyData         string `yaml:"client-key-data,omitempty"`
+}
+
+type kubeconfigContext struct {
+	Cluster   string `yaml:"cluster"`
+	User      string `yaml:"user"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type kubeconfigNamedCluster struct {
+	Name    string            `yaml:"name"`
+	Cluster kubeconfigCluster `yaml:"cluster"`
+}
+
+type kubeconfigNamedUser struct {
+	Name string         `yaml:"name"`
+	User kubeconfigUser `yaml:"user"`
+}
+
+type kubeconfigNamedContext struct {
+	Name    string            `yaml:"name"`
+	Context kubeconfigContext `yaml:"context"`
+}
+
+type kubeconfig struct {
+	APIVersion     string                   `yaml:"apiVersion"`
+	Kind           string                   `yaml:"kind"`
+	Clusters       []kubeconfigNamedCluster `yaml:"clusters"`
+	Users          []kubeconfigNamedUser    `yaml:"users"`
+	Contexts       []kubeconfigNamedContext `yaml:"contexts"`
+	CurrentContext string                   `yaml:"current-context"`
+}
+
+// renderKubeconfig renders a kubeconfig connecting to host, verifying the
+// server certificate for tlsServerName.
+func renderKubeconfig(name, host, tlsServerName string, data *KubeconfigEphemeralResourceModel) (string, error) {
+	b, err := yaml.Marshal(kubeconfig{
+		APIVersion: "v1",
+		Kind:       "Config",
+		Clusters: []kubeconfigNamedCluster{{Name: name, Cluster: kubeconfigCluster{
+			Server:                   host,
+			TLSServerName:            tlsServerName,
+			CertificateAuthorityData: base64Data(data.ClusterCACertificate),
+		}}},
+		Users: []kubeconfigNamedUser{{Name: name, User: kubeconfigUser{
+			Token:                 data.Token.ValueString(),
+			ClientCertificateData: base64Data(data.ClientCertificate),
+			ClientKeyData:         base64Data(data.ClientKey),
+		}}},
+		Contexts: []kubeconfigNamedContext{{Name: name, Context: kubeconfigContext{
+			Cluster:   name,
+			User:      name,
+			Namespace: data.Namespace.ValueString(),
+		}}},
+		CurrentContext: name,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// base64Data encodes v for the *-data fields of a kubeconfig, empty if null.
+func base64Data(v types.String) string {
+	if v.IsNull() {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(v.ValueString()))
+}
@@ -0,0 +1,60 @@
+package provider
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderKubeconfig(t *testing.T) {
+	data := &KubeconfigEphemeralResourceModel{
+		ClusterCACertificate: types.StringValue("-----BEGIN CERTIFICATE-----\n"),
+		Token:                types.StringValue("secret"),
+		ClientCertificate:    types.StringNull(),
+		ClientKey:            types.StringNull(),
+		Namespace:            types.StringValue("apps"),
+	}
+
+	rendered, err := renderKubeconfig("prod", "https://127.0.0.1:16443", "api.internal", data)
+	if err != nil {
+		t.Fatalf("Failed to render kubeconfig: %v", err)
+	}
+
+	var doc kubeconfig
+	if err := yaml.Unmarshal([]byte(rendered), &doc); err != nil {
+		t.Fatalf("Failed to parse kubeconfig: %v\n%s", err, rendered)
+	}
+
+	if doc.CurrentContext != "prod" || len(doc.Clusters) != 1 || len(doc.Users) != 1 || len(doc.Contexts) != 1 {
+		t.Fatalf("Unexpected kubeconfig:\n%s", rendered)
+	}
+	cluster := doc.Clusters[0].Cluster
+	if cluster.Server != "https://127.0.0.1:16443" {
+		t.Errorf("got server %q", cluster.Server)
+	}
+	if cluster.TLSServerName != "api.internal" {
+		t.Errorf("got tls-server-name %q", cluster.TLSServerName)
+	}
+	if ca, _ := base64.StdEncoding.DecodeString(cluster.CertificateAuthorityData); string(ca) != "-----BEGIN CERTIFICATE-----\n" {
+		t.Errorf("got certificate-authority-data %q", cluster.CertificateAuthorityData)
+	}
+	if user := doc.Users[0].User; user.Token != "secret" || user.ClientKeyData != "" {
+		t.Errorf("Unexpected user %+v", user)
+	}
+	if ctx := doc.Contexts[0].Context; ctx.Cluster != "prod" || ctx.Namespace != "apps" {
+		t.Errorf("Unexpected context %+v", ctx)
+	}
+}
+
+func TestParseKubernetesServer(t *testing.T) {
+	if u, err := parseKubernetesServer("https://api.internal:6443"); err != nil || u.Hostname() != "api.internal" {
+		t.Errorf("got %v, %v", u, err)
+	}
+	for _, server := range []string{"http://api.internal", "api.internal:6443", "https://"} {
+		if _, err := parseKubernetesServer(server); err == nil {
+			t.Errorf("Expected an error for %q", server)
+		}
+	}
+}
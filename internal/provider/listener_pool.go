@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"net"
+	"sync"
+)
+
+// ListenerPool holds pre-bound local listeners, e.g. inherited through
+// socket activation, that local port forwardings use instead of binding
+// their local_port themselves.
+type ListenerPool struct {
+	mu        sync.Mutex
+	listeners map[int]net.Listener
+}
+
+// NewListenerPool returns a pool of the given TCP listeners by port.
+// Listeners of other networks are ignored.
+func NewListenerPool(listeners []net.Listener) *ListenerPool {
+	pool := &ListenerPool{
+		listeners: map[int]net.Listener{},
+	}
+	for _, listener := range listeners {
+		if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+			pool.listeners[addr.Port] = listener
+		}
+	}
+	return pool
+}
+
+// take removes the listener bound to port from the pool, it returns nil if
+// there is none.
+func (p *ListenerPool) take(port int32) net.Listener {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	listener := p.listeners[int(port)]
+	delete(p.listeners, int(port))
+	return listener
+}
@@ -0,0 +1,212 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/sshconfig"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+// openLocalPortForwardings sets up the local port forwardings, recording the
+// setup time of each.
+func (o *tunnelOpener) openLocalPortForwardings() bool {
+	return o.each(len(o.data.LocalPortForwardings), func(i int) error {
+		setupStart := time.Now()
+		err := o.openLocalPortForwarding(i)
+		timing := types.StringNull()
+		if err == nil {
+			timing = basetypes.NewStringValue(time.Since(setupStart).String())
+		}
+		o.data.Timings.LocalPortForwardings = append(o.data.Timings.LocalPortForwardings, timing)
+		return err
+	})
+}
+
+func (o *tunnelOpener) openLocalPortForwarding(i int) error {
+	ctx, r, data := o.ctx, o.r, o.data
+	localPortForwarding := data.LocalPortForwardings[i]
+
+	remoteHost, err := hostToASCII(localPortForwarding.RemoteHost.ValueString())
+	if err != nil {
+		return o.fail("Local Port Forwarding Error", fmt.Sprintf("Invalid remote_host %q: %s", localPortForwarding.RemoteHost.ValueString(), err))
+	}
+
+	conf := o.config(priorityClassName(localPortForwarding.PriorityClass))
+	conf.LocalPort = localPortForwarding.LocalPort.ValueInt32Pointer()
+	conf.RemoteAddr = hostAddr(types.StringValue(remoteHost), localPortForwarding.RemotePort)
+	conf.Budget = r.budget
+	if !localPortForwarding.RemoteSocketPath.IsNull() {
+		conf.RemoteAddr = localPortForwarding.RemoteSocketPath.ValueString()
+		conf.Network = "unix"
+	}
+
+	if localPortForwarding.SNIRoutes != nil {
+		routes, err := sniRoutes(localPortForwarding.SNIRoutes)
+		if err != nil {
+			return o.fail("Local Port Forwarding Error", fmt.Sprintf("Invalid sni_routes: %s", err))
+		}
+		conf.SNIRoutes = routes
+	}
+
+	if !localPortForwarding.RetryDelay.IsNull() {
+		retryDelay, err := time.ParseDuration(localPortForwarding.RetryDelay.ValueString())
+		if err != nil {
+			return o.fail("Local Port Forwarding Error", fmt.Sprintf("Invalid retry delay: %s", err))
+		}
+		conf.RetryDelay = retryDelay
+	}
+
+	if !localPortForwarding.RetryAttempts.IsNull() {
+		conf.RetryAttempts = localPortForwarding.RetryAttempts.ValueInt32()
+	}
+
+	if !localPortForwarding.ListenBacklog.IsNull() {
+		conf.Backlog = localPortForwarding.ListenBacklog.ValueInt32()
+	}
+
+	if !localPortForwarding.MaxConnections.IsNull() {
+		conf.MaxConnections = localPortForwarding.MaxConnections.ValueInt32()
+	}
+
+	if !localPortForwarding.AcceptWorkers.IsNull() {
+		conf.AcceptWorkers = localPortForwarding.AcceptWorkers.ValueInt32()
+	}
+
+	if !localPortForwarding.StallTimeout.IsNull() {
+		stallTimeout, err := time.ParseDuration(localPortForwarding.StallTimeout.ValueString())
+		if err != nil {
+			return o.fail("Local Port Forwarding Error", fmt.Sprintf("Invalid stall timeout: %s", err))
+		}
+		conf.StallTimeout = stallTimeout
+	}
+
+	if !localPortForwarding.MaxBytes.IsNull() {
+		quota := portforward.NewQuota(localPortForwarding.MaxBytes.ValueInt64())
+		conf.Quotas = append(conf.Quotas, quota)
+		o.quotas = append(o.quotas, namedQuota{quota, "max_bytes of the local port forwarding to " + conf.RemoteAddr})
+	}
+
+	if err := o.lockLocalPort(conf.LocalPort); err != nil {
+		return err
+	}
+
+	// exec_fallback only relays to TCP targets.
+	var dialer portforward.Dialer = o.conn
+	var fellBack bool
+	if conf.Network == "" {
+		dialer, fellBack = forwardingDialer(ctx, o.conn, conf.RemoteAddr, data.ExecFallback.ValueString())
+	}
+	if fellBack {
+		tflog.Warn(ctx, "Port forwarding prohibited, relaying through exec", map[string]interface{}{"remote_addr": conf.RemoteAddr})
+		o.resp.Diagnostics.AddWarning("Exec Fallback", fmt.Sprintf("The SSH server of the %s prohibits port forwarding, relaying connections to %s through %q instead",
+			o.owner, conf.RemoteAddr, data.ExecFallback.ValueString()))
+	}
+
+	// The forwarding outlives this request, so only keep the logging context.
+	var listener *portforward.Listener
+	if !localPortForwarding.LocalSocketPath.IsNull() {
+		// Consumers get the path with the home directory expanded.
+		localPortForwarding.LocalSocketPath = types.StringValue(sshconfig.ExpandPath(localPortForwarding.LocalSocketPath.ValueString()))
+		data.LocalPortForwardings[i].LocalSocketPath = localPortForwarding.LocalSocketPath
+		listener, err = newLocalSocketForwarding(context.WithoutCancel(ctx), dialer, conf, localPortForwarding.LocalSocketPath.ValueString())
+	} else {
+		reclaim := r.reclaimedPorts.get(o.privateData.Key, i)
+		listener, err = r.newLocalPortForwarding(context.WithoutCancel(ctx), dialer, conf, localPortForwarding.LocalPortSeed, reclaim)
+	}
+	if err != nil {
+		return listenFailure(err, fmt.Sprintf("Unable to create port forwarding to %s, got error: %s", conf.RemoteAddr, err))
+	}
+	if err := o.addListener(listener, true); err != nil {
+		return err
+	}
+	if localPortForwarding.WarnWindowStalls.ValueBool() {
+		o.info.watchWindowStalls(listener, conf.RemoteAddr)
+	}
+
+	if !localPortForwarding.HealthCheckInterval.IsNull() {
+		interval, err := time.ParseDuration(localPortForwarding.HealthCheckInterval.ValueString())
+		if err != nil {
+			return o.fail("Local Port Forwarding Error", fmt.Sprintf("Invalid health check interval: %s", err))
+		}
+		health := newTargetHealth(localPortForwarding.RemoteHost.ValueString(), conf.RemoteAddr)
+		o.info.addTargetHealth(health)
+		go health.run(o.tunnelCtx, dialer, interval)
+	}
+
+	localAddr := localPortForwarding.LocalSocketPath.ValueString()
+	if localPortForwarding.LocalSocketPath.IsNull() {
+		tcpAddr, ok := listener.Addr().(*net.TCPAddr)
+		if !ok {
+			return o.fail("Port Forwarding Error", "Listener address is not a TCP address")
+		}
+
+		tflog.Info(ctx, "Port forwarding created", map[string]interface{}{
+			"local_port": tcpAddr.Port,
+		})
+
+		data.LocalPortForwardings[i].LocalPort = basetypes.NewInt32Value(int32(tcpAddr.Port))
+		o.localPorts[i] = int32(tcpAddr.Port)
+		localAddr = tcpAddr.String()
+	} else {
+		tflog.Info(ctx, "Socket forwarding created", map[string]interface{}{
+			"local_socket_path": localAddr,
+		})
+	}
+	if !localPortForwarding.Name.IsNull() {
+		o.namedAddrs[localPortForwarding.Name.ValueString()] = localAddr
+	}
+	return nil
+}
+
+// newLocalPortForwarding starts a local port forwarding. A fixed local port
+// uses the pre-bound listener of the pool if there is one. With a seed and no
+// fixed local port, the first free port derived from the seed is used.
+// Otherwise the reclaim port, if not 0, is preferred over a random port.
+func (r *ConnectionEphemeralResource) newLocalPortForwarding(ctx context.Context, dialer portforward.Dialer, conf *portforward.Config, seed types.String, reclaim int32) (*portforward.Listener, error) {
+	if conf.LocalPort != nil {
+		if listener := r.listenerPool.take(*conf.LocalPort); listener != nil {
+			tflog.Debug(ctx, "Using pre-bound listener", map[string]interface{}{"local_port": *conf.LocalPort})
+			return portforward.Serve(ctx, listener, dialer, conf), nil
+		}
+	}
+
+	if conf.LocalPort != nil {
+		return portforward.New(ctx, dialer, conf)
+	}
+
+	if seed.IsNull() {
+		if reclaim != 0 {
+			reclaimedConf := *conf
+			reclaimedConf.LocalPort = &reclaim
+			listener, err := portforward.New(ctx, dialer, &reclaimedConf)
+			if err == nil {
+				tflog.Debug(ctx, "Reclaimed local port", map[string]interface{}{"local_port": reclaim})
+				return listener, nil
+			}
+			tflog.Debug(ctx, "Reclaimed local port unavailable", map[string]interface{}{"local_port": reclaim, "err": err})
+		}
+		return portforward.New(ctx, dialer, conf)
+	}
+
+	var err error
+	for n := 0; n < seededPortCandidates; n++ {
+		seededConf := *conf
+		port := seededPort(seed.ValueString(), n)
+		seededConf.LocalPort = &port
+
+		var listener *portforward.Listener
+		listener, err = portforward.New(ctx, dialer, &seededConf)
+		if err == nil {
+			return listener, nil
+		}
+		tflog.Debug(ctx, "Seeded local port unavailable", map[string]interface{}{"local_port": port, "err": err})
+	}
+
+	return nil, fmt.Errorf("no free port derived from seed %q: %w", seed.ValueString(), err)
+}
@@ -0,0 +1,28 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"runtime"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+// newLocalSocketForwarding starts a local forwarding listening on the Unix
+// socket at path, only accessible by the current user like the default
+// StreamLocalBindMask of OpenSSH.
+func newLocalSocketForwarding(ctx context.Context, dialer portforward.Dialer, conf *portforward.Config, path string) (*portforward.Listener, error) {
+	listener, err := portforward.ListenUnix(path)
+	if err != nil {
+		return nil, err
+	}
+	// Windows doesn't support file modes.
+	if runtime.GOOS != "windows" {
+		if err := os.Chmod(path, 0o600); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	return portforward.Serve(ctx, listener, dialer, conf), nil
+}
@@ -0,0 +1,70 @@
+package provider
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/sshconfig"
+)
+
+// pathTokens returns the values of the percent tokens of the paths of data.
+// It reports false while the host, port or user are unknown, including
+// users expanded from unknown labels.
+func pathTokens(data *ConnectionEphemeralResourceModel) (sshconfig.Tokens, bool) {
+	host := data.Host
+	if !data.SRV.IsNull() {
+		host = data.SRV
+	}
+	if host.IsUnknown() || data.Port.IsUnknown() || data.User.IsUnknown() || !knownLabels(data.Labels) {
+		return sshconfig.Tokens{}, false
+	}
+
+	tokens := sshconfig.Tokens{Host: host.ValueString(), User: data.User.ValueString()}
+	if ascii, err := hostToASCII(tokens.Host); err == nil {
+		tokens.Host = ascii
+	}
+	if !data.Port.IsNull() {
+		tokens.Port = strconv.Itoa(int(data.Port.ValueInt32()))
+	}
+	return tokens, true
+}
+
+// expandPaths expands the OpenSSH percent tokens of the paths of data, e.g.
+// `~/.ssh/%h_%r`, so per-host files of a module instantiated for many
+// servers don't collide. Nothing is expanded while the values are unknown.
+func expandPaths(data *ConnectionEphemeralResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	tokens, ok := pathTokens(data)
+	if !ok {
+		return diags
+	}
+
+	expand := func(name string, path *types.String) {
+		if path.IsNull() || path.IsUnknown() {
+			return
+		}
+		expanded, err := sshconfig.ExpandTokens(path.ValueString(), tokens)
+		if err != nil {
+			diags.AddError("Path Error", fmt.Sprintf("Invalid %s: %s", name, err))
+			return
+		}
+		*path = types.StringValue(expanded)
+	}
+
+	expand("auth.private_key_path", &data.Auth.PrivateKeyPath)
+	expand("auth.agent_socket", &data.Auth.AgentSocket)
+	expand("known_hosts_file", &data.KnownHostsFile)
+	for i := range data.LocalPortForwardings {
+		expand("local_socket_path", &data.LocalPortForwardings[i].LocalSocketPath)
+		expand("remote_socket_path", &data.LocalPortForwardings[i].RemoteSocketPath)
+	}
+	for i := range data.RemoteSocketForwardings {
+		expand("remote_socket_path", &data.RemoteSocketForwardings[i].RemoteSocketPath)
+		expand("local_socket_path", &data.RemoteSocketForwardings[i].LocalSocketPath)
+	}
+
+	return diags
+}
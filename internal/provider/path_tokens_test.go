@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestExpandPaths(t *testing.T) {
+	data := ConnectionEphemeralResourceModel{
+		Host: types.StringValue("bastion.example.com"),
+		Port: types.Int32Value(2222),
+		SRV:  types.StringNull(),
+		User: types.StringValue("deploy"),
+		Auth: ConnectionEphemeralResourceModelAuth{
+			PrivateKeyPath: types.StringValue("~/.ssh/%h_%r"),
+		},
+		LocalPortForwardings: []ConnectionEphemeralResourceModelLocalPortForwarding{
+			{LocalSocketPath: types.StringValue("/tmp/%h.sock"), RemoteSocketPath: types.StringValue("/run/user/%r/app.sock")},
+		},
+		RemoteSocketForwardings: []ConnectionEphemeralResourceModelRemoteSocketForwarding{
+			{RemoteSocketPath: types.StringValue("/tmp/%r-%p.sock"), LocalSocketPath: types.StringValue("/tmp/%h-agent.sock")},
+		},
+	}
+	if diags := expandPaths(&data); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	if got := data.Auth.PrivateKeyPath.ValueString(); got != "~/.ssh/bastion.example.com_deploy" {
+		t.Errorf("Expected the private key path to be expanded, got %s", got)
+	}
+	if got := data.LocalPortForwardings[0].LocalSocketPath.ValueString(); got != "/tmp/bastion.example.com.sock" {
+		t.Errorf("Expected the local socket path to be expanded, got %s", got)
+	}
+	if got := data.LocalPortForwardings[0].RemoteSocketPath.ValueString(); got != "/run/user/deploy/app.sock" {
+		t.Errorf("Expected the remote socket path of the local forwarding to be expanded, got %s", got)
+	}
+	if got := data.RemoteSocketForwardings[0].RemoteSocketPath.ValueString(); got != "/tmp/deploy-2222.sock" {
+		t.Errorf("Expected the remote socket path to be expanded, got %s", got)
+	}
+	if got := data.RemoteSocketForwardings[0].LocalSocketPath.ValueString(); got != "/tmp/bastion.example.com-agent.sock" {
+		t.Errorf("Expected the local socket path of the remote forwarding to be expanded, got %s", got)
+	}
+}
+
+func TestExpandPathsUnknown(t *testing.T) {
+	data := ConnectionEphemeralResourceModel{
+		Host: types.StringUnknown(),
+		SRV:  types.StringNull(),
+		User: types.StringValue("deploy"),
+		Auth: ConnectionEphemeralResourceModelAuth{
+			PrivateKeyPath: types.StringValue("~/.ssh/%h"),
+		},
+	}
+	if diags := expandPaths(&data); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	if got := data.Auth.PrivateKeyPath.ValueString(); got != "~/.ssh/%h" {
+		t.Errorf("Expected the path not to be expanded, got %s", got)
+	}
+}
+
+func TestExpandPathsSRV(t *testing.T) {
+	data := ConnectionEphemeralResourceModel{
+		Host: types.StringNull(),
+		Port: types.Int32Null(),
+		SRV:  types.StringValue("_ssh._tcp.example.com"),
+		User: types.StringValue("deploy"),
+		Auth: ConnectionEphemeralResourceModelAuth{
+			PrivateKeyPath: types.StringValue("~/.ssh/%p"),
+		},
+	}
+	if diags := expandPaths(&data); !diags.HasError() {
+		t.Error("Expected an error for the port token with srv")
+	}
+}
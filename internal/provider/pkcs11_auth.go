@@ -0,0 +1,57 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/pkcs11key"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/sshconfig"
+	"golang.org/x/crypto/ssh"
+)
+
+// pkcs11AuthProvider authenticates using the private keys of a PKCS#11
+// token, e.g. a smartcard or an HSM.
+type pkcs11AuthProvider struct{}
+
+func (p *pkcs11AuthProvider) Name() string {
+	return "pkcs11"
+}
+
+// Available reports whether the provider was built with PKCS#11 support.
+func (p *pkcs11AuthProvider) Available() bool {
+	return pkcs11key.Supported
+}
+
+func (p *pkcs11AuthProvider) Configured(auth ConnectionEphemeralResourceModelAuth) bool {
+	return auth.PKCS11 != nil
+}
+
+func (p *pkcs11AuthProvider) ValidateConfig(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) diag.Diagnostics {
+	diags := diag.Diagnostics{}
+
+	if !auth.PKCS11.Module.IsUnknown() && auth.PKCS11.Module.ValueString() == "" {
+		diags.AddError("PKCS#11 Error", "auth.pkcs11.module must not be empty")
+	}
+
+	return diags
+}
+
+func (p *pkcs11AuthProvider) AuthMethods(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.AuthMethod, diag.Diagnostics) {
+	return signerAuthMethods(p.Signers(ctx, auth))
+}
+
+func (p *pkcs11AuthProvider) Signers(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.Signer, diag.Diagnostics) {
+	diags := diag.Diagnostics{}
+
+	module := sshconfig.ExpandPath(auth.PKCS11.Module.ValueString())
+	signers, err := pkcs11key.Signers(module, auth.PKCS11.TokenLabel.ValueString(), auth.PKCS11.PIN.ValueString())
+	if err != nil {
+		diags.AddError("PKCS#11 Error", fmt.Sprintf("Unable to use the keys of the PKCS#11 token, got error: %s", err))
+		return nil, diags
+	}
+	tflog.Debug(ctx, "Using PKCS#11 token keys", map[string]interface{}{"module": module, "keys": len(signers)})
+
+	return signers, diags
+}
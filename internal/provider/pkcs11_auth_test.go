@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestPKCS11AuthProvider(t *testing.T) {
+	ctx := context.Background()
+	p := &pkcs11AuthProvider{}
+
+	if p.Configured(ConnectionEphemeralResourceModelAuth{}) {
+		t.Error("Expected no pkcs11 block not to be configured")
+	}
+
+	auth := ConnectionEphemeralResourceModelAuth{PKCS11: &ConnectionEphemeralResourceModelAuthPKCS11{
+		Module: types.StringValue(""),
+	}}
+	if !p.Configured(auth) {
+		t.Error("Expected the pkcs11 block to be configured")
+	}
+	if diags := p.ValidateConfig(ctx, auth); !diags.HasError() {
+		t.Error("Expected an error for an empty module")
+	}
+
+	auth.PKCS11.Module = types.StringUnknown()
+	if diags := p.ValidateConfig(ctx, auth); diags.HasError() {
+		t.Errorf("Expected an unknown module to be valid, got %v", diags)
+	}
+
+	auth.PKCS11.Module = types.StringValue(filepath.Join(t.TempDir(), "missing.so"))
+	_, diags := p.AuthMethods(ctx, auth)
+	if !diags.HasError() {
+		t.Fatal("Expected an error for a missing module")
+	}
+	if detail := diags.Errors()[0].Detail(); !strings.Contains(detail, "PKCS#11") {
+		t.Errorf("Expected a PKCS#11 error, got %s", detail)
+	}
+}
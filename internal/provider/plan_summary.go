@@ -0,0 +1,193 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// unknownPlanValue describes values only known during apply.
+const unknownPlanValue = "(known after apply)"
+
+// planSummary describes the tunnel data will open for reviewers of a plan:
+// the SSH server, the authentication methods, the forwarded targets and ports
+// and the commands run on the server. authMethods are the names of the
+// configured authentication methods in the order they are offered.
+func planSummary(data *ConnectionEphemeralResourceModel, authMethods []string) string {
+	var b strings.Builder
+
+	if !data.SRV.IsNull() {
+		fmt.Fprintf(&b, "Connection to a server of SRV %s as %s", planString(data.SRV), planUser(data))
+	} else {
+		fmt.Fprintf(&b, "Connection to %s as %s", net.JoinHostPort(planString(data.Host), planInt32(data.Port)), planUser(data))
+	}
+	if len(authMethods) > 0 {
+		fmt.Fprintf(&b, " authenticating with %s", strings.Join(authMethods, ", "))
+	}
+	b.WriteString("\n")
+
+	if len(data.LocalPortForwardings) > 0 {
+		b.WriteString("\nLocal port forwardings, connected to by the SSH server:\n")
+		for _, f := range data.LocalPortForwardings {
+			target := net.JoinHostPort(planString(f.RemoteHost), planInt32(f.RemotePort))
+			if !f.RemoteSocketPath.IsNull() {
+				target = "remote socket " + planString(f.RemoteSocketPath)
+			}
+			fmt.Fprintf(&b, "  - %s -> %s\n", planLocalPort(f), target)
+			names := make([]string, 0, len(f.SNIRoutes))
+			for name := range f.SNIRoutes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(&b, "    - TLS server name %s -> %s\n", name, planString(f.SNIRoutes[name]))
+			}
+		}
+	}
+
+	if len(data.DynamicPortForwardings) > 0 {
+		b.WriteString("\nDynamic port forwardings, connecting to any target requested by local clients:\n")
+		for _, f := range data.DynamicPortForwardings {
+			port := "random local port"
+			if !f.LocalPort.IsNull() {
+				port = "local port " + planInt32(f.LocalPort)
+			}
+			protocol := "SOCKS5"
+			if f.Protocol.IsUnknown() {
+				protocol = unknownPlanValue
+			} else if dynamicProtocol(f) == dynamicProtocolHTTP {
+				protocol = "HTTP CONNECT"
+			}
+			fmt.Fprintf(&b, "  - %s (%s)\n", port, protocol)
+		}
+	}
+
+	if len(data.UDPForwardings) > 0 {
+		b.WriteString("\nUDP forwardings, relayed by a command run on the SSH server:\n")
+		for _, f := range data.UDPForwardings {
+			port := "random local UDP port"
+			if !f.LocalPort.IsNull() {
+				port = "local UDP port " + planInt32(f.LocalPort)
+			}
+			fmt.Fprintf(&b, "  - %s -> %s\n", port, net.JoinHostPort(planString(f.RemoteHost), planInt32(f.RemotePort)))
+		}
+	}
+
+	if len(data.RemotePortForwardings) > 0 {
+		b.WriteString("\nRemote port forwardings, exposed on the SSH server:\n")
+		for _, f := range data.RemotePortForwardings {
+			port := "random remote port"
+			if !f.RemotePort.IsNull() && (f.RemotePort.IsUnknown() || f.RemotePort.ValueInt32() != 0) {
+				port = "remote port " + planInt32(f.RemotePort)
+			}
+			if !f.BindAddress.IsNull() {
+				port += " on " + planString(f.BindAddress)
+			}
+			fmt.Fprintf(&b, "  - %s -> %s\n", port, net.JoinHostPort(planString(f.LocalHost), planInt32(f.LocalPort)))
+		}
+	}
+
+	if len(data.RemoteSocketForwardings) > 0 {
+		b.WriteString("\nRemote socket forwardings, exposed on the SSH server:\n")
+		for _, f := range data.RemoteSocketForwardings {
+			target := net.JoinHostPort(planString(f.LocalHost), planInt32(f.LocalPort))
+			if !f.LocalSocketPath.IsNull() {
+				target = "local socket " + planString(f.LocalSocketPath)
+			}
+			fmt.Fprintf(&b, "  - %s -> %s\n", planString(f.RemoteSocketPath), target)
+		}
+	}
+
+	var commands []string
+	if data.Heartbeat != nil {
+		command := strconv.Quote(defaultHeartbeatCommand)
+		if !data.Heartbeat.Command.IsNull() {
+			command = planQuoted(data.Heartbeat.Command)
+		}
+		commands = append(commands, "heartbeat: "+command)
+	}
+	if data.PTYSession != nil {
+		command := "login shell"
+		if !data.PTYSession.Command.IsNull() {
+			command = planQuoted(data.PTYSession.Command)
+		}
+		commands = append(commands, "pty_session: "+command)
+	}
+	if !data.ExecFallback.IsNull() {
+		commands = append(commands, "exec_fallback, if port forwarding is prohibited: "+planQuoted(data.ExecFallback))
+	}
+	for _, f := range data.UDPForwardings {
+		command := strconv.Quote(defaultUDPRelayCommand)
+		if !f.RelayCommand.IsNull() {
+			command = planQuoted(f.RelayCommand)
+		}
+		commands = append(commands, "udp_forwardings relay, for each local client: "+command)
+	}
+	if len(commands) > 0 {
+		b.WriteString("\nCommands run on the SSH server:\n")
+		for _, command := range commands {
+			fmt.Fprintf(&b, "  - %s\n", command)
+		}
+	}
+
+	if len(data.Labels) > 0 {
+		labels := make([]string, 0, len(data.Labels))
+		for k, v := range data.Labels {
+			labels = append(labels, k+"="+planString(v))
+		}
+		sort.Strings(labels)
+		fmt.Fprintf(&b, "\nLabels: %s\n", strings.Join(labels, ", "))
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func planLocalPort(f ConnectionEphemeralResourceModelLocalPortForwarding) string {
+	switch {
+	case !f.LocalSocketPath.IsNull():
+		return "local socket " + planString(f.LocalSocketPath)
+	case !f.LocalPort.IsNull():
+		return "local port " + planInt32(f.LocalPort)
+	case f.LocalPortSeed.IsUnknown():
+		return "seeded local port " + unknownPlanValue
+	case !f.LocalPortSeed.IsNull():
+		return fmt.Sprintf("local port %d", seededPort(f.LocalPortSeed.ValueString(), 0))
+	default:
+		return "random local port"
+	}
+}
+
+func planString(v types.String) string {
+	if v.IsUnknown() {
+		return unknownPlanValue
+	}
+	return v.ValueString()
+}
+
+// planUser is the user of data, which is read from the OS Login profile
+// when the tunnel is opened if unset.
+func planUser(data *ConnectionEphemeralResourceModel) string {
+	if data.User.IsNull() {
+		return "the user of the OS Login profile"
+	}
+	return planString(data.User)
+}
+
+// planQuoted is planString quoting known values, e.g. commands.
+func planQuoted(v types.String) string {
+	if v.IsUnknown() {
+		return unknownPlanValue
+	}
+	return strconv.Quote(v.ValueString())
+}
+
+func planInt32(v types.Int32) string {
+	if v.IsUnknown() {
+		return unknownPlanValue
+	}
+	return strconv.Itoa(int(v.ValueInt32()))
+}
@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestPlanSummary(t *testing.T) {
+	data := &ConnectionEphemeralResourceModel{
+		Host: types.StringValue("bastion.example.com"),
+		Port: types.Int32Value(22),
+		User: types.StringUnknown(),
+		LocalPortForwardings: []ConnectionEphemeralResourceModelLocalPortForwarding{
+			{LocalPort: types.Int32Value(5432), RemoteHost: types.StringValue("db.internal"), RemotePort: types.Int32Value(5432)},
+			{LocalPort: types.Int32Null(), LocalPortSeed: types.StringValue("cache"), RemoteHost: types.StringUnknown(), RemotePort: types.Int32Value(6379)},
+			{LocalPort: types.Int32Null(), LocalPortSeed: types.StringNull(), RemoteHost: types.StringValue("10.0.0.1"), RemotePort: types.Int32Value(443), SNIRoutes: map[string]types.String{
+				"web.internal": types.StringUnknown(),
+				"api.internal": types.StringValue("10.0.0.5:443"),
+			}},
+			{LocalPort: types.Int32Null(), LocalSocketPath: types.StringValue("/run/docker.sock"), RemoteSocketPath: types.StringValue("/var/run/docker.sock")},
+		},
+		DynamicPortForwardings: []ConnectionEphemeralResourceModelDynamicPortForwarding{
+			{LocalPort: types.Int32Value(1080), Protocol: types.StringNull()},
+			{LocalPort: types.Int32Null(), Protocol: types.StringValue("http")},
+		},
+		UDPForwardings: []ConnectionEphemeralResourceModelUDPForwarding{
+			{LocalPort: types.Int32Value(5353), RemoteHost: types.StringValue("10.0.0.2"), RemotePort: types.Int32Value(53), RelayCommand: types.StringNull()},
+			{LocalPort: types.Int32Null(), RemoteHost: types.StringValue("syslog.internal"), RemotePort: types.Int32Value(514), RelayCommand: types.StringValue("socat - UDP:%h:%p")},
+		},
+		RemotePortForwardings: []ConnectionEphemeralResourceModelRemotePortForwarding{
+			{BindAddress: types.StringNull(), RemotePort: types.Int32Value(9000), LocalHost: types.StringValue("localhost"), LocalPort: types.Int32Unknown()},
+			{BindAddress: types.StringValue("0.0.0.0"), RemotePort: types.Int32Null(), LocalHost: types.StringValue("localhost"), LocalPort: types.Int32Value(3000)},
+		},
+		RemoteSocketForwardings: []ConnectionEphemeralResourceModelRemoteSocketForwarding{
+			{RemoteSocketPath: types.StringValue("/run/app.sock"), LocalHost: types.StringValue("127.0.0.1"), LocalPort: types.Int32Value(8080)},
+			{RemoteSocketPath: types.StringValue("/tmp/agent.sock"), LocalSocketPath: types.StringUnknown()},
+		},
+		Heartbeat:    &ConnectionEphemeralResourceModelHeartbeat{Command: types.StringNull()},
+		PTYSession:   &ConnectionEphemeralResourceModelPTYSession{Command: types.StringValue("tail -f /var/log/syslog")},
+		ExecFallback: types.StringValue("nc %h %p"),
+		Labels: map[string]types.String{
+			"team": types.StringValue("data"),
+			"env":  types.StringValue("prod"),
+		},
+	}
+
+	want := fmt.Sprintf(`Connection to bastion.example.com:22 as (known after apply) authenticating with private_key, agent
+
+Local port forwardings, connected to by the SSH server:
+  - local port 5432 -> db.internal:5432
+  - local port %d -> (known after apply):6379
+  - random local port -> 10.0.0.1:443
+    - TLS server name api.internal -> 10.0.0.5:443
+    - TLS server name web.internal -> (known after apply)
+  - local socket /run/docker.sock -> remote socket /var/run/docker.sock
+
+Dynamic port forwardings, connecting to any target requested by local clients:
+  - local port 1080 (SOCKS5)
+  - random local port (HTTP CONNECT)
+
+UDP forwardings, relayed by a command run on the SSH server:
+  - local UDP port 5353 -> 10.0.0.2:53
+  - random local UDP port -> syslog.internal:514
+
+Remote port forwardings, exposed on the SSH server:
+  - remote port 9000 -> localhost:(known after apply)
+  - random remote port on 0.0.0.0 -> localhost:3000
+
+Remote socket forwardings, exposed on the SSH server:
+  - /run/app.sock -> 127.0.0.1:8080
+  - /tmp/agent.sock -> local socket (known after apply)
+
+Commands run on the SSH server:
+  - heartbeat: "true"
+  - pty_session: "tail -f /var/log/syslog"
+  - exec_fallback, if port forwarding is prohibited: "nc %%h %%p"
+  - udp_forwardings relay, for each local client: "nc -u %%h %%p"
+  - udp_forwardings relay, for each local client: "socat - UDP:%%h:%%p"
+
+Labels: env=prod, team=data`, seededPort("cache", 0))
+
+	if got := planSummary(data, []string{"private_key", "agent"}); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPlanSummaryMinimal(t *testing.T) {
+	data := &ConnectionEphemeralResourceModel{
+		Host: types.StringValue("localhost"),
+		Port: types.Int32Value(2222),
+		User: types.StringValue("root"),
+	}
+
+	if got, want := planSummary(data, nil), "Connection to localhost:2222 as root"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,172 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	// Time zones of access windows have to be available on systems without
+	// a zoneinfo database, e.g. Windows.
+	_ "time/tzdata"
+)
+
+// accessPolicy restricts when and with which labels tunnels may be opened.
+type accessPolicy struct {
+	// windows are the time windows tunnels may be opened in, any time if
+	// empty.
+	windows []accessWindow
+	// requiredLabels have to be set on every connection.
+	requiredLabels []string
+	// targetAllowList restricts the remote targets of forwardings, any
+	// target if nil.
+	targetAllowList *targetAllowList
+}
+
+// accessWindow is a daily time window in a time zone. Windows ending before
+// they start span midnight.
+type accessWindow struct {
+	// days the window starts on, every day if empty.
+	days     map[time.Weekday]bool
+	start    time.Duration
+	end      time.Duration
+	location *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseAccessWindow parses a window from lowercase three letter days, start
+// and end times formatted as HH:MM and an IANA time zone, UTC if empty.
+func parseAccessWindow(days []string, start, end, timeZone string) (accessWindow, error) {
+	w := accessWindow{
+		days:     map[time.Weekday]bool{},
+		location: time.UTC,
+	}
+
+	for _, day := range days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return w, fmt.Errorf("invalid day %q, expected one of mon, tue, wed, thu, fri, sat or sun", day)
+		}
+		w.days[weekday] = true
+	}
+
+	var err error
+	if w.start, err = parseTimeOfDay(start); err != nil {
+		return w, fmt.Errorf("invalid start: %w", err)
+	}
+	if w.end, err = parseTimeOfDay(end); err != nil {
+		return w, fmt.Errorf("invalid end: %w", err)
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("start and end must differ")
+	}
+
+	if timeZone != "" {
+		if w.location, err = time.LoadLocation(timeZone); err != nil {
+			return w, fmt.Errorf("invalid time zone: %w", err)
+		}
+	}
+
+	return w, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w accessWindow) onDay(day time.Weekday) bool {
+	return len(w.days) == 0 || w.days[day]
+}
+
+// contains reports whether now is within the window.
+func (w accessWindow) contains(now time.Time) bool {
+	local := now.In(w.location)
+	offset := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+
+	if w.start < w.end {
+		return w.onDay(local.Weekday()) && offset >= w.start && offset < w.end
+	}
+
+	// The window spans midnight, early times belong to the previous day.
+	if offset >= w.start {
+		return w.onDay(local.Weekday())
+	}
+	return offset < w.end && w.onDay((local.Weekday()+6)%7)
+}
+
+func (w accessWindow) String() string {
+	days := "daily"
+	if len(w.days) > 0 {
+		names := []string{}
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if w.days[day] {
+				names = append(names, strings.ToLower(day.String()[:3]))
+			}
+		}
+		days = strings.Join(names, ",")
+	}
+
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+
+	return fmt.Sprintf("%s %s-%s %s", days, format(w.start), format(w.end), w.location)
+}
+
+// checkLabels returns an error if a required label is missing or empty.
+func (p *accessPolicy) checkLabels(labels map[string]string) error {
+	missing := []string{}
+	for _, label := range p.requiredLabels {
+		if labels[label] == "" {
+			missing = append(missing, label)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required labels: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkTime returns an error if now is outside of all windows.
+func (p *accessPolicy) checkTime(now time.Time) error {
+	if len(p.windows) == 0 {
+		return nil
+	}
+
+	windows := make([]string, 0, len(p.windows))
+	for _, w := range p.windows {
+		if w.contains(now) {
+			return nil
+		}
+		windows = append(windows, w.String())
+	}
+
+	return fmt.Errorf("%s is outside of the allowed windows (%s)", now.UTC().Format(time.RFC3339), strings.Join(windows, "; "))
+}
+
+// restrictsTargets reports whether only some remote targets are allowed.
+func (p *accessPolicy) restrictsTargets() bool {
+	return p.targetAllowList != nil
+}
+
+// checkTargets returns an error if a remote target isn't allowed.
+func (p *accessPolicy) checkTargets(ctx context.Context, targets []string) error {
+	if p.targetAllowList == nil {
+		return nil
+	}
+	return p.targetAllowList.check(ctx, targets)
+}
@@ -0,0 +1,88 @@
+package provider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessWindowContains(t *testing.T) {
+	weekdays, err := parseAccessWindow([]string{"mon", "tue", "wed", "thu", "fri"}, "09:00", "17:00", "Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	overnight, err := parseAccessWindow([]string{"fri"}, "22:00", "02:00", "")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		window accessWindow
+		now    string
+		want   bool
+	}{
+		{"within", weekdays, "2026-10-16T08:30:00Z", true},
+		{"before start", weekdays, "2026-10-16T06:30:00Z", false},
+		{"at end", weekdays, "2026-10-16T15:00:00Z", false},
+		{"weekend", weekdays, "2026-10-17T10:00:00Z", false},
+		{"overnight before midnight", overnight, "2026-10-16T23:00:00Z", true},
+		{"overnight after midnight", overnight, "2026-10-17T01:59:00Z", true},
+		{"overnight after end", overnight, "2026-10-17T02:00:00Z", false},
+		{"overnight wrong day", overnight, "2026-10-16T01:00:00Z", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.window.contains(now); got != tt.want {
+				t.Errorf("%s contains %s: got %v, want %v", tt.window, tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAccessWindowInvalid(t *testing.T) {
+	tests := map[string][]string{
+		"day":       {"monday", "09:00", "17:00", ""},
+		"start":     {"mon", "9", "17:00", ""},
+		"end":       {"mon", "09:00", "25:00", ""},
+		"same":      {"mon", "09:00", "09:00", ""},
+		"time zone": {"mon", "09:00", "17:00", "Mars/Olympus"},
+	}
+
+	for name, args := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseAccessWindow([]string{args[0]}, args[1], args[2], args[3]); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestAccessPolicy(t *testing.T) {
+	window, err := parseAccessWindow(nil, "09:00", "17:00", "")
+	if err != nil {
+		t.Fatalf("Failed to parse window: %v", err)
+	}
+	policy := &accessPolicy{
+		windows:        []accessWindow{window},
+		requiredLabels: []string{"change_ticket"},
+	}
+
+	if err := policy.checkLabels(map[string]string{"change_ticket": "CHG-1234"}); err != nil {
+		t.Errorf("Expected labels to be accepted, got %v", err)
+	}
+	if err := policy.checkLabels(map[string]string{"change_ticket": ""}); err == nil {
+		t.Error("Expected an empty label to be rejected")
+	}
+
+	if err := policy.checkTime(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Errorf("Expected time to be accepted, got %v", err)
+	}
+	if err := policy.checkTime(time.Date(2026, 10, 16, 20, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("Expected time to be rejected")
+	}
+}
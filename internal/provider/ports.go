@@ -0,0 +1,23 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// Seeded ports are picked below the default ephemeral port ranges of
+	// Linux and Windows, so they don't collide with outgoing connections.
+	seededPortMin = 10000
+	seededPortMax = 32767
+
+	seededPortCandidates = 10
+)
+
+// seededPort returns the n-th candidate local port derived from seed. The
+// same seed always yields the same sequence of ports.
+func seededPort(seed string, n int) int32 {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", seed, n)))
+	return seededPortMin + int32(binary.BigEndian.Uint32(sum[:4])%(seededPortMax-seededPortMin+1))
+}
@@ -0,0 +1,21 @@
+package provider
+
+import (
+	"testing"
+)
+
+func TestSeededPort(t *testing.T) {
+	for n := 0; n < seededPortCandidates; n++ {
+		port := seededPort("postgres", n)
+		if port < seededPortMin || port > seededPortMax {
+			t.Errorf("Port %d out of range", port)
+		}
+		if again := seededPort("postgres", n); again != port {
+			t.Errorf("Expected the same port for the same seed, got %d and %d", port, again)
+		}
+	}
+
+	if seededPort("postgres", 0) == seededPort("redis", 0) {
+		t.Error("Expected different ports for different seeds")
+	}
+}
@@ -0,0 +1,57 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/kayrus/putty"
+	"golang.org/x/crypto/ssh"
+)
+
+// puttyKeyPrefix starts PuTTY private key files (.ppk) of all versions.
+const puttyKeyPrefix = "PuTTY-User-Key-File-"
+
+func isPuTTYPrivateKey(privateKey []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(privateKey), []byte(puttyKeyPrefix))
+}
+
+// parsePuTTYPrivateKey converts a PuTTY private key file (.ppk) of version 2
+// or 3, e.g. exported from Pageant, into a signer. Errors match those of
+// parsing OpenSSH keys, so they are reported the same way.
+func parsePuTTYPrivateKey(privateKey []byte, passphrase types.String) (ssh.Signer, error) {
+	key, err := putty.New(bytes.TrimSpace(privateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PuTTY key: %w", err)
+	}
+
+	encrypted := key.Encryption != "none"
+	if encrypted && passphrase.IsNull() {
+		publicKey, err := ssh.ParsePublicKey(key.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PuTTY key: %w", err)
+		}
+		return nil, &ssh.PassphraseMissingError{PublicKey: publicKey}
+	}
+
+	// The MAC of unencrypted keys is computed without the passphrase.
+	var password []byte
+	if encrypted {
+		password = []byte(passphrase.ValueString())
+	}
+	raw, err := key.ParseRawPrivateKey(password)
+	if err != nil {
+		if encrypted {
+			// A wrong passphrase fails the MAC verification.
+			return nil, x509.IncorrectPasswordError
+		}
+		return nil, fmt.Errorf("invalid PuTTY key: %w", err)
+	}
+
+	if k, ok := raw.(*ed25519.PrivateKey); ok {
+		raw = *k
+	}
+	return ssh.NewSignerFromKey(raw)
+}
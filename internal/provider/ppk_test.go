@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// PuTTY keys generated by puttygen, protected by the passphrase "testkey".
+const (
+	testPuTTYKeyV2Ed25519 = `PuTTY-User-Key-File-2: ssh-ed25519
+Encryption: aes256-cbc
+Comment: a@b
+Public-Lines: 2
+AAAAC3NzaC1lZDI1NTE5AAAAIMb3N9pbqMpSJRFb/WF8Wcz80SiW8emW3aLFqdRA
+rs+r
+Private-Lines: 1
+i6a/aAknwkK/cVT8nW9zcsOJDvOdPvfBlx0suOtygmSbz9L4yoBAZZu8AHxWDSgm
+Private-MAC: 8fa9edfc1b94bec840ee1526d290bf1d8eb9fbc9`
+
+	testPuTTYKeyV3RSA = `PuTTY-User-Key-File-3: ssh-rsa
+Encryption: none
+Comment: a@b
+Public-Lines: 6
+AAAAB3NzaC1yc2EAAAADAQABAAABAQDNsvsFOGphVzbJJAARnMs2E9p6jheXLTz7
+dnZqNwZCYomnGurAPEuKmxD3GzdT+xP4BLFbAGDkeJHmjiNAPnbJf7G90u2zD28Y
+J/c/krfKli50ZUOXG1a2DUhIvRM1GewOLhE7q5AOBHLQNFXvU9LR08t9H3u9xPJI
+xNJjP6LqRGn+fP1xqlTbG3NTwCZMMXgXuAUhXGKaKbLUBN5SYmLvLTB6KzdHJQ6x
+H9X+2Ul4hExje5L2X8miQqTxPloNtQNqpEtR2X7ecLyM9v3N1yDUK/NLwJ+PX8C8
+KRbuBi5+xp+k62+btFXIk6CgGpsda/KleLmzTk5QJGLA9DfzrvAd
+Private-Lines: 14
+AAABAQCWR5StE7Jku1sDSJHkTDEKqSaNMxJ5GEvdS4bnwpuIFIWM2FV5bJOkB/Y1
+EmUxrdXA9Wy9l2EyigPN9To7zWbrf6dTj66pizUW6NvyTjaIg4Ac+X6P/yEykDGn
+Mru9p9qV4YIlngn4s7dN9W5zE0KKmbmpCD9XPXPlRiaO7AcSLujUHp7kPij2i9EL
+vYRy0TS2g/HbQlBiaCS3+RI5K1UrwSP/MUFzmy319ZuI5XZUz7Z7OER4tgFi8qth
+HqPkvBTnbi3ORIhRQQT+faEmKHwyDuXTXlITWj+1k3wY6sdr308OfRut6OcH417U
+/YcZfBK6A3iZ9AJ/ih1Sqd0xCDkBAAAAgQD6IYSnq2k8LcGZvEtMt/izjFQICaJu
+xvIbXBRsTqMmpNZiaDJU4i8NTbvfHBOSkx2Ip9dFQIVy9ijOuwg24VuXyCDY8Rzb
+L/3Wkz/a1q4CJJSXgOpqQF60Dk8nYNRqEc2ykGkn/3GV/uqWbz0ohS1Wr55XiZeJ
+fUSKmI72Yk6BVQAAAIEA0oaSAScm+gat8e6jAGpm1mHwf3iLI34NVgY3TzpL4kyz
+Xk0OpxWMY5cgoXmWMnT1yCpun9SYBzyRhrfY8x7VPcNC9X96hNp/nIkp/FIWq/8M
+TV2SIFcxidXpwMbGD8HXjAng+AkNYlK8ow/SDEkYsHWKuZsf99VqiHzgs5Y5U6kA
+AACBAJ3N00Sgdv036FTLnU+NlF4N0kjhzjMDAPWRf9XvwkugiyB2tZ43rVCmXzgE
+FzNeuOrWXPC7xh9Jfbg04rJv7sYZhSIIadTO3y3ToPXHpRNwg9pmC1BaQLMb0I5M
+JUUNn5ASrFQki0/Ok5mwxz+QpktrvUuShkd/4e+sqHZ5mZ0n
+Private-MAC: cceed3168be3c35863ebff8ff41457aa5ab449603b5660df1a4eea0201827c44`
+
+	testPuTTYKeyV3RSAEncrypted = `PuTTY-User-Key-File-3: ssh-rsa
+Encryption: aes256-cbc
+Comment: a@b
+Public-Lines: 6
+AAAAB3NzaC1yc2EAAAADAQABAAABAQDNsvsFOGphVzbJJAARnMs2E9p6jheXLTz7
+dnZqNwZCYomnGurAPEuKmxD3GzdT+xP4BLFbAGDkeJHmjiNAPnbJf7G90u2zD28Y
+J/c/krfKli50ZUOXG1a2DUhIvRM1GewOLhE7q5AOBHLQNFXvU9LR08t9H3u9xPJI
+xNJjP6LqRGn+fP1xqlTbG3NTwCZMMXgXuAUhXGKaKbLUBN5SYmLvLTB6KzdHJQ6x
+H9X+2Ul4hExje5L2X8miQqTxPloNtQNqpEtR2X7ecLyM9v3N1yDUK/NLwJ+PX8C8
+KRbuBi5+xp+k62+btFXIk6CgGpsda/KleLmzTk5QJGLA9DfzrvAd
+Key-Derivation: Argon2id
+Argon2-Memory: 8192
+Argon2-Passes: 13
+Argon2-Parallelism: 1
+Argon2-Salt: 745d60746c67666afa47dbf23226c6c9
+Private-Lines: 14
+gqyGdBy5Nhxs5w00/7LUKZVUgwKVbTOcDjMh0ItVc5mWr7PoqtJhzrv7o8zEshHL
+vviIJJ2NTo+whHEStAIaxqnJC0/KWSXvnhElH0+27+Yvkz+Z32hyczSbQp/fsBSA
+3ZMQoyR92uAjG+gV7b0mqgsC0JWyaZYvippMNBHArZM8kaXdUYLDgmeXwIf7o/1I
+QVh6RPanavcbDtafumHF2bIRCq5og1UoiaVyysgSMdrDpkkFvjHNwc4+xDEqnH3u
+3v9PLIsolhbWUM7BwC1PnuCiaagbRvXoq+QTfdT5cbQw8lFngTgYT5NDkGJKMjB2
+qoDIOYOK8NsoiUxk2UvPP4XpwfJyHYL1LuS3B85e3/RbVcfM2UIm/75CNb/yLJ09
+1x4oLNBDkZQDhxwsT7VMg+h97eq/zJVhoAUXKN17JoV9hVmi5J46tskLAKhWA2vs
+QuDd6pfxjc8TyaiMLNTDr7/72UNw/mn7zH9GedyhMRhyYnzy8qYOFa5k6/bFnV89
+qRmKUqkaVDDf6dGtOOVvGP4iWj8TzrQsOa2qyj4UNUdj/9BSYHvodNPkOFMhUHqn
+fUU6RUKUV3q1Uoj5E8HaMR7OHNMSx9OA7iWcpuMYAYbcyq4OJcE6ggy3FImrgTe0
+9fBTw4Og3p91nBwOTajVj57wg5cs34YfBUQK+6P38A7+xTLBaVwvawaovAyVdDkD
+y1Ae/WtloFz5aRzt8cNYfxvyzoFrGPRaomFgltLfLBhDELZcpXF8TQFpswN/wo4o
+REFZdIWdiIYROykhX+FbKVMiufqj+snbpPACudio/DeC03Dj5oagDNJ5sfqiHn2m
+93g2/twM3JT/bJOD01jL00yaSgaR4lWTelKbfrtqrgcZR1EryBwHv7VZykR066xJ
+Private-MAC: 819054f7340f430ab9896ad76559cd2d489ab23bc517113e1cd425f461fac726`
+)
+
+func TestParsePuTTYPrivateKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		privateKey string
+		passphrase types.String
+		keyType    string
+	}{
+		{"v2 encrypted", testPuTTYKeyV2Ed25519, types.StringValue("testkey"), ssh.KeyAlgoED25519},
+		{"v3", testPuTTYKeyV3RSA, types.StringNull(), ssh.KeyAlgoRSA},
+		{"v3 with passphrase", testPuTTYKeyV3RSA, types.StringValue("unused"), ssh.KeyAlgoRSA},
+		{"v3 encrypted", testPuTTYKeyV3RSAEncrypted, types.StringValue("testkey"), ssh.KeyAlgoRSA},
+		{"windows line endings", strings.ReplaceAll(testPuTTYKeyV2Ed25519, "\n", "\r\n"), types.StringValue("testkey"), ssh.KeyAlgoED25519},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := parsePrivateKey([]byte(tt.privateKey), tt.passphrase)
+			if err != nil {
+				t.Fatalf("Failed to parse key: %v", err)
+			}
+			if got := signer.PublicKey().Type(); got != tt.keyType {
+				t.Errorf("got key type %s, want %s", got, tt.keyType)
+			}
+			if _, err := signer.Sign(nil, []byte("data")); err != nil {
+				t.Errorf("Failed to sign: %v", err)
+			}
+		})
+	}
+}
+
+func TestParsePuTTYPrivateKeyErrors(t *testing.T) {
+	_, err := parsePrivateKey([]byte(testPuTTYKeyV3RSAEncrypted), types.StringNull())
+	var passphraseMissingErr *ssh.PassphraseMissingError
+	if !errors.As(err, &passphraseMissingErr) {
+		t.Errorf("Expected a missing passphrase error, got %v", err)
+	}
+
+	if _, err := parsePrivateKey([]byte(testPuTTYKeyV2Ed25519), types.StringValue("wrong")); !errors.Is(err, x509.IncorrectPasswordError) {
+		t.Errorf("Expected an incorrect passphrase error, got %v", err)
+	}
+
+	corrupted := strings.Replace(testPuTTYKeyV3RSA, "Private-MAC: cc", "Private-MAC: dd", 1)
+	if _, err := parsePrivateKey([]byte(corrupted), types.StringNull()); err == nil || !strings.Contains(err.Error(), "invalid PuTTY key") {
+		t.Errorf("Expected an invalid key error, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+const (
+	priorityClassInteractive = "interactive"
+	priorityClassBulk        = "bulk"
+)
+
+// defaultPriorityWeights are the classes available without configuring
+// priority_classes. Forwardings without a class are interactive, so marking
+// the forwardings of a data copy as bulk is enough.
+var defaultPriorityWeights = map[string]int{
+	priorityClassInteractive: 8,
+	priorityClassBulk:        1,
+}
+
+// priorityWeights returns the weights of the default classes overridden and
+// extended by the configured ones. Unknown weights are skipped.
+func priorityWeights(configured map[string]types.Int32) (map[string]int, error) {
+	weights := make(map[string]int, len(defaultPriorityWeights)+len(configured))
+	for name, weight := range defaultPriorityWeights {
+		weights[name] = weight
+	}
+
+	names := make([]string, 0, len(configured))
+	for name := range configured {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		weight := configured[name]
+		if name == "" {
+			return nil, fmt.Errorf("class names must not be empty")
+		}
+		if weight.IsNull() {
+			return nil, fmt.Errorf("the weight of class %q must not be null", name)
+		}
+		if weight.IsUnknown() {
+			continue
+		}
+		if weight.ValueInt32() <= 0 {
+			return nil, fmt.Errorf("the weight of class %q must be positive", name)
+		}
+		weights[name] = int(weight.ValueInt32())
+	}
+	return weights, nil
+}
+
+// priorityClassName returns the class of a forwarding.
+func priorityClassName(class types.String) string {
+	if class.IsNull() {
+		return priorityClassInteractive
+	}
+	return class.ValueString()
+}
+
+// usesPriorityClasses reports whether any forwarding sets a priority class,
+// otherwise forwardings aren't scheduled.
+func usesPriorityClasses(forwardings []ConnectionEphemeralResourceModelLocalPortForwarding) bool {
+	for _, f := range forwardings {
+		if !f.PriorityClass.IsNull() {
+			return true
+		}
+	}
+	return false
+}
+
+// newPriorityClasses returns the classes of a scheduler shared by all
+// forwardings of a connection.
+func newPriorityClasses(weights map[string]int) map[string]*portforward.PriorityClass {
+	scheduler := portforward.NewScheduler()
+	classes := make(map[string]*portforward.PriorityClass, len(weights))
+	for name, weight := range weights {
+		classes[name] = scheduler.NewClass(name, weight)
+	}
+	return classes
+}
@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestPriorityWeights(t *testing.T) {
+	weights, err := priorityWeights(map[string]types.Int32{
+		"bulk":    types.Int32Value(2),
+		"metrics": types.Int32Value(4),
+		"pending": types.Int32Unknown(),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := map[string]int{"interactive": 8, "bulk": 2, "metrics": 4}
+	if len(weights) != len(want) {
+		t.Errorf("got %v, want %v", weights, want)
+	}
+	for name, weight := range want {
+		if weights[name] != weight {
+			t.Errorf("got weight %d for %s, want %d", weights[name], name, weight)
+		}
+	}
+
+	for name, configured := range map[string]map[string]types.Int32{
+		"zero weight": {"bulk": types.Int32Value(0)},
+		"null weight": {"bulk": types.Int32Null()},
+		"empty name":  {"": types.Int32Value(1)},
+		"negative":    {"metrics": types.Int32Value(-1)},
+	} {
+		if _, err := priorityWeights(configured); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestPriorityClassesOfForwardings(t *testing.T) {
+	forwardings := []ConnectionEphemeralResourceModelLocalPortForwarding{
+		{PriorityClass: types.StringNull()},
+		{PriorityClass: types.StringNull()},
+	}
+	if usesPriorityClasses(forwardings) {
+		t.Error("Expected forwardings without classes not to be scheduled")
+	}
+
+	forwardings[1].PriorityClass = types.StringValue("bulk")
+	if !usesPriorityClasses(forwardings) {
+		t.Error("Expected forwardings to be scheduled once one sets a class")
+	}
+
+	classes := newPriorityClasses(defaultPriorityWeights)
+	if got := classes[priorityClassName(forwardings[0].PriorityClass)].Weight(); got != 8 {
+		t.Errorf("Expected forwardings without a class to be interactive, got weight %d", got)
+	}
+	if got := classes[priorityClassName(forwardings[1].PriorityClass)].Name(); got != "bulk" {
+		t.Errorf("got class %q, want bulk", got)
+	}
+}
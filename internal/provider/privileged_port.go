@@ -0,0 +1,26 @@
+package provider
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// privilegedPortHint describes how to allow binding local ports below 1024
+// on the current platform.
+func privilegedPortHint() string {
+	switch runtime.GOOS {
+	case "linux":
+		exe, err := os.Executable()
+		if err != nil {
+			exe = "terraform-provider-sshtunnel"
+		}
+		return fmt.Sprintf("Grant the provider binary CAP_NET_BIND_SERVICE with `sudo %s setcap`, "+
+			"lower the net.ipv4.ip_unprivileged_port_start sysctl or use a local port of 1024 or above", exe)
+	case "windows":
+		return "Run Terraform as administrator, check the excluded port ranges with " +
+			"`netsh interface ipv4 show excludedportrange protocol=tcp` or use a local port of 1024 or above"
+	default:
+		return "Run Terraform as root or use a local port of 1024 or above"
+	}
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// applyForwardingProfiles fills the attributes of local port forwardings not
+// set in the config from their profile and adds the labels of the profiles
+// to the connection. Nothing is applied before the provider is configured.
+func (r *ConnectionEphemeralResource) applyForwardingProfiles(data *ConnectionEphemeralResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if r.forwardingProfiles == nil {
+		return diags
+	}
+
+	for i := range data.LocalPortForwardings {
+		f := &data.LocalPortForwardings[i]
+		if f.Profile.IsNull() || f.Profile.IsUnknown() {
+			continue
+		}
+
+		profile, ok := r.forwardingProfiles[f.Profile.ValueString()]
+		if !ok {
+			diags.AddError("Forwarding Profile Error", fmt.Sprintf("Unknown forwarding profile %q", f.Profile.ValueString()))
+			continue
+		}
+
+		if f.RemoteHost.IsNull() {
+			f.RemoteHost = profile.RemoteHost
+		}
+		if f.RemotePort.IsNull() {
+			f.RemotePort = profile.RemotePort
+		}
+		if f.RetryAttempts.IsNull() {
+			f.RetryAttempts = profile.RetryAttempts
+		}
+		if f.RetryDelay.IsNull() {
+			f.RetryDelay = profile.RetryDelay
+		}
+		if f.ListenBacklog.IsNull() {
+			f.ListenBacklog = profile.ListenBacklog
+		}
+		if f.MaxConnections.IsNull() {
+			f.MaxConnections = profile.MaxConnections
+		}
+		if f.AcceptWorkers.IsNull() {
+			f.AcceptWorkers = profile.AcceptWorkers
+		}
+		if f.MaxBytes.IsNull() {
+			f.MaxBytes = profile.MaxBytes
+		}
+		if f.PriorityClass.IsNull() {
+			f.PriorityClass = profile.PriorityClass
+		}
+
+		for k, v := range profile.Labels {
+			if _, ok := data.Labels[k]; ok {
+				continue
+			}
+			if data.Labels == nil {
+				data.Labels = map[string]types.String{}
+			}
+			data.Labels[k] = v
+		}
+	}
+
+	return diags
+}
@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestApplyForwardingProfiles(t *testing.T) {
+	r := &ConnectionEphemeralResource{
+		forwardingProfiles: map[string]SSHTunnelProviderModelForwardingProfile{
+			"postgres": {
+				RemoteHost:    types.StringValue("db.internal"),
+				RemotePort:    types.Int32Value(5432),
+				RetryAttempts: types.Int32Value(3),
+				Labels: map[string]types.String{
+					"service": types.StringValue("postgres"),
+					"ticket":  types.StringValue("default"),
+				},
+			},
+		},
+	}
+
+	data := ConnectionEphemeralResourceModel{
+		LocalPortForwardings: []ConnectionEphemeralResourceModelLocalPortForwarding{{
+			RemoteHost: types.StringValue("replica.internal"),
+			Profile:    types.StringValue("postgres"),
+		}},
+		Labels: map[string]types.String{
+			"ticket": types.StringValue("CHG-1"),
+		},
+	}
+
+	if diags := r.applyForwardingProfiles(&data); diags.HasError() {
+		t.Fatalf("Failed to apply profiles: %v", diags)
+	}
+
+	f := data.LocalPortForwardings[0]
+	if got := f.RemoteHost.ValueString(); got != "replica.internal" {
+		t.Errorf("got remote_host %q, want the configured replica.internal", got)
+	}
+	if got := f.RemotePort.ValueInt32(); got != 5432 {
+		t.Errorf("got remote_port %d, want 5432", got)
+	}
+	if got := f.RetryAttempts.ValueInt32(); got != 3 {
+		t.Errorf("got retry_attempts %d, want 3", got)
+	}
+	if !f.RetryDelay.IsNull() {
+		t.Errorf("Expected retry_delay to stay null, got %s", f.RetryDelay)
+	}
+	if got := data.Labels["service"].ValueString(); got != "postgres" {
+		t.Errorf("got service label %q, want postgres", got)
+	}
+	if got := data.Labels["ticket"].ValueString(); got != "CHG-1" {
+		t.Errorf("got ticket label %q, want the configured CHG-1", got)
+	}
+}
+
+func TestApplyForwardingProfilesUnknown(t *testing.T) {
+	data := ConnectionEphemeralResourceModel{
+		LocalPortForwardings: []ConnectionEphemeralResourceModelLocalPortForwarding{{
+			Profile: types.StringValue("missing"),
+		}},
+	}
+
+	r := &ConnectionEphemeralResource{}
+	if diags := r.applyForwardingProfiles(&data); diags.HasError() {
+		t.Errorf("Expected no error before the provider is configured, got %v", diags)
+	}
+
+	r.forwardingProfiles = map[string]SSHTunnelProviderModelForwardingProfile{}
+	if diags := r.applyForwardingProfiles(&data); !diags.HasError() {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
@@ -0,0 +1,271 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"golang.org/x/crypto/ssh"
+)
+
+// ProtocolExtension handles vendor-specific parts of the SSH protocol, e.g.
+// proprietary keepalive requests of appliances closing connections whose
+// requests are rejected. Extensions are registered on the provider, so
+// support for an appliance can be added without touching the ephemeral
+// resource.
+type ProtocolExtension interface {
+	// Name identifies the extension in diagnostics.
+	Name() string
+
+	// Hooks returns the hooks of the extension for a connection, nil if the
+	// connection doesn't use the extension. Values may be unknown during
+	// validation, hooks are only used once they are known.
+	Hooks(data *ConnectionEphemeralResourceModel) (*ProtocolHooks, diag.Diagnostics)
+}
+
+// GlobalRequestHandler answers a global request sent by the SSH server.
+type GlobalRequestHandler func(payload []byte) (ok bool, response []byte)
+
+// ChannelHandler serves a channel opened by the SSH server until ctx is done.
+type ChannelHandler func(ctx context.Context, channel ssh.NewChannel)
+
+// ProtocolHooks are the hooks of a ProtocolExtension for a connection.
+type ProtocolHooks struct {
+	// GlobalRequests answer the global requests of the server by type.
+	// Requests of other types are rejected.
+	GlobalRequests map[string]GlobalRequestHandler
+	// Channels serve the channels opened by the server by type. Channels of
+	// other types are rejected.
+	Channels map[string]ChannelHandler
+	// Run, if not nil, runs on the established connection until ctx is done,
+	// e.g. to send proprietary keepalive requests.
+	Run func(ctx context.Context, conn ssh.Conn)
+}
+
+func defaultProtocolExtensions() []ProtocolExtension {
+	return []ProtocolExtension{
+		&globalRequestsExtension{},
+	}
+}
+
+// protocolHooks are the hooks of all extensions used by a connection. The
+// first extension handling a type takes precedence.
+type protocolHooks struct {
+	globalRequests map[string]GlobalRequestHandler
+	channels       map[string]ChannelHandler
+	runs           []func(ctx context.Context, conn ssh.Conn)
+}
+
+// protocolHooks combines the hooks of the extensions used by data, nil if
+// it uses none.
+func (r *ConnectionEphemeralResource) protocolHooks(data *ConnectionEphemeralResourceModel) (*protocolHooks, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	var hooks *protocolHooks
+
+	for _, extension := range r.getProtocolExtensions() {
+		extensionHooks, extensionDiags := extension.Hooks(data)
+		diags.Append(extensionDiags...)
+		if extensionHooks == nil {
+			continue
+		}
+
+		if hooks == nil {
+			hooks = &protocolHooks{globalRequests: map[string]GlobalRequestHandler{}, channels: map[string]ChannelHandler{}}
+		}
+		for requestType, handler := range extensionHooks.GlobalRequests {
+			if _, ok := hooks.globalRequests[requestType]; !ok {
+				hooks.globalRequests[requestType] = handler
+			}
+		}
+		for channelType, handler := range extensionHooks.Channels {
+			if _, ok := hooks.channels[channelType]; !ok {
+				hooks.channels[channelType] = handler
+			}
+		}
+		if extensionHooks.Run != nil {
+			hooks.runs = append(hooks.runs, extensionHooks.Run)
+		}
+	}
+
+	return hooks, diags
+}
+
+// getProtocolExtensions returns the registered protocol extensions, falling
+// back to the defaults as configs can be validated before the provider is
+// configured.
+func (r *ConnectionEphemeralResource) getProtocolExtensions() []ProtocolExtension {
+	if r.protocolExtensions == nil {
+		return defaultProtocolExtensions()
+	}
+	return r.protocolExtensions
+}
+
+// requestHandlers returns the handlers of global requests passed to dial.
+func (h *protocolHooks) requestHandlers() map[string]GlobalRequestHandler {
+	if h == nil {
+		return nil
+	}
+	return h.globalRequests
+}
+
+// attach serves the channels of the hooks and starts their runs on conn
+// until ctx is done. Channels of types already handled by the client, e.g.
+// those of remote socket forwardings, are left to it.
+func (h *protocolHooks) attach(ctx context.Context, conn *ssh.Client) {
+	if h == nil {
+		return
+	}
+
+	for channelType, handler := range h.channels {
+		channels := conn.HandleChannelOpen(channelType)
+		if channels == nil {
+			tflog.Warn(ctx, "Channel type already handled", map[string]interface{}{"channel_type": channelType})
+			continue
+		}
+		go func() {
+			for channel := range channels {
+				go handler(ctx, channel)
+			}
+		}()
+	}
+
+	for _, run := range h.runs {
+		go run(ctx, conn)
+	}
+}
+
+// handleGlobalRequests answers the requests of handled types and passes all
+// others on to the returned channel, e.g. to be rejected by ssh.NewClient.
+func handleGlobalRequests(in <-chan *ssh.Request, handlers map[string]GlobalRequestHandler) <-chan *ssh.Request {
+	out := make(chan *ssh.Request)
+	go func() {
+		defer close(out)
+		for req := range in {
+			handler, ok := handlers[req.Type]
+			if !ok {
+				out <- req
+				continue
+			}
+			accepted, response := handler(req.Payload)
+			if req.WantReply {
+				_ = req.Reply(accepted, response)
+			}
+		}
+	}()
+	return out
+}
+
+// globalRequestsExtension handles the global_requests and
+// accept_channel_types of a connection.
+type globalRequestsExtension struct{}
+
+func (e *globalRequestsExtension) Name() string {
+	return "global_requests"
+}
+
+func (e *globalRequestsExtension) Hooks(data *ConnectionEphemeralResourceModel) (*ProtocolHooks, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	if len(data.GlobalRequests) == 0 && len(data.AcceptChannelTypes) == 0 {
+		return nil, diags
+	}
+
+	hooks := &ProtocolHooks{GlobalRequests: map[string]GlobalRequestHandler{}, Channels: map[string]ChannelHandler{}}
+	type keepalive struct {
+		requestType string
+		interval    time.Duration
+	}
+	keepalives := []keepalive{}
+
+	for i, request := range data.GlobalRequests {
+		attrPath := path.Root("global_requests").AtListIndex(i)
+		if request.Type.IsUnknown() {
+			continue
+		}
+		requestType := request.Type.ValueString()
+		if requestType == "" {
+			diags.AddAttributeError(attrPath.AtName("type"), "Global Request Error", "type must not be empty")
+			continue
+		}
+		if _, ok := hooks.GlobalRequests[requestType]; ok {
+			diags.AddAttributeError(attrPath.AtName("type"), "Global Request Error", fmt.Sprintf("Duplicate type %q", requestType))
+			continue
+		}
+
+		reply := request.Reply.IsNull() || request.Reply.ValueBool()
+		hooks.GlobalRequests[requestType] = func(payload []byte) (bool, []byte) {
+			return reply, nil
+		}
+
+		if request.Interval.IsNull() || request.Interval.IsUnknown() {
+			continue
+		}
+		interval, err := time.ParseDuration(request.Interval.ValueString())
+		if err != nil || interval <= 0 {
+			diags.AddAttributeError(attrPath.AtName("interval"), "Global Request Error", fmt.Sprintf("Invalid interval %q, expected a positive duration", request.Interval.ValueString()))
+			continue
+		}
+		keepalives = append(keepalives, keepalive{requestType, interval})
+	}
+
+	for i, channelType := range data.AcceptChannelTypes {
+		if channelType.IsUnknown() {
+			continue
+		}
+		if channelType.ValueString() == "" {
+			diags.AddAttributeError(path.Root("accept_channel_types").AtListIndex(i), "Channel Type Error", "Channel types must not be empty")
+			continue
+		}
+		hooks.Channels[channelType.ValueString()] = discardChannel
+	}
+
+	if len(keepalives) > 0 {
+		hooks.Run = func(ctx context.Context, conn ssh.Conn) {
+			for _, k := range keepalives {
+				go sendGlobalRequests(ctx, conn, k.requestType, k.interval)
+			}
+		}
+	}
+
+	return hooks, diags
+}
+
+// sendGlobalRequests sends a global request of requestType every interval
+// until ctx is done. Rejected requests are fine, e.g. appliances only need
+// to see traffic of the type.
+func sendGlobalRequests(ctx context.Context, conn ssh.Conn, requestType string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, _, err := conn.SendRequest(requestType, true, nil); err != nil {
+				tflog.Warn(ctx, "Global request failed", map[string]interface{}{"type": requestType, "err": err})
+			}
+		}
+	}
+}
+
+// discardChannel accepts a channel and discards its data until the server
+// closes it, rejecting its requests.
+func discardChannel(ctx context.Context, newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		tflog.Debug(ctx, "Unable to accept channel", map[string]interface{}{"channel_type": newChannel.ChannelType(), "err": err})
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	go func() {
+		<-ctx.Done()
+		channel.Close()
+	}()
+	_, _ = io.Copy(io.Discard, channel)
+	channel.Close()
+}
@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHandleGlobalRequests(t *testing.T) {
+	in := make(chan *ssh.Request)
+	handled := make(chan []byte, 1)
+	out := handleGlobalRequests(in, map[string]GlobalRequestHandler{
+		"keepalive@vendor.example.com": func(payload []byte) (bool, []byte) {
+			handled <- payload
+			return true, nil
+		},
+	})
+
+	in <- &ssh.Request{Type: "keepalive@vendor.example.com", Payload: []byte("ping")}
+	if payload := <-handled; string(payload) != "ping" {
+		t.Errorf("got payload %q, want %q", payload, "ping")
+	}
+
+	go func() { in <- &ssh.Request{Type: "hostkeys-00@openssh.com"} }()
+	if req := <-out; req.Type != "hostkeys-00@openssh.com" {
+		t.Errorf("got request %q passed on, want hostkeys-00@openssh.com", req.Type)
+	}
+
+	close(in)
+	if _, ok := <-out; ok {
+		t.Error("Expected the requests to be closed")
+	}
+}
+
+func TestProtocolHooks(t *testing.T) {
+	r := &ConnectionEphemeralResource{}
+
+	hooks, diags := r.protocolHooks(&ConnectionEphemeralResourceModel{})
+	if diags.HasError() || hooks != nil {
+		t.Fatalf("Expected no hooks without global_requests, got %v %v", hooks, diags)
+	}
+
+	hooks, diags = r.protocolHooks(&ConnectionEphemeralResourceModel{
+		GlobalRequests: []ConnectionEphemeralResourceModelGlobalRequest{
+			{Type: types.StringValue("keepalive@vendor.example.com"), Interval: types.StringValue("30s")},
+			{Type: types.StringValue("policy@vendor.example.com"), Reply: types.BoolValue(false)},
+		},
+		AcceptChannelTypes: []types.String{types.StringValue("audit@vendor.example.com")},
+	})
+	if diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	if ok, _ := hooks.globalRequests["keepalive@vendor.example.com"](nil); !ok {
+		t.Error("Expected keepalive requests to succeed by default")
+	}
+	if ok, _ := hooks.globalRequests["policy@vendor.example.com"](nil); ok {
+		t.Error("Expected policy requests to fail with reply = false")
+	}
+	if _, ok := hooks.channels["audit@vendor.example.com"]; !ok {
+		t.Error("Expected audit channels to be accepted")
+	}
+	if len(hooks.runs) != 1 {
+		t.Errorf("got %d runs, want 1 sending keepalives", len(hooks.runs))
+	}
+
+	for name, data := range map[string]*ConnectionEphemeralResourceModel{
+		"empty type": {GlobalRequests: []ConnectionEphemeralResourceModelGlobalRequest{{Type: types.StringValue("")}}},
+		"duplicate type": {GlobalRequests: []ConnectionEphemeralResourceModelGlobalRequest{
+			{Type: types.StringValue("keepalive@vendor.example.com")},
+			{Type: types.StringValue("keepalive@vendor.example.com")},
+		}},
+		"invalid interval": {GlobalRequests: []ConnectionEphemeralResourceModelGlobalRequest{
+			{Type: types.StringValue("keepalive@vendor.example.com"), Interval: types.StringValue("often")},
+		}},
+		"empty channel type": {AcceptChannelTypes: []types.String{types.StringValue("")}},
+	} {
+		if _, diags := r.protocolHooks(data); !diags.HasError() {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
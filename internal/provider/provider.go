@@ -2,17 +2,24 @@ package provider
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
 	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/function"
 	"github.com/hashicorp/terraform-plugin-framework/provider"
 	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/filelock"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
 )
 
 // Ensure SSHTunnelProvider satisfies various provider interfaces.
 var _ provider.Provider = &SSHTunnelProvider{}
 var _ provider.ProviderWithEphemeralResources = &SSHTunnelProvider{}
+var _ provider.ProviderWithFunctions = &SSHTunnelProvider{}
 
 // SSHTunnelProvider defines the provider implementation.
 type SSHTunnelProvider struct {
@@ -20,14 +27,115 @@ type SSHTunnelProvider struct {
 	// provider is built and ran locally, and "test" when running acceptance
 	// testing.
 	version string
+
+	// authProviders are the authentication methods available to connections.
+	authProviders []AuthProvider
+	// protocolExtensions handle vendor-specific parts of the SSH protocol.
+	protocolExtensions []ProtocolExtension
+
+	// listenerPool holds pre-bound local listeners, nil if there are none.
+	listenerPool *ListenerPool
+	// reclaimedPorts outlives configurations of the provider, so ports are
+	// reclaimed across plan and apply of the same process.
+	reclaimedPorts *reclaimedPorts
 }
 
 type ProviderConfigData struct {
-	Tracker *TunnelTracker
+	// Version is the version of the provider.
+	Version            string
+	Tracker            *TunnelTracker
+	AuthProviders      []AuthProvider
+	ProtocolExtensions []ProtocolExtension
+	// LockDir coordinates fixed local ports between processes, nil if not
+	// configured.
+	LockDir     *filelock.Dir
+	LockTimeout time.Duration
+	// SystemSSHConfig resolves hosts using the system-wide OpenSSH config.
+	SystemSSHConfig bool
+	// SystemKnownHosts verifies host keys against the system-wide known_hosts.
+	SystemKnownHosts bool
+	// SkipOpen prevents opening tunnels, as they are only allowed during apply.
+	SkipOpen bool
+	// PlanSummary describes each tunnel in a warning, as Terraform is
+	// planning.
+	PlanSummary bool
+	// Policy restricts opening tunnels, nil if not configured.
+	Policy *accessPolicy
+	// ListenerPool holds pre-bound local listeners, nil if there are none.
+	ListenerPool *ListenerPool
+	// ReclaimedPorts remembers the local ports of closed tunnels.
+	ReclaimedPorts *reclaimedPorts
+	// Handshakes limits the connections established concurrently per SSH
+	// server.
+	Handshakes *handshakeLimiter
+	// ForwardingProfiles are the named defaults of local port forwardings.
+	ForwardingProfiles map[string]SSHTunnelProviderModelForwardingProfile
+	// Budget limits the connections forwarded concurrently by all tunnels,
+	// nil if unlimited.
+	Budget *portforward.Budget
+	// Faults degrade the traffic of all forwardings, set by the
+	// SSHTUNNEL_CHAOS_* environment variables, nil if not set.
+	Faults *portforward.Faults
+}
+
+type SSHTunnelProviderModelLeakDetection struct {
+	MaxAge     types.String `tfsdk:"max_age"`
+	ForceClose types.Bool   `tfsdk:"force_close"`
+}
+
+type SSHTunnelProviderModelPolicyWindow struct {
+	Days     []types.String `tfsdk:"days"`
+	Start    types.String   `tfsdk:"start"`
+	End      types.String   `tfsdk:"end"`
+	TimeZone types.String   `tfsdk:"time_zone"`
+}
+
+type SSHTunnelProviderModelPolicyTargetAllowList struct {
+	URL       types.String `tfsdk:"url"`
+	PublicKey types.String `tfsdk:"public_key"`
+	CacheTTL  types.String `tfsdk:"cache_ttl"`
+}
+
+type SSHTunnelProviderModelPolicy struct {
+	Windows         []SSHTunnelProviderModelPolicyWindow         `tfsdk:"windows"`
+	RequiredLabels  []types.String                               `tfsdk:"required_labels"`
+	TargetAllowList *SSHTunnelProviderModelPolicyTargetAllowList `tfsdk:"target_allow_list"`
+}
+
+type SSHTunnelProviderModelForwardingProfile struct {
+	RemoteHost     types.String            `tfsdk:"remote_host"`
+	RemotePort     types.Int32             `tfsdk:"remote_port"`
+	RetryAttempts  types.Int32             `tfsdk:"retry_attempts"`
+	RetryDelay     types.String            `tfsdk:"retry_delay"`
+	ListenBacklog  types.Int32             `tfsdk:"listen_backlog"`
+	MaxConnections types.Int32             `tfsdk:"max_connections"`
+	AcceptWorkers  types.Int32             `tfsdk:"accept_workers"`
+	MaxBytes       types.Int64             `tfsdk:"max_bytes"`
+	Labels         map[string]types.String `tfsdk:"labels"`
+	PriorityClass  types.String            `tfsdk:"priority_class"`
 }
 
 // SSHTunnelProviderModel describes the provider data model.
-type SSHTunnelProviderModel struct{}
+type SSHTunnelProviderModel struct {
+	LeakDetection           *SSHTunnelProviderModelLeakDetection               `tfsdk:"leak_detection"`
+	LockDir                 types.String                                       `tfsdk:"lock_dir"`
+	LockTimeout             types.String                                       `tfsdk:"lock_timeout"`
+	SystemSSHConfig         types.Bool                                         `tfsdk:"system_ssh_config"`
+	SystemKnownHosts        types.Bool                                         `tfsdk:"system_known_hosts"`
+	ApplyOnly               types.Bool                                         `tfsdk:"apply_only"`
+	Applying                types.Bool                                         `tfsdk:"applying"`
+	PlanSummary             types.Bool                                         `tfsdk:"plan_summary"`
+	Policy                  *SSHTunnelProviderModelPolicy                      `tfsdk:"policy"`
+	SharedTracker           types.String                                       `tfsdk:"shared_tracker"`
+	ForwardingProfiles      map[string]SSHTunnelProviderModelForwardingProfile `tfsdk:"forwarding_profiles"`
+	MaxForwardedConnections types.Int64                                        `tfsdk:"max_forwarded_connections"`
+	MaxConcurrentHandshakes types.Int32                                        `tfsdk:"max_concurrent_handshakes"`
+}
+
+const (
+	defaultLeakDetectionMaxAge = time.Hour
+	defaultLockTimeout         = 5 * time.Minute
+)
 
 func (p *SSHTunnelProvider) Metadata(ctx context.Context, req provider.MetadataRequest, resp *provider.MetadataResponse) {
 	resp.TypeName = "sshtunnel"
@@ -37,6 +145,182 @@ func (p *SSHTunnelProvider) Metadata(ctx context.Context, req provider.MetadataR
 func (p *SSHTunnelProvider) Schema(ctx context.Context, req provider.SchemaRequest, resp *provider.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		MarkdownDescription: "The SSH Tunnel provider allow creating ephemeral SSH tunnels.",
+		Attributes: map[string]schema.Attribute{
+			"leak_detection": schema.SingleNestedAttribute{
+				MarkdownDescription: "Detection of tunnels that are still open long after they were created, e.g. because Terraform never closed them",
+				Attributes: map[string]schema.Attribute{
+					"max_age": schema.StringAttribute{
+						MarkdownDescription: "Age after which an open tunnel is reported as leaked (defaults to `1h`, `0s` disables the detection)",
+						Optional:            true,
+					},
+					"force_close": schema.BoolAttribute{
+						MarkdownDescription: "Close leaked tunnels instead of only logging a warning",
+						Optional:            true,
+					},
+				},
+				Optional: true,
+			},
+			"lock_dir": schema.StringAttribute{
+				MarkdownDescription: "Directory for lock files used to coordinate fixed local ports between concurrent Terraform runs on the same machine. " +
+					"A run waits for another run using the same local port to close its tunnel",
+				Optional: true,
+			},
+			"lock_timeout": schema.StringAttribute{
+				MarkdownDescription: "Maximum time to wait for a lock in `lock_dir` (defaults to `5m`)",
+				Optional:            true,
+			},
+			"shared_tracker": schema.StringAttribute{
+				MarkdownDescription: "Name of a tunnel tracker shared with other configurations of this provider, e.g. aliases, served by the same provider process " +
+					"(e.g. in debug mode or the `daemon` subcommand). By default every configuration tracks its tunnels separately. " +
+					"Tunnels of configurations sharing a tracker are subject to a single leak detection, configured by the first configuration",
+				Optional: true,
+			},
+			"system_ssh_config": schema.BoolAttribute{
+				MarkdownDescription: "Resolve `HostName` and `GlobalKnownHostsFile` of connection hosts from the system-wide OpenSSH client config (`/etc/ssh/ssh_config`, on Windows `%ProgramData%\\ssh\\ssh_config`). " +
+					"The `%h`, `%p`, `%r` and `%C` tokens of `GlobalKnownHostsFile` are expanded",
+				Optional: true,
+			},
+			"apply_only": schema.BoolAttribute{
+				MarkdownDescription: "Only open tunnels during apply, e.g. for change policies forbidding network access from plan-only pipelines. Requires `applying`. " +
+					"Outside of apply, dependents are deferred if supported by Terraform, otherwise placeholder values are returned (the configured or seeded `local_port`, otherwise `0`)",
+				Optional: true,
+			},
+			"applying": schema.BoolAttribute{
+				MarkdownDescription: "Whether Terraform is applying, set to `terraform.applying` when using `apply_only` or `plan_summary`",
+				Optional:            true,
+			},
+			"plan_summary": schema.BoolAttribute{
+				MarkdownDescription: "Outside of apply, emit a warning per connection describing the tunnel that will be opened (SSH server, user, " +
+					"authentication methods, forwarded targets and ports, commands run on the server), so reviewers of a plan can approve the network access. Requires `applying`",
+				Optional: true,
+			},
+			"policy": schema.SingleNestedAttribute{
+				MarkdownDescription: "Restrict when, with which labels and to which targets tunnels may be opened, for regulated environments where bastion access is only allowed in maintenance windows",
+				Attributes: map[string]schema.Attribute{
+					"windows": schema.ListNestedAttribute{
+						MarkdownDescription: "Daily time windows tunnels may be opened in (any time if not specified). Windows ending before they start span midnight",
+						NestedObject: schema.NestedAttributeObject{
+							Attributes: map[string]schema.Attribute{
+								"days": schema.ListAttribute{
+									MarkdownDescription: "Days the window starts on, any of `mon`, `tue`, `wed`, `thu`, `fri`, `sat` and `sun` (every day if not specified)",
+									ElementType:         types.StringType,
+									Optional:            true,
+								},
+								"start": schema.StringAttribute{
+									MarkdownDescription: "Start of the window as `HH:MM`",
+									Required:            true,
+								},
+								"end": schema.StringAttribute{
+									MarkdownDescription: "End of the window as `HH:MM`",
+									Required:            true,
+								},
+								"time_zone": schema.StringAttribute{
+									MarkdownDescription: "IANA time zone of `start` and `end`, e.g. `Europe/Berlin` (defaults to `UTC`)",
+									Optional:            true,
+								},
+							},
+						},
+						Optional: true,
+					},
+					"required_labels": schema.ListAttribute{
+						MarkdownDescription: "Labels every connection has to set to a non-empty value, e.g. a change ticket",
+						ElementType:         types.StringType,
+						Optional:            true,
+					},
+					"target_allow_list": schema.SingleNestedAttribute{
+						MarkdownDescription: "Fetch the remote targets forwardings may connect to from an HTTPS policy server when a tunnel is opened, " +
+							"so security teams can update them without changing Terraform code. Tunnels forwarding to other targets, including `sni_routes`, fail to open, " +
+							"as do all tunnels if the allow-list can't be fetched or verified",
+						Attributes: map[string]schema.Attribute{
+							"url": schema.StringAttribute{
+								MarkdownDescription: "`https://` URL of a JSON document `{\"allowed_targets\": [...], \"expires_at\": \"...\"}`. " +
+									"Targets are `host:port` patterns, the host being a name, `*.example.com` matching any direct subdomain, an IP address or a CIDR prefix, and the port a number or `*`. " +
+									"The optional `expires_at` (RFC 3339) rejects the document afterwards. " +
+									"The base64 encoded Ed25519 signature of the document is read from the URL with `.sig` appended",
+								Required: true,
+							},
+							"public_key": schema.StringAttribute{
+								MarkdownDescription: "OpenSSH Ed25519 public key (`ssh-ed25519 AAAA...`) the document has to be signed with",
+								Required:            true,
+							},
+							"cache_ttl": schema.StringAttribute{
+								MarkdownDescription: "Time a verified document is used before fetching it again (defaults to `5m`)",
+								Optional:            true,
+							},
+						},
+						Optional: true,
+					},
+				},
+				Optional: true,
+			},
+			"forwarding_profiles": schema.MapNestedAttribute{
+				MarkdownDescription: "Named defaults of local port forwardings, e.g. `postgres` or `k8s-api`, referenced by their `profile`. " +
+					"Attributes set on a forwarding take precedence over its profile",
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"remote_host": schema.StringAttribute{
+							MarkdownDescription: "Remote host to forward to",
+							Optional:            true,
+						},
+						"remote_port": schema.Int32Attribute{
+							MarkdownDescription: "Remote port to forward to",
+							Optional:            true,
+						},
+						"retry_attempts": schema.Int32Attribute{
+							MarkdownDescription: "Number of attempts to establish the connection",
+							Optional:            true,
+						},
+						"retry_delay": schema.StringAttribute{
+							MarkdownDescription: "Delay between connection attempts",
+							Optional:            true,
+						},
+						"listen_backlog": schema.Int32Attribute{
+							MarkdownDescription: "Size of the queue of pending local connections",
+							Optional:            true,
+						},
+						"max_connections": schema.Int32Attribute{
+							MarkdownDescription: "Maximum number of connections forwarded concurrently",
+							Optional:            true,
+						},
+						"accept_workers": schema.Int32Attribute{
+							MarkdownDescription: "Number of workers accepting local connections concurrently",
+							Optional:            true,
+						},
+						"max_bytes": schema.Int64Attribute{
+							MarkdownDescription: "Maximum number of bytes forwarded in both directions",
+							Optional:            true,
+						},
+						"priority_class": schema.StringAttribute{
+							MarkdownDescription: "Priority class sharing the SSH connection with the other forwardings, e.g. `bulk`",
+							Optional:            true,
+						},
+						"labels": schema.MapAttribute{
+							MarkdownDescription: "Labels added to connections using the profile, unless set on the connection",
+							ElementType:         types.StringType,
+							Optional:            true,
+						},
+					},
+				},
+				Optional: true,
+			},
+			"max_forwarded_connections": schema.Int64Attribute{
+				MarkdownDescription: "Maximum number of connections forwarded concurrently by all tunnels, bounding the file descriptors and goroutines of the provider. " +
+					"Each forwarded connection uses one file descriptor and up to four goroutines. " +
+					"Further connections are rejected right away and reported when the tunnel is closed (unlimited if not specified)",
+				Optional: true,
+			},
+			"max_concurrent_handshakes": schema.Int32Attribute{
+				MarkdownDescription: "Maximum number of connections established concurrently to the same SSH server, counting from the TCP connect until authentication completed. " +
+					"OpenSSH's sshd randomly drops unauthenticated connections beyond its `MaxStartups`, e.g. when opening many tunnels with `terraform apply -parallelism=50`, " +
+					fmt.Sprintf("further connections wait instead (defaults to `%d`, the first `MaxStartups` threshold of sshd)", defaultMaxConcurrentHandshakes),
+				Optional: true,
+			},
+			"system_known_hosts": schema.BoolAttribute{
+				MarkdownDescription: "Verify host keys against the system-wide known_hosts file (`/etc/ssh/ssh_known_hosts`, on Windows `%ProgramData%\\ssh\\ssh_known_hosts`) and, with `system_ssh_config`, the configured `GlobalKnownHostsFile`. " +
+					"Connections to unknown hosts or hosts presenting a different key fail",
+				Optional: true,
+			},
+		},
 	}
 }
 
@@ -49,11 +333,156 @@ func (p *SSHTunnelProvider) Configure(ctx context.Context, req provider.Configur
 		return
 	}
 
+	leakDetector := LeakDetectorConfig{
+		MaxAge: defaultLeakDetectionMaxAge,
+	}
+	if data.LeakDetection != nil {
+		if !data.LeakDetection.MaxAge.IsNull() {
+			maxAge, err := time.ParseDuration(data.LeakDetection.MaxAge.ValueString())
+			if err != nil {
+				resp.Diagnostics.AddError("Leak Detection Error", fmt.Sprintf("Invalid max age: %s", err))
+				return
+			}
+			leakDetector.MaxAge = maxAge
+		}
+		leakDetector.ForceClose = data.LeakDetection.ForceClose.ValueBool()
+	}
+
+	tracker, created := NewTunnelTracker(), true
+	if !data.SharedTracker.IsNull() {
+		tracker, created = SharedTunnelTracker(data.SharedTracker.ValueString())
+	}
+	if created {
+		// The tracker lives as long as the provider process, not the request.
+		tracker.StartLeakDetector(context.WithoutCancel(ctx), leakDetector)
+	}
+
 	config := &ProviderConfigData{
-		Tracker: NewTunnelTracker(),
+		Version:            p.version,
+		Tracker:            tracker,
+		AuthProviders:      p.authProviders,
+		ProtocolExtensions: p.protocolExtensions,
+		LockTimeout:        defaultLockTimeout,
+		ListenerPool:       p.listenerPool,
+		ReclaimedPorts:     p.reclaimedPorts,
+
+		SystemSSHConfig:  data.SystemSSHConfig.ValueBool(),
+		SystemKnownHosts: data.SystemKnownHosts.ValueBool(),
+	}
+
+	if data.ApplyOnly.ValueBool() {
+		if data.Applying.IsNull() {
+			resp.Diagnostics.AddError("Apply Only Error", "applying must be set to terraform.applying when apply_only is enabled")
+			return
+		}
+		config.SkipOpen = !data.Applying.ValueBool()
+	}
+
+	if data.PlanSummary.ValueBool() {
+		if data.Applying.IsNull() {
+			resp.Diagnostics.AddError("Plan Summary Error", "applying must be set to terraform.applying when plan_summary is enabled")
+			return
+		}
+		config.PlanSummary = !data.Applying.ValueBool()
+	}
+
+	if data.Policy != nil {
+		policy := &accessPolicy{}
+		for _, window := range data.Policy.Windows {
+			days := make([]string, 0, len(window.Days))
+			for _, day := range window.Days {
+				days = append(days, day.ValueString())
+			}
+
+			w, err := parseAccessWindow(days, window.Start.ValueString(), window.End.ValueString(), window.TimeZone.ValueString())
+			if err != nil {
+				resp.Diagnostics.AddError("Policy Error", fmt.Sprintf("Invalid window: %s", err))
+				return
+			}
+			policy.windows = append(policy.windows, w)
+		}
+		for _, label := range data.Policy.RequiredLabels {
+			policy.requiredLabels = append(policy.requiredLabels, label.ValueString())
+		}
+		if allowList := data.Policy.TargetAllowList; allowList != nil {
+			ttl := defaultTargetAllowListCacheTTL
+			if !allowList.CacheTTL.IsNull() {
+				var err error
+				if ttl, err = time.ParseDuration(allowList.CacheTTL.ValueString()); err != nil || ttl < 0 {
+					resp.Diagnostics.AddError("Policy Error", fmt.Sprintf("Invalid cache_ttl %q, expected a non-negative duration", allowList.CacheTTL.ValueString()))
+					return
+				}
+			}
+			l, err := newTargetAllowList(allowList.URL.ValueString(), allowList.PublicKey.ValueString(), ttl)
+			if err != nil {
+				resp.Diagnostics.AddError("Policy Error", fmt.Sprintf("Invalid target_allow_list: %s", err))
+				return
+			}
+			policy.targetAllowList = l
+		}
+		config.Policy = policy
+	}
+
+	maxHandshakes := defaultMaxConcurrentHandshakes
+	if !data.MaxConcurrentHandshakes.IsNull() {
+		if data.MaxConcurrentHandshakes.ValueInt32() <= 0 {
+			resp.Diagnostics.AddError("Max Concurrent Handshakes Error", "Max concurrent handshakes must be positive")
+			return
+		}
+		maxHandshakes = int(data.MaxConcurrentHandshakes.ValueInt32())
+	}
+	config.Handshakes = newHandshakeLimiter(maxHandshakes)
+
+	if !data.MaxForwardedConnections.IsNull() {
+		if data.MaxForwardedConnections.ValueInt64() <= 0 {
+			resp.Diagnostics.AddError("Max Forwarded Connections Error", "Max forwarded connections must be positive")
+			return
+		}
+		config.Budget = portforward.NewBudget(data.MaxForwardedConnections.ValueInt64())
+	}
+
+	faults, err := chaosFromEnv()
+	if err != nil {
+		resp.Diagnostics.AddError("Fault Injection Error", err.Error())
+		return
+	}
+	if faults != nil {
+		resp.Diagnostics.AddWarning("Fault Injection Enabled", fmt.Sprintf("Forwarded traffic is degraded on purpose with a latency of %s and a drop rate of %g, unset %s and %s to disable it",
+			faults.Latency, faults.DropRate, chaosLatencyEnv, chaosDropRateEnv))
+		config.Faults = faults
+	}
+
+	config.ForwardingProfiles = map[string]SSHTunnelProviderModelForwardingProfile{}
+	for name, profile := range data.ForwardingProfiles {
+		if !profile.RetryDelay.IsNull() {
+			if _, err := time.ParseDuration(profile.RetryDelay.ValueString()); err != nil {
+				resp.Diagnostics.AddError("Forwarding Profile Error", fmt.Sprintf("Invalid retry delay of profile %q: %s", name, err))
+				return
+			}
+		}
+		config.ForwardingProfiles[name] = profile
+	}
+
+	if !data.LockTimeout.IsNull() {
+		lockTimeout, err := time.ParseDuration(data.LockTimeout.ValueString())
+		if err != nil {
+			resp.Diagnostics.AddError("Lock Error", fmt.Sprintf("Invalid lock timeout: %s", err))
+			return
+		}
+		config.LockTimeout = lockTimeout
+	}
+
+	if !data.LockDir.IsNull() {
+		lockDir, err := filelock.New(data.LockDir.ValueString())
+		if err != nil {
+			resp.Diagnostics.AddError("Lock Error", fmt.Sprintf("Unable to use lock directory, got error: %s", err))
+			return
+		}
+		config.LockDir = lockDir
 	}
 
 	resp.EphemeralResourceData = config
+	resp.DataSourceData = config
 }
 
 func (p *SSHTunnelProvider) Resources(ctx context.Context) []func() resource.Resource {
@@ -61,19 +490,43 @@ func (p *SSHTunnelProvider) Resources(ctx context.Context) []func() resource.Res
 }
 
 func (p *SSHTunnelProvider) DataSources(ctx context.Context) []func() datasource.DataSource {
-	return []func() datasource.DataSource{}
+	return []func() datasource.DataSource{
+		NewCapabilitiesDataSource,
+		NewHostKeyDataSource,
+	}
 }
 
 func (p *SSHTunnelProvider) EphemeralResources(ctx context.Context) []func() ephemeral.EphemeralResource {
 	return []func() ephemeral.EphemeralResource{
 		NewConnectionEphemeralResource,
+		NewKubeconfigEphemeralResource,
+		NewPostgreSQLEphemeralResource,
+		NewKeypairEphemeralResource,
+	}
+}
+
+func (p *SSHTunnelProvider) Functions(ctx context.Context) []func() function.Function {
+	return []func() function.Function{
+		NewEndpointFunction,
+		NewTunnelsFunction,
 	}
 }
 
 func New(version string) func() provider.Provider {
+	return NewWithListenerPool(version, nil)
+}
+
+// NewWithListenerPool returns a provider whose local port forwardings use
+// the listeners of pool for their local_port instead of binding it, e.g.
+// listeners inherited through socket activation.
+func NewWithListenerPool(version string, pool *ListenerPool) func() provider.Provider {
 	return func() provider.Provider {
 		return &SSHTunnelProvider{
-			version: version,
+			version:            version,
+			authProviders:      defaultAuthProviders(),
+			protocolExtensions: defaultProtocolExtensions(),
+			listenerPool:       pool,
+			reclaimedPorts:     newReclaimedPorts(),
 		}
 	}
 }
@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultPTYSessionTerm    = "xterm"
+	ptySessionRestartDelay   = 5 * time.Second
+	ptySessionTerminalHeight = 24
+	ptySessionTerminalWidth  = 80
+)
+
+// ptySession is an interactive session with a pseudo terminal, for bastions
+// that close connections without one.
+type ptySession struct {
+	session *ssh.Session
+	stdin   io.WriteCloser
+}
+
+// startPTYSession requests a pseudo terminal and starts command, or the
+// login shell if empty. Stdin is kept open, so shells don't exit on EOF.
+func startPTYSession(conn *ssh.Client, command, term string) (*ptySession, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	if err := session.RequestPty(term, ptySessionTerminalHeight, ptySessionTerminalWidth, ssh.TerminalModes{}); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	if command == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(command)
+	}
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	return &ptySession{session: session, stdin: stdin}, nil
+}
+
+func (s *ptySession) close() {
+	s.stdin.Close()
+	s.session.Close()
+}
+
+// runPTYSession keeps s open until ctx is done, restarting it after
+// restartDelay whenever it ends.
+func runPTYSession(ctx context.Context, conn *ssh.Client, s *ptySession, command, term string, restartDelay time.Duration) {
+	for {
+		ended := make(chan error, 1)
+		go func(s *ptySession) {
+			ended <- s.session.Wait()
+		}(s)
+
+		select {
+		case <-ctx.Done():
+			s.close()
+			return
+		case err := <-ended:
+			s.close()
+			tflog.Warn(ctx, "PTY session ended, restarting", map[string]interface{}{"err": err, "delay": restartDelay.String()})
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(restartDelay):
+			}
+
+			var err error
+			if s, err = startPTYSession(conn, command, term); err == nil {
+				break
+			}
+			tflog.Warn(ctx, "Failed to restart PTY session", map[string]interface{}{"err": err})
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRunPTYSession(t *testing.T) {
+	shells := make(chan string, 10)
+	addr := startTestSSHServer(t, &ssh.ServerConfig{NoClientAuth: true}, func(newChannel ssh.NewChannel) {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			return
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		defer channel.Close()
+
+		term := ""
+		for req := range requests {
+			switch req.Type {
+			case "pty-req":
+				var payload struct {
+					Term string
+					Rest []byte `ssh:"rest"`
+				}
+				if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				term = payload.Term
+				_ = req.Reply(true, nil)
+			case "shell":
+				_ = req.Reply(true, nil)
+				shells <- term
+				// End the session, which is restarted.
+				_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				return
+			default:
+				_ = req.Reply(false, nil)
+			}
+		}
+	})
+
+	client, _, err := dial(context.Background(), addr, &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	session, err := startPTYSession(client, "", "vt100")
+	if err != nil {
+		t.Fatalf("Failed to start PTY session: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go runPTYSession(ctx, client, session, "", "vt100", 10*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case term := <-shells:
+			if term != "vt100" {
+				t.Errorf("got term %q, want %q", term, "vt100")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for PTY session")
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// reclaimedPorts remembers the local ports of closed tunnels, so tunnels
+// opened again within the same provider process, e.g. when Terraform retries
+// after a transient failure, listen on the same random ports instead of
+// shifting the endpoints of their consumers.
+type reclaimedPorts struct {
+	mu    sync.Mutex
+	ports map[string][]int32
+}
+
+func newReclaimedPorts() *reclaimedPorts {
+	return &reclaimedPorts{ports: map[string][]int32{}}
+}
+
+// get returns the port last used by local port forwarding i of the tunnel
+// key, 0 if there is none.
+func (p *reclaimedPorts) get(key string, i int) int32 {
+	if p == nil {
+		return 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ports := p.ports[key]
+	if i >= len(ports) {
+		return 0
+	}
+	return ports[i]
+}
+
+// record remembers the ports of the local port forwardings of the tunnel
+// key, 0 for forwardings without a port.
+func (p *reclaimedPorts) record(key string, ports []int32) {
+	if p == nil || key == "" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ports[key] = append(p.ports[key][:0:0], ports...)
+}
+
+// tunnelKey identifies the tunnel of data by its SSH server and the targets
+// of its local port forwardings, which stay the same when it is opened again.
+func tunnelKey(data *ConnectionEphemeralResourceModel) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s@%s", data.User.ValueString(), serverName(data))
+	for _, f := range data.LocalPortForwardings {
+		if !f.RemoteSocketPath.IsNull() {
+			fmt.Fprintf(&b, " %s", f.RemoteSocketPath.ValueString())
+			continue
+		}
+		fmt.Fprintf(&b, " %s", hostAddr(f.RemoteHost, f.RemotePort))
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package provider
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+func TestReclaimedPorts(t *testing.T) {
+	p := newReclaimedPorts()
+	if got := p.get("tunnel", 0); got != 0 {
+		t.Errorf("got %d for an unknown tunnel, want 0", got)
+	}
+
+	ports := []int32{40000, 0}
+	p.record("tunnel", ports)
+	ports[0] = 1
+	if got := p.get("tunnel", 0); got != 40000 {
+		t.Errorf("got %d, want 40000", got)
+	}
+	if got := p.get("tunnel", 2); got != 0 {
+		t.Errorf("got %d for a new forwarding, want 0", got)
+	}
+
+	var unset *reclaimedPorts
+	unset.record("tunnel", ports)
+	if got := unset.get("tunnel", 0); got != 0 {
+		t.Errorf("got %d without reclaimed ports, want 0", got)
+	}
+}
+
+func TestTunnelKey(t *testing.T) {
+	data := &ConnectionEphemeralResourceModel{
+		Host: types.StringValue("bastion"),
+		Port: types.Int32Value(22),
+		User: types.StringValue("ubuntu"),
+		LocalPortForwardings: []ConnectionEphemeralResourceModelLocalPortForwarding{
+			{LocalPort: types.Int32Null(), RemoteHost: types.StringValue("db"), RemotePort: types.Int32Value(5432)},
+		},
+	}
+	key := tunnelKey(data)
+
+	// The allocated local port doesn't change the identity of the tunnel.
+	data.LocalPortForwardings[0].LocalPort = types.Int32Value(40000)
+	if got := tunnelKey(data); got != key {
+		t.Errorf("got %q, want %q", got, key)
+	}
+
+	data.LocalPortForwardings[0].RemotePort = types.Int32Value(5433)
+	if got := tunnelKey(data); got == key {
+		t.Errorf("Expected a different key for a different target, got %q", got)
+	}
+}
+
+func TestNewLocalPortForwardingReclaim(t *testing.T) {
+	ctx := context.Background()
+	r := &ConnectionEphemeralResource{}
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := int32(free.Addr().(*net.TCPAddr).Port)
+	free.Close()
+
+	listener, err := r.newLocalPortForwarding(ctx, nil, &portforward.Config{RemoteAddr: "db:5432"}, types.StringNull(), port)
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+	if got := int32(listener.Addr().(*net.TCPAddr).Port); got != port {
+		t.Errorf("got port %d, want the reclaimed port %d", got, port)
+	}
+
+	// The reclaimed port is in use, so a random port is used instead.
+	fallback, err := r.newLocalPortForwarding(ctx, nil, &portforward.Config{RemoteAddr: "db:5432"}, types.StringNull(), port)
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer fallback.Close()
+	if got := int32(fallback.Addr().(*net.TCPAddr).Port); got == port {
+		t.Errorf("Expected a random port, got the reclaimed port %d", got)
+	}
+}
@@ -0,0 +1,145 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/consul"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/sshconfig"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultRemoteBindAddress is the address remote port forwardings listen on
+// on the SSH server without bind_address, its loopback interface like the
+// default of `ssh -R`.
+const defaultRemoteBindAddress = "127.0.0.1"
+
+func remoteBindAddress(f ConnectionEphemeralResourceModelRemotePortForwarding) string {
+	if f.BindAddress.IsNull() {
+		return defaultRemoteBindAddress
+	}
+	return f.BindAddress.ValueString()
+}
+
+// grantedAddress returns the address clients reach the remote listener of
+// conn at. The tcpip-forward reply only carries the port, the listener
+// address is the requested bind address with it. A wildcard bind address is
+// resolved to the address of the SSH server.
+func grantedAddress(conn *ssh.Client, listener net.Listener) *net.TCPAddr {
+	granted := *listener.Addr().(*net.TCPAddr)
+	if granted.IP == nil || granted.IP.IsUnspecified() {
+		if server, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			granted.IP = server.IP
+		}
+	}
+	return &granted
+}
+
+func (o *tunnelOpener) openRemotePortForwardings() bool {
+	return o.each(len(o.data.RemotePortForwardings), o.openRemotePortForwarding)
+}
+
+func (o *tunnelOpener) openRemotePortForwarding(i int) error {
+	remotePortForwarding := o.data.RemotePortForwardings[i]
+
+	remoteAddr := net.JoinHostPort(remoteBindAddress(remotePortForwarding), strconv.Itoa(int(remotePortForwarding.RemotePort.ValueInt32())))
+	remoteListener, err := o.conn.Listen("tcp", remoteAddr)
+	if err != nil {
+		return forwardFailure("Remote Port Forwarding Error", fmt.Sprintf("Unable to listen on remote address %s, got error: %s", remoteAddr, err))
+	}
+	// The server allocates a port if 0 is requested, the listener address
+	// carries the port of its reply.
+	granted := grantedAddress(o.conn, remoteListener)
+	o.data.RemotePortForwardings[i].RemotePort = basetypes.NewInt32Value(int32(granted.Port))
+	o.data.RemotePortForwardings[i].GrantedAddress = types.StringValue(granted.String())
+
+	conf := o.config(priorityClassInteractive)
+	conf.RemoteAddr = hostAddr(remotePortForwarding.LocalHost, remotePortForwarding.LocalPort)
+
+	listener := portforward.Serve(context.WithoutCancel(o.ctx), remoteListener, &net.Dialer{}, conf)
+	if err := o.addListener(listener, false); err != nil {
+		return err
+	}
+	if remotePortForwarding.ConsulService != nil {
+		if err := o.registerConsulService(remotePortForwarding.ConsulService, i, granted); err != nil {
+			return err
+		}
+	}
+
+	tflog.Info(o.ctx, "Remote port forwarding created", map[string]interface{}{
+		"bind_address":    remoteBindAddress(remotePortForwarding),
+		"remote_port":     granted.Port,
+		"granted_address": granted.String(),
+	})
+	return nil
+}
+
+// registerConsulService registers the address granted to the remote port
+// forwarding i as service with Consul until the tunnel is closed.
+func (o *tunnelOpener) registerConsulService(service *ConnectionEphemeralResourceModelConsulService, i int, granted *net.TCPAddr) error {
+	address := service.Address.ValueString()
+	if service.Address.IsNull() {
+		address = granted.IP.String()
+	}
+	tags := make([]string, 0, len(service.Tags))
+	for _, tag := range service.Tags {
+		tags = append(tags, tag.ValueString())
+	}
+
+	client := consul.NewClient(service.ConsulAddress.ValueString(), service.ConsulToken.ValueString())
+	registration := consul.Service{
+		ID:      fmt.Sprintf("sshtunnel-%s-%d", o.id, i),
+		Name:    service.Name.ValueString(),
+		Address: address,
+		Port:    granted.Port,
+		Tags:    tags,
+	}
+	if err := client.Register(o.ctx, registration); err != nil {
+		return forwardFailure("Consul Registration Error", fmt.Sprintf("Unable to register service %q with the Consul agent at %s, got error: %s", registration.Name, client.Addr, err))
+	}
+	if err := o.info.addRegistration(client, registration.ID); err != nil {
+		return o.tunnelClosed(err)
+	}
+
+	tflog.Info(o.ctx, "Registered remote port forwarding with Consul", map[string]interface{}{
+		"service": registration.Name,
+		"address": net.JoinHostPort(address, strconv.Itoa(granted.Port)),
+	})
+	return nil
+}
+
+func (o *tunnelOpener) openRemoteSocketForwardings() bool {
+	return o.each(len(o.data.RemoteSocketForwardings), o.openRemoteSocketForwarding)
+}
+
+func (o *tunnelOpener) openRemoteSocketForwarding(i int) error {
+	remoteSocketForwarding := o.data.RemoteSocketForwardings[i]
+
+	remoteListener, err := o.conn.ListenUnix(remoteSocketForwarding.RemoteSocketPath.ValueString())
+	if err != nil {
+		return forwardFailure("Remote Socket Forwarding Error", fmt.Sprintf("Unable to listen on remote socket %s, got error: %s", remoteSocketForwarding.RemoteSocketPath.ValueString(), err))
+	}
+
+	conf := o.config(priorityClassInteractive)
+	conf.RemoteAddr = hostAddr(remoteSocketForwarding.LocalHost, remoteSocketForwarding.LocalPort)
+	if !remoteSocketForwarding.LocalSocketPath.IsNull() {
+		conf.RemoteAddr = sshconfig.ExpandPath(remoteSocketForwarding.LocalSocketPath.ValueString())
+		conf.Network = "unix"
+	}
+
+	listener := portforward.Serve(context.WithoutCancel(o.ctx), remoteListener, &net.Dialer{}, conf)
+	if err := o.addListener(listener, false); err != nil {
+		return err
+	}
+
+	tflog.Info(o.ctx, "Remote socket forwarding created", map[string]interface{}{
+		"remote_socket_path": remoteSocketForwarding.RemoteSocketPath.ValueString(),
+	})
+	return nil
+}
@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestOpenRemotePortForwardingGrantedAddress(t *testing.T) {
+	ctx := context.Background()
+
+	// The server allocates this port for forwardings requesting port 0.
+	const allocatedPort = 43817
+	addr := startTestSSHServerWithRequests(t, &ssh.ServerConfig{NoClientAuth: true}, func(req *ssh.Request) {
+		var forward struct {
+			Addr string
+			Port uint32
+		}
+		switch {
+		case req.Type == "cancel-tcpip-forward":
+			_ = req.Reply(true, nil)
+		case req.Type != "tcpip-forward" || ssh.Unmarshal(req.Payload, &forward) != nil:
+			_ = req.Reply(false, nil)
+		case forward.Port == 0:
+			_ = req.Reply(true, ssh.Marshal(struct{ Port uint32 }{allocatedPort}))
+		default:
+			_ = req.Reply(true, nil)
+		}
+	}, nil)
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		name        string
+		bindAddress types.String
+		remotePort  types.Int32
+		wantPort    int32
+		wantAddress string
+	}{
+		{"default", types.StringNull(), types.Int32Null(), allocatedPort, "127.0.0.1:43817"},
+		{"allocated", types.StringValue("127.0.0.1"), types.Int32Value(0), allocatedPort, "127.0.0.1:43817"},
+		{"fixed", types.StringValue("10.0.0.5"), types.Int32Value(8080), 8080, "10.0.0.5:8080"},
+		// Wildcard binds are reached at the address of the SSH server.
+		{"wildcard", types.StringValue("0.0.0.0"), types.Int32Value(0), allocatedPort, "127.0.0.1:43817"},
+		{"empty", types.StringValue(""), types.Int32Value(0), allocatedPort, "127.0.0.1:43817"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := &ConnectionEphemeralResourceModel{RemotePortForwardings: []ConnectionEphemeralResourceModelRemotePortForwarding{{
+				BindAddress: tt.bindAddress,
+				RemotePort:  tt.remotePort,
+				LocalHost:   types.StringValue("127.0.0.1"),
+				LocalPort:   types.Int32Value(8080),
+			}}}
+			info := &TunnelInfo{}
+			defer info.close()
+			o := &tunnelOpener{
+				r:         &ConnectionEphemeralResource{tunnelTracker: NewTunnelTracker()},
+				ctx:       ctx,
+				tunnelCtx: ctx,
+				resp:      &ephemeral.OpenResponse{},
+				data:      data,
+				info:      info,
+				conn:      client,
+			}
+
+			if err := o.openRemotePortForwarding(0); err != nil {
+				t.Fatalf("Failed to open remote port forwarding: %v", err)
+			}
+			if got := data.RemotePortForwardings[0].RemotePort.ValueInt32(); got != tt.wantPort {
+				t.Errorf("got remote_port %d, want %d", got, tt.wantPort)
+			}
+			if got := data.RemotePortForwardings[0].GrantedAddress.ValueString(); got != tt.wantAddress {
+				t.Errorf("got granted_address %q, want %q", got, tt.wantAddress)
+			}
+		})
+	}
+}
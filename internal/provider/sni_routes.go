@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// sniRoutes converts the sni_routes of a local port forwarding to the
+// SNIRoutes of portforward, converting internationalized server names and
+// remote hosts to punycode as sent by TLS clients and to SSH servers. Unknown
+// values are skipped, so the routes can be validated during plan.
+func sniRoutes(routes map[string]types.String) (map[string]string, error) {
+	converted := make(map[string]string, len(routes))
+	for name, target := range routes {
+		serverName, err := sniServerName(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server name %q: %w", name, err)
+		}
+
+		if target.IsUnknown() {
+			continue
+		}
+		host, port, err := net.SplitHostPort(target.ValueString())
+		if err == nil {
+			_, err = strconv.ParseUint(port, 10, 16)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %q of server name %q, expected host:port", target.ValueString(), name)
+		}
+		host, err = hostToASCII(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %q of server name %q: %w", target.ValueString(), name, err)
+		}
+		converted[serverName] = net.JoinHostPort(host, port)
+	}
+
+	return converted, nil
+}
+
+// sniServerName converts a server name of sni_routes, optionally prefixed
+// with a "*." wildcard label, to punycode.
+func sniServerName(name string) (string, error) {
+	wildcard := strings.HasPrefix(name, "*.")
+	host, err := hostToASCII(strings.TrimPrefix(name, "*."))
+	if err != nil {
+		return "", err
+	}
+	if host == "" || strings.ContainsAny(host, ":*") || net.ParseIP(host) != nil {
+		return "", fmt.Errorf("expected a DNS name")
+	}
+	if wildcard {
+		return "*." + host, nil
+	}
+	return host, nil
+}
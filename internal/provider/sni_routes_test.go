@@ -0,0 +1,50 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestSNIRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		routes  map[string]types.String
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			routes: map[string]types.String{
+				"api.internal":      types.StringValue("10.0.0.5:443"),
+				"*.web.internal":    types.StringValue("web.internal:8443"),
+				"bücher.example":    types.StringValue("[fe80::1%eth0]:443"),
+				"unknown.internal":  types.StringUnknown(),
+				"gateway.internal.": types.StringValue("gateway.internal:443"),
+			},
+			want: map[string]string{
+				"api.internal":          "10.0.0.5:443",
+				"*.web.internal":        "web.internal:8443",
+				"xn--bcher-kva.example": "[fe80::1%eth0]:443",
+				"gateway.internal.":     "gateway.internal:443",
+			},
+		},
+		{name: "missing port", routes: map[string]types.String{"api.internal": types.StringValue("10.0.0.5")}, wantErr: true},
+		{name: "invalid port", routes: map[string]types.String{"api.internal": types.StringValue("10.0.0.5:https")}, wantErr: true},
+		{name: "ip server name", routes: map[string]types.String{"10.0.0.5": types.StringValue("10.0.0.5:443")}, wantErr: true},
+		{name: "inner wildcard", routes: map[string]types.String{"api.*.internal": types.StringValue("10.0.0.5:443")}, wantErr: true},
+		{name: "empty server name", routes: map[string]types.String{"": types.StringValue("10.0.0.5:443")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sniRoutes(tt.routes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %t, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// lookupSRV looks up the SRV records of a name, replaced in tests.
+var lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+	_, addrs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	return addrs, err
+}
+
+// sshServer is a host and port an SSH server is reachable at.
+type sshServer struct {
+	host string
+	port int32
+}
+
+// sshServers returns the SSH servers of data to try in order: host and port
+// or the targets of the SRV records of srv, ordered by priority and randomly
+// by weight within a priority as described in RFC 2782.
+func sshServers(ctx context.Context, data *ConnectionEphemeralResourceModel) ([]sshServer, error) {
+	if data.SRV.IsNull() {
+		return []sshServer{{host: data.Host.ValueString(), port: data.Port.ValueInt32()}}, nil
+	}
+
+	records, err := lookupSRV(ctx, data.SRV.ValueString())
+	if err != nil {
+		return nil, err
+	}
+	// A single record with the target "." announces that the service is
+	// not available.
+	if len(records) == 1 && records[0].Target == "." {
+		return nil, errors.New("the SRV record announces that no SSH server is available")
+	}
+
+	servers := make([]sshServer, 0, len(records))
+	for _, record := range records {
+		servers = append(servers, sshServer{host: strings.TrimSuffix(record.Target, "."), port: int32(record.Port)})
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("no SRV records found")
+	}
+	return servers, nil
+}
+
+// serverName describes the SSH server of data in messages.
+func serverName(data *ConnectionEphemeralResourceModel) string {
+	if !data.SRV.IsNull() {
+		return fmt.Sprintf("SRV %s", data.SRV.ValueString())
+	}
+	return hostAddr(data.Host, data.Port)
+}
@@ -0,0 +1,100 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+)
+
+func stubLookupSRV(t *testing.T, records []*net.SRV, err error) {
+	t.Helper()
+
+	previous := lookupSRV
+	lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+		return records, err
+	}
+	t.Cleanup(func() { lookupSRV = previous })
+}
+
+func TestSSHServers(t *testing.T) {
+	ctx := context.Background()
+
+	data := &ConnectionEphemeralResourceModel{Host: types.StringValue("bastion"), Port: types.Int32Value(22), SRV: types.StringNull()}
+	servers, err := sshServers(ctx, data)
+	if err != nil || len(servers) != 1 || servers[0] != (sshServer{host: "bastion", port: 22}) {
+		t.Errorf("Expected host and port, got %v, %v", servers, err)
+	}
+
+	data = &ConnectionEphemeralResourceModel{Host: types.StringNull(), Port: types.Int32Null(), SRV: types.StringValue("_ssh._tcp.bastions.example.com")}
+	stubLookupSRV(t, []*net.SRV{{Target: "a.example.com.", Port: 2222}, {Target: "b.example.com.", Port: 22}}, nil)
+	servers, err = sshServers(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sshServer{{host: "a.example.com", port: 2222}, {host: "b.example.com", port: 22}}
+	if len(servers) != len(want) || servers[0] != want[0] || servers[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, servers)
+	}
+
+	stubLookupSRV(t, []*net.SRV{{Target: ".", Port: 0}}, nil)
+	if _, err := sshServers(ctx, data); err == nil {
+		t.Error("Expected an error for an unavailable service")
+	}
+
+	stubLookupSRV(t, nil, errors.New("no such host"))
+	if _, err := sshServers(ctx, data); err == nil {
+		t.Error("Expected the lookup error")
+	}
+}
+
+func TestConnectSRVFailover(t *testing.T) {
+	addr := startTestSSHServer(t, &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}, nil)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverPort, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A port nothing listens on anymore.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	stubLookupSRV(t, []*net.SRV{
+		{Target: "127.0.0.1.", Port: uint16(closedPort)},
+		{Target: host + ".", Port: uint16(serverPort)},
+	}, nil)
+
+	r := &ConnectionEphemeralResource{}
+	data := &ConnectionEphemeralResourceModel{
+		SRV:     types.StringValue("_ssh._tcp.bastions.example.com"),
+		User:    types.StringValue("test"),
+		Auth:    ConnectionEphemeralResourceModelAuth{Password: types.StringValue("secret")},
+		HostKey: &ConnectionEphemeralResourceModelHostKey{Mode: types.StringValue("insecure")},
+	}
+	conn, _, diags := r.connect(context.Background(), data)
+	if diags.HasError() {
+		t.Fatalf("Expected to fail over to the second server, got %v", diags)
+	}
+	defer conn.Close()
+
+	if !strings.HasSuffix(conn.RemoteAddr().String(), ":"+port) {
+		t.Errorf("Expected a connection to port %s, got %s", port, conn.RemoteAddr())
+	}
+}
@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// sshConfigSnippet renders an OpenSSH client config Host block opening the
+// same tunnel as data, for engineers replicating what Terraform did with
+// ssh -N. serverAddr is the address of the SSH server connected to and
+// localPorts the ports the local port forwardings listen on, 0 for
+// forwardings that failed.
+func (r *ConnectionEphemeralResource) sshConfigSnippet(data *ConnectionEphemeralResourceModel, serverAddr string, localPorts []int32) string {
+	var b strings.Builder
+
+	hostName, port := data.Host.ValueString(), strconv.Itoa(int(data.Port.ValueInt32()))
+	if !data.SRV.IsNull() {
+		// Replicate the server picked from the SRV records.
+		if host, p, err := net.SplitHostPort(serverAddr); err == nil {
+			hostName, port = host, p
+		}
+	}
+	alias := "sshtunnel-" + hostName
+
+	fmt.Fprintf(&b, "# Open with: ssh -N %s\n", alias)
+	fmt.Fprintf(&b, "Host %s\n", alias)
+	fmt.Fprintf(&b, "  HostName %s\n", hostName)
+	fmt.Fprintf(&b, "  Port %s\n", port)
+	fmt.Fprintf(&b, "  User %s\n", data.User.ValueString())
+
+	auth := data.Auth
+	switch {
+	case !auth.PrivateKeyPath.IsNull():
+		fmt.Fprintf(&b, "  IdentityFile %s\n", auth.PrivateKeyPath.ValueString())
+		b.WriteString("  IdentitiesOnly yes\n")
+	case !auth.PrivateKey.IsNull() || auth.PrivateKeys != nil || !auth.PrivateKeyRef.IsNull() || auth.Vault != nil || auth.AWS != nil || auth.GCPSecret != nil || auth.AzureKeyVault != nil || !auth.EncryptedPrivateKey.IsNull():
+		b.WriteString("  # IdentityFile: the private key is not read from a file, save it to one\n")
+	}
+	if auth.Agent.ValueBool() && !auth.AgentSocket.IsNull() {
+		fmt.Fprintf(&b, "  IdentityAgent %s\n", auth.AgentSocket.ValueString())
+	}
+	if auth.Agent.ValueBool() && !auth.AgentIdentity.IsNull() {
+		fmt.Fprintf(&b, "  # agent_identity %s: set IdentityFile to its public key and IdentitiesOnly yes\n", auth.AgentIdentity.ValueString())
+	}
+	if !auth.Certificate.IsNull() {
+		b.WriteString("  # CertificateFile: the certificate is not read from a file, save it to one\n")
+	}
+	if auth.VaultSSH != nil {
+		mount := defaultVaultSSHMount
+		if !auth.VaultSSH.Mount.IsNull() {
+			mount = auth.VaultSSH.Mount.ValueString()
+		}
+		fmt.Fprintf(&b, "  # Sign a key with: vault write -field=signed_key %s/sign/%s public_key=@id_ed25519.pub > id_ed25519-cert.pub\n",
+			mount, auth.VaultSSH.Role.ValueString())
+	}
+	if auth.GCPOSLogin != nil {
+		b.WriteString("  # Add a key with: gcloud compute os-login ssh-keys add --key-file=id_ed25519.pub --ttl=1h\n")
+	}
+	if auth.PKCS11 != nil {
+		fmt.Fprintf(&b, "  PKCS11Provider %s\n", auth.PKCS11.Module.ValueString())
+	}
+
+	if algorithms := hostKeyAlgorithms(data); algorithms != nil {
+		fmt.Fprintf(&b, "  HostKeyAlgorithms %s\n", strings.Join(algorithms, ","))
+	}
+	switch newHostKeyConfig(data).effectiveMode() {
+	case hostKeyModeFingerprint:
+		// OpenSSH can't pin fingerprints, ask to confirm the presented one.
+		fmt.Fprintf(&b, "  # Only accept the host key %s\n", data.HostKeyFingerprint.ValueString())
+		b.WriteString("  StrictHostKeyChecking ask\n")
+		b.WriteString("  UserKnownHostsFile /dev/null\n")
+	case hostKeyModeInsecure:
+		b.WriteString("  StrictHostKeyChecking no\n")
+		b.WriteString("  UserKnownHostsFile /dev/null\n")
+	case hostKeyModeAcceptNew:
+		b.WriteString("  StrictHostKeyChecking accept-new\n")
+	default:
+		b.WriteString("  StrictHostKeyChecking yes\n")
+	}
+	if !data.KnownHostsFile.IsNull() {
+		fmt.Fprintf(&b, "  UserKnownHostsFile %s\n", data.KnownHostsFile.ValueString())
+	}
+	if !data.RevokedHostKeysFile.IsNull() {
+		fmt.Fprintf(&b, "  RevokedHostKeys %s\n", data.RevokedHostKeysFile.ValueString())
+	}
+
+	for _, authority := range data.HostCertificateAuthorities {
+		fmt.Fprintf(&b, "  # Trust host certificates with the known_hosts entry: @cert-authority %s %s\n", hostName, strings.TrimSpace(authority.ValueString()))
+	}
+
+	if data.ExitOnForwardFailure.IsNull() || data.ExitOnForwardFailure.ValueBool() {
+		b.WriteString("  ExitOnForwardFailure yes\n")
+	} else {
+		b.WriteString("  ExitOnForwardFailure no\n")
+	}
+
+	for i, f := range data.LocalPortForwardings {
+		local, description := f.LocalSocketPath.ValueString(), "local socket "+f.LocalSocketPath.ValueString()
+		if f.LocalSocketPath.IsNull() {
+			if i >= len(localPorts) || localPorts[i] == 0 {
+				continue
+			}
+			local = strconv.Itoa(int(localPorts[i]))
+			description = "local port " + local
+		}
+		remoteHost, err := hostToASCII(f.RemoteHost.ValueString())
+		if err != nil {
+			remoteHost = f.RemoteHost.ValueString()
+		}
+		target := net.JoinHostPort(remoteHost, strconv.Itoa(int(f.RemotePort.ValueInt32())))
+		if !f.RemoteSocketPath.IsNull() {
+			target = f.RemoteSocketPath.ValueString()
+		}
+		fmt.Fprintf(&b, "  LocalForward %s %s\n", local, target)
+		if len(f.SNIRoutes) > 0 {
+			fmt.Fprintf(&b, "  # sni_routes of %s: OpenSSH forwards all connections to the remote host\n", description)
+		}
+	}
+	for _, f := range data.DynamicPortForwardings {
+		if f.LocalPort.ValueInt32() == 0 {
+			continue
+		}
+		if dynamicProtocol(f) == dynamicProtocolHTTP {
+			fmt.Fprintf(&b, "  # HTTP CONNECT proxy of local port %d: OpenSSH only serves SOCKS clients\n", f.LocalPort.ValueInt32())
+		}
+		fmt.Fprintf(&b, "  DynamicForward %s\n", net.JoinHostPort(dynamicListenHost, strconv.Itoa(int(f.LocalPort.ValueInt32()))))
+	}
+	for _, f := range data.UDPForwardings {
+		if f.LocalPort.ValueInt32() == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "  # udp_forwardings of local UDP port %d: OpenSSH doesn't forward UDP\n", f.LocalPort.ValueInt32())
+	}
+	for _, f := range data.RemotePortForwardings {
+		if f.RemotePort.ValueInt32() == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "  RemoteForward %s %s\n", net.JoinHostPort(remoteBindAddress(f), strconv.Itoa(int(f.RemotePort.ValueInt32()))), net.JoinHostPort(f.LocalHost.ValueString(), strconv.Itoa(int(f.LocalPort.ValueInt32()))))
+	}
+	for _, f := range data.RemoteSocketForwardings {
+		target := net.JoinHostPort(f.LocalHost.ValueString(), strconv.Itoa(int(f.LocalPort.ValueInt32())))
+		if !f.LocalSocketPath.IsNull() {
+			target = f.LocalSocketPath.ValueString()
+		}
+		fmt.Fprintf(&b, "  RemoteForward %s %s\n", f.RemoteSocketPath.ValueString(), target)
+	}
+
+	return b.String()
+}
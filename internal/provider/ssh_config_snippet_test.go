@@ -0,0 +1,174 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestSSHConfigSnippet(t *testing.T) {
+	data := &ConnectionEphemeralResourceModel{
+		Host: types.StringValue("bastion.example.com"),
+		Port: types.Int32Value(2222),
+		SRV:  types.StringNull(),
+		User: types.StringValue("ubuntu"),
+		Auth: ConnectionEphemeralResourceModelAuth{
+			PrivateKeyPath: types.StringValue("~/.ssh/deploy"),
+			PrivateKey:     types.StringNull(),
+			PrivateKeyRef:  types.StringNull(),
+			Certificate:    types.StringNull(),
+		},
+		LocalPortForwardings: []ConnectionEphemeralResourceModelLocalPortForwarding{
+			{RemoteHost: types.StringValue("db.internal"), RemotePort: types.Int32Value(5432)},
+			{RemoteHost: types.StringValue("fd00::1"), RemotePort: types.Int32Value(6379)},
+			{RemoteHost: types.StringValue("failed.internal"), RemotePort: types.Int32Value(80)},
+			{LocalSocketPath: types.StringValue("/run/user/1000/docker.sock"), RemoteHost: types.StringValue("localhost"), RemotePort: types.Int32Value(2375)},
+			{LocalSocketPath: types.StringValue("/run/user/1000/pg.sock"), RemoteSocketPath: types.StringValue("/var/run/postgresql/.s.PGSQL.5432")},
+		},
+		DynamicPortForwardings: []ConnectionEphemeralResourceModelDynamicPortForwarding{
+			{LocalPort: types.Int32Value(1080), Protocol: types.StringNull()},
+			{LocalPort: types.Int32Value(3128), Protocol: types.StringValue("http")},
+		},
+		UDPForwardings: []ConnectionEphemeralResourceModelUDPForwarding{
+			{LocalPort: types.Int32Value(5353), RemoteHost: types.StringValue("10.0.0.2"), RemotePort: types.Int32Value(53)},
+			{LocalPort: types.Int32Value(0), RemoteHost: types.StringValue("failed.internal"), RemotePort: types.Int32Value(514)},
+		},
+		RemotePortForwardings: []ConnectionEphemeralResourceModelRemotePortForwarding{
+			{BindAddress: types.StringNull(), RemotePort: types.Int32Value(9000), LocalHost: types.StringValue("localhost"), LocalPort: types.Int32Value(3000)},
+			{BindAddress: types.StringValue("::"), RemotePort: types.Int32Value(9001), LocalHost: types.StringValue("localhost"), LocalPort: types.Int32Value(3001)},
+			{BindAddress: types.StringValue("0.0.0.0"), RemotePort: types.Int32Value(0), LocalHost: types.StringValue("localhost"), LocalPort: types.Int32Value(3002)},
+		},
+		RemoteSocketForwardings: []ConnectionEphemeralResourceModelRemoteSocketForwarding{
+			{RemoteSocketPath: types.StringValue("/run/app.sock"), LocalHost: types.StringValue("127.0.0.1"), LocalPort: types.Int32Value(8080)},
+			{RemoteSocketPath: types.StringValue("/tmp/agent.sock"), LocalSocketPath: types.StringValue("/run/user/1000/agent.sock")},
+		},
+		ExitOnForwardFailure: types.BoolValue(false),
+	}
+
+	r := &ConnectionEphemeralResource{systemKnownHosts: true}
+	got := r.sshConfigSnippet(data, "192.0.2.1:2222", []int32{15432, 16379, 0})
+	want := `# Open with: ssh -N sshtunnel-bastion.example.com
+Host sshtunnel-bastion.example.com
+  HostName bastion.example.com
+  Port 2222
+  User ubuntu
+  IdentityFile ~/.ssh/deploy
+  IdentitiesOnly yes
+  StrictHostKeyChecking yes
+  ExitOnForwardFailure no
+  LocalForward 15432 db.internal:5432
+  LocalForward 16379 [fd00::1]:6379
+  LocalForward /run/user/1000/docker.sock localhost:2375
+  LocalForward /run/user/1000/pg.sock /var/run/postgresql/.s.PGSQL.5432
+  DynamicForward 127.0.0.1:1080
+  # HTTP CONNECT proxy of local port 3128: OpenSSH only serves SOCKS clients
+  DynamicForward 127.0.0.1:3128
+  # udp_forwardings of local UDP port 5353: OpenSSH doesn't forward UDP
+  RemoteForward 127.0.0.1:9000 localhost:3000
+  RemoteForward [::]:9001 localhost:3001
+  RemoteForward /run/app.sock 127.0.0.1:8080
+  RemoteForward /tmp/agent.sock /run/user/1000/agent.sock
+`
+	if got != want {
+		t.Errorf("Unexpected snippet:\n%s\nwant:\n%s", got, want)
+	}
+
+	data.SRV = types.StringValue("_ssh._tcp.bastions.example.com")
+	data.Host = types.StringNull()
+	data.Port = types.Int32Null()
+	data.HostKey = &ConnectionEphemeralResourceModelHostKey{Mode: types.StringValue("insecure")}
+	got = r.sshConfigSnippet(data, "192.0.2.1:2200", nil)
+	want = `# Open with: ssh -N sshtunnel-192.0.2.1
+Host sshtunnel-192.0.2.1
+  HostName 192.0.2.1
+  Port 2200
+  User ubuntu
+  IdentityFile ~/.ssh/deploy
+  IdentitiesOnly yes
+  StrictHostKeyChecking no
+  UserKnownHostsFile /dev/null
+  ExitOnForwardFailure no
+  LocalForward /run/user/1000/docker.sock localhost:2375
+  LocalForward /run/user/1000/pg.sock /var/run/postgresql/.s.PGSQL.5432
+  DynamicForward 127.0.0.1:1080
+  # HTTP CONNECT proxy of local port 3128: OpenSSH only serves SOCKS clients
+  DynamicForward 127.0.0.1:3128
+  # udp_forwardings of local UDP port 5353: OpenSSH doesn't forward UDP
+  RemoteForward 127.0.0.1:9000 localhost:3000
+  RemoteForward [::]:9001 localhost:3001
+  RemoteForward /run/app.sock 127.0.0.1:8080
+  RemoteForward /tmp/agent.sock /run/user/1000/agent.sock
+`
+	if got != want {
+		t.Errorf("Unexpected SRV snippet:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSSHConfigSnippetAgent(t *testing.T) {
+	data := &ConnectionEphemeralResourceModel{
+		Host: types.StringValue("bastion.example.com"),
+		Port: types.Int32Value(22),
+		SRV:  types.StringNull(),
+		User: types.StringValue("ubuntu"),
+		Auth: ConnectionEphemeralResourceModelAuth{
+			PrivateKeyPath: types.StringNull(),
+			PrivateKey:     types.StringNull(),
+			PrivateKeyRef:  types.StringNull(),
+			Certificate:    types.StringNull(),
+			Agent:          types.BoolValue(true),
+			AgentSocket:    types.StringValue("/home/ubuntu/.1password/agent.sock"),
+			AgentIdentity:  types.StringValue("deploy"),
+		},
+	}
+
+	r := &ConnectionEphemeralResource{systemKnownHosts: true}
+	got := r.sshConfigSnippet(data, "192.0.2.1:22", nil)
+	want := `# Open with: ssh -N sshtunnel-bastion.example.com
+Host sshtunnel-bastion.example.com
+  HostName bastion.example.com
+  Port 22
+  User ubuntu
+  IdentityAgent /home/ubuntu/.1password/agent.sock
+  # agent_identity deploy: set IdentityFile to its public key and IdentitiesOnly yes
+  StrictHostKeyChecking yes
+  ExitOnForwardFailure yes
+`
+	if got != want {
+		t.Errorf("Unexpected snippet:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSSHConfigSnippetKnownHostsFile(t *testing.T) {
+	data := &ConnectionEphemeralResourceModel{
+		Host: types.StringValue("bastion.example.com"),
+		Port: types.Int32Value(22),
+		SRV:  types.StringNull(),
+		User: types.StringValue("ubuntu"),
+		Auth: ConnectionEphemeralResourceModelAuth{
+			PrivateKeyPath: types.StringValue("~/.ssh/deploy"),
+			PrivateKey:     types.StringNull(),
+			PrivateKeyRef:  types.StringNull(),
+			Certificate:    types.StringNull(),
+		},
+		KnownHostsFile:    types.StringValue("~/.ssh/known_hosts_bastion"),
+		HostKeyAlgorithms: []types.String{types.StringValue("ssh-ed25519"), types.StringValue("ssh-rsa")},
+	}
+
+	r := &ConnectionEphemeralResource{}
+	got := r.sshConfigSnippet(data, "192.0.2.1:22", nil)
+	want := `# Open with: ssh -N sshtunnel-bastion.example.com
+Host sshtunnel-bastion.example.com
+  HostName bastion.example.com
+  Port 22
+  User ubuntu
+  IdentityFile ~/.ssh/deploy
+  IdentitiesOnly yes
+  HostKeyAlgorithms ssh-ed25519,ssh-rsa
+  StrictHostKeyChecking yes
+  UserKnownHostsFile ~/.ssh/known_hosts_bastion
+  ExitOnForwardFailure yes
+`
+	if got != want {
+		t.Errorf("Unexpected snippet:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,314 @@
+package provider
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultTargetAllowListCacheTTL = 5 * time.Minute
+	targetAllowListTimeout         = 30 * time.Second
+	// maxTargetAllowListSize bounds the documents read from the policy
+	// server.
+	maxTargetAllowListSize = 1 << 20
+)
+
+// targetAllowList fetches the remote targets tunnels may forward to from an
+// HTTPS policy server, so security teams can update them without changing
+// Terraform code. The document has to be signed by publicKey, the base64
+// encoded Ed25519 signature of the response body is read from url + ".sig".
+// Verified documents are cached for ttl by the provider process, fetching
+// fails closed.
+type targetAllowList struct {
+	url       string
+	publicKey ed25519.PublicKey
+	ttl       time.Duration
+	client    *http.Client
+	now       func() time.Time
+
+	mu        sync.Mutex
+	cached    *allowedTargets
+	fetchedAt time.Time
+}
+
+// allowedTargetsDocument is the document served by the policy server.
+type allowedTargetsDocument struct {
+	// AllowedTargets are patterns of host:port, see parseTargetPattern.
+	AllowedTargets []string `json:"allowed_targets"`
+	// ExpiresAt rejects the document afterwards, so an old signed document
+	// can't be replayed forever.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type allowedTargets struct {
+	patterns  []targetPattern
+	expiresAt time.Time
+}
+
+// targetPattern matches host:port targets. The host is a name, where
+// "*.example.com" matches any direct subdomain, an IP address or a CIDR
+// prefix. The port is a number or "*".
+type targetPattern struct {
+	host   string
+	prefix netip.Prefix
+	port   int
+}
+
+func newTargetAllowList(rawURL, publicKey string, ttl time.Duration) (*targetAllowList, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q, expected an https:// URL", rawURL)
+	}
+
+	key, err := parseEd25519PublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &targetAllowList{
+		url:       rawURL,
+		publicKey: key,
+		ttl:       ttl,
+		client:    &http.Client{Timeout: targetAllowListTimeout},
+		now:       time.Now,
+	}, nil
+}
+
+// parseEd25519PublicKey parses an OpenSSH Ed25519 public key, e.g.
+// "ssh-ed25519 AAAA... security@example.com".
+func parseEd25519PublicKey(s string) (ed25519.PublicKey, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid public key type %s, expected ssh-ed25519", key.Type())
+	}
+	edKey, ok := cryptoKey.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid public key type %s, expected ssh-ed25519", key.Type())
+	}
+	return edKey, nil
+}
+
+// check returns an error unless all targets are allowed by the current
+// document.
+func (l *targetAllowList) check(ctx context.Context, targets []string) error {
+	allowed, err := l.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	denied := []string{}
+	for _, target := range targets {
+		if !allowed.allows(target) {
+			denied = append(denied, target)
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("targets not allowed by %s: %s", l.url, strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// get returns the cached document, fetching it if it is older than the ttl
+// or expired.
+func (l *targetAllowList) get(ctx context.Context) (*allowedTargets, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.cached != nil && now.Sub(l.fetchedAt) < l.ttl && !l.cached.expired(now) {
+		return l.cached, nil
+	}
+
+	allowed, err := l.fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch the allowed targets from %s: %w", l.url, err)
+	}
+	if allowed.expired(now) {
+		return nil, fmt.Errorf("the allowed targets from %s expired at %s", l.url, allowed.expiresAt.Format(time.RFC3339))
+	}
+
+	l.cached = allowed
+	l.fetchedAt = now
+	return allowed, nil
+}
+
+func (l *targetAllowList) fetch(ctx context.Context) (*allowedTargets, error) {
+	body, err := l.download(ctx, l.url)
+	if err != nil {
+		return nil, err
+	}
+	encodedSignature, err := l.download(ctx, l.url+".sig")
+	if err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encodedSignature)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	if !ed25519.Verify(l.publicKey, body, signature) {
+		return nil, fmt.Errorf("invalid signature, the document isn't signed by public_key")
+	}
+
+	return parseAllowedTargets(body)
+}
+
+func (l *targetAllowList) download(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTargetAllowListSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxTargetAllowListSize {
+		return nil, fmt.Errorf("GET %s: response exceeds %d bytes", u, maxTargetAllowListSize)
+	}
+	return body, nil
+}
+
+func parseAllowedTargets(body []byte) (*allowedTargets, error) {
+	var doc allowedTargetsDocument
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("invalid document: %w", err)
+	}
+
+	allowed := &allowedTargets{}
+	if doc.ExpiresAt != nil {
+		allowed.expiresAt = *doc.ExpiresAt
+	}
+	for _, raw := range doc.AllowedTargets {
+		pattern, err := parseTargetPattern(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid document: %w", err)
+		}
+		allowed.patterns = append(allowed.patterns, pattern)
+	}
+	return allowed, nil
+}
+
+func parseTargetPattern(raw string) (targetPattern, error) {
+	host, port, err := net.SplitHostPort(raw)
+	if err != nil {
+		return targetPattern{}, fmt.Errorf("invalid target %q, expected host:port: %w", raw, err)
+	}
+
+	pattern := targetPattern{port: -1}
+	if port != "*" {
+		if pattern.port, err = strconv.Atoi(port); err != nil || pattern.port < 1 || pattern.port > 65535 {
+			return targetPattern{}, fmt.Errorf("invalid port of target %q", raw)
+		}
+	}
+
+	if prefix, err := netip.ParsePrefix(host); err == nil {
+		pattern.prefix = prefix.Masked()
+	} else if addr, err := netip.ParseAddr(host); err == nil {
+		addr = addr.Unmap().WithZone("")
+		pattern.prefix = netip.PrefixFrom(addr, addr.BitLen())
+	} else if host == "" {
+		return targetPattern{}, fmt.Errorf("invalid target %q, the host must not be empty", raw)
+	} else {
+		pattern.host = strings.ToLower(host)
+	}
+	return pattern, nil
+}
+
+func (p targetPattern) matches(host string, port int) bool {
+	if p.port != -1 && p.port != port {
+		return false
+	}
+
+	if p.prefix.IsValid() {
+		addr, err := netip.ParseAddr(host)
+		return err == nil && p.prefix.Contains(addr.Unmap().WithZone(""))
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if suffix, ok := strings.CutPrefix(p.host, "*."); ok {
+		name, ok := strings.CutSuffix(host, "."+suffix)
+		return ok && name != "" && !strings.Contains(name, ".")
+	}
+	return p.host == host
+}
+
+func (a *allowedTargets) expired(now time.Time) bool {
+	return !a.expiresAt.IsZero() && !now.Before(a.expiresAt)
+}
+
+// allows reports whether the host:port target matches any pattern.
+func (a *allowedTargets) allows(target string) bool {
+	host, rawPort, err := net.SplitHostPort(target)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.Atoi(rawPort)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range a.patterns {
+		if pattern.matches(host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardingTargets returns the remote targets of the local port forwardings
+// including their sni_routes, as they are sent to the SSH server.
+func forwardingTargets(forwardings []ConnectionEphemeralResourceModelLocalPortForwarding) ([]string, error) {
+	targets := []string{}
+	for _, f := range forwardings {
+		if !f.RemoteHost.IsNull() {
+			host, err := hostToASCII(f.RemoteHost.ValueString())
+			if err != nil {
+				return nil, fmt.Errorf("invalid remote_host %q: %w", f.RemoteHost.ValueString(), err)
+			}
+			targets = append(targets, hostAddr(types.StringValue(host), f.RemotePort))
+		}
+
+		routes, err := sniRoutes(f.SNIRoutes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sni_routes: %w", err)
+		}
+		for _, target := range routes {
+			targets = append(targets, target)
+		}
+	}
+	sort.Strings(targets)
+	return slices.Compact(targets), nil
+}
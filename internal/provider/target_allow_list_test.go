@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestTargetAllowList(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var document atomic.Value
+	document.Store(`{"allowed_targets": ["db.internal:5432", "*.k8s.internal:443", "10.0.0.0/8:*"]}`)
+	var signWith atomic.Value
+	signWith.Store(privateKey)
+	var fetches atomic.Int32
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc := document.Load().(string)
+		switch r.URL.Path {
+		case "/targets.json":
+			fetches.Add(1)
+			_, _ = w.Write([]byte(doc))
+		case "/targets.json.sig":
+			signature := ed25519.Sign(signWith.Load().(ed25519.PrivateKey), []byte(doc))
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(signature) + "\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	l, err := newTargetAllowList(server.URL+"/targets.json", string(ssh.MarshalAuthorizedKey(sshKey)), time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	l.client = server.Client()
+	l.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	if err := l.check(ctx, []string{"db.internal:5432", "api.k8s.internal:443", "10.1.2.3:22"}); err != nil {
+		t.Errorf("Expected targets to be allowed, got %v", err)
+	}
+	for _, target := range []string{"db.internal:5433", "a.b.k8s.internal:443", "k8s.internal:443", "192.168.0.1:22", "[::ffff:11.0.0.1]:22"} {
+		if err := l.check(ctx, []string{target}); err == nil || !strings.Contains(err.Error(), target) {
+			t.Errorf("Expected %s to be denied, got %v", target, err)
+		}
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("Expected the document to be cached, fetched %d times", got)
+	}
+
+	// Updates are picked up once the cache expired.
+	document.Store(`{"allowed_targets": ["db.internal:5433"]}`)
+	now = now.Add(time.Minute)
+	if err := l.check(ctx, []string{"db.internal:5433"}); err != nil {
+		t.Errorf("Expected the updated document to be used, got %v", err)
+	}
+
+	// Documents signed by another key are rejected and not cached.
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signWith.Store(otherKey)
+	now = now.Add(time.Minute)
+	if err := l.check(ctx, []string{"db.internal:5433"}); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("Expected an invalid signature, got %v", err)
+	}
+	if err := l.check(ctx, []string{"db.internal:5433"}); err == nil {
+		t.Error("Expected fetching to fail closed")
+	}
+
+	// Expired documents are rejected.
+	signWith.Store(privateKey)
+	document.Store(`{"allowed_targets": ["db.internal:5433"], "expires_at": "2026-10-16T12:00:00Z"}`)
+	if err := l.check(ctx, []string{"db.internal:5433"}); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Expected an expired document, got %v", err)
+	}
+}
+
+func TestNewTargetAllowListInvalid(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSSHKey, err := ssh.NewPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	sshKey, err := ssh.NewPublicKey(edKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	validKey := string(ssh.MarshalAuthorizedKey(sshKey))
+
+	tests := map[string][2]string{
+		"http":      {"http://policy.example.com/targets.json", validKey},
+		"no host":   {"https:///targets.json", validKey},
+		"ecdsa key": {"https://policy.example.com/targets.json", string(ssh.MarshalAuthorizedKey(ecSSHKey))},
+		"no key":    {"https://policy.example.com/targets.json", "not a key"},
+	}
+	for name, args := range tests {
+		if _, err := newTargetAllowList(args[0], args[1], time.Minute); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+
+	for _, pattern := range []string{"db.internal", ":443", "db.internal:0", "db.internal:http"} {
+		if _, err := parseTargetPattern(pattern); err == nil {
+			t.Errorf("Expected an error for %q", pattern)
+		}
+	}
+}
+
+func TestForwardingTargets(t *testing.T) {
+	targets, err := forwardingTargets([]ConnectionEphemeralResourceModelLocalPortForwarding{
+		{RemoteHost: types.StringValue("bücher.internal"), RemotePort: types.Int32Value(443), SNIRoutes: map[string]types.String{
+			"api.internal": types.StringValue("10.0.0.5:443"),
+		}},
+		{RemoteHost: types.StringValue("10.0.0.5"), RemotePort: types.Int32Value(443)},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"10.0.0.5:443", "xn--bcher-kva.internal:443"}
+	if strings.Join(targets, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", targets, want)
+	}
+}
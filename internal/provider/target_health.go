@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+// targetFailureThreshold is the number of consecutive failed health checks
+// after which a remote target is considered gone.
+const targetFailureThreshold = 3
+
+// targetHealthTimeout limits a single health check, so a black-holed target
+// doesn't delay the next one.
+const targetHealthTimeout = 10 * time.Second
+
+// resolveErrorMessages are parts of the errors SSH servers report when the
+// name of a forwarding target can't be resolved, e.g. OpenSSH returns the
+// getaddrinfo error.
+var resolveErrorMessages = []string{
+	"name or service not known",
+	"nodename nor servname",
+	"no address associated",
+	"temporary failure in name resolution",
+	"no such host",
+	"unknown host",
+}
+
+// targetHealth tracks the health of the remote target of a local port
+// forwarding. Each check opens a new channel, so the SSH server resolves the
+// name of the target again.
+type targetHealth struct {
+	remoteHost string
+	remoteAddr string
+
+	mu          sync.Mutex
+	consecutive int
+	lastErr     error
+	// unavailable describes the outages of the target, oldest first.
+	unavailable []string
+	downSince   time.Time
+}
+
+func newTargetHealth(remoteHost, remoteAddr string) *targetHealth {
+	return &targetHealth{remoteHost: remoteHost, remoteAddr: remoteAddr}
+}
+
+// run checks the target every interval until ctx is done.
+func (h *targetHealth) run(ctx context.Context, dialer portforward.Dialer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.check(ctx, dialer, time.Now())
+		}
+	}
+}
+
+func (h *targetHealth) check(ctx context.Context, dialer portforward.Dialer, now time.Time) {
+	checkCtx, cancel := context.WithTimeout(ctx, targetHealthTimeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(checkCtx, "tcp", h.remoteAddr)
+	if err == nil {
+		conn.Close()
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	h.record(ctx, err, now)
+}
+
+// record updates the health with the result of a check at now.
+func (h *targetHealth) record(ctx context.Context, err error, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err == nil {
+		if h.consecutive >= targetFailureThreshold {
+			tflog.Info(ctx, "Remote target available again", map[string]interface{}{"remote_addr": h.remoteAddr})
+		}
+		h.consecutive = 0
+		return
+	}
+
+	h.consecutive++
+	h.lastErr = err
+	if h.consecutive == 1 {
+		h.downSince = now
+	}
+	if h.consecutive != targetFailureThreshold {
+		return
+	}
+
+	outage := fmt.Sprintf("%s since %s (%s)", h.describe(err), h.downSince.Format(time.RFC3339), err)
+	h.unavailable = append(h.unavailable, outage)
+	tflog.Warn(ctx, "Remote target unavailable", map[string]interface{}{"remote_addr": h.remoteAddr, "err": err})
+}
+
+// describe describes the failure of the target indicated by err.
+func (h *targetHealth) describe(err error) string {
+	msg := strings.ToLower(err.Error())
+	for _, m := range resolveErrorMessages {
+		if strings.Contains(msg, m) {
+			return fmt.Sprintf("%s stopped resolving on the SSH server", h.remoteHost)
+		}
+	}
+	if strings.Contains(msg, "refused") {
+		return fmt.Sprintf("%s refused connections", h.remoteAddr)
+	}
+	return fmt.Sprintf("%s became unreachable", h.remoteAddr)
+}
+
+// report describes the outages of the target, empty if there were none.
+func (h *targetHealth) report() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	report := strings.Join(h.unavailable, ", ")
+	if h.consecutive >= targetFailureThreshold {
+		report += ", still unavailable"
+	}
+	return report
+}
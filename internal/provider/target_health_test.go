@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type stubDialer struct {
+	err error
+}
+
+func (d *stubDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	local, remote := net.Pipe()
+	remote.Close()
+	return local, nil
+}
+
+func TestTargetHealth(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	dialer := &stubDialer{}
+	h := newTargetHealth("db.internal", "db.internal:5432")
+
+	h.check(ctx, dialer, now)
+	if report := h.report(); report != "" {
+		t.Errorf("Expected no report for a healthy target, got %q", report)
+	}
+
+	dialer.err = &ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "Name or service not known"}
+	for i := 0; i < targetFailureThreshold-1; i++ {
+		h.check(ctx, dialer, now.Add(time.Duration(i)*time.Minute))
+	}
+	if report := h.report(); report != "" {
+		t.Errorf("Expected no report below the failure threshold, got %q", report)
+	}
+
+	h.check(ctx, dialer, now.Add(time.Hour))
+	report := h.report()
+	if !strings.Contains(report, "db.internal stopped resolving on the SSH server since 2024-01-01T12:00:00Z") {
+		t.Errorf("Expected the report to name the host that stopped resolving, got %q", report)
+	}
+	if !strings.HasSuffix(report, "still unavailable") {
+		t.Errorf("Expected the target to be still unavailable, got %q", report)
+	}
+
+	dialer.err = nil
+	h.check(ctx, dialer, now.Add(2*time.Hour))
+	if report := h.report(); strings.HasSuffix(report, "still unavailable") || report == "" {
+		t.Errorf("Expected the outage to be reported after recovering, got %q", report)
+	}
+}
+
+func TestTargetHealthDescribe(t *testing.T) {
+	h := newTargetHealth("db.internal", "db.internal:5432")
+
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "Temporary failure in name resolution"}, "db.internal stopped resolving on the SSH server"},
+		{&ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "Connection refused"}, "db.internal:5432 refused connections"},
+		{errors.New("i/o timeout"), "db.internal:5432 became unreachable"},
+	}
+	for _, tt := range tests {
+		if got := h.describe(tt.err); got != tt.want {
+			t.Errorf("describe(%q) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+// errUnknownDefinition is returned while parts of a definition are unknown,
+// e.g. during validation.
+var errUnknownDefinition = errors.New("definition is unknown")
+
+// tunnelDefinition is a connection with its local port forwardings, as
+// accepted by the `definition` attribute and returned by the tunnels
+// function, so `for_each` over many targets is validated in one place.
+type tunnelDefinition struct {
+	host        string
+	port        int32
+	user        string
+	forwardings []forwardingDefinition
+}
+
+type forwardingDefinition struct {
+	name       string
+	remoteHost string
+	remotePort int32
+	// localPort is 0 for a random port.
+	localPort int32
+}
+
+var (
+	forwardingDefinitionType = types.ObjectType{AttrTypes: map[string]attr.Type{
+		"name":        types.StringType,
+		"remote_host": types.StringType,
+		"remote_port": types.NumberType,
+		"local_port":  types.NumberType,
+	}}
+	tunnelDefinitionType = types.ObjectType{AttrTypes: map[string]attr.Type{
+		"host":                   types.StringType,
+		"port":                   types.NumberType,
+		"user":                   types.StringType,
+		"local_port_forwardings": types.ListType{ElemType: forwardingDefinitionType},
+	}}
+)
+
+// parseTunnelDefinitions parses a map or object of named definitions.
+func parseTunnelDefinitions(value attr.Value) (map[string]tunnelDefinition, error) {
+	entries, err := dynamicAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := map[string]tunnelDefinition{}
+	for name, entry := range entries {
+		definition, err := parseTunnelDefinition(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		definitions[name] = definition
+	}
+	return definitions, nil
+}
+
+// parseTunnelDefinition parses and validates a definition. Unknown
+// attributes are rejected, so typos don't go unnoticed.
+func parseTunnelDefinition(value attr.Value) (tunnelDefinition, error) {
+	attributes, err := dynamicAttributes(value)
+	if err != nil {
+		return tunnelDefinition{}, err
+	}
+
+	definition := tunnelDefinition{port: 22}
+	for name, v := range attributes {
+		switch name {
+		case "host":
+			definition.host, err = dynamicString(v)
+		case "port":
+			definition.port, err = dynamicPort(v, 22)
+		case "user":
+			definition.user, err = dynamicString(v)
+		case "local_port_forwardings":
+			definition.forwardings, err = parseForwardingDefinitions(v)
+		default:
+			err = fmt.Errorf("unsupported attribute")
+		}
+		if err != nil {
+			if errors.Is(err, errUnknownDefinition) {
+				return tunnelDefinition{}, err
+			}
+			return tunnelDefinition{}, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	if definition.host == "" {
+		return tunnelDefinition{}, fmt.Errorf("host is required")
+	}
+	if _, err := hostToASCII(definition.host); err != nil {
+		return tunnelDefinition{}, fmt.Errorf("invalid host %q: %w", definition.host, err)
+	}
+	if len(definition.forwardings) == 0 {
+		return tunnelDefinition{}, fmt.Errorf("local_port_forwardings must not be empty")
+	}
+	return definition, nil
+}
+
+func parseForwardingDefinitions(value attr.Value) ([]forwardingDefinition, error) {
+	elements, err := dynamicElements(value)
+	if err != nil {
+		return nil, err
+	}
+
+	forwardings := make([]forwardingDefinition, 0, len(elements))
+	names := map[string]bool{}
+	for i, element := range elements {
+		attributes, err := dynamicAttributes(element)
+		if err != nil {
+			return nil, fmt.Errorf("[%d]: %w", i, err)
+		}
+
+		var f forwardingDefinition
+		for name, v := range attributes {
+			switch name {
+			case "name":
+				f.name, err = dynamicString(v)
+			case "remote_host":
+				f.remoteHost, err = dynamicString(v)
+			case "remote_port":
+				f.remotePort, err = dynamicPort(v, 0)
+			case "local_port":
+				f.localPort, err = dynamicPort(v, 0)
+			default:
+				err = fmt.Errorf("unsupported attribute")
+			}
+			if err != nil {
+				if errors.Is(err, errUnknownDefinition) {
+					return nil, err
+				}
+				return nil, fmt.Errorf("[%d].%s: %w", i, name, err)
+			}
+		}
+
+		if f.remoteHost == "" {
+			return nil, fmt.Errorf("[%d]: remote_host is required", i)
+		}
+		if _, err := hostToASCII(f.remoteHost); err != nil {
+			return nil, fmt.Errorf("[%d]: invalid remote_host %q: %w", i, f.remoteHost, err)
+		}
+		if f.remotePort == 0 {
+			return nil, fmt.Errorf("[%d]: remote_port is required", i)
+		}
+		if f.name != "" {
+			if names[f.name] {
+				return nil, fmt.Errorf("[%d]: duplicate name %q", i, f.name)
+			}
+			names[f.name] = true
+		}
+		forwardings = append(forwardings, f)
+	}
+	return forwardings, nil
+}
+
+// dynamicAttributes returns the attributes of an object or the elements of
+// a map.
+func dynamicAttributes(value attr.Value) (map[string]attr.Value, error) {
+	value = underlyingValue(value)
+	if value.IsUnknown() {
+		return nil, errUnknownDefinition
+	}
+	if value.IsNull() {
+		return nil, fmt.Errorf("must not be null")
+	}
+
+	switch v := value.(type) {
+	case basetypes.ObjectValue:
+		return v.Attributes(), nil
+	case basetypes.MapValue:
+		return v.Elements(), nil
+	default:
+		return nil, fmt.Errorf("expected an object, got %s", value.Type(context.Background()))
+	}
+}
+
+// dynamicElements returns the elements of a list, tuple or set.
+func dynamicElements(value attr.Value) ([]attr.Value, error) {
+	value = underlyingValue(value)
+	if value.IsUnknown() {
+		return nil, errUnknownDefinition
+	}
+	if value.IsNull() {
+		return nil, nil
+	}
+
+	switch v := value.(type) {
+	case basetypes.ListValue:
+		return v.Elements(), nil
+	case basetypes.TupleValue:
+		return v.Elements(), nil
+	case basetypes.SetValue:
+		return v.Elements(), nil
+	default:
+		return nil, fmt.Errorf("expected a list, got %s", value.Type(context.Background()))
+	}
+}
+
+func dynamicString(value attr.Value) (string, error) {
+	value = underlyingValue(value)
+	if value.IsUnknown() {
+		return "", errUnknownDefinition
+	}
+	if value.IsNull() {
+		return "", nil
+	}
+	v, ok := value.(basetypes.StringValue)
+	if !ok {
+		return "", fmt.Errorf("expected a string, got %s", value.Type(context.Background()))
+	}
+	return v.ValueString(), nil
+}
+
+// dynamicPort parses a port number, returning def if it is null.
+func dynamicPort(value attr.Value, def int32) (int32, error) {
+	value = underlyingValue(value)
+	if value.IsUnknown() {
+		return 0, errUnknownDefinition
+	}
+	if value.IsNull() {
+		return def, nil
+	}
+
+	var number *big.Float
+	switch v := value.(type) {
+	case basetypes.NumberValue:
+		number = v.ValueBigFloat()
+	case basetypes.Int64Value:
+		number = new(big.Float).SetInt64(v.ValueInt64())
+	case basetypes.Int32Value:
+		number = new(big.Float).SetInt64(int64(v.ValueInt32()))
+	default:
+		return 0, fmt.Errorf("expected a number, got %s", value.Type(context.Background()))
+	}
+	port, accuracy := number.Int64()
+	if accuracy != big.Exact || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %s", number.String())
+	}
+	return int32(port), nil
+}
+
+// underlyingValue returns the value of a dynamic value.
+func underlyingValue(value attr.Value) attr.Value {
+	v, ok := value.(basetypes.DynamicValue)
+	switch {
+	case !ok || v.IsNull() || v.IsUnknown():
+		return value
+	case v.IsUnderlyingValueUnknown():
+		return types.DynamicUnknown()
+	case v.IsUnderlyingValueNull():
+		return types.DynamicNull()
+	}
+	return v.UnderlyingValue()
+}
+
+// objectValue returns the definition normalized to tunnelDefinitionType.
+func (d tunnelDefinition) objectValue() (attr.Value, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	forwardings := make([]attr.Value, 0, len(d.forwardings))
+	for _, f := range d.forwardings {
+		localPort := types.NumberNull()
+		if f.localPort != 0 {
+			localPort = types.NumberValue(big.NewFloat(float64(f.localPort)))
+		}
+		name := types.StringNull()
+		if f.name != "" {
+			name = types.StringValue(f.name)
+		}
+		forwarding, forwardingDiags := types.ObjectValue(forwardingDefinitionType.AttrTypes, map[string]attr.Value{
+			"name":        name,
+			"remote_host": types.StringValue(f.remoteHost),
+			"remote_port": types.NumberValue(big.NewFloat(float64(f.remotePort))),
+			"local_port":  localPort,
+		})
+		diags.Append(forwardingDiags...)
+		forwardings = append(forwardings, forwarding)
+	}
+	list, listDiags := types.ListValue(forwardingDefinitionType, forwardings)
+	diags.Append(listDiags...)
+
+	user := types.StringNull()
+	if d.user != "" {
+		user = types.StringValue(d.user)
+	}
+	value, valueDiags := types.ObjectValue(tunnelDefinitionType.AttrTypes, map[string]attr.Value{
+		"host":                   types.StringValue(d.host),
+		"port":                   types.NumberValue(big.NewFloat(float64(d.port))),
+		"user":                   user,
+		"local_port_forwardings": list,
+	})
+	diags.Append(valueDiags...)
+	return value, diags
+}
+
+// applyTunnelDefinition sets the host, port and local port forwardings of
+// data from its definition, and the user unless set in the config. Nothing
+// is applied while the definition is unknown.
+func applyTunnelDefinition(data *ConnectionEphemeralResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if data.Definition.IsNull() {
+		return diags
+	}
+
+	definition, err := parseTunnelDefinition(data.Definition)
+	if errors.Is(err, errUnknownDefinition) {
+		return diags
+	}
+	if err != nil {
+		diags.AddAttributeError(path.Root("definition"), "Tunnel Definition Error", fmt.Sprintf("Invalid definition: %s", err))
+		return diags
+	}
+
+	data.Host = types.StringValue(definition.host)
+	data.Port = types.Int32Value(definition.port)
+	if data.User.IsNull() && definition.user != "" {
+		data.User = types.StringValue(definition.user)
+	}
+	data.LocalPortForwardings = nil
+	for _, f := range definition.forwardings {
+		forwarding := ConnectionEphemeralResourceModelLocalPortForwarding{
+			RemoteHost: types.StringValue(f.remoteHost),
+			RemotePort: types.Int32Value(f.remotePort),
+		}
+		if f.name != "" {
+			forwarding.Name = types.StringValue(f.name)
+		}
+		if f.localPort != 0 {
+			forwarding.LocalPort = types.Int32Value(f.localPort)
+		}
+		data.LocalPortForwardings = append(data.LocalPortForwardings, forwarding)
+	}
+	return diags
+}
+
+var _ function.Function = &TunnelsFunction{}
+
+// TunnelsFunction validates and normalizes a map of tunnel definitions for
+// `for_each` over sshtunnel_connection.
+type TunnelsFunction struct{}
+
+func NewTunnelsFunction() function.Function {
+	return &TunnelsFunction{}
+}
+
+func (f *TunnelsFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "tunnels"
+}
+
+func (f *TunnelsFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary: "Validates a map of tunnel definitions for for_each",
+		MarkdownDescription: "Validates and normalizes a map of tunnel definitions, each an object with `host`, `port` (defaults to `22`), `user` and a non-empty list of `local_port_forwardings` with `remote_host`, `remote_port`, `name` and `local_port`. " +
+			"Invalid definitions, including unsupported attributes, fail naming the key of the definition. " +
+			"The result is meant for `for_each` of `sshtunnel_connection`, passing each value to its `definition`",
+		Parameters: []function.Parameter{
+			function.DynamicParameter{
+				Name:                "definitions",
+				MarkdownDescription: "Map or object of tunnel definitions",
+			},
+		},
+		Return: function.MapReturn{ElementType: tunnelDefinitionType},
+	}
+}
+
+func (f *TunnelsFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var value types.Dynamic
+
+	resp.Error = function.ConcatFuncErrors(resp.Error, req.Arguments.Get(ctx, &value))
+	if resp.Error != nil {
+		return
+	}
+
+	definitions, err := parseTunnelDefinitions(value)
+	if err != nil {
+		resp.Error = function.ConcatFuncErrors(resp.Error, function.NewArgumentFuncError(0, fmt.Sprintf("Invalid tunnel definition %s", err)))
+		return
+	}
+
+	var diags diag.Diagnostics
+	elements := map[string]attr.Value{}
+	for name, definition := range definitions {
+		element, elementDiags := definition.objectValue()
+		diags.Append(elementDiags...)
+		elements[name] = element
+	}
+	result, resultDiags := types.MapValue(tunnelDefinitionType, elements)
+	diags.Append(resultDiags...)
+	resp.Error = function.ConcatFuncErrors(resp.Error, function.FuncErrorFromDiags(ctx, diags))
+	if resp.Error != nil {
+		return
+	}
+
+	resp.Error = function.ConcatFuncErrors(resp.Error, resp.Result.Set(ctx, result))
+}
@@ -0,0 +1,143 @@
+package provider
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// testObject returns an object literal of attributes, like Terraform passes
+// them to dynamic attributes.
+func testObject(attributes map[string]attr.Value) attr.Value {
+	attrTypes := map[string]attr.Type{}
+	for name, value := range attributes {
+		attrTypes[name] = value.Type(context.Background())
+	}
+	return types.ObjectValueMust(attrTypes, attributes)
+}
+
+func testTuple(elements ...attr.Value) attr.Value {
+	elemTypes := make([]attr.Type, 0, len(elements))
+	for _, element := range elements {
+		elemTypes = append(elemTypes, element.Type(context.Background()))
+	}
+	return types.TupleValueMust(elemTypes, elements)
+}
+
+func testNumber(n int) attr.Value {
+	return types.NumberValue(big.NewFloat(float64(n)))
+}
+
+func TestTunnelsFunction(t *testing.T) {
+	run := func(definitions attr.Value) function.RunResponse {
+		resp := function.RunResponse{Result: function.NewResultData(types.MapUnknown(tunnelDefinitionType))}
+		NewTunnelsFunction().Run(context.Background(), function.RunRequest{
+			Arguments: function.NewArgumentsData([]attr.Value{types.DynamicValue(definitions)}),
+		}, &resp)
+		return resp
+	}
+
+	resp := run(testObject(map[string]attr.Value{
+		"orders": testObject(map[string]attr.Value{
+			"host": types.StringValue("bastion.example.com"),
+			"local_port_forwardings": testTuple(testObject(map[string]attr.Value{
+				"name":        types.StringValue("primary"),
+				"remote_host": types.StringValue("orders.db.internal"),
+				"remote_port": testNumber(5432),
+			})),
+		}),
+	}))
+	if resp.Error != nil {
+		t.Fatalf("Unexpected error: %s", resp.Error)
+	}
+	result := resp.Result.Value().(types.Map).Elements()["orders"].(types.Object).Attributes()
+	if !result["port"].Equal(testNumber(22)) {
+		t.Errorf("got port %s, want the default 22", result["port"])
+	}
+	if !result["user"].IsNull() {
+		t.Errorf("got user %s, want null", result["user"])
+	}
+	forwarding := result["local_port_forwardings"].(types.List).Elements()[0].(types.Object).Attributes()
+	if !forwarding["remote_host"].Equal(types.StringValue("orders.db.internal")) || !forwarding["local_port"].IsNull() {
+		t.Errorf("Unexpected forwarding %v", forwarding)
+	}
+
+	validForwarding := testObject(map[string]attr.Value{
+		"remote_host": types.StringValue("orders.db.internal"),
+		"remote_port": testNumber(5432),
+	})
+	for name, test := range map[string]struct {
+		definition attr.Value
+		want       string
+	}{
+		"missing host": {testObject(map[string]attr.Value{
+			"local_port_forwardings": testTuple(validForwarding),
+		}), "orders: host is required"},
+		"no forwardings": {testObject(map[string]attr.Value{
+			"host": types.StringValue("bastion.example.com"),
+		}), "local_port_forwardings must not be empty"},
+		"typo": {testObject(map[string]attr.Value{
+			"host":                   types.StringValue("bastion.example.com"),
+			"prot":                   testNumber(2222),
+			"local_port_forwardings": testTuple(validForwarding),
+		}), "prot: unsupported attribute"},
+		"invalid remote port": {testObject(map[string]attr.Value{
+			"host": types.StringValue("bastion.example.com"),
+			"local_port_forwardings": testTuple(testObject(map[string]attr.Value{
+				"remote_host": types.StringValue("orders.db.internal"),
+				"remote_port": testNumber(70000),
+			})),
+		}), "[0].remote_port: invalid port 70000"},
+		"duplicate name": {testObject(map[string]attr.Value{
+			"host": types.StringValue("bastion.example.com"),
+			"local_port_forwardings": testTuple(
+				testObject(map[string]attr.Value{"name": types.StringValue("db"), "remote_host": types.StringValue("a.internal"), "remote_port": testNumber(5432)}),
+				testObject(map[string]attr.Value{"name": types.StringValue("db"), "remote_host": types.StringValue("b.internal"), "remote_port": testNumber(5432)}),
+			),
+		}), `duplicate name "db"`},
+	} {
+		resp := run(testObject(map[string]attr.Value{"orders": test.definition}))
+		if resp.Error == nil || !strings.Contains(resp.Error.Error(), test.want) {
+			t.Errorf("Expected an error containing %q for %s, got %v", test.want, name, resp.Error)
+		}
+	}
+}
+
+func TestApplyTunnelDefinition(t *testing.T) {
+	data := &ConnectionEphemeralResourceModel{
+		User: types.StringValue("deploy"),
+		Definition: types.DynamicValue(testObject(map[string]attr.Value{
+			"host": types.StringValue("bastion.example.com"),
+			"port": testNumber(2222),
+			"user": types.StringValue("ubuntu"),
+			"local_port_forwardings": testTuple(testObject(map[string]attr.Value{
+				"remote_host": types.StringValue("orders.db.internal"),
+				"remote_port": testNumber(5432),
+				"local_port":  testNumber(15432),
+			})),
+		})),
+	}
+	if diags := applyTunnelDefinition(data); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	if data.Host.ValueString() != "bastion.example.com" || data.Port.ValueInt32() != 2222 {
+		t.Errorf("got %s:%d, want bastion.example.com:2222", data.Host.ValueString(), data.Port.ValueInt32())
+	}
+	if data.User.ValueString() != "deploy" {
+		t.Errorf("got user %s, want the configured deploy", data.User.ValueString())
+	}
+	if len(data.LocalPortForwardings) != 1 || data.LocalPortForwardings[0].LocalPort.ValueInt32() != 15432 || !data.LocalPortForwardings[0].Name.IsNull() {
+		t.Errorf("Unexpected forwardings %v", data.LocalPortForwardings)
+	}
+
+	// Unknown definitions are validated once they are known.
+	unknown := &ConnectionEphemeralResourceModel{Definition: types.DynamicUnknown()}
+	if diags := applyTunnelDefinition(unknown); diags.HasError() || !unknown.Host.IsNull() {
+		t.Errorf("Expected an unknown definition to be skipped, got %v", diags)
+	}
+}
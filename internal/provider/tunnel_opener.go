@@ -0,0 +1,184 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+	"golang.org/x/crypto/ssh"
+)
+
+// errOpenAborted is returned once opening the tunnel was aborted, its error
+// is already reported and the tunnel closed.
+var errOpenAborted = errors.New("opening the tunnel was aborted")
+
+// forwardingFailure is a forwarding that failed to set up, aborting the
+// tunnel unless exit_on_forward_failure is disabled.
+type forwardingFailure struct {
+	summary string
+	detail  string
+}
+
+func (f *forwardingFailure) Error() string {
+	return f.detail
+}
+
+func forwardFailure(summary, detail string) error {
+	return &forwardingFailure{summary: summary, detail: detail}
+}
+
+// listenFailure is the failure of a forwarding unable to listen locally,
+// with a hint for the privileges ports below 1024 require.
+func listenFailure(err error, detail string) error {
+	var privilegedPortErr *portforward.PrivilegedPortError
+	if errors.As(err, &privilegedPortErr) {
+		return forwardFailure("Privileged Port Error", fmt.Sprintf("Unable to listen on local port %d, got error: %s. %s",
+			privilegedPortErr.Port, privilegedPortErr, privilegedPortHint()))
+	}
+	return forwardFailure("Port Forwarding Error", detail)
+}
+
+// namedQuota is a quota watched under name once all forwardings are set up.
+type namedQuota struct {
+	quota *portforward.Quota
+	name  string
+}
+
+// tunnelOpener sets up the forwardings of a tunnel being opened by Open.
+type tunnelOpener struct {
+	r *ConnectionEphemeralResource
+	// ctx is the context of the request, tunnelCtx the one of the tunnel.
+	ctx       context.Context
+	tunnelCtx context.Context
+	resp      *ephemeral.OpenResponse
+	id        string
+	owner     string
+	data      *ConnectionEphemeralResourceModel
+	info      *TunnelInfo
+	conn      *ssh.Client
+
+	privateData          *ConnectionPrivateData
+	exitOnForwardFailure bool
+
+	quotas          []namedQuota
+	connQuota       *portforward.Quota
+	priorityClasses map[string]*portforward.PriorityClass
+
+	// localListeners are waited on by wait_for_first_connection.
+	localListeners []*portforward.Listener
+	// localPorts are the ports of the local port forwardings, 0 for sockets.
+	localPorts []int32
+	// namedAddrs are the local addresses of the named forwardings, published
+	// under the connection id.
+	namedAddrs map[string]string
+}
+
+// fail reports an error opening the tunnel and closes everything set up so
+// far.
+func (o *tunnelOpener) fail(summary, detail string) error {
+	o.resp.Diagnostics.AddError(summary, detail)
+	o.resp.Diagnostics.Append(o.r.closeByConnectionID(o.id)...)
+	return errOpenAborted
+}
+
+// tunnelClosed reports a tunnel closed concurrently while opening it, e.g.
+// by the leak detector.
+func (o *tunnelOpener) tunnelClosed(err error) error {
+	return o.fail("Tunnel Error", fmt.Sprintf("Unable to open tunnel, got error: %s", err))
+}
+
+// forwardFailed reports a failed forwarding and whether opening the tunnel
+// has to be aborted.
+func (o *tunnelOpener) forwardFailed(summary, detail string) bool {
+	if !o.exitOnForwardFailure {
+		o.resp.Diagnostics.AddWarning(summary, detail)
+		return false
+	}
+
+	o.fail(summary, detail)
+	return true
+}
+
+// each sets up n forwardings with setup. Forwardings failing with a
+// forwardingFailure are reported by forwardFailed, any other error aborts.
+// It reports false once opening the tunnel was aborted.
+func (o *tunnelOpener) each(n int, setup func(i int) error) bool {
+	for i := 0; i < n; i++ {
+		err := setup(i)
+		var failure *forwardingFailure
+		switch {
+		case err == nil:
+		case errors.As(err, &failure):
+			if o.forwardFailed(failure.summary, failure.detail) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// limits sets up the byte quota and priority classes shared by the
+// forwardings.
+func (o *tunnelOpener) limits() error {
+	if !o.data.MaxBytes.IsNull() {
+		o.connQuota = portforward.NewQuota(o.data.MaxBytes.ValueInt64())
+		o.quotas = append(o.quotas, namedQuota{o.connQuota, "max_bytes"})
+	}
+
+	// Forwardings share the connection by priority class once any of them
+	// sets one.
+	if usesPriorityClasses(o.data.LocalPortForwardings) {
+		weights, err := priorityWeights(o.data.PriorityClasses)
+		if err != nil {
+			return o.fail("Priority Class Error", fmt.Sprintf("Invalid priority_classes: %s", err))
+		}
+		o.priorityClasses = newPriorityClasses(weights)
+	}
+	return nil
+}
+
+// config returns the configuration of a forwarding in priority class
+// priority, sharing the byte quota of the connection.
+func (o *tunnelOpener) config(priority string) *portforward.Config {
+	conf := &portforward.Config{
+		Faults:   o.r.faults,
+		Priority: o.priorityClasses[priority],
+	}
+	if o.connQuota != nil {
+		conf.Quotas = append(conf.Quotas, o.connQuota)
+	}
+	return conf
+}
+
+// lockLocalPort locks the fixed local port of a forwarding in lock_dir until
+// the tunnel is closed.
+func (o *tunnelOpener) lockLocalPort(port *int32) error {
+	if o.r.lockDir == nil || port == nil {
+		return nil
+	}
+
+	lock, err := o.r.lockLocalPort(o.ctx, *port, o.owner)
+	if err != nil {
+		return forwardFailure("Port Forwarding Error", fmt.Sprintf("Unable to lock local port %d, got error: %s", *port, err))
+	}
+	if err := o.info.addLock(lock); err != nil {
+		return o.tunnelClosed(err)
+	}
+	return nil
+}
+
+// addListener adds the listener of a forwarding to the tunnel, local ones are
+// also waited on by wait_for_first_connection.
+func (o *tunnelOpener) addListener(listener *portforward.Listener, local bool) error {
+	if err := o.info.addListener(listener); err != nil {
+		return o.tunnelClosed(err)
+	}
+	if local {
+		o.localListeners = append(o.localListeners, listener)
+	}
+	return nil
+}
@@ -1,27 +1,75 @@
 package provider
 
 import (
-	"net"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/consul"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/endpoints"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/filelock"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
 	"golang.org/x/crypto/ssh"
 )
 
+// TunnelTracker tracks the open tunnels of a provider process.
+//
+// Terraform opens and closes tunnels concurrently, up to its -parallelism,
+// while the leak detector runs in the background. The tracker lock only
+// guards the map of tunnels and is never held while calling into a tunnel,
+// each TunnelInfo guards its own state. Closing tunnels, which can block on
+// the network, happens outside of any tracker lock, so opens of other
+// tunnels never wait for it.
 type TunnelTracker struct {
 	mu      sync.Mutex
 	tunnels map[string]*TunnelInfo
+	// failedGroups describes the first tunnel that failed to open per group.
+	failedGroups map[string]string
 }
 
 func NewTunnelTracker() *TunnelTracker {
 	return &TunnelTracker{
-		tunnels: map[string]*TunnelInfo{},
+		tunnels:      map[string]*TunnelInfo{},
+		failedGroups: map[string]string{},
 	}
 }
 
+// sharedTrackers are the trackers shared by name between provider
+// configurations in the same process.
+var sharedTrackers = struct {
+	sync.Mutex
+	trackers map[string]*TunnelTracker
+}{
+	trackers: map[string]*TunnelTracker{},
+}
+
+// SharedTunnelTracker returns the tracker shared under name, creating it if
+// it doesn't exist yet. created reports whether the tracker was created.
+func SharedTunnelTracker(name string) (tracker *TunnelTracker, created bool) {
+	sharedTrackers.Lock()
+	defer sharedTrackers.Unlock()
+
+	if tracker, ok := sharedTrackers.trackers[name]; ok {
+		return tracker, false
+	}
+
+	tracker = NewTunnelTracker()
+	sharedTrackers.trackers[name] = tracker
+	return tracker, true
+}
+
 func (t *TunnelTracker) Add(name string, info *TunnelInfo) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	if info.CreatedAt.IsZero() {
+		info.CreatedAt = time.Now()
+	}
 	t.tunnels[name] = info
 }
 
@@ -39,7 +87,481 @@ func (t *TunnelTracker) Remove(name string) {
 	delete(t.tunnels, name)
 }
 
+// GroupFailure describes the tunnel that failed to open in group, empty if
+// none did.
+func (t *TunnelTracker) GroupFailure(group string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.failedGroups[group]
+}
+
+// FailGroup marks group as failed, as the tunnel described by owner failed to
+// open, and closes all tunnels of the group. The diagnostics of closing the
+// tunnels are logged.
+func (t *TunnelTracker) FailGroup(ctx context.Context, group, owner string) {
+	t.mu.Lock()
+	if _, ok := t.failedGroups[group]; !ok {
+		t.failedGroups[group] = owner
+	}
+	members := map[string]*TunnelInfo{}
+	for id, info := range t.tunnels {
+		if info.Group == group {
+			members[id] = info
+			delete(t.tunnels, id)
+		}
+	}
+	t.mu.Unlock()
+
+	// Members are closed concurrently, a large group shouldn't take the sum
+	// of all close timeouts.
+	var wg sync.WaitGroup
+	for id, info := range members {
+		tflog.Warn(ctx, "Closing tunnel as another tunnel of its group failed to open", map[string]interface{}{
+			"id":     id,
+			"owner":  info.Owner,
+			"group":  group,
+			"failed": owner,
+		})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, d := range info.close() {
+				tflog.Warn(ctx, d.Summary(), map[string]interface{}{"id": id, "detail": d.Detail()})
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TrackedTunnel is a snapshot of a tracked tunnel.
+type TrackedTunnel struct {
+	ID        string
+	Owner     string
+	CreatedAt time.Time
+	Listeners int
+}
+
+// List returns a snapshot of all tracked tunnels, oldest first.
+func (t *TunnelTracker) List() []TrackedTunnel {
+	t.mu.Lock()
+	infos := make(map[string]*TunnelInfo, len(t.tunnels))
+	for id, info := range t.tunnels {
+		infos[id] = info
+	}
+	t.mu.Unlock()
+
+	tunnels := make([]TrackedTunnel, 0, len(infos))
+	for id, info := range infos {
+		tunnels = append(tunnels, TrackedTunnel{
+			ID:        id,
+			Owner:     info.Owner,
+			CreatedAt: info.CreatedAt,
+			Listeners: info.listenerCount(),
+		})
+	}
+
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].CreatedAt.Before(tunnels[j].CreatedAt)
+	})
+
+	return tunnels
+}
+
+// LeakDetectorConfig configures the background leak detector.
+type LeakDetectorConfig struct {
+	// MaxAge is the age after which a tunnel that is still tracked is
+	// considered leaked, e.g. because Terraform never closed it.
+	MaxAge time.Duration
+	// Interval is how often tunnels are checked.
+	Interval time.Duration
+	// ForceClose closes leaked tunnels instead of only warning about them.
+	ForceClose bool
+}
+
+// StartLeakDetector periodically checks for leaked tunnels until ctx is
+// cancelled.
+func (t *TunnelTracker) StartLeakDetector(ctx context.Context, conf LeakDetectorConfig) {
+	if conf.MaxAge <= 0 {
+		return
+	}
+	if conf.Interval <= 0 {
+		conf.Interval = time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(conf.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				t.DetectLeaks(ctx, conf, now)
+			}
+		}
+	}()
+}
+
+// DetectLeaks warns about, and with ForceClose closes, all tunnels older than
+// conf.MaxAge at now. It returns the IDs of the leaked tunnels.
+func (t *TunnelTracker) DetectLeaks(ctx context.Context, conf LeakDetectorConfig, now time.Time) []string {
+	leaked := []string{}
+
+	for _, tunnel := range t.List() {
+		age := now.Sub(tunnel.CreatedAt)
+		if age < conf.MaxAge {
+			continue
+		}
+		leaked = append(leaked, tunnel.ID)
+
+		fields := map[string]interface{}{
+			"id":          tunnel.ID,
+			"owner":       tunnel.Owner,
+			"age":         age.Round(time.Second).String(),
+			"force_close": conf.ForceClose,
+		}
+		tflog.Warn(ctx, "Tunnel is still open long after it was created, it might have been leaked", fields)
+
+		if !conf.ForceClose {
+			continue
+		}
+
+		info := t.Get(tunnel.ID)
+		if info == nil {
+			continue
+		}
+		for _, d := range info.close() {
+			tflog.Warn(ctx, d.Summary(), map[string]interface{}{"id": tunnel.ID, "detail": d.Detail()})
+		}
+		t.Remove(tunnel.ID)
+	}
+
+	return leaked
+}
+
 type TunnelInfo struct {
+	// Owner describes what opened the tunnel, used in diagnostics.
+	Owner string
+	// CreatedAt is when the tunnel was opened, set by the tracker if empty.
+	CreatedAt time.Time
+	// Group is the group the tunnel is opened all-or-nothing with, may be
+	// empty.
+	Group string
+
+	// cancel stops background tasks of the tunnel, may be nil.
+	cancel context.CancelFunc
+	// budget limits the connections forwarded by the tunnel together with
+	// other tunnels, may be nil.
+	budget *portforward.Budget
+
+	closeOnce  sync.Once
+	closeDiags diag.Diagnostics
+
+	// mu guards the fields below, the tunnel can be closed, e.g. by the leak
+	// detector, while it is still being opened.
+	mu        sync.Mutex
+	closed    bool
 	conn      *ssh.Client
-	listeners []net.Listener
+	listeners []*portforward.Listener
+	locks     []*filelock.Lock
+	targets   []*targetHealth
+	// windowStalls are the listeners reported when window stalls dominated
+	// their connections.
+	windowStalls []windowStallWatch
+	// availability records flaps of conn, may be nil.
+	availability *availabilityWatcher
+	// quotaExceeded describes the quota that caused the tunnel to be closed.
+	quotaExceeded string
+	// fanOut describes the fan-out guard that caused the tunnel to be closed.
+	fanOut string
+	// endpointsDir and endpointsID are where the named local addresses of
+	// the tunnel are published, withdrawn when closing the tunnel. Empty if
+	// nothing was published.
+	endpointsDir string
+	endpointsID  string
+	// registrations are the Consul services of remote forwardings,
+	// deregistered when closing the tunnel.
+	registrations []consulRegistration
+}
+
+type consulRegistration struct {
+	client *consul.Client
+	id     string
+}
+
+// deregisterTimeout bounds deregistering a Consul service when closing a
+// tunnel.
+const deregisterTimeout = 10 * time.Second
+
+func (r consulRegistration) deregister() error {
+	ctx, cancel := context.WithTimeout(context.Background(), deregisterTimeout)
+	defer cancel()
+
+	return r.client.Deregister(ctx, r.id)
+}
+
+// errTunnelClosed is returned when adding to a tunnel that was closed.
+var errTunnelClosed = errors.New("tunnel was closed while opening")
+
+// setConn sets the SSH connection of the tunnel. If the tunnel was already
+// closed, conn is closed and errTunnelClosed returned.
+func (i *TunnelInfo) setConn(conn *ssh.Client) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.closed {
+		conn.Close()
+		return errTunnelClosed
+	}
+	i.conn = conn
+	return nil
+}
+
+// addListener adds a listener closed with the tunnel. If the tunnel was
+// already closed, listener is closed and errTunnelClosed returned.
+func (i *TunnelInfo) addListener(listener *portforward.Listener) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.closed {
+		listener.Close()
+		return errTunnelClosed
+	}
+	i.listeners = append(i.listeners, listener)
+	return nil
+}
+
+// addLock adds a lock released with the tunnel. If the tunnel was already
+// closed, lock is released and errTunnelClosed returned.
+func (i *TunnelInfo) addLock(lock *filelock.Lock) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.closed {
+		_ = lock.Unlock()
+		return errTunnelClosed
+	}
+	i.locks = append(i.locks, lock)
+	return nil
+}
+
+// addRegistration adds a Consul service deregistered with the tunnel. If the
+// tunnel was already closed, the service is deregistered and errTunnelClosed
+// returned.
+func (i *TunnelInfo) addRegistration(client *consul.Client, id string) error {
+	registration := consulRegistration{client: client, id: id}
+
+	i.mu.Lock()
+	closed := i.closed
+	if !closed {
+		i.registrations = append(i.registrations, registration)
+	}
+	i.mu.Unlock()
+
+	// Deregister outside of the lock, it waits for the network.
+	if closed {
+		_ = registration.deregister()
+		return errTunnelClosed
+	}
+	return nil
+}
+
+// publishEndpoints publishes the named local addresses of the tunnel as id
+// in dir until the tunnel is closed. If the tunnel was already closed,
+// nothing is published and errTunnelClosed returned.
+func (i *TunnelInfo) publishEndpoints(dir, id string, addrs map[string]string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.closed {
+		return errTunnelClosed
+	}
+	if err := endpoints.Publish(dir, id, addrs); err != nil {
+		return err
+	}
+	i.endpointsDir, i.endpointsID = dir, id
+	return nil
+}
+
+// addTargetHealth adds the health of a remote target reported when closing
+// the tunnel.
+func (i *TunnelInfo) addTargetHealth(target *targetHealth) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.targets = append(i.targets, target)
+}
+
+// watchWindowStalls reports the connections of listener, forwarding to
+// remoteAddr, when closing the tunnel if they were mostly waiting for the SSH
+// server to accept more data.
+func (i *TunnelInfo) watchWindowStalls(listener *portforward.Listener, remoteAddr string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.windowStalls = append(i.windowStalls, windowStallWatch{listener: listener, remoteAddr: remoteAddr})
+}
+
+// setAvailabilityWatcher sets the watcher of conn, its flaps are reported
+// when closing the tunnel.
+func (i *TunnelInfo) setAvailabilityWatcher(watcher *availabilityWatcher) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.availability = watcher
+}
+
+func (i *TunnelInfo) listenerCount() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return len(i.listeners)
+}
+
+// watchQuota closes the tunnel once quota is exceeded, described by name in
+// the diagnostic returned when closing the tunnel.
+func (i *TunnelInfo) watchQuota(ctx context.Context, quota *portforward.Quota, name string) {
+	go func() {
+		select {
+		case <-quota.Exceeded():
+		case <-ctx.Done():
+			return
+		}
+
+		tflog.Error(ctx, "Data transfer quota exceeded, closing tunnel", map[string]interface{}{
+			"owner":     i.Owner,
+			"quota":     name,
+			"max_bytes": quota.Max(),
+		})
+
+		i.mu.Lock()
+		if i.quotaExceeded == "" {
+			i.quotaExceeded = fmt.Sprintf("%s (%d bytes)", name, quota.Max())
+		}
+		i.mu.Unlock()
+
+		i.close()
+	}()
+}
+
+// watchFanOut closes the tunnel once guard trips, described by name in the
+// diagnostic returned when closing the tunnel.
+func (i *TunnelInfo) watchFanOut(ctx context.Context, guard *portforward.FanOutGuard, name string) {
+	go func() {
+		select {
+		case <-guard.Tripped():
+		case <-ctx.Done():
+			return
+		}
+
+		tflog.Error(ctx, "Destination fan-out detected, closing tunnel", map[string]interface{}{
+			"owner": i.Owner,
+			"guard": name,
+			"err":   guard.Err(),
+		})
+
+		i.mu.Lock()
+		if i.fanOut == "" {
+			i.fanOut = fmt.Sprintf("%s: %s", name, guard.Err())
+		}
+		i.mu.Unlock()
+
+		i.close()
+	}()
+}
+
+// close closes all listeners and the SSH connection of the tunnel and
+// releases its locks. Subsequent calls return the diagnostics of the first.
+func (i *TunnelInfo) close() diag.Diagnostics {
+	i.closeOnce.Do(func() {
+		i.closeDiags = i.doClose()
+	})
+
+	return i.closeDiags
+}
+
+func (i *TunnelInfo) doClose() diag.Diagnostics {
+	diags := diag.Diagnostics{}
+
+	i.mu.Lock()
+	i.closed = true
+	conn, listeners, locks, targets, availability, windowStalls := i.conn, i.listeners, i.locks, i.targets, i.availability, i.windowStalls
+	endpointsDir, endpointsID := i.endpointsDir, i.endpointsID
+	registrations := i.registrations
+	if i.quotaExceeded != "" {
+		diags.AddError("Data Transfer Quota Exceeded", fmt.Sprintf("The %s was closed after exceeding the %s", i.Owner, i.quotaExceeded))
+	}
+	if i.fanOut != "" {
+		diags.AddError("Destination Fan-Out Detected", fmt.Sprintf("The %s was closed as a proxy client fanned out across too many destinations, %s", i.Owner, i.fanOut))
+	}
+	i.mu.Unlock()
+
+	if i.cancel != nil {
+		i.cancel()
+	}
+
+	// Withdraw the endpoints and services first, so they aren't resolved to
+	// closed listeners.
+	if endpointsID != "" {
+		if err := endpoints.Withdraw(endpointsDir, endpointsID); err != nil {
+			diags.AddError("Failed to withdraw endpoints", fmt.Sprintf("Failed to withdraw the endpoints of connection %q: %v", endpointsID, err))
+		}
+	}
+	for _, registration := range registrations {
+		if err := registration.deregister(); err != nil {
+			diags.AddError("Failed to deregister service", fmt.Sprintf("Failed to deregister Consul service %q from %s: %v", registration.id, registration.client.Addr, err))
+		}
+	}
+
+	var stalled, rejected uint64
+	for _, listener := range listeners {
+		if err := listener.Close(); err != nil {
+			diags.AddError("Failed to close listener", fmt.Sprintf("Failed to close listener: %v", err))
+		}
+		stats := listener.Stats()
+		stalled += stats.Stalled
+		rejected += stats.Rejected
+	}
+	if rejected > 0 && i.budget != nil {
+		diags.AddWarning("Connection Budget Exhausted", fmt.Sprintf("%d local connections of the %s were rejected, as max_forwarded_connections (%d) was reached. Peak utilization was %d/%d connections",
+			rejected, i.Owner, i.budget.Max(), i.budget.Peak(), i.budget.Max()))
+	}
+	if stalled > 0 {
+		diags.AddWarning("Stalled Connections", fmt.Sprintf("%d forwarded connections of the %s were closed after making no progress for their stall_timeout", stalled, i.Owner))
+	}
+
+	for _, watch := range windowStalls {
+		if report := windowStallReport(watch.listener.Stats()); report != "" {
+			diags.AddWarning("SSH Server Throttling", fmt.Sprintf("The connections of the local port forwarding to %s of the %s %s. "+
+				"The SSH server or the link to it limited the transfer rather than the remote target", watch.remoteAddr, i.Owner, report))
+		}
+	}
+
+	for _, target := range targets {
+		if report := target.report(); report != "" {
+			diags.AddWarning("Remote Target Unavailable", fmt.Sprintf("The remote target %s of the %s was unavailable: %s", target.remoteAddr, i.Owner, report))
+		}
+	}
+
+	if availability != nil {
+		if summary := availability.summary(time.Now()); summary != "" {
+			diags.AddWarning("Unstable Connection", fmt.Sprintf("The SSH server of the %s %s. Failures of this run may be caused by the SSH server rather than the provider", i.Owner, summary))
+		}
+	}
+
+	if conn != nil {
+		if err := conn.Close(); err != nil {
+			diags.AddError("Failed to close connection", fmt.Sprintf("Failed to close connection: %v", err))
+		}
+	}
+
+	for _, lock := range locks {
+		if err := lock.Unlock(); err != nil {
+			diags.AddError("Failed to release lock", fmt.Sprintf("Failed to release lock: %v", err))
+		}
+	}
+
+	return diags
 }
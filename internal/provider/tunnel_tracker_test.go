@@ -0,0 +1,401 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/consul"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+func TestTunnelTrackerDetectLeaks(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	tracker := NewTunnelTracker()
+	tracker.Add("old", &TunnelInfo{Owner: "connection to old:22", CreatedAt: now.Add(-2 * time.Hour)})
+	tracker.Add("new", &TunnelInfo{Owner: "connection to new:22", CreatedAt: now.Add(-time.Minute)})
+
+	if got := tracker.List(); len(got) != 2 || got[0].ID != "old" || got[1].ID != "new" {
+		t.Fatalf("Expected tunnels ordered by age, got %+v", got)
+	}
+
+	leaked := tracker.DetectLeaks(ctx, LeakDetectorConfig{MaxAge: time.Hour}, now)
+	if want := []string{"old"}; !reflect.DeepEqual(leaked, want) {
+		t.Errorf("got %v, want %v", leaked, want)
+	}
+	if tracker.Get("old") == nil {
+		t.Error("Expected leaked tunnel to stay tracked without force close")
+	}
+
+	tracker.DetectLeaks(ctx, LeakDetectorConfig{MaxAge: time.Hour, ForceClose: true}, now)
+	if tracker.Get("old") != nil {
+		t.Error("Expected leaked tunnel to be closed")
+	}
+	if tracker.Get("new") == nil {
+		t.Error("Expected recent tunnel to stay tracked")
+	}
+}
+
+func TestOpenUntracksTunnelOnConnectFailure(t *testing.T) {
+	ctx := context.Background()
+	r := &ConnectionEphemeralResource{tunnelTracker: NewTunnelTracker()}
+	schemaResp := ephemeral.SchemaResponse{}
+	r.Schema(ctx, ephemeral.SchemaRequest{}, &schemaResp)
+	typ := schemaResp.Schema.Type().TerraformType(ctx).(tftypes.Object)
+
+	// Nothing listens on the port once the listener is closed.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	config := tfsdk.Config{Schema: schemaResp.Schema, Raw: nullObject(typ, map[string]tftypes.Value{
+		"host": tftypes.NewValue(tftypes.String, "127.0.0.1"),
+		"port": tftypes.NewValue(tftypes.Number, port),
+		"user": tftypes.NewValue(tftypes.String, "deploy"),
+		"auth": nullObject(typ.AttributeTypes["auth"].(tftypes.Object), map[string]tftypes.Value{
+			"password": tftypes.NewValue(tftypes.String, "secret"),
+		}),
+	})}
+	resp := ephemeral.OpenResponse{Result: tfsdk.EphemeralResultData{Schema: schemaResp.Schema}}
+	r.Open(ctx, ephemeral.OpenRequest{Config: config}, &resp)
+	if !resp.Diagnostics.HasError() {
+		t.Fatal("Expected an error connecting to a closed port")
+	}
+
+	if tunnels := r.tunnelTracker.List(); len(tunnels) != 0 {
+		t.Errorf("Expected the tunnel that failed to connect to be untracked, got %+v", tunnels)
+	}
+	if leaked := r.tunnelTracker.DetectLeaks(ctx, LeakDetectorConfig{MaxAge: time.Nanosecond}, time.Now().Add(time.Hour)); len(leaked) != 0 {
+		t.Errorf("Expected no leaked tunnels, got %v", leaked)
+	}
+}
+
+func TestTunnelInfoQuotaExceeded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Forward to a local server discarding everything it receives.
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer server.Close()
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	quota := portforward.NewQuota(2)
+	listener := portforward.Serve(ctx, local, &net.Dialer{}, &portforward.Config{
+		RemoteAddr: server.Addr().String(),
+		Quotas:     []*portforward.Quota{quota},
+	})
+
+	info := &TunnelInfo{Owner: "connection to test:22", cancel: cancel, listeners: []*portforward.Listener{listener}}
+	info.watchQuota(ctx, quota, "max_bytes")
+
+	conn, err := net.Dial("tcp", local.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "too much"); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	select {
+	case <-listener.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the tunnel to close")
+	}
+
+	// The listener closes itself, wait for the watcher to record the quota.
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		info.mu.Lock()
+		exceeded := info.quotaExceeded != ""
+		info.mu.Unlock()
+		if exceeded {
+			break
+		}
+	}
+
+	diags := info.close()
+	if len(diags) != 1 || diags[0].Summary() != "Data Transfer Quota Exceeded" {
+		t.Errorf("Expected a quota diagnostic, got %v", diags)
+	}
+}
+
+func TestTunnelInfoWatchFanOut(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	guard := portforward.NewFanOutGuard(portforward.FanOutLimits{MaxRequests: 1})
+	listener, err := portforward.New(ctx, &net.Dialer{}, &portforward.Config{ListenHost: "127.0.0.1", SOCKS5: true, FanOut: guard})
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+
+	info := &TunnelInfo{Owner: "connection to test:22", cancel: cancel, listeners: []*portforward.Listener{listener}}
+	info.watchFanOut(ctx, guard, "fan_out_guard")
+
+	// The second request exceeds max_requests, the targets needn't exist.
+	for _, port := range []byte{1, 2} {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		if _, err := conn.Write([]byte{5, 1, 0, 5, 1, 0, 1, 127, 0, 0, 1, 0, port}); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, conn)
+		conn.Close()
+	}
+
+	select {
+	case <-listener.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the tunnel to close")
+	}
+
+	// The listener closes itself, wait for the watcher to record the guard.
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		info.mu.Lock()
+		tripped := info.fanOut != ""
+		info.mu.Unlock()
+		if tripped {
+			break
+		}
+	}
+
+	diags := info.close()
+	if len(diags) != 1 || diags[0].Summary() != "Destination Fan-Out Detected" {
+		t.Errorf("Expected a fan-out diagnostic, got %v", diags)
+	}
+}
+
+func TestTunnelInfoDeregistersServices(t *testing.T) {
+	var mu sync.Mutex
+	var deregistered []string
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		deregistered = append(deregistered, strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/"))
+	}))
+	defer agent.Close()
+	client := consul.NewClient(agent.URL, "")
+
+	info := &TunnelInfo{Owner: "connection to test:22"}
+	if err := info.addRegistration(client, "sshtunnel-a-0"); err != nil {
+		t.Fatalf("Failed to add registration: %v", err)
+	}
+	if diags := info.close(); diags.HasError() {
+		t.Errorf("Unexpected diagnostics: %v", diags)
+	}
+
+	// Services registered while closing are deregistered right away.
+	if err := info.addRegistration(client, "sshtunnel-a-1"); !errors.Is(err, errTunnelClosed) {
+		t.Errorf("got %v, want %v", err, errTunnelClosed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"sshtunnel-a-0", "sshtunnel-a-1"}; !reflect.DeepEqual(deregistered, want) {
+		t.Errorf("got %v, want %v", deregistered, want)
+	}
+}
+
+func TestSharedTunnelTracker(t *testing.T) {
+	a, created := SharedTunnelTracker(t.Name())
+	if !created {
+		t.Error("Expected the tracker to be created")
+	}
+	b, created := SharedTunnelTracker(t.Name())
+	if created || a != b {
+		t.Error("Expected the tracker to be shared")
+	}
+	if c, _ := SharedTunnelTracker(t.Name() + "-other"); c == a {
+		t.Error("Expected trackers with different names to be isolated")
+	}
+}
+
+// stressParallelism is the number of concurrent opens of the stress tests,
+// like `terraform apply -parallelism=50`.
+const stressParallelism = 50
+
+// TestTunnelTrackerConcurrent exercises the tracker like concurrent opens,
+// closes and the leak detector do, run with -race.
+func TestTunnelTrackerConcurrent(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewTunnelTracker()
+
+	var wg sync.WaitGroup
+	for i := 0; i < stressParallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			id := fmt.Sprintf("tunnel-%d", i)
+			info := &TunnelInfo{Owner: id}
+			tracker.Add(id, info)
+
+			local, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Errorf("Failed to listen: %v", err)
+				return
+			}
+			listener := portforward.Serve(ctx, local, &net.Dialer{}, &portforward.Config{RemoteAddr: "127.0.0.1:1"})
+			if err := info.addListener(listener); err != nil && !errors.Is(err, errTunnelClosed) {
+				t.Errorf("Failed to add listener: %v", err)
+			}
+
+			_ = tracker.List()
+			if i%2 == 0 {
+				info.close()
+				tracker.Remove(id)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			tracker.DetectLeaks(ctx, LeakDetectorConfig{MaxAge: time.Nanosecond, ForceClose: i%2 == 0}, time.Now())
+		}
+	}()
+
+	wg.Wait()
+
+	tracker.DetectLeaks(ctx, LeakDetectorConfig{MaxAge: time.Nanosecond, ForceClose: true}, time.Now())
+	if got := tracker.List(); len(got) != 0 {
+		t.Errorf("Expected all tunnels to be closed, got %+v", got)
+	}
+}
+
+func TestTunnelInfoAddAfterClose(t *testing.T) {
+	info := &TunnelInfo{Owner: "connection to test:22"}
+	info.close()
+
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	listener := portforward.Serve(context.Background(), local, &net.Dialer{}, &portforward.Config{RemoteAddr: "127.0.0.1:1"})
+
+	if err := info.addListener(listener); !errors.Is(err, errTunnelClosed) {
+		t.Errorf("Expected errTunnelClosed, got %v", err)
+	}
+	select {
+	case <-listener.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the listener to be closed")
+	}
+}
+
+func TestTunnelTrackerFailGroup(t *testing.T) {
+	tracker := NewTunnelTracker()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker.Add("db", &TunnelInfo{Owner: "connection to db:22", Group: "app", cancel: cancel})
+	tracker.Add("other", &TunnelInfo{Owner: "connection to other:22", Group: "other"})
+	tracker.Add("single", &TunnelInfo{Owner: "connection to single:22"})
+
+	if failed := tracker.GroupFailure("app"); failed != "" {
+		t.Fatalf("Expected no group failure, got %q", failed)
+	}
+
+	tracker.FailGroup(context.Background(), "app", "connection to cache:22")
+
+	if failed := tracker.GroupFailure("app"); failed != "connection to cache:22" {
+		t.Errorf("got group failure %q, want connection to cache:22", failed)
+	}
+	if tracker.Get("db") != nil {
+		t.Error("Expected the tunnel of the failed group to be removed")
+	}
+	select {
+	case <-ctx.Done():
+	default:
+		t.Error("Expected the tunnel of the failed group to be closed")
+	}
+	if tracker.Get("other") == nil || tracker.Get("single") == nil {
+		t.Error("Expected tunnels of other groups to stay tracked")
+	}
+
+	// The first failure is kept.
+	tracker.FailGroup(context.Background(), "app", "connection to db:22")
+	if failed := tracker.GroupFailure("app"); failed != "connection to cache:22" {
+		t.Errorf("got group failure %q, want connection to cache:22", failed)
+	}
+}
+
+// TestTunnelTrackerConcurrentFailGroup opens tunnels of a group concurrently
+// while one of them fails, every tunnel of the group has to end up closed
+// and untracked, whether it was opened before or after the failure.
+func TestTunnelTrackerConcurrentFailGroup(t *testing.T) {
+	ctx := context.Background()
+	tracker := NewTunnelTracker()
+
+	infos := make([]*TunnelInfo, stressParallelism)
+	var wg sync.WaitGroup
+	for i := range infos {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			id := fmt.Sprintf("tunnel-%d", i)
+			if i == stressParallelism/2 {
+				tracker.FailGroup(ctx, "app", id)
+				return
+			}
+			if tracker.GroupFailure("app") != "" {
+				return
+			}
+
+			tunnelCtx, cancel := context.WithCancel(ctx)
+			infos[i] = &TunnelInfo{Owner: id, Group: "app", cancel: cancel}
+			tracker.Add(id, infos[i])
+			_ = tracker.List()
+
+			// Like Open, roll back the group if it failed meanwhile.
+			if tracker.GroupFailure("app") != "" {
+				tracker.FailGroup(ctx, "app", id)
+			}
+			<-tunnelCtx.Done()
+		}()
+	}
+	wg.Wait()
+
+	if got := tracker.List(); len(got) != 0 {
+		t.Errorf("Expected all tunnels of the group to be untracked, got %+v", got)
+	}
+}
@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+// udpListenHost is the address UDP forwardings listen on, anyone able to send
+// them datagrams can reach the remote address.
+const udpListenHost = "127.0.0.1"
+
+// defaultUDPRelayCommand relays the datagrams of UDP forwardings with netcat,
+// which is available on most servers.
+const defaultUDPRelayCommand = "nc -u %h %p"
+
+func udpRelayCommand(f ConnectionEphemeralResourceModelUDPForwarding) string {
+	if f.RelayCommand.IsNull() {
+		return defaultUDPRelayCommand
+	}
+	return f.RelayCommand.ValueString()
+}
+
+func (o *tunnelOpener) openUDPForwardings() bool {
+	return o.each(len(o.data.UDPForwardings), o.openUDPForwarding)
+}
+
+func (o *tunnelOpener) openUDPForwarding(i int) error {
+	udpForwarding := o.data.UDPForwardings[i]
+
+	remoteHost, err := hostToASCII(udpForwarding.RemoteHost.ValueString())
+	if err != nil {
+		return o.fail("UDP Forwarding Error", fmt.Sprintf("Invalid remote_host %q: %s", udpForwarding.RemoteHost.ValueString(), err))
+	}
+	var idleTimeout time.Duration
+	if !udpForwarding.IdleTimeout.IsNull() {
+		idleTimeout, err = time.ParseDuration(udpForwarding.IdleTimeout.ValueString())
+		if err != nil {
+			return o.fail("UDP Forwarding Error", fmt.Sprintf("Invalid idle timeout: %s", err))
+		}
+	}
+
+	localAddr := net.JoinHostPort(udpListenHost, strconv.Itoa(int(udpForwarding.LocalPort.ValueInt32())))
+	udpListener, err := portforward.ListenUDP(localAddr, idleTimeout)
+	if err != nil {
+		return forwardFailure("UDP Forwarding Error", fmt.Sprintf("Unable to listen on local address %s, got error: %s", localAddr, err))
+	}
+
+	conf := o.config(priorityClassInteractive)
+	conf.RemoteAddr = hostAddr(types.StringValue(remoteHost), udpForwarding.RemotePort)
+	conf.Network = "udp"
+	conf.Budget = o.r.budget
+
+	listener := portforward.Serve(context.WithoutCancel(o.ctx), udpListener, &execDialer{conn: o.conn, command: udpRelayCommand(udpForwarding)}, conf)
+	if err := o.addListener(listener, true); err != nil {
+		return err
+	}
+
+	localPort := int32(udpListener.Addr().(*net.UDPAddr).Port)
+	tflog.Info(o.ctx, "UDP forwarding created", map[string]interface{}{
+		"local_port":  localPort,
+		"remote_addr": conf.RemoteAddr,
+	})
+	o.data.UDPForwardings[i].LocalPort = basetypes.NewInt32Value(localPort)
+	return nil
+}
@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// expandUser replaces the placeholders of the user with the labels of the
+// connection and the environment, for bastions routing tenants by username.
+// Nothing is expanded while the user or the labels are unknown, or without
+// user, which is then read from the OS Login profile.
+func expandUser(data *ConnectionEphemeralResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if data.User.IsNull() || data.User.IsUnknown() || !knownLabels(data.Labels) {
+		return diags
+	}
+
+	user, err := expandUserTemplate(data.User.ValueString(), labelValues(data.Labels))
+	if err != nil {
+		diags.AddError("User Error", fmt.Sprintf("Invalid user template: %s", err))
+		return diags
+	}
+	data.User = types.StringValue(user)
+
+	return diags
+}
+
+// expandUserTemplate replaces {name} with the label name and {env.NAME} with
+// the environment variable NAME. The result must not be empty and must not
+// contain whitespace or control characters.
+func expandUserTemplate(template string, labels map[string]string) (string, error) {
+	var b strings.Builder
+
+	rest := template
+	for {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			b.WriteString(rest)
+			break
+		}
+		if rest[start] == '}' {
+			return "", errors.New("unexpected } without a preceding {")
+		}
+		b.WriteString(rest[:start])
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return "", errors.New("unterminated placeholder, missing }")
+		}
+		name := rest[start+1 : start+end]
+		rest = rest[start+end+1:]
+
+		var value string
+		var ok bool
+		if env, isEnv := strings.CutPrefix(name, "env."); isEnv {
+			if value, ok = os.LookupEnv(env); !ok {
+				return "", fmt.Errorf("environment variable %s of placeholder {%s} is not set", env, name)
+			}
+		} else if value, ok = labels[name]; !ok {
+			return "", fmt.Errorf("placeholder {%s} does not match a label of the connection", name)
+		}
+		b.WriteString(value)
+	}
+
+	user := b.String()
+	if user == "" {
+		return "", errors.New("user must not be empty")
+	}
+	if strings.IndexFunc(user, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return "", errors.New("user must not contain whitespace or control characters")
+	}
+
+	return user, nil
+}
@@ -0,0 +1,60 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestExpandUserTemplate(t *testing.T) {
+	t.Setenv("SSHTUNNEL_TEST_TENANT", "acme")
+	labels := map[string]string{"workspace": "prod"}
+
+	tests := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{template: "ubuntu", want: "ubuntu"},
+		{template: "deploy-{workspace}", want: "deploy-prod"},
+		{template: "{env.SSHTUNNEL_TEST_TENANT}+{workspace}", want: "acme+prod"},
+		{template: "deploy-{missing}", wantErr: true},
+		{template: "deploy-{env.SSHTUNNEL_TEST_UNSET}", wantErr: true},
+		{template: "deploy-{workspace", wantErr: true},
+		{template: "deploy-}", wantErr: true},
+		{template: "{workspace} admin", wantErr: true},
+		{template: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			got, err := expandUserTemplate(tt.template, labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %t, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExpandUserUnknownLabels(t *testing.T) {
+	data := ConnectionEphemeralResourceModel{
+		User:   types.StringValue("deploy-{workspace}"),
+		Labels: map[string]types.String{"workspace": types.StringUnknown()},
+	}
+	if diags := expandUser(&data); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	if data.User.ValueString() != "deploy-{workspace}" {
+		t.Errorf("Expected the user not to be expanded, got %s", data.User.ValueString())
+	}
+
+	data.Labels["workspace"] = types.StringValue("prod")
+	if diags := expandUser(&data); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	if data.User.ValueString() != "deploy-prod" {
+		t.Errorf("Expected deploy-prod, got %s", data.User.ValueString())
+	}
+}
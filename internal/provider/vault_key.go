@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/secretref"
+)
+
+const (
+	defaultVaultKVMount   = "secret"
+	defaultVaultKVField   = "private_key"
+	defaultVaultKVVersion = 2
+)
+
+// validateVaultKey validates the auth.vault block, values may be unknown.
+func validateVaultKey(v *ConnectionEphemeralResourceModelAuthVault) diag.Diagnostics {
+	diags := diag.Diagnostics{}
+
+	if !v.Path.IsUnknown() && v.Path.ValueString() == "" {
+		diags.AddError("Auth Error", "auth.vault.path must not be empty")
+	}
+	if !v.KVVersion.IsNull() && !v.KVVersion.IsUnknown() && v.KVVersion.ValueInt32() != 1 && v.KVVersion.ValueInt32() != 2 {
+		diags.AddError("Auth Error", "auth.vault.kv_version must be 1 or 2")
+	}
+
+	methods := 0
+	if !v.Token.IsNull() {
+		methods++
+	}
+	if v.AppRole != nil {
+		methods++
+	}
+	if v.Kubernetes != nil {
+		methods++
+	}
+	if methods > 1 {
+		diags.AddError("Auth Error", "At most one of auth.vault.token, auth.vault.approle or auth.vault.kubernetes can be set")
+	}
+
+	return diags
+}
+
+// vaultKeyRef returns the reference of the private key in the Vault KV secret
+// of the auth.vault block and the options to resolve it with.
+func vaultKeyRef(v *ConnectionEphemeralResourceModelAuthVault) (*secretref.Ref, secretref.Options, error) {
+	config := secretref.VaultConfig{
+		Address:   v.Address.ValueString(),
+		Namespace: v.Namespace.ValueString(),
+		Token:     v.Token.ValueString(),
+	}
+	if v.AppRole != nil {
+		config.AppRole = &secretref.VaultAppRole{
+			Mount:    v.AppRole.Mount.ValueString(),
+			RoleID:   v.AppRole.RoleID.ValueString(),
+			SecretID: v.AppRole.SecretID.ValueString(),
+		}
+	}
+	if v.Kubernetes != nil {
+		config.Kubernetes = &secretref.VaultKubernetes{
+			Mount:   v.Kubernetes.Mount.ValueString(),
+			Role:    v.Kubernetes.Role.ValueString(),
+			JWTPath: v.Kubernetes.JWTPath.ValueString(),
+		}
+	}
+
+	mount, field, version := defaultVaultKVMount, defaultVaultKVField, defaultVaultKVVersion
+	if !v.Mount.IsNull() {
+		mount = v.Mount.ValueString()
+	}
+	if !v.Field.IsNull() {
+		field = v.Field.ValueString()
+	}
+	if !v.KVVersion.IsNull() {
+		version = int(v.KVVersion.ValueInt32())
+	}
+
+	ref, err := secretref.VaultKVRef(mount, v.Path.ValueString(), field, version)
+	return ref, secretref.Options{Vault: config}, err
+}
@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/secretref"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultVaultSSHMount is the default path of the Vault SSH secrets engine.
+const defaultVaultSSHMount = "ssh"
+
+// vaultSSHAuthProvider authenticates with an ephemeral key, generated when
+// the tunnel is opened and signed by the SSH secrets engine of Vault, so no
+// long-lived key is needed.
+type vaultSSHAuthProvider struct{}
+
+func (p *vaultSSHAuthProvider) Name() string {
+	return "vault_ssh"
+}
+
+func (p *vaultSSHAuthProvider) Configured(auth ConnectionEphemeralResourceModelAuth) bool {
+	return auth.VaultSSH != nil
+}
+
+func (p *vaultSSHAuthProvider) ValidateConfig(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) diag.Diagnostics {
+	diags := diag.Diagnostics{}
+
+	if !auth.VaultSSH.Role.IsUnknown() && auth.VaultSSH.Role.ValueString() == "" {
+		diags.AddError("Vault SSH Error", "auth.vault_ssh.role must not be empty")
+	}
+	if !auth.VaultSSH.Mount.IsNull() && !auth.VaultSSH.Mount.IsUnknown() && auth.VaultSSH.Mount.ValueString() == "" {
+		diags.AddError("Vault SSH Error", "auth.vault_ssh.mount must not be empty")
+	}
+
+	return diags
+}
+
+func (p *vaultSSHAuthProvider) AuthMethods(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.AuthMethod, diag.Diagnostics) {
+	return signerAuthMethods(p.Signers(ctx, auth))
+}
+
+func (p *vaultSSHAuthProvider) Signers(ctx context.Context, auth ConnectionEphemeralResourceModelAuth) ([]ssh.Signer, diag.Diagnostics) {
+	diags := diag.Diagnostics{}
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		diags.AddError("Vault SSH Error", fmt.Sprintf("Unable to generate a key, got error: %s", err))
+		return nil, diags
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		diags.AddError("Vault SSH Error", fmt.Sprintf("Unable to generate a key, got error: %s", err))
+		return nil, diags
+	}
+
+	mount := defaultVaultSSHMount
+	if !auth.VaultSSH.Mount.IsNull() {
+		mount = auth.VaultSSH.Mount.ValueString()
+	}
+	principals := make([]string, 0, len(auth.VaultSSH.ValidPrincipals))
+	for _, principal := range auth.VaultSSH.ValidPrincipals {
+		principals = append(principals, principal.ValueString())
+	}
+
+	signed, err := secretref.SignVaultSSHKey(ctx, secretref.VaultSSHSignRequest{
+		Mount:           mount,
+		Role:            auth.VaultSSH.Role.ValueString(),
+		PublicKey:       ssh.MarshalAuthorizedKey(signer.PublicKey()),
+		ValidPrincipals: principals,
+		TTL:             auth.VaultSSH.TTL.ValueString(),
+	})
+	if err != nil {
+		diags.AddError("Vault SSH Error", fmt.Sprintf("Unable to sign the key with role %s of %s, got error: %s", auth.VaultSSH.Role.ValueString(), mount, err))
+		return nil, diags
+	}
+
+	cert, err := parseCertificate(string(signed))
+	if err != nil {
+		diags.AddError("Vault SSH Error", fmt.Sprintf("Unable to parse the certificate signed by Vault, got error: %s", err))
+		return nil, diags
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		diags.AddError("Vault SSH Error", fmt.Sprintf("Unable to use the certificate signed by Vault, got error: %s", err))
+		return nil, diags
+	}
+	tflog.Debug(ctx, "Using certificate signed by Vault", map[string]interface{}{
+		"serial":       cert.Serial,
+		"principals":   cert.ValidPrincipals,
+		"valid_before": time.Unix(int64(cert.ValidBefore), 0).UTC().Format(time.RFC3339),
+	})
+
+	return []ssh.Signer{certSigner}, diags
+}
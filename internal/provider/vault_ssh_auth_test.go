@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestVaultSSHAuthProvider(t *testing.T) {
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/ssh/sign/deploy" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body struct {
+			PublicKey string `json:"public_key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(body.PublicKey))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cert := &ssh.Certificate{
+			Key:             key,
+			CertType:        ssh.UserCert,
+			ValidPrincipals: []string{"ubuntu"},
+			ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]string{"signed_key": string(ssh.MarshalAuthorizedKey(cert))},
+		})
+	}))
+	defer server.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+
+	ctx := context.Background()
+	p := &vaultSSHAuthProvider{}
+	auth := ConnectionEphemeralResourceModelAuth{VaultSSH: &ConnectionEphemeralResourceModelAuthVaultSSH{
+		Role:  types.StringValue("deploy"),
+		Mount: types.StringNull(),
+		TTL:   types.StringNull(),
+	}}
+	if diags := p.ValidateConfig(ctx, auth); diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+
+	signers, diags := p.Signers(ctx, auth)
+	if diags.HasError() {
+		t.Fatalf("Unexpected error: %v", diags)
+	}
+	cert, ok := signers[0].PublicKey().(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("Expected a certificate, got %s", signers[0].PublicKey().Type())
+	}
+	if string(cert.SignatureKey.Marshal()) != string(ca.PublicKey().Marshal()) {
+		t.Error("Expected the certificate to be signed by the CA")
+	}
+
+	auth.VaultSSH.Role = types.StringValue("missing")
+	if _, diags := p.Signers(ctx, auth); !diags.HasError() {
+		t.Error("Expected an error for an unknown role")
+	}
+
+	auth.VaultSSH.Role = types.StringValue("")
+	if diags := p.ValidateConfig(ctx, auth); !diags.HasError() {
+		t.Error("Expected an error for an empty role")
+	}
+}
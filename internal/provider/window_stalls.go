@@ -0,0 +1,36 @@
+package provider
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+const (
+	// windowStallRatio is the share of the connection time writes to the SSH
+	// server have to be blocked for to be reported.
+	windowStallRatio = 0.5
+	// windowStallMin ignores short blocks, e.g. of a single large write.
+	windowStallMin = time.Second
+)
+
+// windowStallWatch is a local port forwarding whose connections are reported
+// when they were mostly waiting for the SSH server to accept more data.
+type windowStallWatch struct {
+	listener   *portforward.Listener
+	remoteAddr string
+}
+
+// windowStallReport describes the time the connections of stats spent
+// waiting for the SSH channel window, empty unless it dominated the time of
+// the connections.
+func windowStallReport(stats portforward.Stats) string {
+	if stats.Duration <= 0 || stats.SendBlocked < windowStallMin || float64(stats.SendBlocked) < windowStallRatio*float64(stats.Duration) {
+		return ""
+	}
+
+	return fmt.Sprintf("spent %s of %s (%d%%) waiting for the SSH server to accept more data (SSH channel window) and %s waiting for local clients",
+		stats.SendBlocked.Round(time.Millisecond), stats.Duration.Round(time.Millisecond),
+		int(100*float64(stats.SendBlocked)/float64(stats.Duration)), stats.ReceiveBlocked.Round(time.Millisecond))
+}
@@ -0,0 +1,32 @@
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+func TestWindowStallReport(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats portforward.Stats
+		want  string
+	}{
+		{name: "no connections"},
+		{name: "short", stats: portforward.Stats{SendBlocked: 500 * time.Millisecond, Duration: 600 * time.Millisecond}},
+		{name: "minor", stats: portforward.Stats{SendBlocked: 2 * time.Second, Duration: 10 * time.Second}},
+		{
+			name:  "dominating",
+			stats: portforward.Stats{SendBlocked: 8 * time.Second, ReceiveBlocked: 100 * time.Millisecond, Duration: 10 * time.Second},
+			want:  "spent 8s of 10s (80%) waiting for the SSH server to accept more data (SSH channel window) and 100ms waiting for local clients",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := windowStallReport(tt.stats); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
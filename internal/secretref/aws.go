@@ -0,0 +1,47 @@
+package secretref
+
+import (
+	"context"
+)
+
+// AWSConfig configures the access to AWS. Empty fields default to the
+// ambient credential chain, e.g. AWS_PROFILE, AWS_REGION or an instance role.
+type AWSConfig struct {
+	Region  string
+	Profile string
+	// RoleARN is a role to assume with the ambient credentials.
+	RoleARN string
+}
+
+// resolveAWSSecretsManager reads a secret string from AWS Secrets Manager.
+// The name is the secret name or ARN, a #field selects a key of a JSON
+// secret.
+func resolveAWSSecretsManager(ctx context.Context, ref *Ref, opts Options) ([]byte, error) {
+	sdk, err := registeredSDK()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := sdk.AWSSecretsManagerSecret(ctx, opts.AWS, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return extractField(secret, ref.Field)
+}
+
+// resolveAWSSSM reads a (SecureString) parameter from AWS SSM Parameter
+// Store. The name is the parameter name or ARN.
+func resolveAWSSSM(ctx context.Context, ref *Ref, opts Options) ([]byte, error) {
+	sdk, err := registeredSDK()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := sdk.AWSSSMParameter(ctx, opts.AWS, ref.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return extractField(secret, ref.Field)
+}
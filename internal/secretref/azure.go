@@ -0,0 +1,99 @@
+package secretref
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const azureKeyVaultAPIVersion = "7.4"
+
+// AzureKeyVaultRequest is a request to read a secret of Azure Key Vault.
+type AzureKeyVaultRequest struct {
+	// VaultURI is the URI of the vault, e.g. https://<vault>.vault.azure.net.
+	VaultURI string
+	// Name is the name of the secret.
+	Name string
+	// Version is the version of the secret, the current version if empty.
+	Version string
+}
+
+// DefaultAzureCredential returns the default Azure credential chain of the
+// registered SDK.
+func DefaultAzureCredential() (AzureCredential, error) {
+	sdk, err := registeredSDK()
+	if err != nil {
+		return nil, err
+	}
+	return sdk.AzureCredential()
+}
+
+// ReadAzureKeyVaultSecret reads a secret of Azure Key Vault, authenticating
+// with cred, usually DefaultAzureCredential.
+func ReadAzureKeyVaultSecret(ctx context.Context, cred AzureCredential, req AzureKeyVaultRequest) ([]byte, error) {
+	vaultURI, err := url.Parse(req.VaultURI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault URI: %w", err)
+	}
+
+	// The token audience is the vault domain of the cloud, e.g.
+	// vault.azure.net or vault.azure.cn.
+	_, domain, ok := strings.Cut(vaultURI.Hostname(), ".")
+	if !ok {
+		return nil, fmt.Errorf("invalid vault URI %q, expected https://<vault>.vault.azure.net", req.VaultURI)
+	}
+	token, err := cred.Token(ctx, []string{"https://" + domain + "/.default"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get an Azure token: %w", err)
+	}
+
+	path := "/secrets/" + url.PathEscape(req.Name)
+	if req.Version != "" {
+		path += "/" + url.PathEscape(req.Version)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(req.VaultURI, "/")+path+"?api-version="+azureKeyVaultAPIVersion, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, azureError(res)
+	}
+
+	var body struct {
+		Value *string `json:"value"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("unable to decode key vault response: %w", err)
+	}
+	if body.Value == nil {
+		return nil, errors.New("secret has no value")
+	}
+
+	return []byte(*body.Value), nil
+}
+
+// azureError describes a failed Key Vault response, including the error
+// message, e.g. that the secret is disabled.
+func azureError(res *http.Response) error {
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err == nil && body.Error.Message != "" {
+		return fmt.Errorf("key vault returned status %s: %s", res.Status, body.Error.Message)
+	}
+	return fmt.Errorf("key vault returned status %s", res.Status)
+}
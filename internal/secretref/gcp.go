@@ -0,0 +1,144 @@
+package secretref
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultGCPSecretManagerEndpoint = "https://secretmanager.googleapis.com"
+	defaultGCPSecretVersion         = "latest"
+	gcpCloudPlatformScope           = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// gcpLocation matches the location of regional secrets, which are served by
+// regional endpoints.
+var gcpLocation = regexp.MustCompile(`^projects/[^/]+/locations/([^/]+)/`)
+
+// GCPSecretRequest is a request to access a secret version of GCP Secret
+// Manager.
+type GCPSecretRequest struct {
+	// Secret is the secret ID or the resource name of the secret, e.g.
+	// projects/<project>/secrets/<secret>, optionally including the version.
+	Secret string
+	// Project is the project of a secret ID, the project of the application
+	// default credentials if empty.
+	Project string
+	// Version is the version of the secret, "latest" if empty.
+	Version string
+	// Field selects a key of a JSON secret, the whole secret if empty.
+	Field string
+	// Endpoint is the Secret Manager API endpoint, the global or regional
+	// endpoint of the secret if empty.
+	Endpoint string
+}
+
+// ReadGCPSecret accesses a secret version of GCP Secret Manager using the
+// application default credentials of the registered SDK.
+func ReadGCPSecret(ctx context.Context, req GCPSecretRequest) ([]byte, error) {
+	creds, err := gcpCredentials(ctx, gcpCloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := gcpSecretVersionName(req, creds.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := req.Endpoint
+	if endpoint == "" {
+		endpoint = defaultGCPSecretManagerEndpoint
+		if m := gcpLocation.FindStringSubmatch(name); m != nil {
+			endpoint = fmt.Sprintf("https://secretmanager.%s.rep.googleapis.com", m[1])
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(endpoint, "/")+"/v1/"+name+":access", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := creds.Client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, gcpError("secret manager", res)
+	}
+
+	var body struct {
+		Payload struct {
+			Data       string `json:"data"`
+			DataCRC32C string `json:"dataCrc32c"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("unable to decode secret manager response: %w", err)
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(body.Payload.Data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode secret: %w", err)
+	}
+	if body.Payload.DataCRC32C != "" {
+		checksum, err := strconv.ParseUint(body.Payload.DataCRC32C, 10, 32)
+		if err != nil || uint32(checksum) != crc32.Checksum(secret, crc32.MakeTable(crc32.Castagnoli)) {
+			return nil, errors.New("secret checksum mismatch, the secret was corrupted in transit")
+		}
+	}
+
+	return extractField(secret, req.Field)
+}
+
+// gcpSecretVersionName returns the resource name of the secret version of
+// req, defaultProject is the project of a secret ID without project.
+func gcpSecretVersionName(req GCPSecretRequest, defaultProject string) (string, error) {
+	name := strings.Trim(req.Secret, "/")
+	if !strings.HasPrefix(name, "projects/") {
+		project := req.Project
+		if project == "" {
+			project = defaultProject
+		}
+		if project == "" {
+			return "", fmt.Errorf("no project set for secret %q and the application default credentials have none", req.Secret)
+		}
+		name = "projects/" + project + "/secrets/" + name
+	}
+
+	if strings.Contains(name, "/versions/") {
+		if req.Version != "" {
+			return "", fmt.Errorf("secret %q already includes a version", req.Secret)
+		}
+		return name, nil
+	}
+
+	version := req.Version
+	if version == "" {
+		version = defaultGCPSecretVersion
+	}
+	return name + "/versions/" + version, nil
+}
+
+// gcpError describes a failed response of a Google API, including the error
+// message, e.g. which permission is missing.
+func gcpError(api string, res *http.Response) error {
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err == nil && body.Error.Message != "" {
+		return fmt.Errorf("%s returned status %s: %s", api, res.Status, body.Error.Message)
+	}
+	return fmt.Errorf("%s returned status %s", api, res.Status)
+}
@@ -0,0 +1,174 @@
+package secretref
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultGCPOSLoginEndpoint = "https://oslogin.googleapis.com"
+	gcpUserinfoEmailScope     = "https://www.googleapis.com/auth/userinfo.email"
+	gcpTokenInfoEndpoint      = "https://oauth2.googleapis.com/tokeninfo"
+)
+
+// GCPOSLoginRequest identifies the OS Login profile of a Google account.
+type GCPOSLoginRequest struct {
+	// Account is the email address of the user or service account, the
+	// account of the application default credentials if empty.
+	Account string
+	// Project is the project of the POSIX account, the project of the
+	// application default credentials if empty.
+	Project string
+	// Endpoint is the OS Login API endpoint, the global endpoint if empty.
+	Endpoint string
+}
+
+// GCPOSLoginKeyRequest is a request to add a public key to an OS Login
+// profile.
+type GCPOSLoginKeyRequest struct {
+	GCPOSLoginRequest
+	// PublicKey is the public key to add in authorized_keys format.
+	PublicKey []byte
+	// Expiry is the time the key is removed from the profile again.
+	Expiry time.Time
+}
+
+// gcpOSLoginProfile is the part of an OS Login profile used.
+type gcpOSLoginProfile struct {
+	PosixAccounts []struct {
+		Primary  bool   `json:"primary"`
+		Username string `json:"username"`
+	} `json:"posixAccounts"`
+}
+
+// ImportGCPOSLoginKey adds a public key with an expiry to the OS Login
+// profile of req using the application default credentials.
+func ImportGCPOSLoginKey(ctx context.Context, req GCPOSLoginKeyRequest) error {
+	body, err := json.Marshal(map[string]string{
+		"key":                strings.TrimSpace(string(req.PublicKey)),
+		"expirationTimeUsec": strconv.FormatInt(req.Expiry.UnixMicro(), 10),
+	})
+	if err != nil {
+		return err
+	}
+
+	var out struct{}
+	return gcpOSLoginDo(ctx, req.GCPOSLoginRequest, http.MethodPost, ":importSshPublicKey", body, &out)
+}
+
+// GCPOSLoginUser returns the username of the primary POSIX account of the
+// OS Login profile of req, using the application default credentials.
+func GCPOSLoginUser(ctx context.Context, req GCPOSLoginRequest) (string, error) {
+	var profile gcpOSLoginProfile
+	if err := gcpOSLoginDo(ctx, req, http.MethodGet, "/loginProfile", nil, &profile); err != nil {
+		return "", err
+	}
+
+	for _, account := range profile.PosixAccounts {
+		if account.Primary && account.Username != "" {
+			return account.Username, nil
+		}
+	}
+	for _, account := range profile.PosixAccounts {
+		if account.Username != "" {
+			return account.Username, nil
+		}
+	}
+	return "", errors.New("the OS Login profile has no POSIX account")
+}
+
+// gcpOSLoginDo sends a request for the user of req to the OS Login API and
+// decodes the response into out.
+func gcpOSLoginDo(ctx context.Context, req GCPOSLoginRequest, method, suffix string, body []byte, out interface{}) error {
+	creds, err := gcpCredentials(ctx, gcpCloudPlatformScope, gcpUserinfoEmailScope)
+	if err != nil {
+		return err
+	}
+
+	account := req.Account
+	if account == "" {
+		account, err = gcpAccount(ctx, creds)
+		if err != nil {
+			return err
+		}
+	}
+	project := req.Project
+	if project == "" {
+		project = creds.ProjectID
+	}
+
+	endpoint := req.Endpoint
+	if endpoint == "" {
+		endpoint = defaultGCPOSLoginEndpoint
+	}
+	u := strings.TrimSuffix(endpoint, "/") + "/v1/users/" + url.PathEscape(account) + suffix
+	if project != "" {
+		u += "?projectId=" + url.QueryEscape(project)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	res, err := creds.Client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return gcpError("OS Login", res)
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("unable to decode OS Login response: %w", err)
+	}
+	return nil
+}
+
+// gcpAccount returns the email address of the application default
+// credentials, read from the service account key or asked from the token
+// info endpoint, e.g. for user credentials or the metadata server.
+func gcpAccount(ctx context.Context, creds *GCPCredentials) (string, error) {
+	var key struct {
+		ClientEmail string `json:"client_email"`
+	}
+	if len(creds.JSON) > 0 && json.Unmarshal(creds.JSON, &key) == nil && key.ClientEmail != "" {
+		return key.ClientEmail, nil
+	}
+
+	token, err := creds.Token()
+	if err != nil {
+		return "", fmt.Errorf("unable to get an access token: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpTokenInfoEndpoint+"?access_token="+url.QueryEscape(token), nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := creds.Client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var info struct {
+		Email string `json:"email"`
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", gcpError("token info", res)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil || info.Email == "" {
+		return "", errors.New("unable to determine the account of the application default credentials, set the account explicitly")
+	}
+	return info.Email, nil
+}
@@ -0,0 +1,86 @@
+package secretref
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// ErrNoSDK is returned for secrets requiring the AWS, Azure or GCP SDKs if no
+// SDK was registered.
+var ErrNoSDK = errors.New("the AWS, Azure and GCP SDKs are not linked into this binary, use the provider binary built from the cloud module")
+
+// SDK provides the clients requiring the AWS, Azure and GCP SDKs. It is
+// implemented by the cloud module, which builds the released provider binary
+// and registers it, so this module builds without the SDKs.
+type SDK interface {
+	// AWSSecretsManagerSecret reads the secret string (or binary) of secretID
+	// from AWS Secrets Manager.
+	AWSSecretsManagerSecret(ctx context.Context, config AWSConfig, secretID string) ([]byte, error)
+	// AWSSSMParameter reads the decrypted value of the parameter name from
+	// SSM Parameter Store.
+	AWSSSMParameter(ctx context.Context, config AWSConfig, name string) ([]byte, error)
+	// AzureCredential returns the default Azure credential chain.
+	AzureCredential() (AzureCredential, error)
+	// GCPCredentials returns the application default credentials of GCP for
+	// scopes.
+	GCPCredentials(ctx context.Context, scopes ...string) (*GCPCredentials, error)
+}
+
+// AzureCredential provides Azure access tokens.
+type AzureCredential interface {
+	// Token returns an access token for scopes.
+	Token(ctx context.Context, scopes []string) (string, error)
+}
+
+// GCPCredentials are the application default credentials of GCP.
+type GCPCredentials struct {
+	// ProjectID is the project of the credentials, empty if unknown.
+	ProjectID string
+	// JSON is the content of the credentials file, empty e.g. for the
+	// credentials of the metadata server.
+	JSON []byte
+	// Client sends requests authenticated with the credentials.
+	Client *http.Client
+	// Token returns an access token.
+	Token func() (string, error)
+}
+
+var (
+	sdkMu sync.RWMutex
+	sdk   SDK
+)
+
+// RegisterSDK registers the SDK used to resolve secrets of AWS, Azure and GCP.
+func RegisterSDK(s SDK) {
+	sdkMu.Lock()
+	defer sdkMu.Unlock()
+
+	sdk = s
+}
+
+func registeredSDK() (SDK, error) {
+	sdkMu.RLock()
+	defer sdkMu.RUnlock()
+
+	if sdk == nil {
+		return nil, ErrNoSDK
+	}
+	return sdk, nil
+}
+
+// gcpCredentials returns the application default credentials of the
+// registered SDK.
+func gcpCredentials(ctx context.Context, scopes ...string) (*GCPCredentials, error) {
+	sdk, err := registeredSDK()
+	if err != nil {
+		return nil, err
+	}
+	creds, err := sdk.GCPCredentials(ctx, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find application default credentials: %w", err)
+	}
+	return creds, nil
+}
@@ -0,0 +1,136 @@
+// Package secretref resolves references to secrets stored in external
+// secret managers, so key material never has to pass through Terraform.
+//
+// A reference has the form "<scheme>:<name>[#<field>]", for example
+// "vault:secret/data/ssh#private_key" or
+// "aws-secretsmanager:prod/bastion#private_key".
+package secretref
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	SchemeVault             = "vault"
+	SchemeAWSSecretsManager = "aws-secretsmanager"
+	SchemeAWSSSM            = "aws-ssm"
+)
+
+type resolver func(ctx context.Context, ref *Ref, opts Options) ([]byte, error)
+
+var resolvers = map[string]resolver{
+	SchemeVault:             resolveVault,
+	SchemeAWSSecretsManager: resolveAWSSecretsManager,
+	SchemeAWSSSM:            resolveAWSSSM,
+}
+
+// Options configure the access to the secret managers. Zero values use the
+// ambient credentials of the respective secret manager.
+type Options struct {
+	Vault VaultConfig
+	AWS   AWSConfig
+}
+
+// Ref is a parsed secret reference.
+type Ref struct {
+	Scheme string
+	Name   string
+	Field  string
+}
+
+func (r *Ref) String() string {
+	s := r.Scheme + ":" + r.Name
+	if r.Field != "" {
+		s += "#" + r.Field
+	}
+	return s
+}
+
+// Parse parses and validates a secret reference.
+func Parse(ref string) (*Ref, error) {
+	scheme, rest, ok := strings.Cut(ref, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid secret reference %q, expected <scheme>:<name>[#<field>]", ref)
+	}
+
+	if _, ok := resolvers[scheme]; !ok {
+		return nil, fmt.Errorf("unsupported secret reference scheme %q, expected one of %s", scheme, strings.Join(Schemes(), ", "))
+	}
+
+	name, field, _ := strings.Cut(rest, "#")
+	if name == "" {
+		return nil, fmt.Errorf("invalid secret reference %q, secret name is empty", ref)
+	}
+
+	return &Ref{Scheme: scheme, Name: name, Field: field}, nil
+}
+
+// Schemes returns the supported reference schemes.
+func Schemes() []string {
+	schemes := make([]string, 0, len(resolvers))
+	for scheme := range resolvers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Resolve fetches the secret referenced by ref using the ambient credentials
+// of the respective secret manager.
+func Resolve(ctx context.Context, ref string) ([]byte, error) {
+	r, err := Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return ResolveRef(ctx, r, Options{})
+}
+
+// ResolveRef fetches the secret referenced by ref, accessing the secret
+// manager as configured by opts.
+func ResolveRef(ctx context.Context, ref *Ref, opts Options) ([]byte, error) {
+	resolve, ok := resolvers[ref.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported secret reference scheme %q, expected one of %s", ref.Scheme, strings.Join(Schemes(), ", "))
+	}
+
+	secret, err := resolve(ctx, ref, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+
+	return secret, nil
+}
+
+// extractField returns the given field of a JSON object secret, or the
+// secret itself if no field was requested.
+func extractField(secret []byte, field string) ([]byte, error) {
+	if field == "" {
+		return secret, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(secret, &fields); err != nil {
+		return nil, fmt.Errorf("secret is not a JSON object, can't extract field %q", field)
+	}
+
+	return fieldValue(fields, field)
+}
+
+func fieldValue(fields map[string]interface{}, field string) ([]byte, error) {
+	value, ok := fields[field]
+	if !ok {
+		return nil, fmt.Errorf("field %q not found in secret", field)
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("field %q is not a string", field)
+	}
+
+	return []byte(s), nil
+}
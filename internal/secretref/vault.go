@@ -0,0 +1,260 @@
+package secretref
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultVaultAddr              = "https://127.0.0.1:8200"
+	defaultVaultAppRoleMount      = "approle"
+	defaultVaultKubernetesMount   = "kubernetes"
+	defaultVaultKubernetesJWTPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// VaultConfig configures the access to Vault. Empty fields default to the
+// standard VAULT_ADDR, VAULT_TOKEN (or ~/.vault-token) and VAULT_NAMESPACE
+// environment. With AppRole or Kubernetes, a token is obtained by logging in.
+type VaultConfig struct {
+	Address    string
+	Namespace  string
+	Token      string
+	AppRole    *VaultAppRole
+	Kubernetes *VaultKubernetes
+}
+
+// VaultAppRole logs in to Vault using the AppRole auth method.
+type VaultAppRole struct {
+	// Mount is the path the auth method is mounted at, "approle" if empty.
+	Mount    string
+	RoleID   string
+	SecretID string
+}
+
+// VaultKubernetes logs in to Vault using the Kubernetes auth method with the
+// service account token of the pod.
+type VaultKubernetes struct {
+	// Mount is the path the auth method is mounted at, "kubernetes" if empty.
+	Mount string
+	Role  string
+	// JWTPath is the path of the service account token, the default path of
+	// the token mounted into pods if empty.
+	JWTPath string
+}
+
+// VaultKVRef returns the reference of field of the secret at path of the KV
+// secrets engine mounted at mount, of version 1 or 2.
+func VaultKVRef(mount, path, field string, version int) (*Ref, error) {
+	mount, path = strings.Trim(mount, "/"), strings.Trim(path, "/")
+	switch version {
+	case 1:
+		return &Ref{Scheme: SchemeVault, Name: mount + "/" + path, Field: field}, nil
+	case 2:
+		return &Ref{Scheme: SchemeVault, Name: mount + "/data/" + path, Field: field}, nil
+	default:
+		return nil, fmt.Errorf("unsupported KV version %d, expected 1 or 2", version)
+	}
+}
+
+// resolveVault reads a KV secret from Vault. The name is the API path of the
+// secret, e.g. "secret/data/ssh" for KV v2 or "kv/ssh" for KV v1. Empty
+// fields of opts.Vault default to the standard VAULT_ADDR, VAULT_TOKEN (or
+// ~/.vault-token) and VAULT_NAMESPACE environment.
+func resolveVault(ctx context.Context, ref *Ref, opts Options) ([]byte, error) {
+	if ref.Field == "" {
+		return nil, errors.New("vault references require a #field")
+	}
+
+	client, err := newVaultClient(ctx, opts.Vault)
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := client.request(ctx, http.MethodGet, ref.Name, nil, &body); err != nil {
+		return nil, err
+	}
+
+	fields, err := vaultKVData(body.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return fieldValue(fields, ref.Field)
+}
+
+// vaultKVData returns the fields of the KV secret data, which KV v2 nests
+// next to its metadata. The data of deleted KV v2 versions is null.
+func vaultKVData(data map[string]interface{}) (map[string]interface{}, error) {
+	nested, ok := data["data"]
+	if !ok {
+		return data, nil
+	}
+	for key := range data {
+		if key != "data" && key != "metadata" {
+			return data, nil
+		}
+	}
+
+	switch nested := nested.(type) {
+	case map[string]interface{}:
+		return nested, nil
+	case nil:
+		return nil, errors.New("the secret has no data, it may have been deleted")
+	default:
+		return data, nil
+	}
+}
+
+// vaultClient sends requests to the Vault API.
+type vaultClient struct {
+	addr      string
+	namespace string
+	token     string
+}
+
+func newVaultClient(ctx context.Context, config VaultConfig) (*vaultClient, error) {
+	c := &vaultClient{addr: config.Address, namespace: config.Namespace}
+	if c.addr == "" {
+		c.addr = os.Getenv("VAULT_ADDR")
+	}
+	if c.addr == "" {
+		c.addr = defaultVaultAddr
+	}
+	if c.namespace == "" {
+		c.namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+
+	var err error
+	switch {
+	case config.AppRole != nil:
+		mount := config.AppRole.Mount
+		if mount == "" {
+			mount = defaultVaultAppRoleMount
+		}
+		err = c.login(ctx, mount, map[string]string{"role_id": config.AppRole.RoleID, "secret_id": config.AppRole.SecretID})
+	case config.Kubernetes != nil:
+		mount := config.Kubernetes.Mount
+		if mount == "" {
+			mount = defaultVaultKubernetesMount
+		}
+		jwtPath := config.Kubernetes.JWTPath
+		if jwtPath == "" {
+			jwtPath = defaultVaultKubernetesJWTPath
+		}
+		jwt, readErr := os.ReadFile(jwtPath)
+		if readErr != nil {
+			return nil, fmt.Errorf("unable to read the kubernetes service account token: %w", readErr)
+		}
+		err = c.login(ctx, mount, map[string]string{"role": config.Kubernetes.Role, "jwt": strings.TrimSpace(string(jwt))})
+	case config.Token != "":
+		c.token = config.Token
+	default:
+		c.token, err = vaultToken()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// login obtains a token from the auth method mounted at mount.
+func (c *vaultClient) login(ctx context.Context, mount string, credentials map[string]string) error {
+	var out struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := c.request(ctx, http.MethodPost, "auth/"+strings.Trim(mount, "/")+"/login", credentials, &out); err != nil {
+		return fmt.Errorf("unable to log in to vault using auth/%s: %w", strings.Trim(mount, "/"), err)
+	}
+	if out.Auth.ClientToken == "" {
+		return fmt.Errorf("unable to log in to vault using auth/%s: no token returned", strings.Trim(mount, "/"))
+	}
+
+	c.token = out.Auth.ClientToken
+	return nil
+}
+
+// request sends a request with a JSON body, if not nil, to the Vault API
+// path and decodes the JSON response into out.
+func (c *vaultClient) request(ctx context.Context, method, path string, in, out interface{}) error {
+	var reqBody io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), reqBody)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return vaultError(res)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("unable to decode vault response: %w", err)
+	}
+	return nil
+}
+
+// vaultError describes a failed Vault response, including the errors Vault
+// returned, e.g. why a role denied signing.
+func vaultError(res *http.Response) error {
+	var body struct {
+		Errors []string `json:"errors"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err == nil && len(body.Errors) > 0 {
+		return fmt.Errorf("vault returned status %s: %s", res.Status, strings.Join(body.Errors, ", "))
+	}
+	return fmt.Errorf("vault returned status %s", res.Status)
+}
+
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.New("no vault token found, set VAULT_TOKEN")
+	}
+
+	b, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+	if err != nil {
+		return "", errors.New("no vault token found, set VAULT_TOKEN or login using the vault CLI")
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
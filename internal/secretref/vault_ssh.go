@@ -0,0 +1,59 @@
+package secretref
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// VaultSSHSignRequest is a request to sign a public key with the SSH secrets
+// engine of Vault.
+type VaultSSHSignRequest struct {
+	// Mount is the path the SSH secrets engine is mounted at, e.g. "ssh".
+	Mount string
+	// Role is the role to sign with.
+	Role string
+	// PublicKey is the public key to sign in authorized_keys format.
+	PublicKey []byte
+	// ValidPrincipals are the users the certificate is valid for, the
+	// default user of the role if empty.
+	ValidPrincipals []string
+	// TTL is the validity of the certificate, the TTL of the role if empty.
+	TTL string
+}
+
+// SignVaultSSHKey signs a public key with the SSH secrets engine of Vault and
+// returns the user certificate in authorized_keys format. Vault is accessed
+// like for vault references.
+func SignVaultSSHKey(ctx context.Context, req VaultSSHSignRequest) ([]byte, error) {
+	in := map[string]interface{}{
+		"public_key": string(req.PublicKey),
+		"cert_type":  "user",
+	}
+	if len(req.ValidPrincipals) > 0 {
+		in["valid_principals"] = strings.Join(req.ValidPrincipals, ",")
+	}
+	if req.TTL != "" {
+		in["ttl"] = req.TTL
+	}
+
+	var out struct {
+		Data struct {
+			SignedKey string `json:"signed_key"`
+		} `json:"data"`
+	}
+	client, err := newVaultClient(ctx, VaultConfig{})
+	if err != nil {
+		return nil, err
+	}
+	path := strings.Trim(req.Mount, "/") + "/sign/" + req.Role
+	if err := client.request(ctx, http.MethodPost, path, in, &out); err != nil {
+		return nil, err
+	}
+	if out.Data.SignedKey == "" {
+		return nil, errors.New("vault returned no signed key")
+	}
+
+	return []byte(out.Data.SignedKey), nil
+}
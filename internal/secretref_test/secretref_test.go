@@ -0,0 +1,387 @@
+package secretref_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/secretref"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    secretref.Ref
+		wantErr bool
+	}{
+		{ref: "vault:secret/data/ssh#private_key", want: secretref.Ref{Scheme: "vault", Name: "secret/data/ssh", Field: "private_key"}},
+		{ref: "aws-secretsmanager:arn:aws:secretsmanager:eu-west-1:123456789012:secret:ssh", want: secretref.Ref{Scheme: "aws-secretsmanager", Name: "arn:aws:secretsmanager:eu-west-1:123456789012:secret:ssh"}},
+		{ref: "aws-ssm:/ssh/private_key", want: secretref.Ref{Scheme: "aws-ssm", Name: "/ssh/private_key"}},
+		{ref: "secret/data/ssh", wantErr: true},
+		{ref: "unknown:secret", wantErr: true},
+		{ref: "vault:#private_key", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := secretref.Parse(tt.ref)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/ssh":
+			_, _ = w.Write([]byte(`{"data":{"data":{"private_key":"kv2-key"},"metadata":{"version":1}}}`))
+		case "/v1/kv/ssh":
+			_, _ = w.Write([]byte(`{"data":{"private_key":"kv1-key"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+
+	tests := map[string]string{
+		"vault:secret/data/ssh#private_key": "kv2-key",
+		"vault:kv/ssh#private_key":          "kv1-key",
+	}
+
+	for ref, want := range tests {
+		got, err := secretref.Resolve(context.Background(), ref)
+		if err != nil {
+			t.Fatalf("failed to resolve %s: %v", ref, err)
+		}
+		if string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	if _, err := secretref.Resolve(context.Background(), "vault:secret/data/missing#private_key"); err == nil {
+		t.Error("expected an error resolving a missing secret")
+	}
+}
+
+func TestSignVaultSSHKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/ssh-client/sign/deploy" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":["unknown role"]}`))
+			return
+		}
+
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if body["public_key"] != "ssh-ed25519 AAAA" || body["valid_principals"] != "ubuntu,admin" || body["ttl"] != "5m" || body["cert_type"] != "user" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["unexpected request"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"serial_number":"1","signed_key":"ssh-ed25519-cert-v01@openssh.com AAAA"}}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+
+	req := secretref.VaultSSHSignRequest{
+		Mount:           "ssh-client/",
+		Role:            "deploy",
+		PublicKey:       []byte("ssh-ed25519 AAAA"),
+		ValidPrincipals: []string{"ubuntu", "admin"},
+		TTL:             "5m",
+	}
+	signed, err := secretref.SignVaultSSHKey(context.Background(), req)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	if string(signed) != "ssh-ed25519-cert-v01@openssh.com AAAA" {
+		t.Errorf("got %q", signed)
+	}
+
+	req.Role = "missing"
+	if _, err := secretref.SignVaultSSHKey(context.Background(), req); err == nil || !strings.Contains(err.Error(), "unknown role") {
+		t.Errorf("expected the error of vault, got %v", err)
+	}
+}
+
+func TestResolveVaultKV(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login", "/v1/auth/k8s/login":
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if (body["role_id"] == "role" && body["secret_id"] == "secret") || (body["role"] == "deploy" && body["jwt"] == "service-account-jwt") {
+				_, _ = w.Write([]byte(`{"auth":{"client_token":"login-token"}}`))
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["invalid credentials"]}`))
+			return
+		}
+
+		if token := r.Header.Get("X-Vault-Token"); token != "login-token" && token != "config-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/ssh/bastion":
+			_, _ = w.Write([]byte(`{"data":{"data":{"private_key":"kv2-key"},"metadata":{"version":1}}}`))
+		case "/v1/kv/ssh/bastion":
+			_, _ = w.Write([]byte(`{"data":{"key":"kv1-key"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("VAULT_TOKEN", "")
+	jwtPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(jwtPath, []byte("service-account-jwt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	kv2, err := secretref.VaultKVRef("secret", "ssh/bastion", "private_key", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv1, err := secretref.VaultKVRef("/kv/", "ssh/bastion", "key", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secretref.VaultKVRef("kv", "ssh/bastion", "key", 3); err == nil {
+		t.Error("expected an error for an unsupported KV version")
+	}
+
+	tests := []struct {
+		name   string
+		config secretref.VaultConfig
+		ref    *secretref.Ref
+		want   string
+	}{
+		{name: "token", config: secretref.VaultConfig{Address: server.URL, Token: "config-token"}, ref: kv2, want: "kv2-key"},
+		{name: "approle", config: secretref.VaultConfig{Address: server.URL, AppRole: &secretref.VaultAppRole{RoleID: "role", SecretID: "secret"}}, ref: kv1, want: "kv1-key"},
+		{name: "kubernetes", config: secretref.VaultConfig{Address: server.URL, Kubernetes: &secretref.VaultKubernetes{Mount: "k8s", Role: "deploy", JWTPath: jwtPath}}, ref: kv2, want: "kv2-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := secretref.ResolveRef(context.Background(), tt.ref, secretref.Options{Vault: tt.config})
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	_, err = secretref.ResolveRef(context.Background(), kv2, secretref.Options{Vault: secretref.VaultConfig{Address: server.URL, AppRole: &secretref.VaultAppRole{RoleID: "role", SecretID: "wrong"}}})
+	if err == nil || !strings.Contains(err.Error(), "invalid credentials") {
+		t.Errorf("expected a login error, got %v", err)
+	}
+}
+
+func TestResolveWithoutSDK(t *testing.T) {
+	_, err := secretref.Resolve(context.Background(), "aws-ssm:/ssh/bastion")
+	if !errors.Is(err, secretref.ErrNoSDK) {
+		t.Errorf("expected ErrNoSDK, got %v", err)
+	}
+	if _, err := secretref.DefaultAzureCredential(); !errors.Is(err, secretref.ErrNoSDK) {
+		t.Errorf("expected ErrNoSDK, got %v", err)
+	}
+	if _, err := secretref.ReadGCPSecret(context.Background(), secretref.GCPSecretRequest{Secret: "bastion"}); !errors.Is(err, secretref.ErrNoSDK) {
+		t.Errorf("expected ErrNoSDK, got %v", err)
+	}
+}
+
+// gcpTestSDK implements the GCP credentials of secretref.SDK, sending token
+// with every request.
+type gcpTestSDK struct {
+	secretref.SDK
+	projectID string
+	token     string
+}
+
+func (s gcpTestSDK) GCPCredentials(context.Context, ...string) (*secretref.GCPCredentials, error) {
+	return &secretref.GCPCredentials{
+		ProjectID: s.projectID,
+		Client:    &http.Client{Transport: bearerTransport(s.token)},
+		Token:     func() (string, error) { return s.token, nil },
+	}, nil
+}
+
+// bearerTransport authenticates requests with the token.
+type bearerTransport string
+
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+string(t))
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestReadGCPSecret(t *testing.T) {
+	payload := func(data string) string {
+		return fmt.Sprintf(`{"payload":{"data":%q,"dataCrc32c":"%d"}}`,
+			base64.StdEncoding.EncodeToString([]byte(data)), crc32.Checksum([]byte(data), crc32.MakeTable(crc32.Castagnoli)))
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/projects/default-project/secrets/bastion/versions/latest:access":
+			_, _ = w.Write([]byte(payload("default-key")))
+		case "/v1/projects/ops/secrets/bastion/versions/3:access":
+			_, _ = w.Write([]byte(payload(`{"private_key":"json-key"}`)))
+		case "/v1/projects/ops/secrets/corrupted/versions/latest:access":
+			_, _ = w.Write([]byte(`{"payload":{"data":"a2V5","dataCrc32c":"1"}}`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"code":403,"message":"Permission 'secretmanager.versions.access' denied"}}`))
+		}
+	}))
+	defer server.Close()
+
+	secretref.RegisterSDK(gcpTestSDK{projectID: "default-project", token: "test-token"})
+	defer secretref.RegisterSDK(nil)
+
+	tests := []struct {
+		name string
+		req  secretref.GCPSecretRequest
+		want string
+	}{
+		{name: "secret id", req: secretref.GCPSecretRequest{Secret: "bastion"}, want: "default-key"},
+		{name: "project and version", req: secretref.GCPSecretRequest{Secret: "bastion", Project: "ops", Version: "3", Field: "private_key"}, want: "json-key"},
+		{name: "resource name", req: secretref.GCPSecretRequest{Secret: "projects/ops/secrets/bastion/versions/3", Field: "private_key"}, want: "json-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Endpoint = server.URL
+			got, err := secretref.ReadGCPSecret(context.Background(), tt.req)
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	errorTests := []struct {
+		name      string
+		req       secretref.GCPSecretRequest
+		wantError string
+	}{
+		{name: "denied", req: secretref.GCPSecretRequest{Secret: "missing"}, wantError: "secretmanager.versions.access"},
+		{name: "corrupted", req: secretref.GCPSecretRequest{Secret: "projects/ops/secrets/corrupted"}, wantError: "checksum mismatch"},
+		{name: "two versions", req: secretref.GCPSecretRequest{Secret: "projects/ops/secrets/bastion/versions/3", Version: "4"}, wantError: "already includes a version"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Endpoint = server.URL
+			_, err := secretref.ReadGCPSecret(context.Background(), tt.req)
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantError, err)
+			}
+		})
+	}
+}
+
+// staticAzureCredential returns a fixed token for the Key Vault scope.
+type staticAzureCredential struct{}
+
+func (staticAzureCredential) Token(_ context.Context, scopes []string) (string, error) {
+	if len(scopes) != 1 || scopes[0] != "https://vault.azure.net/.default" {
+		return "", fmt.Errorf("unexpected scopes %v", scopes)
+	}
+	return "test-token", nil
+}
+
+func TestReadAzureKeyVaultSecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" || r.URL.Query().Get("api-version") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/secrets/bastion":
+			_, _ = w.Write([]byte(`{"value":"current-key"}`))
+		case "/secrets/bastion/0123abcd":
+			_, _ = w.Write([]byte(`{"value":"old-key"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":"SecretNotFound","message":"A secret with (name/id) missing was not found in this key vault."}}`))
+		}
+	}))
+	defer server.Close()
+
+	// The vault domain, used as the token audience, is taken from the URI.
+	vaultURI := strings.Replace(server.URL, "127.0.0.1", "127.vault.azure.net", 1)
+	defaultTransport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		},
+	}
+	t.Cleanup(func() { http.DefaultClient.Transport = defaultTransport })
+
+	tests := []struct {
+		name string
+		req  secretref.AzureKeyVaultRequest
+		want string
+	}{
+		{name: "current", req: secretref.AzureKeyVaultRequest{VaultURI: vaultURI, Name: "bastion"}, want: "current-key"},
+		{name: "version", req: secretref.AzureKeyVaultRequest{VaultURI: vaultURI + "/", Name: "bastion", Version: "0123abcd"}, want: "old-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := secretref.ReadAzureKeyVaultSecret(context.Background(), staticAzureCredential{}, tt.req)
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	_, err := secretref.ReadAzureKeyVaultSecret(context.Background(), staticAzureCredential{}, secretref.AzureKeyVaultRequest{VaultURI: vaultURI, Name: "missing"})
+	if err == nil || !strings.Contains(err.Error(), "was not found") {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
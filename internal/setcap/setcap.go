@@ -0,0 +1,59 @@
+// Package setcap implements the setcap subcommand of the provider binary,
+// which grants the binary CAP_NET_BIND_SERVICE on Linux so local port
+// forwardings can listen on ports below 1024 without running Terraform as
+// root:
+//
+//	sudo terraform-provider-sshtunnel setcap
+//
+// The capability is lost when the provider is reinstalled.
+package setcap
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// Main runs the setcap subcommand with args, excluding the command name.
+func Main(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("setcap", flag.ContinueOnError)
+	remove := flags.Bool("remove", false, "remove the capability instead")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("setcap is not supported on %s", runtime.GOOS)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	capability := "cap_net_bind_service=+ep"
+	if *remove {
+		capability = "cap_net_bind_service=-ep"
+	}
+
+	cmd := exec.CommandContext(ctx, "setcap", capability, exe)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return errors.New("setcap not found, install libcap (e.g. the libcap2-bin package)")
+		}
+		return fmt.Errorf("setcap %s %s: %w", capability, exe, err)
+	}
+
+	fmt.Fprintf(os.Stdout, "%s %s\n", capability, exe)
+	return nil
+}
@@ -0,0 +1,115 @@
+package sshcmd
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// defaultIdentityFile is used if the command line doesn't name one, ssh
+// tries several default identity files in this case.
+const defaultIdentityFile = "~/.ssh/id_ed25519"
+
+// HCL renders the command as an sshtunnel_connection ephemeral resource
+// block named name. Unsupported options are listed as comments.
+func (c *Command) HCL(name string) []byte {
+	f := hclwrite.NewEmptyFile()
+	root := f.Body()
+
+	for _, unsupported := range c.Unsupported {
+		appendComment(root, "Not supported: "+unsupported)
+	}
+
+	block := root.AppendNewBlock("ephemeral", []string{"sshtunnel_connection", name})
+	body := block.Body()
+
+	body.SetAttributeValue("host", cty.StringVal(c.Host))
+	if c.Port != 0 {
+		body.SetAttributeValue("port", cty.NumberIntVal(int64(c.Port)))
+	}
+	if c.User == "" {
+		appendComment(body, "TODO: set the user, ssh defaults to the local user")
+	}
+	body.SetAttributeValue("user", cty.StringVal(c.User))
+	body.AppendNewline()
+
+	identityFile := c.IdentityFile
+	if identityFile == "" {
+		appendComment(body, "TODO: no identity file given, ssh tries its default identity files")
+		identityFile = defaultIdentityFile
+	}
+	// The key is read when the tunnel is opened, so it doesn't pass
+	// through Terraform values.
+	auth := []hclwrite.ObjectAttrTokens{objectAttr("private_key_path", cty.StringVal(identityFile))}
+	if c.CertificateFile != "" {
+		auth = append(auth, fileAttr("certificate", c.CertificateFile))
+	}
+	body.SetAttributeRaw("auth", hclwrite.TokensForObject(auth))
+
+	if len(c.LocalForwards) > 0 {
+		body.AppendNewline()
+		forwardings := []hclwrite.Tokens{}
+		for _, forward := range c.LocalForwards {
+			if forward.BindAddress != "" && forward.BindAddress != "*" && forward.BindAddress != "0.0.0.0" {
+				appendComment(body, fmt.Sprintf("Bind address %s of local port %d is not supported, local ports listen on all interfaces", forward.BindAddress, forward.LocalPort))
+			}
+
+			attrs := []hclwrite.ObjectAttrTokens{}
+			if forward.LocalPort != 0 {
+				attrs = append(attrs, objectAttr("local_port", cty.NumberIntVal(int64(forward.LocalPort))))
+			}
+			attrs = append(attrs,
+				objectAttr("remote_host", cty.StringVal(forward.RemoteHost)),
+				objectAttr("remote_port", cty.NumberIntVal(int64(forward.RemotePort))),
+			)
+			forwardings = append(forwardings, hclwrite.TokensForObject(attrs))
+		}
+		body.SetAttributeRaw("local_port_forwardings", hclwrite.TokensForTuple(forwardings))
+	}
+
+	if len(c.RemoteSocketForwards) > 0 {
+		body.AppendNewline()
+		forwardings := []hclwrite.Tokens{}
+		for _, forward := range c.RemoteSocketForwards {
+			forwardings = append(forwardings, hclwrite.TokensForObject([]hclwrite.ObjectAttrTokens{
+				objectAttr("remote_socket_path", cty.StringVal(forward.RemoteSocketPath)),
+				objectAttr("local_host", cty.StringVal(forward.LocalHost)),
+				objectAttr("local_port", cty.NumberIntVal(int64(forward.LocalPort))),
+			}))
+		}
+		body.SetAttributeRaw("remote_socket_forwardings", hclwrite.TokensForTuple(forwardings))
+	}
+
+	if c.ExitOnForwardFailure != nil {
+		body.AppendNewline()
+		body.SetAttributeValue("exit_on_forward_failure", cty.BoolVal(*c.ExitOnForwardFailure))
+	}
+
+	return hclwrite.Format(f.Bytes())
+}
+
+func objectAttr(name string, value cty.Value) hclwrite.ObjectAttrTokens {
+	return hclwrite.ObjectAttrTokens{
+		Name:  hclwrite.TokensForIdentifier(name),
+		Value: hclwrite.TokensForValue(value),
+	}
+}
+
+// fileAttr reads the file at path into the attribute name.
+func fileAttr(name, path string) hclwrite.ObjectAttrTokens {
+	return hclwrite.ObjectAttrTokens{
+		Name: hclwrite.TokensForIdentifier(name),
+		Value: hclwrite.TokensForFunctionCall("file",
+			hclwrite.TokensForFunctionCall("pathexpand", hclwrite.TokensForValue(cty.StringVal(path))),
+		),
+	}
+}
+
+func appendComment(body *hclwrite.Body, comment string) {
+	body.AppendUnstructuredTokens(hclwrite.Tokens{{
+		Type:  hclsyntax.TokenComment,
+		Bytes: []byte("# " + comment + "\n"),
+	}})
+}
@@ -0,0 +1,293 @@
+// Package sshcmd converts OpenSSH client command lines, e.g. from shell
+// scripts wrapping Terraform, into sshtunnel_connection configurations.
+package sshcmd
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Command is a parsed ssh command line.
+type Command struct {
+	Host         string
+	Port         int
+	User         string
+	IdentityFile string
+	// CertificateFile is the OpenSSH certificate used with IdentityFile.
+	CertificateFile string
+
+	LocalForwards        []LocalForward
+	RemoteSocketForwards []RemoteSocketForward
+	// ExitOnForwardFailure is nil if not set.
+	ExitOnForwardFailure *bool
+
+	// Unsupported lists the options and arguments that have no equivalent.
+	Unsupported []string
+}
+
+// LocalForward is a -L forwarding of a local TCP port.
+type LocalForward struct {
+	BindAddress string
+	LocalPort   int
+	RemoteHost  string
+	RemotePort  int
+}
+
+// RemoteSocketForward is a -R forwarding of a Unix socket on the server to a
+// local TCP address.
+type RemoteSocketForward struct {
+	RemoteSocketPath string
+	LocalHost        string
+	LocalPort        int
+}
+
+// optionsWithArgument are the ssh options taking an argument.
+const optionsWithArgument = "BbcDEeFIiJLlmOoPpQRSWw"
+
+// ignoredOptions are ssh flags without effect on the tunnel.
+const ignoredOptions = "46AaCfGgKkMNnqsTtVvXxYy"
+
+// Parse parses the arguments of an ssh command line. A leading "ssh" is
+// skipped.
+func Parse(args []string) (*Command, error) {
+	if len(args) > 0 && (args[0] == "ssh" || strings.HasSuffix(args[0], "/ssh")) {
+		args = args[1:]
+	}
+
+	c := &Command{}
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			args = append([]string{arg}, args...)
+			break
+		}
+
+		// Flags can be combined, e.g. -NfL 5432:db:5432.
+		for i := 1; i < len(arg); i++ {
+			opt := arg[i]
+			if !strings.ContainsRune(optionsWithArgument, rune(opt)) {
+				if !strings.ContainsRune(ignoredOptions, rune(opt)) {
+					return nil, fmt.Errorf("unknown option -%c", opt)
+				}
+				continue
+			}
+
+			value := arg[i+1:]
+			if value == "" {
+				if len(args) == 0 {
+					return nil, fmt.Errorf("option -%c requires an argument", opt)
+				}
+				value = args[0]
+				args = args[1:]
+			}
+			if err := c.option(opt, value); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing destination")
+	}
+	if err := c.destination(args[0]); err != nil {
+		return nil, err
+	}
+	if len(args) > 1 {
+		c.Unsupported = append(c.Unsupported, "remote command "+strings.Join(args[1:], " "))
+	}
+
+	return c, nil
+}
+
+func (c *Command) option(opt byte, value string) error {
+	var err error
+	switch opt {
+	case 'L':
+		err = c.localForward(value)
+	case 'R':
+		err = c.remoteForward(value)
+	case 'p':
+		c.Port, err = parsePort(value)
+	case 'l':
+		c.User = value
+	case 'i':
+		c.IdentityFile = value
+	case 'o':
+		err = c.configOption(value)
+	case 'D':
+		c.Unsupported = append(c.Unsupported, "-D "+value+" (dynamic forwarding)")
+	case 'J':
+		c.Unsupported = append(c.Unsupported, "-J "+value+" (jump hosts)")
+	default:
+		c.Unsupported = append(c.Unsupported, fmt.Sprintf("-%c %s", opt, value))
+	}
+	return err
+}
+
+// configOption handles -o Key=Value and -o "Key Value".
+func (c *Command) configOption(option string) error {
+	key, value, ok := strings.Cut(option, "=")
+	if !ok {
+		key, value, _ = strings.Cut(option, " ")
+	}
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+	var err error
+	switch strings.ToLower(key) {
+	case "port":
+		c.Port, err = parsePort(value)
+	case "user":
+		c.User = value
+	case "identityfile":
+		c.IdentityFile = value
+	case "certificatefile":
+		c.CertificateFile = value
+	case "localforward":
+		err = c.localForward(strings.Replace(value, " ", ":", 1))
+	case "remoteforward":
+		err = c.remoteForward(strings.Replace(value, " ", ":", 1))
+	case "exitonforwardfailure":
+		enabled := strings.EqualFold(value, "yes")
+		c.ExitOnForwardFailure = &enabled
+	case "stricthostkeychecking", "userknownhostsfile", "batchmode", "loglevel":
+		// Host key verification and logging are configured on the provider.
+	default:
+		c.Unsupported = append(c.Unsupported, "-o "+option)
+	}
+	return err
+}
+
+func (c *Command) localForward(spec string) error {
+	parts := splitSpec(spec)
+
+	f := LocalForward{}
+	switch len(parts) {
+	case 3:
+	case 4:
+		f.BindAddress = parts[0]
+		parts = parts[1:]
+	default:
+		c.Unsupported = append(c.Unsupported, "-L "+spec+" (Unix socket forwarding)")
+		return nil
+	}
+
+	var err error
+	if f.LocalPort, err = parsePort(parts[0]); err != nil {
+		return fmt.Errorf("-L %s: %w", spec, err)
+	}
+	f.RemoteHost = parts[1]
+	if f.RemotePort, err = parsePort(parts[2]); err != nil {
+		return fmt.Errorf("-L %s: %w", spec, err)
+	}
+
+	c.LocalForwards = append(c.LocalForwards, f)
+	return nil
+}
+
+func (c *Command) remoteForward(spec string) error {
+	parts := splitSpec(spec)
+	if len(parts) != 3 || !strings.Contains(parts[0], "/") {
+		c.Unsupported = append(c.Unsupported, "-R "+spec+" (remote port forwarding)")
+		return nil
+	}
+
+	port, err := parsePort(parts[2])
+	if err != nil {
+		return fmt.Errorf("-R %s: %w", spec, err)
+	}
+
+	c.RemoteSocketForwards = append(c.RemoteSocketForwards, RemoteSocketForward{
+		RemoteSocketPath: parts[0],
+		LocalHost:        parts[1],
+		LocalPort:        port,
+	})
+	return nil
+}
+
+// destination parses [user@]host[:port] and ssh://[user@]host[:port].
+func (c *Command) destination(dest string) error {
+	if strings.HasPrefix(dest, "ssh://") {
+		u, err := url.Parse(dest)
+		if err != nil {
+			return fmt.Errorf("invalid destination: %w", err)
+		}
+		if u.User != nil {
+			c.User = u.User.Username()
+		}
+		c.Host = u.Hostname()
+		if u.Port() != "" {
+			if c.Port, err = parsePort(u.Port()); err != nil {
+				return fmt.Errorf("invalid destination: %w", err)
+			}
+		}
+		return nil
+	}
+
+	if user, host, ok := strings.Cut(dest, "@"); ok {
+		c.User = user
+		dest = host
+	}
+	c.Host = strings.Trim(dest, "[]")
+	return nil
+}
+
+// splitSpec splits a forwarding specification at colons outside of
+// brackets, which enclose IPv6 addresses.
+func splitSpec(spec string) []string {
+	parts := []string{}
+	depth, start := 0, 0
+	for i, r := range spec {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				parts = append(parts, strings.Trim(spec[start:i], "[]"))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.Trim(spec[start:], "[]"))
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// Main converts the ssh command line args, optionally preceded by -name and
+// "--", and writes the resulting block to stdout.
+func Main(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: terraform-provider-sshtunnel convert [-name NAME] -- ssh [options] destination")
+		flags.PrintDefaults()
+	}
+	name := flags.String("name", "tunnel", "name of the ephemeral resource")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := Parse(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(c.HCL(*name))
+	return err
+}
@@ -0,0 +1,95 @@
+package sshcmd_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/sshcmd"
+)
+
+func TestParse(t *testing.T) {
+	c, err := sshcmd.Parse(strings.Fields("ssh -NfL 5432:db.internal:5432 -L 127.0.0.1:6379:[fe80::1]:6379 -p 2222 -i ~/.ssh/key -o CertificateFile=~/.ssh/key-cert.pub -o ExitOnForwardFailure=yes -R /tmp/app.sock:localhost:8080 ubuntu@bastion"))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	enabled := true
+	want := &sshcmd.Command{
+		Host:            "bastion",
+		Port:            2222,
+		User:            "ubuntu",
+		IdentityFile:    "~/.ssh/key",
+		CertificateFile: "~/.ssh/key-cert.pub",
+		LocalForwards: []sshcmd.LocalForward{
+			{LocalPort: 5432, RemoteHost: "db.internal", RemotePort: 5432},
+			{BindAddress: "127.0.0.1", LocalPort: 6379, RemoteHost: "fe80::1", RemotePort: 6379},
+		},
+		RemoteSocketForwards: []sshcmd.RemoteSocketForward{
+			{RemoteSocketPath: "/tmp/app.sock", LocalHost: "localhost", LocalPort: 8080},
+		},
+		ExitOnForwardFailure: &enabled,
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+}
+
+func TestParse_Unsupported(t *testing.T) {
+	c, err := sshcmd.Parse(strings.Fields("ssh -D 1080 -J jump -R 8080:localhost:80 ssh://ubuntu@bastion:2222 uptime"))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if c.Host != "bastion" || c.Port != 2222 || c.User != "ubuntu" {
+		t.Errorf("unexpected destination %+v", c)
+	}
+	if len(c.Unsupported) != 4 {
+		t.Errorf("Expected 4 unsupported entries, got %q", c.Unsupported)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"missing destination": "ssh -L 5432:db:5432",
+		"missing argument":    "ssh -p",
+		"invalid port":        "ssh -L 5432:db:postgres bastion",
+		"unknown option":      "ssh -Z bastion",
+	}
+
+	for name, cmd := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := sshcmd.Parse(strings.Fields(cmd)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestHCL(t *testing.T) {
+	c, err := sshcmd.Parse(strings.Fields("ssh -N -L 5432:db.internal:5432 -o CertificateFile=~/.ssh/id_ed25519-cert.pub -J jump bastion"))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	src := c.HCL("db")
+	if _, diags := hclsyntax.ParseConfig(src, "tunnel.tf", hcl.InitialPos); diags.HasErrors() {
+		t.Fatalf("Generated invalid HCL: %s\n%s", diags, src)
+	}
+
+	for _, want := range []string{
+		`# Not supported: -J jump`,
+		`ephemeral "sshtunnel_connection" "db" {`,
+		`host = "bastion"`,
+		`private_key_path = "~/.ssh/id_ed25519"`,
+		`certificate      = file(pathexpand("~/.ssh/id_ed25519-cert.pub"))`,
+		`remote_host = "db.internal"`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("Expected %q in\n%s", want, src)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Package sshconfig resolves host settings and host key verification from
+// OpenSSH configuration and known_hosts files.
+package sshconfig
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/kevinburke/ssh_config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SystemConfigFile returns the path of the system-wide OpenSSH client config.
+func SystemConfigFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "ssh", "ssh_config")
+	}
+	return "/etc/ssh/ssh_config"
+}
+
+// SystemKnownHostsFile returns the path of the system-wide known_hosts file.
+func SystemKnownHostsFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "ssh", "ssh_known_hosts")
+	}
+	return "/etc/ssh/ssh_known_hosts"
+}
+
+// Settings are the settings of a host resolved from OpenSSH config files.
+type Settings struct {
+	// HostName is the real host name to connect to, empty if not configured.
+	HostName string
+	// GlobalKnownHostsFiles are additional known_hosts files to verify the
+	// host key against.
+	GlobalKnownHostsFiles []string
+}
+
+// Resolve returns the settings for host from the given config files. Like
+// OpenSSH, the first obtained value of each setting wins, so files have to
+// be passed in order of precedence. Missing files are skipped.
+func Resolve(host string, files ...string) (*Settings, error) {
+	settings := &Settings{}
+
+	for _, file := range files {
+		cfg, err := decodeFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if cfg == nil {
+			continue
+		}
+
+		if settings.HostName == "" {
+			hostName, err := cfg.Get(host, "HostName")
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			settings.HostName = expandHostName(hostName, host)
+		}
+
+		if settings.GlobalKnownHostsFiles == nil {
+			knownHostsFiles, err := cfg.Get(host, "GlobalKnownHostsFile")
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			if fields := strings.Fields(knownHostsFiles); len(fields) > 0 {
+				for i, field := range fields {
+					fields[i] = ExpandPath(field)
+				}
+				settings.GlobalKnownHostsFiles = fields
+			}
+		}
+	}
+
+	return settings, nil
+}
+
+func decodeFile(file string) (*ssh_config.Config, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, err := ssh_config.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return cfg, nil
+}
+
+// expandHostName expands the %h and %% tokens allowed in HostName.
+func expandHostName(hostName, host string) string {
+	return strings.NewReplacer("%h", host, "%%", "%").Replace(hostName)
+}
+
+// ExpandPath expands a leading ~ to the home directory and, on Windows,
+// __PROGRAMDATA__ to the ProgramData directory like the Windows port of
+// OpenSSH does.
+func ExpandPath(path string) string {
+	if runtime.GOOS == "windows" && strings.HasPrefix(path, "__PROGRAMDATA__") {
+		return os.Getenv("ProgramData") + strings.TrimPrefix(path, "__PROGRAMDATA__")
+	}
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+// Tokens are the values of the percent tokens expanded by ExpandTokens.
+type Tokens struct {
+	// Host is the remote host, %h.
+	Host string
+	// Port is the remote port, %p.
+	Port string
+	// User is the remote user, %r.
+	User string
+}
+
+// ExpandTokens expands the percent tokens of path like OpenSSH does for
+// per-host files: %h, %p and %r to the remote host, port and user, %C to a
+// hash of the local host name and these and %% to %. Unknown tokens and
+// tokens without value are an error.
+func ExpandTokens(path string, t Tokens) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' {
+			b.WriteByte(path[i])
+			continue
+		}
+		if i == len(path)-1 {
+			return "", fmt.Errorf("%q ends with an incomplete token", path)
+		}
+		i++
+
+		var value string
+		switch path[i] {
+		case '%':
+			value = "%"
+		case 'h':
+			value = t.Host
+		case 'p':
+			value = t.Port
+		case 'r':
+			value = t.User
+		case 'C':
+			if t.Host != "" && t.Port != "" && t.User != "" {
+				localHost, _ := os.Hostname()
+				sum := sha1.Sum([]byte(localHost + t.Host + t.Port + t.User))
+				value = hex.EncodeToString(sum[:])
+			}
+		default:
+			return "", fmt.Errorf("unknown token %%%c in %q, supported are %%h, %%p, %%r, %%C and %%%%", path[i], path)
+		}
+		if value == "" {
+			return "", fmt.Errorf("token %%%c in %q has no value", path[i], path)
+		}
+		b.WriteString(value)
+	}
+
+	return b.String(), nil
+}
+
+// KnownHostsCallback returns a host key callback verifying host keys against
+// the given known_hosts files. Missing files are skipped, but at least one
+// has to exist.
+func KnownHostsCallback(files ...string) (ssh.HostKeyCallback, error) {
+	existing := []string{}
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			existing = append(existing, file)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if len(existing) == 0 {
+		return nil, fmt.Errorf("none of the known_hosts files exist: %s", strings.Join(files, ", "))
+	}
+
+	return knownhosts.New(existing...)
+}
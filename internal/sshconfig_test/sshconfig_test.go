@@ -0,0 +1,164 @@
+package sshconfig_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/sshconfig"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestResolve(t *testing.T) {
+	userConfig := writeFile(t, "config", `
+Host bastion
+  HostName bastion.internal.example.com
+`)
+	systemConfig := writeFile(t, "ssh_config", `
+Host *.example.com bastion
+  HostName %h.corp
+  GlobalKnownHostsFile /etc/ssh/corp_known_hosts /etc/ssh/ssh_known_hosts
+`)
+
+	tests := []struct {
+		host     string
+		files    []string
+		expected *sshconfig.Settings
+	}{
+		{
+			host:  "bastion",
+			files: []string{userConfig, systemConfig},
+			expected: &sshconfig.Settings{
+				HostName:              "bastion.internal.example.com",
+				GlobalKnownHostsFiles: []string{"/etc/ssh/corp_known_hosts", "/etc/ssh/ssh_known_hosts"},
+			},
+		},
+		{
+			host:  "db.example.com",
+			files: []string{userConfig, systemConfig, filepath.Join(t.TempDir(), "missing")},
+			expected: &sshconfig.Settings{
+				HostName:              "db.example.com.corp",
+				GlobalKnownHostsFiles: []string{"/etc/ssh/corp_known_hosts", "/etc/ssh/ssh_known_hosts"},
+			},
+		},
+		{
+			host:     "other",
+			files:    []string{userConfig, systemConfig},
+			expected: &sshconfig.Settings{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			settings, err := sshconfig.Resolve(tt.host, tt.files...)
+			if err != nil {
+				t.Fatalf("Failed to resolve: %v", err)
+			}
+			if !reflect.DeepEqual(settings, tt.expected) {
+				t.Errorf("got %+v, want %+v", settings, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResolveExpandsPaths(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	programData := t.TempDir()
+	t.Setenv("ProgramData", programData)
+
+	config := writeFile(t, "ssh_config", `
+Host bastion
+  GlobalKnownHostsFile ~/.ssh/corp_known_hosts __PROGRAMDATA__/ssh/ssh_known_hosts
+`)
+
+	settings, err := sshconfig.Resolve("bastion", config)
+	if err != nil {
+		t.Fatalf("Failed to resolve: %v", err)
+	}
+
+	expected := []string{filepath.Join(home, ".ssh", "corp_known_hosts"), "__PROGRAMDATA__/ssh/ssh_known_hosts"}
+	if runtime.GOOS == "windows" {
+		expected[1] = programData + "/ssh/ssh_known_hosts"
+	}
+	if !reflect.DeepEqual(settings.GlobalKnownHostsFiles, expected) {
+		t.Errorf("got %v, want %v", settings.GlobalKnownHostsFiles, expected)
+	}
+}
+
+func TestExpandTokens(t *testing.T) {
+	tokens := sshconfig.Tokens{Host: "bastion.example.com", Port: "2222", User: "deploy"}
+
+	got, err := sshconfig.ExpandTokens("~/.ssh/%r@%h:%p-100%%", tokens)
+	if err != nil {
+		t.Fatalf("Failed to expand: %v", err)
+	}
+	if want := "~/.ssh/deploy@bastion.example.com:2222-100%"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	hash, err := sshconfig.ExpandTokens("%C", tokens)
+	if err != nil {
+		t.Fatalf("Failed to expand: %v", err)
+	}
+	other, err := sshconfig.ExpandTokens("%C", sshconfig.Tokens{Host: "bastion.example.com", Port: "22", User: "deploy"})
+	if err != nil {
+		t.Fatalf("Failed to expand: %v", err)
+	}
+	if len(hash) != 40 || hash == other {
+		t.Errorf("Expected distinct hashes per port, got %q and %q", hash, other)
+	}
+
+	for _, path := range []string{"%d/key", "key%", "%p/key"} {
+		if _, err := sshconfig.ExpandTokens(path, sshconfig.Tokens{Host: "bastion"}); err == nil {
+			t.Errorf("Expected an error for %q", path)
+		}
+	}
+}
+
+func TestKnownHostsCallback(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to create public key: %v", err)
+	}
+
+	knownHosts := writeFile(t, "ssh_known_hosts", knownhosts.Line([]string{"[bastion]:2222"}, key)+"\n")
+
+	callback, err := sshconfig.KnownHostsCallback(filepath.Join(t.TempDir(), "missing"), knownHosts)
+	if err != nil {
+		t.Fatalf("Failed to create callback: %v", err)
+	}
+
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 2222}
+	if err := callback("bastion:2222", remote, key); err != nil {
+		t.Errorf("Expected known host key to be accepted, got %v", err)
+	}
+	if err := callback("other:2222", remote, key); err == nil {
+		t.Error("Expected unknown host to be rejected")
+	}
+
+	if _, err := sshconfig.KnownHostsCallback(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected an error without any known_hosts file")
+	}
+}
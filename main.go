@@ -1,13 +1,7 @@
 package main
 
 import (
-	"context"
-	"flag"
-	"log"
-
-	"github.com/hashicorp/terraform-plugin-framework/providerserver"
-
-	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/provider"
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/internal/command"
 )
 
 var (
@@ -19,23 +13,9 @@ var (
 	// https://goreleaser.com/cookbooks/using-main.version/
 )
 
+// main runs the provider without the AWS, Azure and GCP SDKs, secrets and
+// credentials of these clouds fail with secretref.ErrNoSDK. The released
+// provider binary is built from the cloud module.
 func main() {
-	var debug bool
-
-	flag.BoolVar(&debug, "debug", false, "set to true to run the provider with support for debuggers like delve")
-	flag.Parse()
-
-	opts := providerserver.ServeOpts{
-		// TODO: Update this string with the published name of your provider.
-		// Also update the tfplugindocs generate command to either remove the
-		// -provider-name flag or set its value to the updated provider name.
-		Address: "registry.terraform.io/johanneswuerbach/sshtunnel",
-		Debug:   debug,
-	}
-
-	err := providerserver.Serve(context.Background(), provider.New(version), opts)
-
-	if err != nil {
-		log.Fatal(err.Error())
-	}
+	command.Main(version)
 }
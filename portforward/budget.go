@@ -0,0 +1,67 @@
+package portforward
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrBudgetExhausted is reported to Metrics for local connections rejected
+// because the Budget was exhausted.
+var ErrBudgetExhausted = errors.New("connection budget exhausted")
+
+// Budget limits the connections forwarded concurrently by all listeners
+// sharing it, bounding the file descriptors and goroutines they use. Unlike
+// MaxConnections, connections beyond the budget are rejected by closing them
+// right away instead of waiting in the listen backlog.
+type Budget struct {
+	max   int64
+	inUse atomic.Int64
+	peak  atomic.Int64
+}
+
+// NewBudget returns a Budget allowing maxConnections concurrent connections.
+func NewBudget(maxConnections int64) *Budget {
+	return &Budget{max: maxConnections}
+}
+
+// Max returns the number of connections allowed.
+func (b *Budget) Max() int64 {
+	return b.max
+}
+
+// InUse returns the number of connections currently forwarded.
+func (b *Budget) InUse() int64 {
+	return b.inUse.Load()
+}
+
+// Peak returns the highest number of connections forwarded concurrently.
+func (b *Budget) Peak() int64 {
+	return b.peak.Load()
+}
+
+// acquire reserves a connection, reporting false if the budget is exhausted.
+func (b *Budget) acquire() bool {
+	for {
+		inUse := b.inUse.Load()
+		if inUse >= b.max {
+			return false
+		}
+		if b.inUse.CompareAndSwap(inUse, inUse+1) {
+			b.updatePeak(inUse + 1)
+			return true
+		}
+	}
+}
+
+func (b *Budget) release() {
+	b.inUse.Add(-1)
+}
+
+func (b *Budget) updatePeak(inUse int64) {
+	for {
+		peak := b.peak.Load()
+		if inUse <= peak || b.peak.CompareAndSwap(peak, inUse) {
+			return
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package portforward
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrFanOut is returned for proxy connections refused because a FanOutGuard
+// tripped.
+var ErrFanOut = errors.New("destination fan-out detected")
+
+// DefaultFanOutWindow is the window of FanOutLimits without one.
+const DefaultFanOutWindow = time.Minute
+
+// FanOutLimits are the limits of a FanOutGuard. Zero limits are not
+// enforced.
+type FanOutLimits struct {
+	// Window is how long requested destinations are remembered. Zero uses
+	// DefaultFanOutWindow.
+	Window time.Duration
+	// MaxRequests is the number of connections requested within Window.
+	MaxRequests int
+	// MaxHosts is the number of distinct hosts requested within Window.
+	MaxHosts int
+	// MaxPorts is the number of distinct ports requested within Window,
+	// across all hosts.
+	MaxPorts int
+}
+
+// FanOutGuard detects SOCKS5 and HTTP CONNECT clients fanning out across
+// many destinations, e.g. a compromised tool scanning the network behind the
+// SSH server. Once the requests of a Window exceed any of its limits, the
+// guard trips: the request and all further ones are refused and the
+// listeners using it are closed. A FanOutGuard can be shared between
+// listeners.
+type FanOutGuard struct {
+	limits FanOutLimits
+
+	mu     sync.Mutex
+	recent []destination
+	hosts  map[string]int
+	ports  map[string]int
+	err    error
+
+	tripped chan struct{}
+}
+
+type destination struct {
+	at   time.Time
+	host string
+	port string
+}
+
+// NewFanOutGuard returns a FanOutGuard enforcing limits.
+func NewFanOutGuard(limits FanOutLimits) *FanOutGuard {
+	if limits.Window <= 0 {
+		limits.Window = DefaultFanOutWindow
+	}
+	return &FanOutGuard{
+		limits:  limits,
+		hosts:   map[string]int{},
+		ports:   map[string]int{},
+		tripped: make(chan struct{}),
+	}
+}
+
+// Tripped is closed once a limit was exceeded.
+func (g *FanOutGuard) Tripped() <-chan struct{} {
+	return g.tripped
+}
+
+// Err describes the exceeded limit, nil until the guard tripped.
+func (g *FanOutGuard) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.err
+}
+
+// observe records a request for addr at now and returns an error wrapping
+// ErrFanOut if it exceeds a limit or the guard already tripped.
+func (g *FanOutGuard) observe(addr string, now time.Time) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.err != nil {
+		return g.err
+	}
+
+	for len(g.recent) > 0 && now.Sub(g.recent[0].at) >= g.limits.Window {
+		forget(g.hosts, g.recent[0].host)
+		forget(g.ports, g.recent[0].port)
+		g.recent = g.recent[1:]
+	}
+	g.recent = append(g.recent, destination{at: now, host: host, port: port})
+	g.hosts[host]++
+	g.ports[port]++
+
+	switch {
+	case g.limits.MaxRequests > 0 && len(g.recent) > g.limits.MaxRequests:
+		g.err = fmt.Errorf("%w: more than %d requests within %s", ErrFanOut, g.limits.MaxRequests, g.limits.Window)
+	case g.limits.MaxHosts > 0 && len(g.hosts) > g.limits.MaxHosts:
+		g.err = fmt.Errorf("%w: more than %d distinct hosts within %s", ErrFanOut, g.limits.MaxHosts, g.limits.Window)
+	case g.limits.MaxPorts > 0 && len(g.ports) > g.limits.MaxPorts:
+		g.err = fmt.Errorf("%w: more than %d distinct ports within %s", ErrFanOut, g.limits.MaxPorts, g.limits.Window)
+	default:
+		return nil
+	}
+
+	close(g.tripped)
+	return g.err
+}
+
+func forget(counts map[string]int, key string) {
+	counts[key]--
+	if counts[key] == 0 {
+		delete(counts, key)
+	}
+}
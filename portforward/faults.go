@@ -0,0 +1,41 @@
+package portforward
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrFaultInjected is returned for forwarded connections that were dropped
+// by the DropRate of Faults.
+var ErrFaultInjected = errors.New("connection dropped by fault injection")
+
+// Faults degrade forwarded traffic on purpose, e.g. to rehearse how clients
+// behave over a slow or flaky bastion link.
+type Faults struct {
+	// Latency delays every chunk of data forwarded in either direction.
+	Latency time.Duration
+	// DropRate is the probability between 0 and 1 that a chunk of data is
+	// not forwarded and its connection is dropped instead.
+	DropRate float64
+}
+
+// apply delays a chunk of data and reports whether it is forwarded. It
+// returns early once done is closed.
+func (f *Faults) apply(done <-chan struct{}) error {
+	if f.Latency > 0 {
+		timer := time.NewTimer(f.Latency)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-done:
+		}
+	}
+
+	if f.DropRate > 0 && rand.Float64() < f.DropRate {
+		return ErrFaultInjected
+	}
+
+	return nil
+}
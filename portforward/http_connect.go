@@ -0,0 +1,56 @@
+package portforward
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrHTTPConnect is returned for connections to HTTP CONNECT listeners that
+// don't send a valid CONNECT request.
+var ErrHTTPConnect = errors.New("invalid HTTP CONNECT request")
+
+// httpConnectTimeout bounds the time a client may take to send its CONNECT
+// request.
+const httpConnectTimeout = 10 * time.Second
+
+// readHTTPConnectRequest reads the HTTP CONNECT request of the client on conn
+// and returns the requested address. Host names are returned unresolved, so
+// the SSH server resolves them. The returned conn replays the bytes the
+// client sent after the request, e.g. a pipelined TLS ClientHello. Other
+// requests are answered with an error response.
+func readHTTPConnectRequest(conn net.Conn, timeout time.Duration) (string, net.Conn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return "", nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %w", ErrHTTPConnect, err)
+	}
+	if req.Method != http.MethodConnect {
+		_ = writeHTTPConnectResponse(conn, http.StatusMethodNotAllowed)
+		return "", nil, fmt.Errorf("%w: unsupported method %s", ErrHTTPConnect, req.Method)
+	}
+	if _, _, err := net.SplitHostPort(req.Host); err != nil {
+		_ = writeHTTPConnectResponse(conn, http.StatusBadRequest)
+		return "", nil, fmt.Errorf("%w: invalid address %q", ErrHTTPConnect, req.Host)
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return "", nil, err
+	}
+	return req.Host, &replayConn{Conn: conn, r: io.MultiReader(r, conn)}, nil
+}
+
+// writeHTTPConnectResponse answers a CONNECT request with status, on success
+// the connection is tunneled from then on.
+func writeHTTPConnectResponse(conn net.Conn, status int) error {
+	_, err := fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package portforward
+
+import (
+	"net"
+)
+
+// listenWithBacklog falls back to net.Listen on platforms without a way to
+// configure the backlog; the operating system default is used.
+func listenWithBacklog(addr string, _ int) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
@@ -0,0 +1,44 @@
+//go:build unix
+
+package portforward
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenWithBacklog listens with net.Listen, which always uses the system
+// maximum as backlog, and calls listen(2) again on the socket to apply
+// backlog, updating the queue size of the listening socket.
+func listenWithBacklog(addr string, backlog int) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setBacklog(listener.(*net.TCPListener), backlog); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+func setBacklog(listener *net.TCPListener, backlog int) error {
+	rawConn, err := listener.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var listenErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), backlog)
+	}); err != nil {
+		return err
+	}
+	if listenErr != nil {
+		return os.NewSyscallError("listen", listenErr)
+	}
+	return nil
+}
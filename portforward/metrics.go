@@ -0,0 +1,41 @@
+package portforward
+
+import (
+	"net"
+)
+
+// Metrics receives the events of a Listener, e.g. to export them to
+// Prometheus or OpenTelemetry. The methods are called concurrently from the
+// goroutines forwarding connections and must not block. Embed NopMetrics to
+// implement only some of them.
+type Metrics interface {
+	// OnAccept is called for every accepted local connection, including
+	// connections rejected afterwards.
+	OnAccept(localAddr net.Addr)
+	// OnClose is called with the stats of every forwarded connection once it
+	// is closed, including connections the remote address could not be
+	// dialed for.
+	OnClose(stats ConnStats)
+	// OnBytes is called after n bytes were forwarded, from local to remote if
+	// sent and from remote to local otherwise.
+	OnBytes(n int, sent bool)
+	// OnError is called when a connection fails: with the dial error if the
+	// remote address could not be dialed, the routing error if it could not
+	// be determined from SNIRoutes, ErrBudgetExhausted if the
+	// connection was rejected, an error wrapping ErrFanOut if a proxy
+	// connection was refused by FanOut, ErrStalled if it made no progress,
+	// ErrQuotaExceeded if it was cut off by one of Quotas and
+	// ErrFaultInjected if it was dropped by Faults.
+	OnError(err error)
+}
+
+// NopMetrics implements Metrics ignoring all events.
+type NopMetrics struct{}
+
+func (NopMetrics) OnAccept(localAddr net.Addr) {}
+
+func (NopMetrics) OnClose(stats ConnStats) {}
+
+func (NopMetrics) OnBytes(n int, sent bool) {}
+
+func (NopMetrics) OnError(err error) {}
@@ -0,0 +1,745 @@
+// Package portforward forwards connections accepted on a local TCP listener
+// to a remote address through an SSH connection, like `ssh -L`, or to the
+// addresses requested by SOCKS5 or HTTP CONNECT proxy clients, like `ssh -D`.
+//
+// A forwarding is started with New and runs until its Listener is closed or
+// the context passed to New is cancelled:
+//
+//	listener, err := portforward.New(ctx, sshClient, &portforward.Config{
+//		RemoteAddr: "db.internal:5432",
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	defer listener.Close()
+//
+//	fmt.Println("forwarding", listener.Addr())
+package portforward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const (
+	defaultListenHost = "0.0.0.0"
+)
+
+// Dialer opens connections to the remote address, *ssh.Client implements it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Config describes a port forwarding.
+type Config struct {
+	// LocalPort is the local port to listen on. Nil picks a random port.
+	LocalPort *int32
+	// ListenHost is the local address to listen on, defaults to all
+	// interfaces.
+	ListenHost string
+	// RemoteAddr is the address to forward to, for local forwardings a
+	// host:port resolved by the SSH server.
+	RemoteAddr string
+	// Network is the network of RemoteAddr passed to the dialer, defaults to
+	// "tcp".
+	Network string
+	// RetryDelay is the delay between attempts to dial the remote address.
+	RetryDelay time.Duration
+	// RetryAttempts is the number of additional attempts to dial the remote
+	// address before a local connection is dropped.
+	RetryAttempts int32
+	// Backlog is the size of the kernel queue of pending local connections.
+	// Zero uses the operating system default.
+	Backlog int32
+	// MaxConnections limits the number of connections forwarded concurrently.
+	// Further connections wait in the listen backlog until a slot is free.
+	// Zero means unlimited.
+	MaxConnections int32
+	// AcceptWorkers is the number of goroutines accepting local connections
+	// concurrently, so bursts of new connections from highly parallel
+	// clients aren't serialized behind a single accept loop. Zero means one.
+	AcceptWorkers int32
+	// OnConnClose is called with the stats of every forwarded connection
+	// once it is closed. It must not block.
+	OnConnClose func(ConnStats)
+	// Metrics receives the events of the listener. Nil disables them.
+	Metrics Metrics
+	// Quotas limit the bytes forwarded by the listener. Once any of them is
+	// exceeded, the listener is closed.
+	Quotas []*Quota
+	// StallTimeout closes forwarded connections making no progress for this
+	// long while a write is blocked or data sent to the remote awaits a
+	// response, e.g. because the remote is black-holed. Idle connections
+	// are not affected. Zero disables the detection.
+	StallTimeout time.Duration
+	// Budget limits the connections forwarded concurrently together with
+	// other listeners, connections beyond it are rejected. Nil means
+	// unlimited.
+	Budget *Budget
+	// Faults degrade the forwarded traffic on purpose. Nil forwards it
+	// unchanged.
+	Faults *Faults
+	// SNIRoutes routes TLS connections by the server name (SNI) of their
+	// ClientHello to other remote addresses, e.g. "api.internal" to
+	// "10.0.0.5:443", so one listener serves many TLS services. Names are
+	// matched case-insensitively and "*.example.com" matches any direct
+	// subdomain. Connections matching no name are forwarded to RemoteAddr,
+	// if set, and dropped with ErrNoSNIRoute otherwise. The TLS handshake is
+	// passed through unchanged.
+	SNIRoutes map[string]string
+	// SOCKS5 makes the listener a SOCKS5 proxy, like `ssh -D`: every
+	// connection is forwarded to the address of its CONNECT request instead
+	// of RemoteAddr, domain names are resolved by the dialer. Clients can't
+	// authenticate, so set ListenHost to a loopback address unless other
+	// hosts are trusted.
+	SOCKS5 bool
+	// HTTPConnect makes the listener an HTTP proxy only supporting the
+	// CONNECT method, for clients configured with HTTPS_PROXY: every
+	// connection is forwarded to the address of its CONNECT request instead
+	// of RemoteAddr, host names are resolved by the dialer. Like with SOCKS5,
+	// clients can't authenticate.
+	HTTPConnect bool
+	// FanOut refuses the requests of SOCKS5 and HTTPConnect clients once
+	// they fan out across too many destinations and closes the listener.
+	// Nil allows any destinations.
+	FanOut *FanOutGuard
+	// Priority is the class the listener shares the connection with other
+	// listeners of the same Scheduler in, writes in both directions wait for
+	// their turn. Nil forwards without waiting.
+	Priority *PriorityClass
+}
+
+// Stats are the cumulative counters of a Listener.
+type Stats struct {
+	// Accepted is the number of local connections accepted.
+	Accepted uint64
+	// Active is the number of connections currently forwarded.
+	Active int64
+	// Failed is the number of local connections dropped because the remote
+	// address could not be dialed or, with SNIRoutes, SOCKS5 or HTTPConnect,
+	// not be determined or was refused by FanOut.
+	Failed uint64
+	// BytesSent is the number of bytes forwarded from local to remote.
+	BytesSent uint64
+	// BytesReceived is the number of bytes forwarded from remote to local.
+	BytesReceived uint64
+	// Stalled is the number of connections closed because they made no
+	// progress for the StallTimeout.
+	Stalled uint64
+	// Rejected is the number of local connections rejected because the
+	// Budget was exhausted.
+	Rejected uint64
+	// SendBlocked is the time writes to the remote were blocked. For SSH
+	// channels this is mostly time waiting for the SSH server to grant more
+	// window, i.e. throttling by the server rather than a slow remote.
+	SendBlocked time.Duration
+	// ReceiveBlocked is the time writes to local clients were blocked, e.g.
+	// by a slow consumer.
+	ReceiveBlocked time.Duration
+	// Duration is the total time of the forwarded connections that were
+	// closed.
+	Duration time.Duration
+}
+
+// ConnStats describes a single forwarded connection after it was closed.
+type ConnStats struct {
+	// LocalAddr is the address of the local client.
+	LocalAddr net.Addr
+	// BytesSent is the number of bytes forwarded from local to remote.
+	BytesSent int64
+	// BytesReceived is the number of bytes forwarded from remote to local.
+	BytesReceived int64
+	// Duration is the time from accepting the connection until it was closed.
+	Duration time.Duration
+	// SendBlocked is the time writes to the remote were blocked, see
+	// Stats.SendBlocked.
+	SendBlocked time.Duration
+	// ReceiveBlocked is the time writes to the local client were blocked.
+	ReceiveBlocked time.Duration
+	// Err is the error that ended the connection, if any.
+	Err error
+}
+
+// Listener is a running port forwarding. Closing it stops accepting new
+// connections and tears down all forwarded connections.
+type Listener struct {
+	net.Listener
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	dialer  Dialer
+	conf    Config
+	metrics Metrics
+	wg      sync.WaitGroup
+
+	closeOnce sync.Once
+	closeErr  error
+	closed    chan struct{}
+
+	firstOnce sync.Once
+	first     chan struct{}
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+
+	// sniRoutes are the SNIRoutes with lower-cased names.
+	sniRoutes map[string]string
+
+	accepted      atomic.Uint64
+	active        atomic.Int64
+	failed        atomic.Uint64
+	stalled       atomic.Uint64
+	rejected      atomic.Uint64
+	bytesSent     atomic.Uint64
+	bytesReceived atomic.Uint64
+	// sendBlocked, receiveBlocked and duration are in nanoseconds.
+	sendBlocked    atomic.Int64
+	receiveBlocked atomic.Int64
+	duration       atomic.Int64
+}
+
+// New starts listening on the local port and forwards every accepted
+// connection to conf.RemoteAddr using dialer. The forwarding stops when the
+// returned Listener is closed or ctx is cancelled.
+func New(ctx context.Context, dialer Dialer, conf *Config) (*Listener, error) {
+	listenHost := conf.ListenHost
+	if listenHost == "" {
+		listenHost = defaultListenHost
+	}
+	var port int32
+	if conf.LocalPort != nil {
+		port = *conf.LocalPort
+	}
+	listenAddr := net.JoinHostPort(listenHost, strconv.Itoa(int(port)))
+
+	localListener, err := listen(listenAddr, int(conf.Backlog))
+	if err != nil {
+		return nil, fmt.Errorf("net.Listen failed: %w", privilegedPortError(conf.LocalPort, err))
+	}
+
+	return Serve(ctx, localListener, dialer, conf), nil
+}
+
+// Serve forwards every connection accepted on listener to conf.RemoteAddr
+// using dialer. It is the building block for forwardings not listening on a
+// local TCP port, e.g. remote forwardings where listener is created by
+// *ssh.Client.Listen or ListenUnix and dialer is a *net.Dialer. LocalPort,
+// ListenHost and Backlog are ignored. The listener is closed with the returned Listener.
+func Serve(ctx context.Context, listener net.Listener, dialer Dialer, conf *Config) *Listener {
+	ctx, cancel := context.WithCancel(ctx)
+	metrics := conf.Metrics
+	if metrics == nil {
+		metrics = NopMetrics{}
+	}
+	l := &Listener{
+		Listener: listener,
+		ctx:      ctx,
+		cancel:   cancel,
+		dialer:   dialer,
+		conf:     *conf,
+		metrics:  metrics,
+		conns:    map[net.Conn]struct{}{},
+		closed:   make(chan struct{}),
+		first:    make(chan struct{}),
+	}
+
+	if len(conf.SNIRoutes) > 0 {
+		l.sniRoutes = make(map[string]string, len(conf.SNIRoutes))
+		for name, addr := range conf.SNIRoutes {
+			l.sniRoutes[strings.ToLower(strings.TrimSuffix(name, "."))] = addr
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for _, quota := range conf.Quotas {
+		go func() {
+			select {
+			case <-quota.Exceeded():
+				tflog.Warn(ctx, "data transfer quota exceeded, closing listener", map[string]interface{}{"max_bytes": quota.Max()})
+				l.Close()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	if conf.FanOut != nil {
+		go func() {
+			select {
+			case <-conf.FanOut.Tripped():
+				tflog.Warn(ctx, "destination fan-out detected, closing listener", map[string]interface{}{"err": conf.FanOut.Err()})
+				l.Close()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	var slots chan struct{}
+	if conf.MaxConnections > 0 {
+		slots = make(chan struct{}, conf.MaxConnections)
+	}
+
+	workers := max(int(conf.AcceptWorkers), 1)
+	l.wg.Add(workers)
+	for range workers {
+		go l.serve(slots)
+	}
+
+	return l
+}
+
+// Stats returns a snapshot of the counters of the listener.
+func (l *Listener) Stats() Stats {
+	return Stats{
+		Accepted:       l.accepted.Load(),
+		Active:         l.active.Load(),
+		Failed:         l.failed.Load(),
+		Stalled:        l.stalled.Load(),
+		Rejected:       l.rejected.Load(),
+		BytesSent:      l.bytesSent.Load(),
+		BytesReceived:  l.bytesReceived.Load(),
+		SendBlocked:    time.Duration(l.sendBlocked.Load()),
+		ReceiveBlocked: time.Duration(l.receiveBlocked.Load()),
+		Duration:       time.Duration(l.duration.Load()),
+	}
+}
+
+// Done is closed once the listener is closed and all forwarded connections
+// finished.
+func (l *Listener) Done() <-chan struct{} {
+	return l.closed
+}
+
+// FirstConnection is closed once the first connection was accepted, e.g. to
+// wait for the consumer of a forwarding.
+func (l *Listener) FirstConnection() <-chan struct{} {
+	return l.first
+}
+
+// Close stops accepting connections, closes all forwarded connections and
+// waits for them to finish.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		l.cancel()
+		l.closeErr = l.Listener.Close()
+
+		l.mu.Lock()
+		for conn := range l.conns {
+			conn.Close()
+		}
+		l.mu.Unlock()
+
+		l.wg.Wait()
+		close(l.closed)
+	})
+
+	return l.closeErr
+}
+
+// serve accepts and forwards connections until the listener is closed. slots,
+// if not nil, is shared by all accept workers to limit the connections
+// forwarded concurrently.
+func (l *Listener) serve(slots chan struct{}) {
+	defer l.wg.Done()
+
+	for {
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-l.ctx.Done():
+				return
+			}
+		}
+
+		// Accept a connection
+		localConn, err := l.Listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || l.ctx.Err() != nil {
+				return
+			}
+			tflog.Error(l.ctx, "failed to accept connection", map[string]interface{}{"err": err})
+			return
+		}
+		l.accepted.Add(1)
+		l.metrics.OnAccept(localConn.RemoteAddr())
+		l.firstOnce.Do(func() { close(l.first) })
+
+		budget := l.conf.Budget
+		if budget != nil && !budget.acquire() {
+			l.rejected.Add(1)
+			l.metrics.OnError(ErrBudgetExhausted)
+			tflog.Warn(l.ctx, "connection budget exhausted, rejecting connection", map[string]interface{}{"in_use": budget.InUse(), "max": budget.Max()})
+			localConn.Close()
+			if slots != nil {
+				<-slots
+			}
+			continue
+		}
+
+		if !l.track(localConn) {
+			localConn.Close()
+			if budget != nil {
+				budget.release()
+			}
+			return
+		}
+
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+			if slots != nil {
+				defer func() { <-slots }()
+			}
+			if budget != nil {
+				defer budget.release()
+			}
+			defer l.untrack(localConn)
+			l.handleConnection(localConn)
+		}()
+	}
+}
+
+// track registers conn so it is closed with the listener, it reports false
+// when the listener is already closed.
+func (l *Listener) track(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.ctx.Err() != nil {
+		return false
+	}
+	l.conns[conn] = struct{}{}
+	return true
+}
+
+func (l *Listener) untrack(conn net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.conns, conn)
+}
+
+// listen opens a TCP listener on addr. A positive backlog overrides the
+// operating system default where the platform supports it.
+func listen(addr string, backlog int) (net.Listener, error) {
+	if backlog <= 0 {
+		return net.Listen("tcp", addr)
+	}
+
+	return listenWithBacklog(addr, backlog)
+}
+
+// dialRemote dials remoteAddr, retrying as configured.
+func (l *Listener) dialRemote(remoteAddr string) (net.Conn, error) {
+	var remoteConn net.Conn
+	var err error
+
+	network := l.conf.Network
+	if network == "" {
+		network = "tcp"
+	}
+
+	for i := int32(0); i <= l.conf.RetryAttempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(l.conf.RetryDelay):
+			case <-l.ctx.Done():
+				return nil, l.ctx.Err()
+			}
+		}
+
+		remoteConn, err = l.dialer.DialContext(l.ctx, network, remoteAddr)
+		if err == nil {
+			return remoteConn, nil
+		}
+		if i < l.conf.RetryAttempts {
+			tflog.Warn(l.ctx, "failed to dial remote connection, retrying", map[string]interface{}{"err": err})
+		}
+	}
+
+	return nil, err
+}
+
+func (l *Listener) handleConnection(localConn net.Conn) {
+	defer localConn.Close()
+
+	stats := ConnStats{LocalAddr: localConn.RemoteAddr()}
+	start := time.Now()
+	defer func() {
+		stats.Duration = time.Since(start)
+		l.duration.Add(int64(stats.Duration))
+		l.metrics.OnClose(stats)
+		if l.conf.OnConnClose != nil {
+			l.conf.OnConnClose(stats)
+		}
+	}()
+
+	remoteAddr := l.conf.RemoteAddr
+	if l.sniRoutes != nil {
+		serverName, conn, err := readClientHello(localConn, sniTimeout)
+		if err == nil {
+			remoteAddr, err = l.routeSNI(serverName)
+		}
+		if err != nil {
+			l.failed.Add(1)
+			l.metrics.OnError(err)
+			stats.Err = err
+			tflog.Error(l.ctx, "failed to route TLS connection", map[string]interface{}{"err": err})
+			return
+		}
+		tflog.Debug(l.ctx, "routing TLS connection", map[string]interface{}{"server_name": serverName, "remote_addr": remoteAddr})
+		localConn = conn
+	}
+	if l.conf.SOCKS5 {
+		addr, err := readSOCKS5Request(localConn, socksTimeout)
+		if err != nil {
+			l.failed.Add(1)
+			l.metrics.OnError(err)
+			stats.Err = err
+			tflog.Error(l.ctx, "failed to read SOCKS5 request", map[string]interface{}{"err": err})
+			return
+		}
+		tflog.Info(l.ctx, "forwarding SOCKS5 connection", map[string]interface{}{"local_addr": stats.LocalAddr.String(), "remote_addr": addr})
+		remoteAddr = addr
+	}
+	if l.conf.HTTPConnect {
+		addr, conn, err := readHTTPConnectRequest(localConn, httpConnectTimeout)
+		if err != nil {
+			l.failed.Add(1)
+			l.metrics.OnError(err)
+			stats.Err = err
+			tflog.Error(l.ctx, "failed to read HTTP CONNECT request", map[string]interface{}{"err": err})
+			return
+		}
+		tflog.Info(l.ctx, "forwarding HTTP CONNECT connection", map[string]interface{}{"local_addr": stats.LocalAddr.String(), "remote_addr": addr})
+		remoteAddr = addr
+		localConn = conn
+	}
+
+	var remoteConn net.Conn
+	var err error
+	if l.conf.FanOut != nil && (l.conf.SOCKS5 || l.conf.HTTPConnect) {
+		err = l.conf.FanOut.observe(remoteAddr, time.Now())
+	}
+	if err == nil {
+		remoteConn, err = l.dialRemote(remoteAddr)
+	}
+	refused := errors.Is(err, ErrFanOut)
+	if l.conf.SOCKS5 {
+		reply := byte(socksSucceeded)
+		if refused {
+			reply = socksNotAllowed
+		} else if err != nil {
+			reply = socksHostUnreachable
+		}
+		if replyErr := writeSOCKS5Reply(localConn, reply); replyErr != nil && err == nil {
+			remoteConn.Close()
+			err = replyErr
+		}
+	}
+	if l.conf.HTTPConnect {
+		status := http.StatusOK
+		if refused {
+			status = http.StatusForbidden
+		} else if err != nil {
+			status = http.StatusBadGateway
+		}
+		if replyErr := writeHTTPConnectResponse(localConn, status); replyErr != nil && err == nil {
+			remoteConn.Close()
+			err = replyErr
+		}
+	}
+	if err != nil {
+		l.failed.Add(1)
+		l.metrics.OnError(err)
+		stats.Err = err
+		if refused {
+			tflog.Error(l.ctx, "refused proxy connection", map[string]interface{}{"remote_addr": remoteAddr, "err": err})
+			return
+		}
+		tflog.Error(l.ctx, "failed to dial remote connection", map[string]interface{}{"retry_attempts": l.conf.RetryAttempts, "err": err})
+		return
+	}
+	defer remoteConn.Close()
+
+	l.active.Add(1)
+	defer l.active.Add(-1)
+
+	var stall *stallDetector
+	var stalled atomic.Bool
+	if l.conf.StallTimeout > 0 {
+		stall = newStallDetector(l.conf.StallTimeout)
+		done := make(chan struct{})
+		defer close(done)
+		go stall.watch(done, func() {
+			stalled.Store(true)
+			l.stalled.Add(1)
+			l.metrics.OnError(ErrStalled)
+			tflog.Error(l.ctx, "connection made no progress, closing", map[string]interface{}{"stall_timeout": l.conf.StallTimeout.String()})
+			localConn.Close()
+			remoteConn.Close()
+		})
+	}
+
+	type result struct {
+		n       int64
+		blocked time.Duration
+		err     error
+	}
+	sent := make(chan result, 1)
+	received := make(chan result, 1)
+
+	go func() {
+		n, blocked, err := l.copy(remoteConn, localConn, &l.bytesSent, &l.sendBlocked, stall, true)
+		if err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, ErrQuotaExceeded) {
+			tflog.Error(l.ctx, "failed to copy data from local to remote", map[string]interface{}{"err": err})
+		}
+		sent <- result{n, blocked, err}
+	}()
+	go func() {
+		n, blocked, err := l.copy(localConn, remoteConn, &l.bytesReceived, &l.receiveBlocked, stall, false)
+		if err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, ErrQuotaExceeded) {
+			tflog.Error(l.ctx, "failed to copy data from remote to local", map[string]interface{}{"err": err})
+		}
+		received <- result{n, blocked, err}
+	}()
+
+	// A direction finishing cleanly is propagated as a half-close so the
+	// other direction can still drain. Errors tear down both sides.
+	for pending := 2; pending > 0; pending-- {
+		var r result
+		select {
+		case r = <-sent:
+			stats.BytesSent = r.n
+			stats.SendBlocked = r.blocked
+			sent = nil
+		case r = <-received:
+			stats.BytesReceived = r.n
+			stats.ReceiveBlocked = r.blocked
+			received = nil
+		}
+
+		if r.err != nil {
+			if stats.Err == nil && !errors.Is(r.err, net.ErrClosed) {
+				stats.Err = r.err
+			}
+			localConn.Close()
+			remoteConn.Close()
+		}
+	}
+
+	if stalled.Load() {
+		stats.Err = ErrStalled
+	}
+}
+
+// copy copies src to dst, adding the bytes copied to counter and the time
+// writes to dst were blocked to blocked, and closes the write side of dst
+// once src is exhausted. Destinations that do not support half-closing are
+// closed completely. Progress is reported to stall, if not nil, sent is
+// whether dst is the remote. The time writes were blocked is returned.
+func (l *Listener) copy(dst, src net.Conn, counter *atomic.Uint64, blocked *atomic.Int64, stall *stallDetector, sent bool) (int64, time.Duration, error) {
+	w := &countingWriter{w: dst, counter: counter, blockedCounter: blocked, quotas: l.conf.Quotas, stall: stall, metrics: l.metrics, sent: sent, faults: l.conf.Faults, priority: l.conf.Priority, done: l.ctx.Done()}
+	n, err := io.Copy(w, src)
+	if err != nil {
+		return n, w.blocked, err
+	}
+
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		if err := cw.CloseWrite(); err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+			return n, w.blocked, err
+		}
+		return n, w.blocked, nil
+	}
+
+	return n, w.blocked, dst.Close()
+}
+
+// countingWriter counts the bytes written to w, reporting them to metrics,
+// and the time writes were blocked, and cuts off writes exceeding any of the
+// quotas. Writes are degraded by faults and wait for the turn of priority,
+// if not nil, until done is closed.
+type countingWriter struct {
+	w       io.Writer
+	counter *atomic.Uint64
+	// blocked is the time writes to w were blocked, also added to
+	// blockedCounter in nanoseconds.
+	blocked        time.Duration
+	blockedCounter *atomic.Int64
+	quotas         []*Quota
+	stall          *stallDetector
+	metrics        Metrics
+	sent           bool
+	faults         *Faults
+	priority       *PriorityClass
+	done           <-chan struct{}
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.faults != nil {
+		if err := c.faults.apply(c.done); err != nil {
+			c.metrics.OnError(err)
+			return 0, err
+		}
+	}
+
+	granted := len(p)
+	for _, quota := range c.quotas {
+		granted = min(granted, quota.reserve(len(p)))
+	}
+	// Every quota reserved all of p, settle it with what was written, so
+	// overlapping quotas are only charged for the bytes forwarded.
+	var n int
+	defer func() {
+		for _, quota := range c.quotas {
+			quota.settle(len(p), n, granted < len(p))
+		}
+	}()
+
+	if c.priority != nil {
+		release, err := c.priority.acquire(granted, c.done)
+		if err != nil {
+			return 0, err
+		}
+		defer release()
+	}
+
+	if c.stall != nil {
+		c.stall.startWrite()
+	}
+	start := time.Now()
+	var err error
+	n, err = c.w.Write(p[:granted])
+	blocked := time.Since(start)
+	c.blocked += blocked
+	c.blockedCounter.Add(int64(blocked))
+	if c.stall != nil {
+		c.stall.endWrite(n, c.sent)
+	}
+	c.counter.Add(uint64(n))
+	if c.priority != nil {
+		c.priority.forwarded.Add(uint64(n))
+	}
+	if n > 0 {
+		c.metrics.OnBytes(n, c.sent)
+	}
+	if err == nil && granted < len(p) {
+		err = ErrQuotaExceeded
+		c.metrics.OnError(err)
+	}
+	return n, err
+}
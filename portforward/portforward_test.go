@@ -0,0 +1,1239 @@
+package portforward_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+func TestPortForwardIntegration(t *testing.T) {
+	tcpServer, sshClient, tcpServerAddr := setupTestServer(t, testServerOpts{})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	// Create port forward
+	ctx := context.Background()
+	config := &portforward.Config{
+		RemoteAddr: tcpServerAddr,
+	}
+
+	listener, err := portforward.New(ctx, sshClient, config)
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	// Connect to forwarded port
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarded port: %v", err)
+	}
+	defer conn.Close()
+
+	// Read response
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read from connection: %v", err)
+	}
+
+	response := string(buf[:n])
+	expected := "Hello from TCP server!"
+	if response != expected {
+		t.Errorf("got %q, want %q", response, expected)
+	}
+}
+
+func TestPortForwardRemoteSocket(t *testing.T) {
+	tcpServer, sshClient, _ := setupTestServer(t, testServerOpts{socketPath: "/var/run/app.sock"})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	for socketPath, want := range map[string]string{
+		"/var/run/app.sock":     "Hello from TCP server!",
+		"/var/run/missing.sock": "",
+	} {
+		listener, err := portforward.New(context.Background(), sshClient, &portforward.Config{
+			RemoteAddr: socketPath,
+			Network:    "unix",
+		})
+		if err != nil {
+			t.Fatalf("Failed to create port forward: %v", err)
+		}
+		defer listener.Close()
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect to forwarded port: %v", err)
+		}
+		got, _ := io.ReadAll(conn)
+		conn.Close()
+		if string(got) != want {
+			t.Errorf("got %q from %s, want %q", got, socketPath, want)
+		}
+	}
+}
+
+func TestPortForwardFirstConnection(t *testing.T) {
+	tcpServer, sshClient, tcpServerAddr := setupTestServer(t, testServerOpts{})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	listener, err := portforward.New(context.Background(), sshClient, &portforward.Config{
+		RemoteAddr: tcpServerAddr,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	select {
+	case <-listener.FirstConnection():
+		t.Fatal("FirstConnection closed before any connection")
+	default:
+	}
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarded port: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case <-listener.FirstConnection():
+	case <-time.After(5 * time.Second):
+		t.Fatal("FirstConnection not closed after a connection")
+	}
+}
+
+func TestPortForwardRetry(t *testing.T) {
+	tcpServer, sshClient, tcpServerAddr := setupTestServer(t, testServerOpts{failedAttempts: 2})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	// Create port forward with retry configuration
+	ctx := context.Background()
+	config := &portforward.Config{
+		RemoteAddr:    tcpServerAddr,
+		RetryDelay:    10 * time.Millisecond, // Short delay for tests
+		RetryAttempts: 2,                     // Should succeed on the 2nd retry attempt
+	}
+
+	listener, err := portforward.New(ctx, sshClient, config)
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	// Connect to forwarded port
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarded port: %v", err)
+	}
+	defer conn.Close()
+
+	// Read response
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read from connection: %v", err)
+	}
+
+	response := string(buf[:n])
+	expected := "Hello from TCP server!"
+	if response != expected {
+		t.Errorf("got %q, want %q", response, expected)
+	}
+}
+
+func TestPortForwardMaxConnections(t *testing.T) {
+	// The limit is shared by all accept workers.
+	for _, acceptWorkers := range []int32{0, 4} {
+		t.Run(fmt.Sprintf("%d accept workers", acceptWorkers), func(t *testing.T) {
+			// Backend holding every connection open until the client closes it,
+			// recording how many reach it at once.
+			backend, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer backend.Close()
+			var mu sync.Mutex
+			active, maxActive := 0, 0
+			arrived := make(chan struct{}, 8)
+			go func() {
+				for {
+					conn, err := backend.Accept()
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					active++
+					maxActive = max(maxActive, active)
+					mu.Unlock()
+					arrived <- struct{}{}
+					go func() {
+						defer conn.Close()
+						_, _ = io.Copy(io.Discard, conn)
+						mu.Lock()
+						active--
+						mu.Unlock()
+					}()
+				}
+			}()
+
+			// Create port forward only forwarding two connections at a time
+			listener, err := portforward.New(context.Background(), &net.Dialer{}, &portforward.Config{
+				ListenHost:     "127.0.0.1",
+				RemoteAddr:     backend.Addr().String(),
+				Backlog:        16,
+				MaxConnections: 2,
+				AcceptWorkers:  acceptWorkers,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create port forward: %v", err)
+			}
+			defer listener.Close()
+
+			clients := make([]net.Conn, 3)
+			for i := range clients {
+				clients[i], err = net.Dial("tcp", listener.Addr().String())
+				if err != nil {
+					t.Fatalf("Failed to connect to forwarded port: %v", err)
+				}
+				defer clients[i].Close()
+			}
+
+			waitArrived := func() {
+				t.Helper()
+				select {
+				case <-arrived:
+				case <-time.After(5 * time.Second):
+					t.Fatal("Timed out waiting for a connection to reach the remote")
+				}
+			}
+			waitArrived()
+			waitArrived()
+
+			// The third connection waits in the listen backlog.
+			select {
+			case <-arrived:
+				t.Fatal("Expected the third connection to wait for a free slot")
+			case <-time.After(200 * time.Millisecond):
+			}
+			if stats := listener.Stats(); stats.Accepted != 2 || stats.Rejected != 0 {
+				t.Errorf("got %d accepted and %d rejected connections, want 2 accepted and the third queued", stats.Accepted, stats.Rejected)
+			}
+
+			// Closing a forwarded connection frees its slot for the queued one.
+			clients[0].Close()
+			waitArrived()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if maxActive > 2 {
+				t.Errorf("got %d connections at the remote at once, want at most 2", maxActive)
+			}
+		})
+	}
+}
+
+func TestPortForwardHalfCloseAndStats(t *testing.T) {
+	tcpServer, sshClient, tcpServerAddr := setupTestServer(t, testServerOpts{echo: true})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	closed := make(chan portforward.ConnStats, 1)
+	listener, err := portforward.New(context.Background(), sshClient, &portforward.Config{
+		RemoteAddr:  tcpServerAddr,
+		OnConnClose: func(stats portforward.ConnStats) { closed <- stats },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarded port: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatalf("Failed to write to connection: %v", err)
+	}
+	// The echo server only replies after seeing EOF, so the response can
+	// only arrive if the half-close is forwarded.
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatalf("Failed to half-close connection: %v", err)
+	}
+
+	buf, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Failed to read from connection: %v", err)
+	}
+	if got, want := string(buf), "ping"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	select {
+	case stats := <-closed:
+		if stats.BytesSent != 4 || stats.BytesReceived != 4 || stats.Err != nil {
+			t.Errorf("unexpected connection stats: %+v", stats)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the connection to close")
+	}
+
+	stats := listener.Stats()
+	if stats.Accepted != 1 || stats.Active != 0 || stats.BytesSent != 4 || stats.BytesReceived != 4 {
+		t.Errorf("unexpected listener stats: %+v", stats)
+	}
+}
+
+// recordingMetrics records the events of a listener.
+type recordingMetrics struct {
+	mu            sync.Mutex
+	accepted      int
+	bytesSent     int
+	bytesReceived int
+	errs          []error
+	closed        chan portforward.ConnStats
+}
+
+func (m *recordingMetrics) OnAccept(localAddr net.Addr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accepted++
+}
+
+func (m *recordingMetrics) OnClose(stats portforward.ConnStats) {
+	m.closed <- stats
+}
+
+func (m *recordingMetrics) OnBytes(n int, sent bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if sent {
+		m.bytesSent += n
+	} else {
+		m.bytesReceived += n
+	}
+}
+
+func (m *recordingMetrics) OnError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errs = append(m.errs, err)
+}
+
+func TestPortForwardMetrics(t *testing.T) {
+	// The first connection fails to dial, the second is forwarded.
+	tcpServer, sshClient, tcpServerAddr := setupTestServer(t, testServerOpts{failedAttempts: 1})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	metrics := &recordingMetrics{closed: make(chan portforward.ConnStats, 2)}
+	listener, err := portforward.New(context.Background(), sshClient, &portforward.Config{
+		RemoteAddr: tcpServerAddr,
+		Metrics:    metrics,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	waitClosed := func() portforward.ConnStats {
+		select {
+		case stats := <-metrics.closed:
+			return stats
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the connection to close")
+			return portforward.ConnStats{}
+		}
+	}
+
+	failed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarded port: %v", err)
+	}
+	defer failed.Close()
+	if stats := waitClosed(); stats.Err == nil {
+		t.Errorf("Expected the dial error, got %+v", stats)
+	}
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarded port: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatalf("Failed to half-close connection: %v", err)
+	}
+	buf, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Failed to read from connection: %v", err)
+	}
+	if stats := waitClosed(); stats.Err != nil || stats.BytesReceived != int64(len(buf)) {
+		t.Errorf("unexpected connection stats: %+v", stats)
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.accepted != 2 || metrics.bytesSent != 0 || metrics.bytesReceived != len(buf) {
+		t.Errorf("unexpected metrics: accepted %d, sent %d, received %d", metrics.accepted, metrics.bytesSent, metrics.bytesReceived)
+	}
+	if len(metrics.errs) != 1 {
+		t.Errorf("got errors %v, want the dial error", metrics.errs)
+	}
+}
+
+func TestPortForwardContextCancel(t *testing.T) {
+	tcpServer, sshClient, tcpServerAddr := setupTestServer(t, testServerOpts{})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	listener, err := portforward.New(ctx, sshClient, &portforward.Config{
+		RemoteAddr: tcpServerAddr,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	cancel()
+
+	select {
+	case <-listener.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the listener to stop")
+	}
+
+	if conn, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("Expected the local port to be closed")
+	}
+}
+
+func TestPortForwardPrivilegedPort(t *testing.T) {
+	port := int32(1)
+	listener, err := portforward.New(context.Background(), nil, &portforward.Config{
+		LocalPort:  &port,
+		RemoteAddr: "127.0.0.1:1",
+	})
+	if err == nil {
+		listener.Close()
+		t.Skip("The process is allowed to bind privileged ports")
+	}
+
+	var privilegedPortErr *portforward.PrivilegedPortError
+	if !errors.As(err, &privilegedPortErr) {
+		t.Fatalf("Expected a PrivilegedPortError, got %v", err)
+	}
+	if privilegedPortErr.Port != port {
+		t.Errorf("got port %d, want %d", privilegedPortErr.Port, port)
+	}
+}
+
+func TestServe(t *testing.T) {
+	tcpServer, sshClient, tcpServerAddr := setupTestServer(t, testServerOpts{})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	// Forward connections accepted on a Unix socket to a TCP address, like a
+	// remote socket forwarding does with a listener on the SSH server.
+	socketListener, err := net.Listen("unix", filepath.Join(t.TempDir(), "tunnel.sock"))
+	if err != nil {
+		t.Fatalf("Failed to listen on socket: %v", err)
+	}
+
+	listener := portforward.Serve(context.Background(), socketListener, &net.Dialer{}, &portforward.Config{
+		RemoteAddr: tcpServerAddr,
+	})
+	defer listener.Close()
+
+	conn, err := net.Dial("unix", socketListener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to socket: %v", err)
+	}
+	defer conn.Close()
+
+	buf, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Failed to read from connection: %v", err)
+	}
+	if got, want := string(buf), "Hello from TCP server!"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPortForwardQuota(t *testing.T) {
+	tcpServer, sshClient, tcpServerAddr := setupTestServer(t, testServerOpts{echo: true})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	quota := portforward.NewQuota(6)
+	// A larger quota, e.g. of the whole tunnel, is only charged for the bytes
+	// forwarded within the smaller one.
+	shared := portforward.NewQuota(100)
+	closed := make(chan portforward.ConnStats, 1)
+	metrics := &recordingMetrics{closed: make(chan portforward.ConnStats, 1)}
+	listener, err := portforward.New(context.Background(), sshClient, &portforward.Config{
+		RemoteAddr:  tcpServerAddr,
+		Quotas:      []*portforward.Quota{quota, shared},
+		Metrics:     metrics,
+		OnConnClose: func(stats portforward.ConnStats) { closed <- stats },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarded port: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatalf("Failed to write to connection: %v", err)
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatalf("Failed to half-close connection: %v", err)
+	}
+
+	// Only 2 of the 4 echoed bytes fit into the quota.
+	buf, _ := io.ReadAll(conn)
+	if got, want := string(buf), "pi"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	select {
+	case stats := <-closed:
+		if !errors.Is(stats.Err, portforward.ErrQuotaExceeded) {
+			t.Errorf("Expected ErrQuotaExceeded, got %v", stats.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the connection to close")
+	}
+
+	select {
+	case <-quota.Exceeded():
+	default:
+		t.Error("Expected the quota to be exceeded")
+	}
+	if got := quota.Used(); got != 6 {
+		t.Errorf("got %d bytes used, want 6", got)
+	}
+	if got := shared.Used(); got != 6 {
+		t.Errorf("got %d bytes used of the shared quota, want 6", got)
+	}
+	select {
+	case <-shared.Exceeded():
+		t.Error("Expected the shared quota not to be exceeded")
+	default:
+	}
+	metrics.mu.Lock()
+	if len(metrics.errs) != 1 || !errors.Is(metrics.errs[0], portforward.ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded to be reported, got %v", metrics.errs)
+	}
+	metrics.mu.Unlock()
+
+	select {
+	case <-listener.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the listener to stop")
+	}
+}
+
+// brokenRemoteDialer dials remote connections whose writes fail.
+type brokenRemoteDialer struct{}
+
+func (brokenRemoteDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	local, remote := net.Pipe()
+	remote.Close()
+	return local, nil
+}
+
+func TestPortForwardQuotaUnforwardedBytes(t *testing.T) {
+	// Writing all of "pingpong" would exceed the quota, but the write fails
+	// and no byte is forwarded.
+	quota := portforward.NewQuota(6)
+	closed := make(chan portforward.ConnStats, 1)
+	listener, err := portforward.New(context.Background(), brokenRemoteDialer{}, &portforward.Config{
+		RemoteAddr:  "db.internal:5432",
+		Quotas:      []*portforward.Quota{quota},
+		OnConnClose: func(stats portforward.ConnStats) { closed <- stats },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarded port: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "pingpong"); err != nil {
+		t.Fatalf("Failed to write to connection: %v", err)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the connection to close")
+	}
+
+	select {
+	case <-quota.Exceeded():
+		t.Error("Expected the quota not to be exceeded by bytes that were not forwarded")
+	default:
+	}
+	if got := quota.Used(); got != 0 {
+		t.Errorf("got %d bytes used, want 0", got)
+	}
+}
+
+func TestPortForwardStallTimeout(t *testing.T) {
+	// The echo server only responds once the client half-closed.
+	tcpServer, sshClient, tcpServerAddr := setupTestServer(t, testServerOpts{echo: true})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	closed := make(chan portforward.ConnStats, 2)
+	listener, err := portforward.New(context.Background(), sshClient, &portforward.Config{
+		RemoteAddr:   tcpServerAddr,
+		StallTimeout: 100 * time.Millisecond,
+		OnConnClose:  func(stats portforward.ConnStats) { closed <- stats },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	idle, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarded port: %v", err)
+	}
+	defer idle.Close()
+
+	stalled, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarded port: %v", err)
+	}
+	defer stalled.Close()
+
+	if _, err := io.WriteString(stalled, "ping"); err != nil {
+		t.Fatalf("Failed to write to connection: %v", err)
+	}
+
+	select {
+	case stats := <-closed:
+		if !errors.Is(stats.Err, portforward.ErrStalled) {
+			t.Errorf("Expected ErrStalled, got %v", stats.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the stalled connection to close")
+	}
+	if got := listener.Stats().Stalled; got != 1 {
+		t.Errorf("got %d stalled connections, want 1", got)
+	}
+
+	// The idle connection outlived the stall timeout and still works.
+	if _, err := io.WriteString(idle, "pong"); err != nil {
+		t.Fatalf("Failed to write to connection: %v", err)
+	}
+	if err := idle.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatalf("Failed to half-close connection: %v", err)
+	}
+	buf, err := io.ReadAll(idle)
+	if err != nil {
+		t.Fatalf("Failed to read from connection: %v", err)
+	}
+	if got, want := string(buf), "pong"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPortForwardBudget(t *testing.T) {
+	// The echo server keeps connections open until the client half-closed.
+	tcpServer, sshClient, tcpServerAddr := setupTestServer(t, testServerOpts{echo: true})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	budget := portforward.NewBudget(1)
+	listeners := make([]*portforward.Listener, 2)
+	for i := range listeners {
+		listener, err := portforward.New(context.Background(), sshClient, &portforward.Config{
+			RemoteAddr: tcpServerAddr,
+			Budget:     budget,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create port forward: %v", err)
+		}
+		defer listener.Close()
+		listeners[i] = listener
+	}
+
+	first, err := net.Dial("tcp", listeners[0].Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarded port: %v", err)
+	}
+	defer first.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for budget.InUse() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the first connection to be forwarded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The budget is shared, so the second listener rejects connections.
+	rejected, err := net.Dial("tcp", listeners[1].Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarded port: %v", err)
+	}
+	defer rejected.Close()
+	_ = rejected.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(rejected); err != nil {
+		t.Fatalf("Expected the rejected connection to be closed, got %v", err)
+	}
+	if got := listeners[1].Stats().Rejected; got != 1 {
+		t.Errorf("got %d rejected connections, want 1", got)
+	}
+
+	if err := first.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatalf("Failed to half-close connection: %v", err)
+	}
+	if _, err := io.ReadAll(first); err != nil {
+		t.Fatalf("Failed to read from connection: %v", err)
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for budget.InUse() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the budget to be released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := budget.Peak(); got != 1 {
+		t.Errorf("got peak %d, want 1", got)
+	}
+}
+
+func TestPortForwardFaults(t *testing.T) {
+	tcpServer, sshClient, tcpServerAddr := setupTestServer(t, testServerOpts{echo: true})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	t.Run("latency", func(t *testing.T) {
+		listener, err := portforward.New(context.Background(), sshClient, &portforward.Config{
+			RemoteAddr: tcpServerAddr,
+			Faults:     &portforward.Faults{Latency: 50 * time.Millisecond},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create port forward: %v", err)
+		}
+		defer listener.Close()
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect to forwarded port: %v", err)
+		}
+		defer conn.Close()
+
+		start := time.Now()
+		if _, err := io.WriteString(conn, "ping"); err != nil {
+			t.Fatalf("Failed to write to connection: %v", err)
+		}
+		if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+			t.Fatalf("Failed to half-close connection: %v", err)
+		}
+		buf, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatalf("Failed to read from connection: %v", err)
+		}
+		if got, want := string(buf), "ping"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		// The data is delayed once in each direction.
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("Expected a round trip of at least 100ms, got %s", elapsed)
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		closed := make(chan portforward.ConnStats, 1)
+		listener, err := portforward.New(context.Background(), sshClient, &portforward.Config{
+			RemoteAddr:  tcpServerAddr,
+			Faults:      &portforward.Faults{DropRate: 1},
+			OnConnClose: func(stats portforward.ConnStats) { closed <- stats },
+		})
+		if err != nil {
+			t.Fatalf("Failed to create port forward: %v", err)
+		}
+		defer listener.Close()
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect to forwarded port: %v", err)
+		}
+		defer conn.Close()
+
+		if _, err := io.WriteString(conn, "ping"); err != nil {
+			t.Fatalf("Failed to write to connection: %v", err)
+		}
+
+		select {
+		case stats := <-closed:
+			if !errors.Is(stats.Err, portforward.ErrFaultInjected) {
+				t.Errorf("Expected ErrFaultInjected, got %v", stats.Err)
+			}
+			if stats.BytesSent != 0 {
+				t.Errorf("Expected no bytes to be sent, got %d", stats.BytesSent)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the connection to be dropped")
+		}
+	})
+}
+
+func TestPortForwardSNIRoutes(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, name)
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	api := newBackend("api")
+	web := newBackend("web")
+	fallback := newBackend("fallback")
+
+	listener, err := portforward.New(context.Background(), &net.Dialer{}, &portforward.Config{
+		RemoteAddr: fallback.Listener.Addr().String(),
+		SNIRoutes: map[string]string{
+			"API.internal":   api.Listener.Addr().String(),
+			"*.web.internal": web.Listener.Addr().String(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, listener.Addr().String())
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	defer client.CloseIdleConnections()
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://api.internal/", want: "api"},
+		{url: "https://www.web.internal/", want: "web"},
+		{url: "https://web.internal/", want: "fallback"},
+		{url: "https://other.internal/", want: "fallback"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			res, err := client.Get(tt.url)
+			if err != nil {
+				t.Fatalf("Failed to request: %v", err)
+			}
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			if got := string(body); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPortForwardSNIRoutesNoRoute(t *testing.T) {
+	closed := make(chan portforward.ConnStats, 1)
+	listener, err := portforward.New(context.Background(), &net.Dialer{}, &portforward.Config{
+		SNIRoutes:   map[string]string{"api.internal": "127.0.0.1:1"},
+		OnConnClose: func(stats portforward.ConnStats) { closed <- stats },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: "other.internal", InsecureSkipVerify: true})
+	if err == nil {
+		conn.Close()
+		t.Fatal("Expected the handshake to fail")
+	}
+
+	select {
+	case stats := <-closed:
+		if !errors.Is(stats.Err, portforward.ErrNoSNIRoute) {
+			t.Errorf("Expected ErrNoSNIRoute, got %v", stats.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the connection to close")
+	}
+	if got := listener.Stats().Failed; got != 1 {
+		t.Errorf("got %d failed connections, want 1", got)
+	}
+}
+
+func TestPortForwardSOCKS5(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "through the proxy")
+	}))
+	defer backend.Close()
+	_, backendPort, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := portforward.New(context.Background(), &net.Dialer{}, &portforward.Config{ListenHost: "127.0.0.1", SOCKS5: true})
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	proxyURL, err := url.Parse("socks5h://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	defer client.CloseIdleConnections()
+
+	// localhost is resolved by the dialer, 127.0.0.1 is passed as is.
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		res, err := client.Get("http://" + net.JoinHostPort(host, backendPort) + "/")
+		if err != nil {
+			t.Fatalf("Failed to request through the proxy: %v", err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		if got := string(body); got != "through the proxy" {
+			t.Errorf("got %q, want %q", got, "through the proxy")
+		}
+	}
+
+	// Unreachable targets are reported to the client.
+	if _, err := client.Get("http://127.0.0.1:1/"); err == nil {
+		t.Error("Expected an error for an unreachable target")
+	}
+
+	// Clients not speaking SOCKS5 are disconnected.
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, conn)
+	if got := listener.Stats().Failed; got != 2 {
+		t.Errorf("got %d failed connections, want 2", got)
+	}
+}
+
+func TestPortForwardFanOutGuard(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "through the proxy")
+	}))
+	defer backend.Close()
+	_, backendPort, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		limits portforward.FanOutLimits
+		// targets are requested in order, the last one trips the guard.
+		targets []string
+	}{
+		{
+			name:    "ports",
+			limits:  portforward.FanOutLimits{MaxPorts: 2},
+			targets: []string{"127.0.0.1:" + backendPort, "127.0.0.1:" + backendPort, "127.0.0.1:1", "127.0.0.1:2"},
+		},
+		{
+			name:    "hosts",
+			limits:  portforward.FanOutLimits{MaxHosts: 1},
+			targets: []string{"127.0.0.1:" + backendPort, "127.0.0.1:1", "localhost:" + backendPort},
+		},
+		{
+			name:    "requests",
+			limits:  portforward.FanOutLimits{MaxRequests: 2, Window: time.Hour},
+			targets: []string{"127.0.0.1:" + backendPort, "127.0.0.1:" + backendPort, "127.0.0.1:" + backendPort},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := portforward.NewFanOutGuard(tt.limits)
+			listener, err := portforward.New(context.Background(), &net.Dialer{}, &portforward.Config{ListenHost: "127.0.0.1", SOCKS5: true, FanOut: guard})
+			if err != nil {
+				t.Fatalf("Failed to create port forward: %v", err)
+			}
+			defer listener.Close()
+
+			proxyURL, err := url.Parse("socks5h://" + listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Every request dials, so each one is checked by the guard.
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableKeepAlives: true}}
+
+			for _, target := range tt.targets[:len(tt.targets)-1] {
+				res, err := client.Get("http://" + target + "/")
+				if err == nil {
+					res.Body.Close()
+				}
+				if guard.Err() != nil {
+					t.Fatalf("Guard tripped early requesting %s: %v", target, guard.Err())
+				}
+			}
+
+			if _, err := client.Get("http://" + tt.targets[len(tt.targets)-1] + "/"); err == nil {
+				t.Error("Expected the request fanning out to be refused")
+			}
+			if !errors.Is(guard.Err(), portforward.ErrFanOut) {
+				t.Errorf("got %v, want %v", guard.Err(), portforward.ErrFanOut)
+			}
+			select {
+			case <-listener.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("Timed out waiting for the listener to close")
+			}
+		})
+	}
+}
+
+func TestPortForwardHTTPConnect(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "through the proxy")
+	}))
+	defer backend.Close()
+
+	listener, err := portforward.New(context.Background(), &net.Dialer{}, &portforward.Config{ListenHost: "127.0.0.1", HTTPConnect: true})
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	proxyURL, err := url.Parse("http://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := backend.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	client := &http.Client{Transport: transport}
+	defer client.CloseIdleConnections()
+
+	res, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatalf("Failed to request through the proxy: %v", err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if got := string(body); got != "through the proxy" {
+		t.Errorf("got %q, want %q", got, "through the proxy")
+	}
+
+	// Unreachable targets are reported to the client.
+	if _, err := client.Get("https://127.0.0.1:1/"); err == nil {
+		t.Error("Expected an error for an unreachable target")
+	}
+
+	// Requests other than CONNECT are rejected.
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	reply, _ := io.ReadAll(conn)
+	if want := "HTTP/1.1 405 Method Not Allowed\r\n\r\n"; string(reply) != want {
+		t.Errorf("got %q, want %q", reply, want)
+	}
+	if got := listener.Stats().Failed; got != 2 {
+		t.Errorf("got %d failed connections, want 2", got)
+	}
+}
+
+// slowWriteDialer dials connections whose writes block for delay, like an
+// SSH channel waiting for window.
+type slowWriteDialer struct {
+	delay time.Duration
+}
+
+func (d *slowWriteDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &slowWriteConn{Conn: conn, delay: d.delay}, nil
+}
+
+type slowWriteConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (c *slowWriteConn) Write(p []byte) (int, error) {
+	time.Sleep(c.delay)
+	return c.Conn.Write(p)
+}
+
+func (c *slowWriteConn) CloseWrite() error {
+	return c.Conn.(*net.TCPConn).CloseWrite()
+}
+
+func TestPortForwardBlockedWrites(t *testing.T) {
+	tcpServer, sshClient, tcpServerAddr := setupTestServer(t, testServerOpts{echo: true})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	closed := make(chan portforward.ConnStats, 1)
+	listener, err := portforward.New(context.Background(), &slowWriteDialer{delay: 100 * time.Millisecond}, &portforward.Config{
+		RemoteAddr:  tcpServerAddr,
+		OnConnClose: func(stats portforward.ConnStats) { closed <- stats },
+	})
+	if err != nil {
+		t.Fatalf("Failed to create port forward: %v", err)
+	}
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to forwarded port: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatalf("Failed to write to connection: %v", err)
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatalf("Failed to half-close connection: %v", err)
+	}
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("Failed to read from connection: %v", err)
+	}
+
+	select {
+	case stats := <-closed:
+		if stats.SendBlocked < 100*time.Millisecond {
+			t.Errorf("Expected sends to be blocked for at least 100ms, got %s", stats.SendBlocked)
+		}
+		if stats.ReceiveBlocked >= 100*time.Millisecond {
+			t.Errorf("Expected receives not to be blocked, got %s", stats.ReceiveBlocked)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the connection to close")
+	}
+
+	stats := listener.Stats()
+	if stats.SendBlocked < 100*time.Millisecond {
+		t.Errorf("Expected the listener sends to be blocked for at least 100ms, got %s", stats.SendBlocked)
+	}
+	if stats.Duration < stats.SendBlocked {
+		t.Errorf("Expected the duration %s to include the blocked time %s", stats.Duration, stats.SendBlocked)
+	}
+}
+
+func TestPortForwardPriority(t *testing.T) {
+	// The echo server reads until the client half-closed, sinking the data.
+	tcpServer, sshClient, tcpServerAddr := setupTestServer(t, testServerOpts{echo: true})
+	defer tcpServer.Close()
+	defer sshClient.Close()
+
+	// Writes taking 2ms each, like a saturated link, are served one at a
+	// time by the scheduler.
+	dialer := &slowWriteDialer{delay: 2 * time.Millisecond}
+	scheduler := portforward.NewScheduler()
+	interactive := scheduler.NewClass("interactive", 4)
+	bulk := scheduler.NewClass("bulk", 1)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for _, class := range []*portforward.PriorityClass{interactive, bulk} {
+		listener, err := portforward.New(context.Background(), dialer, &portforward.Config{
+			RemoteAddr: tcpServerAddr,
+			Priority:   class,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create port forward: %v", err)
+		}
+		defer listener.Close()
+
+		// Two connections per class keep writes of both classes queued.
+		for i := 0; i < 2; i++ {
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatalf("Failed to connect to forwarded port: %v", err)
+			}
+			defer conn.Close()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				chunk := make([]byte, 1024)
+				for {
+					select {
+					case <-done:
+						// Read the echo so the server finishes before the test.
+						_ = conn.(*net.TCPConn).CloseWrite()
+						_, _ = io.Copy(io.Discard, conn)
+						return
+					default:
+					}
+					if _, err := conn.Write(chunk); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}
+
+	time.Sleep(time.Second)
+	interactiveForwarded, bulkForwarded := interactive.Forwarded(), bulk.Forwarded()
+	close(done)
+	wg.Wait()
+
+	if interactiveForwarded < 2*bulkForwarded {
+		t.Errorf("Expected the interactive class to forward about 4 times the bytes of the bulk class, got %d and %d", interactiveForwarded, bulkForwarded)
+	}
+	if bulkForwarded == 0 {
+		t.Error("Expected the bulk class not to starve")
+	}
+}
@@ -0,0 +1,172 @@
+package portforward
+
+import (
+	"container/heap"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// schedulerQuantum is the longest a write holds the turn of a Scheduler. A
+// write blocked for longer, e.g. waiting for the window of its SSH channel,
+// keeps blocking but lets the next write go ahead.
+const schedulerQuantum = 20 * time.Millisecond
+
+// Scheduler shares the connection used by listeners, e.g. the SSH connection
+// of a tunnel, between their priority classes by weighted fair queuing:
+// writes take turns in the order of their virtual finish time, so under
+// contention a class with weight 8 forwards eight times the bytes of a class
+// with weight 1, while an idle class doesn't take anything away. Without
+// contention writes aren't delayed.
+type Scheduler struct {
+	mu sync.Mutex
+	// virtual is the virtual time, the start tag of the write holding the
+	// turn.
+	virtual float64
+	busy    bool
+	waiting writeQueue
+	seq     uint64
+}
+
+// NewScheduler returns a Scheduler without classes.
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// PriorityClass is a class of listeners of a Scheduler, e.g. interactive or
+// bulk. Writes of all listeners of a class are queued together.
+type PriorityClass struct {
+	scheduler *Scheduler
+	name      string
+	weight    float64
+	// finish is the virtual finish time of the last write of the class,
+	// guarded by the mutex of the scheduler.
+	finish    float64
+	forwarded atomic.Uint64
+}
+
+// NewClass returns a class with a positive weight.
+func (s *Scheduler) NewClass(name string, weight int) *PriorityClass {
+	return &PriorityClass{scheduler: s, name: name, weight: float64(max(weight, 1))}
+}
+
+// Name returns the name of the class.
+func (c *PriorityClass) Name() string {
+	return c.name
+}
+
+// Weight returns the weight of the class.
+func (c *PriorityClass) Weight() int {
+	return int(c.weight)
+}
+
+// Forwarded returns the number of bytes forwarded in both directions by the
+// listeners of the class.
+func (c *PriorityClass) Forwarded() uint64 {
+	return c.forwarded.Load()
+}
+
+type queuedWrite struct {
+	start, finish float64
+	seq           uint64
+	index         int
+	ready         chan struct{}
+}
+
+// writeQueue is a min-heap of writes ordered by their finish time.
+type writeQueue []*queuedWrite
+
+func (q writeQueue) Len() int { return len(q) }
+
+func (q writeQueue) Less(i, j int) bool {
+	if q[i].finish != q[j].finish {
+		return q[i].finish < q[j].finish
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q writeQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *writeQueue) Push(x any) {
+	w := x.(*queuedWrite)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *writeQueue) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
+
+// acquire waits for the turn of a write of n bytes and returns the function
+// ending it, which has to be called once the write returned. It fails with
+// net.ErrClosed once done is closed.
+func (c *PriorityClass) acquire(n int, done <-chan struct{}) (release func(), err error) {
+	s := c.scheduler
+
+	s.mu.Lock()
+	start := max(s.virtual, c.finish)
+	c.finish = start + float64(n)/c.weight
+	if !s.busy {
+		s.busy = true
+		s.virtual = start
+		s.mu.Unlock()
+		return s.turn(), nil
+	}
+	s.seq++
+	w := &queuedWrite{start: start, finish: c.finish, seq: s.seq, ready: make(chan struct{})}
+	heap.Push(&s.waiting, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return s.turn(), nil
+	case <-done:
+		s.mu.Lock()
+		queued := w.index >= 0
+		if queued {
+			heap.Remove(&s.waiting, w.index)
+		}
+		s.mu.Unlock()
+		if !queued {
+			// The turn was passed on concurrently, pass it on again.
+			s.turn()()
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+// turn starts the turn of a write, ending it once the returned function is
+// called or after schedulerQuantum, whichever is first.
+func (s *Scheduler) turn() func() {
+	var once sync.Once
+	next := func() { once.Do(s.next) }
+	timer := time.AfterFunc(schedulerQuantum, next)
+	return func() {
+		timer.Stop()
+		next()
+	}
+}
+
+// next passes the turn on to the queued write finishing first.
+func (s *Scheduler) next() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.waiting.Len() == 0 {
+		s.busy = false
+		return
+	}
+	w := heap.Pop(&s.waiting).(*queuedWrite)
+	s.virtual = w.start
+	close(w.ready)
+}
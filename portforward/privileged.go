@@ -0,0 +1,38 @@
+package portforward
+
+import (
+	"fmt"
+)
+
+// privilegedPortLimit is the first port not requiring privileges to bind.
+const privilegedPortLimit = 1024
+
+// PrivilegedPortError is returned by New if binding a local port below 1024
+// is not permitted.
+type PrivilegedPortError struct {
+	Port int32
+	// Reason describes the missing privilege, if it could be detected.
+	Reason string
+	Err    error
+}
+
+func (e *PrivilegedPortError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("binding privileged port %d is not permitted: %v", e.Port, e.Err)
+	}
+	return fmt.Sprintf("binding privileged port %d is not permitted, %s: %v", e.Port, e.Reason, e.Err)
+}
+
+func (e *PrivilegedPortError) Unwrap() error {
+	return e.Err
+}
+
+// privilegedPortError wraps err in a PrivilegedPortError if it was caused by
+// missing privileges to bind port.
+func privilegedPortError(port *int32, err error) error {
+	if port == nil || *port <= 0 || *port >= privilegedPortLimit || !isPermissionError(err) {
+		return err
+	}
+
+	return &PrivilegedPortError{Port: *port, Reason: privilegedPortReason(*port), Err: err}
+}
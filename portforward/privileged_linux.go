@@ -0,0 +1,66 @@
+package portforward
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// capNetBindService is the bit of CAP_NET_BIND_SERVICE in capability sets.
+const capNetBindService = 10
+
+func isPermissionError(err error) bool {
+	return errors.Is(err, os.ErrPermission)
+}
+
+// privilegedPortReason checks the capabilities of the process and the
+// net.ipv4.ip_unprivileged_port_start sysctl.
+func privilegedPortReason(port int32) string {
+	start := unprivilegedPortStart()
+	if int(port) >= start {
+		return "although net.ipv4.ip_unprivileged_port_start allows it, check SELinux or AppArmor policies"
+	}
+	if hasCapability(capNetBindService) {
+		return "although the process has CAP_NET_BIND_SERVICE, check SELinux or AppArmor policies"
+	}
+	return fmt.Sprintf("the process lacks CAP_NET_BIND_SERVICE and net.ipv4.ip_unprivileged_port_start is %d", start)
+}
+
+func unprivilegedPortStart() int {
+	b, err := os.ReadFile("/proc/sys/net/ipv4/ip_unprivileged_port_start")
+	if err != nil {
+		return privilegedPortLimit
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return privilegedPortLimit
+	}
+	return start
+}
+
+// hasCapability reports whether capability is in the effective set of the
+// process.
+func hasCapability(capability uint) bool {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return false
+		}
+		return caps&(1<<capability) != 0
+	}
+	return false
+}
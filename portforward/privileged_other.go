@@ -0,0 +1,19 @@
+//go:build !linux && !windows
+
+package portforward
+
+import (
+	"errors"
+	"os"
+)
+
+func isPermissionError(err error) bool {
+	return errors.Is(err, os.ErrPermission)
+}
+
+func privilegedPortReason(_ int32) string {
+	if os.Geteuid() != 0 {
+		return "the process is not running as root"
+	}
+	return ""
+}
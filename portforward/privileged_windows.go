@@ -0,0 +1,22 @@
+package portforward
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+func isPermissionError(err error) bool {
+	return errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.WSAEACCES)
+}
+
+// privilegedPortReason checks whether the process is elevated. Binding is
+// also denied for ports in an excluded port range of the system.
+func privilegedPortReason(_ int32) string {
+	if windows.GetCurrentProcessToken().IsElevated() {
+		return "the port may be in an excluded port range"
+	}
+	return "the process is not running as administrator"
+}
@@ -0,0 +1,71 @@
+package portforward
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrQuotaExceeded is returned for forwarded connections that were cut off
+// because a Quota was exceeded.
+var ErrQuotaExceeded = errors.New("data transfer quota exceeded")
+
+// Quota limits the number of bytes forwarded in both directions. A Quota can
+// be shared between listeners, e.g. to limit all forwardings of a connection.
+// Once it is exceeded, all listeners using it are closed.
+type Quota struct {
+	max int64
+	// reserved are the bytes forwarded and reserved by writes in progress.
+	reserved  atomic.Int64
+	forwarded atomic.Int64
+
+	exceededOnce sync.Once
+	exceeded     chan struct{}
+}
+
+// NewQuota returns a Quota allowing maxBytes to be forwarded.
+func NewQuota(maxBytes int64) *Quota {
+	return &Quota{
+		max:      maxBytes,
+		exceeded: make(chan struct{}),
+	}
+}
+
+// Max returns the number of bytes allowed by the quota.
+func (q *Quota) Max() int64 {
+	return q.max
+}
+
+// Used returns the number of bytes forwarded so far.
+func (q *Quota) Used() int64 {
+	return q.forwarded.Load()
+}
+
+// Exceeded is closed once all bytes allowed were forwarded and forwarding
+// more was attempted.
+func (q *Quota) Exceeded() <-chan struct{} {
+	return q.exceeded
+}
+
+// reserve takes n bytes from the quota and returns the number of bytes
+// granted, which is less than n once the quota is exhausted. All n bytes are
+// reserved until settled.
+func (q *Quota) reserve(n int) int {
+	reserved := q.reserved.Add(int64(n))
+	if reserved <= q.max {
+		return n
+	}
+
+	return int(max(q.max-(reserved-int64(n)), 0))
+}
+
+// settle settles a reservation of n bytes of which forwarded were forwarded,
+// returning the rest, e.g. because another quota granted less or the write
+// was short. Exceeded is signalled once the forwarded bytes reach the quota
+// while more were to be forwarded than granted.
+func (q *Quota) settle(n, forwarded int, cutOff bool) {
+	q.reserved.Add(-int64(n - forwarded))
+	if q.forwarded.Add(int64(forwarded)) >= q.max && cutOff {
+		q.exceededOnce.Do(func() { close(q.exceeded) })
+	}
+}
@@ -0,0 +1,104 @@
+package portforward
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ErrNoSNIRoute is returned for connections whose TLS server name matches
+// none of the SNIRoutes when no RemoteAddr is configured.
+var ErrNoSNIRoute = errors.New("no route for the TLS server name")
+
+// sniTimeout bounds the time a client may take to send its ClientHello.
+const sniTimeout = 10 * time.Second
+
+// errClientHelloRead aborts the handshake once the ClientHello was read.
+var errClientHelloRead = errors.New("client hello read")
+
+// routeSNI returns the remote address for serverName: the address of the
+// matching SNIRoutes entry, a "*.example.com" entry matching any direct
+// subdomain, otherwise RemoteAddr.
+func (l *Listener) routeSNI(serverName string) (string, error) {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if addr, ok := l.sniRoutes[name]; ok && name != "" {
+		return addr, nil
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		if addr, ok := l.sniRoutes["*."+parent]; ok {
+			return addr, nil
+		}
+	}
+
+	if l.conf.RemoteAddr == "" {
+		return "", fmt.Errorf("%w %q", ErrNoSNIRoute, serverName)
+	}
+	return l.conf.RemoteAddr, nil
+}
+
+// readClientHello reads the TLS ClientHello from conn and returns its server
+// name, empty if the client sent none. The returned conn replays the bytes
+// read, so the handshake can be completed by the remote.
+func readClientHello(conn net.Conn, timeout time.Duration) (string, net.Conn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return "", nil, err
+	}
+
+	var read bytes.Buffer
+	var hello *tls.ClientHelloInfo
+	err := tls.Server(&readOnlyConn{Conn: conn, r: io.TeeReader(conn, &read)}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = info
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+	if hello == nil {
+		return "", nil, fmt.Errorf("unable to read TLS client hello: %w", err)
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return "", nil, err
+	}
+
+	return hello.ServerName, &replayConn{Conn: conn, r: io.MultiReader(&read, conn)}, nil
+}
+
+// readOnlyConn lets a TLS server read the ClientHello from r without
+// responding to the client.
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readOnlyConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *readOnlyConn) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// replayConn reads from r, the bytes already read from Conn followed by
+// Conn itself.
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CloseWrite half-closes Conn if it supports it, so half-closes are still
+// propagated.
+func (c *replayConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,126 @@
+package portforward
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ErrSOCKS is returned for connections to SOCKS5 listeners that don't send
+// a valid SOCKS5 CONNECT request.
+var ErrSOCKS = errors.New("invalid SOCKS5 request")
+
+// socksTimeout bounds the time a client may take to send its SOCKS5 request.
+const socksTimeout = 10 * time.Second
+
+const (
+	socksVersion          = 0x05
+	socksMethodNoAuth     = 0x00
+	socksMethodNoneUsable = 0xff
+	socksCommandConnect   = 0x01
+	socksAddrIPv4         = 0x01
+	socksAddrDomain       = 0x03
+	socksAddrIPv6         = 0x04
+)
+
+// Reply codes of RFC 1928, section 6.
+const (
+	socksSucceeded           = 0x00
+	socksNotAllowed          = 0x02
+	socksHostUnreachable     = 0x04
+	socksCommandNotSupported = 0x07
+	socksAddrNotSupported    = 0x08
+)
+
+// readSOCKS5Request negotiates a SOCKS5 session without authentication with
+// the client on conn and returns the address of its CONNECT request. Domain
+// names are returned unresolved, so the SSH server resolves them, like
+// `ssh -D`. Unsupported requests are answered with an error reply.
+func readSOCKS5Request(conn net.Conn, timeout time.Duration) (string, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrSOCKS, err)
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("%w: unsupported version %d", ErrSOCKS, header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrSOCKS, err)
+	}
+	method := byte(socksMethodNoneUsable)
+	for _, m := range methods {
+		if m == socksMethodNoAuth {
+			method = socksMethodNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == socksMethodNoneUsable {
+		return "", fmt.Errorf("%w: client requires authentication", ErrSOCKS)
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrSOCKS, err)
+	}
+	if request[0] != socksVersion {
+		return "", fmt.Errorf("%w: unsupported version %d", ErrSOCKS, request[0])
+	}
+
+	var host string
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socksAddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", fmt.Errorf("%w: %w", ErrSOCKS, err)
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", fmt.Errorf("%w: %w", ErrSOCKS, err)
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", fmt.Errorf("%w: %w", ErrSOCKS, err)
+		}
+		host = string(domain)
+	default:
+		_ = writeSOCKS5Reply(conn, socksAddrNotSupported)
+		return "", fmt.Errorf("%w: unsupported address type %d", ErrSOCKS, request[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrSOCKS, err)
+	}
+
+	if request[1] != socksCommandConnect {
+		_ = writeSOCKS5Reply(conn, socksCommandNotSupported)
+		return "", fmt.Errorf("%w: unsupported command %d", ErrSOCKS, request[1])
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// writeSOCKS5Reply answers a CONNECT request with reply. The bound address
+// is left unspecified, as the SSH server doesn't report it.
+func writeSOCKS5Reply(conn net.Conn, reply byte) error {
+	_, err := conn.Write([]byte{socksVersion, reply, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
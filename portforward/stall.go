@@ -0,0 +1,75 @@
+package portforward
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrStalled is returned for forwarded connections that were closed because
+// they made no progress for the StallTimeout.
+var ErrStalled = errors.New("connection made no progress")
+
+// stallDetector tracks the progress of a forwarded connection. A connection
+// is stalled if it made no progress for the timeout while a write is blocked
+// or data sent to the remote is awaiting a response. Idle connections are
+// never stalled.
+type stallDetector struct {
+	timeout time.Duration
+
+	mu           sync.Mutex
+	lastProgress time.Time
+	writing      int
+	awaiting     bool
+}
+
+func newStallDetector(timeout time.Duration) *stallDetector {
+	return &stallDetector{timeout: timeout, lastProgress: time.Now()}
+}
+
+// startWrite records a write to the remote, if sent, or the local side.
+func (d *stallDetector) startWrite() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.writing++
+}
+
+// endWrite records that n bytes were written to the remote, if sent, or the
+// local side.
+func (d *stallDetector) endWrite(n int, sent bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.writing--
+	if n > 0 {
+		d.lastProgress = time.Now()
+		d.awaiting = sent
+	}
+}
+
+// stalled reports whether the connection is stalled at now.
+func (d *stallDetector) stalled(now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return (d.writing > 0 || d.awaiting) && now.Sub(d.lastProgress) >= d.timeout
+}
+
+// watch calls onStall once the connection stalls, until done is closed.
+func (d *stallDetector) watch(done <-chan struct{}, onStall func()) {
+	ticker := time.NewTicker(max(d.timeout/4, 10*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if d.stalled(now) {
+				onStall()
+				return
+			}
+		}
+	}
+}
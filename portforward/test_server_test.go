@@ -35,6 +35,13 @@ func generateTestSSHKey(t *testing.T) ssh.Signer {
 
 type testServerOpts struct {
 	failedAttempts int
+	// echo makes the TCP server reply with everything it read once the
+	// client half-closed the connection.
+	echo bool
+	// socketPath is the remote Unix socket accepted for
+	// direct-streamlocal@openssh.com channels, which are connected to the
+	// TCP server as well.
+	socketPath string
 }
 
 func setupTestServer(t *testing.T, opts testServerOpts) (net.Listener, *ssh.Client, string) {
@@ -58,9 +65,15 @@ func setupTestServer(t *testing.T, opts testServerOpts) (net.Listener, *ssh.Clie
 			}
 			go func(conn net.Conn) {
 				defer conn.Close()
-				if attempts < opts.failedAttempts {
-					attempts++
-					conn.Close()
+				if opts.echo {
+					data, err := io.ReadAll(conn)
+					if err != nil {
+						t.Log("Failed to read from connection", "err", err)
+						return
+					}
+					if _, err := conn.Write(data); err != nil {
+						t.Log("Failed to write to connection", "err", err)
+					}
 					return
 				}
 				_, err := io.WriteString(conn, "Hello from TCP server!")
@@ -101,13 +114,35 @@ func setupTestServer(t *testing.T, opts testServerOpts) (net.Listener, *ssh.Clie
 				go ssh.DiscardRequests(reqs)
 
 				for newChannel := range chans {
-					if newChannel.ChannelType() != "direct-tcpip" {
+					switch newChannel.ChannelType() {
+					case "direct-tcpip":
+					case "direct-streamlocal@openssh.com":
+						var msg struct {
+							SocketPath string
+							Reserved0  string
+							Reserved1  uint32
+						}
+						if err := ssh.Unmarshal(newChannel.ExtraData(), &msg); err != nil || msg.SocketPath != opts.socketPath {
+							if err := newChannel.Reject(ssh.ConnectionFailed, "no such socket"); err != nil {
+								t.Log("Failed to reject channel", "err", err)
+							}
+							continue
+						}
+					default:
 						if err := newChannel.Reject(ssh.UnknownChannelType, "unknown channel type"); err != nil {
 							t.Log("Failed to reject channel", "err", err)
 						}
 						continue
 					}
 
+					if attempts < opts.failedAttempts {
+						attempts++
+						if err := newChannel.Reject(ssh.ConnectionFailed, "connection refused"); err != nil {
+							t.Log("Failed to reject channel", "err", err)
+						}
+						continue
+					}
+
 					channel, requests, err := newChannel.Accept()
 					if err != nil {
 						return
@@ -121,20 +156,27 @@ func setupTestServer(t *testing.T, opts testServerOpts) (net.Listener, *ssh.Clie
 						continue
 					}
 
-					// Bind bidirectional communication
+					// Bind bidirectional communication, propagating half-closes
+					done := make(chan struct{}, 2)
 					go func() {
-						defer channel.Close()
-						defer targetConn.Close()
 						if _, err := io.Copy(channel, targetConn); err != nil {
 							t.Log("Failed to copy data from remote to local", "err", err)
 						}
+						_ = channel.CloseWrite()
+						done <- struct{}{}
 					}()
 					go func() {
-						defer channel.Close()
-						defer targetConn.Close()
 						if _, err := io.Copy(targetConn, channel); err != nil {
 							t.Log("Failed to copy data from local to remote", "err", err)
 						}
+						_ = targetConn.(*net.TCPConn).CloseWrite()
+						done <- struct{}{}
+					}()
+					go func() {
+						<-done
+						<-done
+						channel.Close()
+						targetConn.Close()
 					}()
 				}
 			}(conn)
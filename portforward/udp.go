@@ -0,0 +1,209 @@
+package portforward
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var errUDPDeadline = errors.New("udp: deadline not supported")
+
+// DefaultUDPIdleTimeout is the time UDP clients are remembered without
+// sending or receiving a datagram.
+const DefaultUDPIdleTimeout = time.Minute
+
+// udpQueue is the number of datagrams and new clients queued before further
+// ones are dropped, like a full socket buffer would.
+const udpQueue = 64
+
+// maxDatagram is the largest UDP payload.
+const maxDatagram = 65535
+
+// ListenUDP listens for datagrams on the local UDP address addr and returns
+// a net.Listener for Serve. Datagrams of a new client address are accepted
+// as a connection, its reads return one datagram each and its writes are
+// sent back to the client as one datagram. Connections without a datagram
+// in either direction for idleTimeout are closed, so the relays of clients
+// that went away are torn down. Zero uses DefaultUDPIdleTimeout.
+//
+// Datagram boundaries are preserved as far as the dialer preserves the
+// boundaries of reads and writes, e.g. a stream relayed through `nc -u`
+// may merge datagrams arriving back to back.
+func ListenUDP(addr string, idleTimeout time.Duration) (net.Listener, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultUDPIdleTimeout
+	}
+
+	l := &udpListener{
+		conn:        conn,
+		idleTimeout: idleTimeout,
+		clients:     map[string]*udpConn{},
+		accept:      make(chan *udpConn, udpQueue),
+		closed:      make(chan struct{}),
+	}
+	go l.read()
+	return l, nil
+}
+
+// udpListener demultiplexes the datagrams received on conn by client
+// address.
+type udpListener struct {
+	conn        *net.UDPConn
+	idleTimeout time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*udpConn
+
+	accept    chan *udpConn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (l *udpListener) read() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			l.Close()
+			return
+		}
+		datagram := append([]byte(nil), buf[:n]...)
+
+		l.mu.Lock()
+		client, ok := l.clients[addr.String()]
+		if !ok {
+			client = &udpConn{
+				listener:  l,
+				addr:      addr,
+				datagrams: make(chan []byte, udpQueue),
+				closed:    make(chan struct{}),
+			}
+			client.idle = time.AfterFunc(l.idleTimeout, func() { client.Close() })
+			select {
+			case l.accept <- client:
+				l.clients[addr.String()] = client
+			default:
+				// Drop clients exceeding the queue until Serve catches up.
+				client.idle.Stop()
+				l.mu.Unlock()
+				continue
+			}
+		}
+		l.mu.Unlock()
+
+		client.deliver(datagram)
+	}
+}
+
+func (l *udpListener) Accept() (net.Conn, error) {
+	select {
+	case client := <-l.accept:
+		return client, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *udpListener) Close() error {
+	err := net.ErrClosed
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.conn.Close()
+	})
+	return err
+}
+
+func (l *udpListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *udpListener) remove(client *udpConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.clients[client.addr.String()] == client {
+		delete(l.clients, client.addr.String())
+	}
+}
+
+// udpConn is the connection of a single client of a udpListener.
+type udpConn struct {
+	listener  *udpListener
+	addr      *net.UDPAddr
+	datagrams chan []byte
+	idle      *time.Timer
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// deliver queues datagram for Read, dropping it if the queue is full.
+func (c *udpConn) deliver(datagram []byte) {
+	c.idle.Reset(c.listener.idleTimeout)
+	select {
+	case c.datagrams <- datagram:
+	default:
+	}
+}
+
+// Read returns the next datagram of the client, truncated to b like a UDP
+// socket does.
+func (c *udpConn) Read(b []byte) (int, error) {
+	select {
+	case datagram := <-c.datagrams:
+		return copy(b, datagram), nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+// Write sends b to the client as one datagram.
+func (c *udpConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	c.idle.Reset(c.listener.idleTimeout)
+	return c.listener.conn.WriteToUDP(b, c.addr)
+}
+
+// Close forgets the client, its next datagram is accepted as a new
+// connection.
+func (c *udpConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.idle.Stop()
+		c.listener.remove(c)
+	})
+	return nil
+}
+
+func (c *udpConn) LocalAddr() net.Addr {
+	return c.listener.Addr()
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *udpConn) SetDeadline(t time.Time) error {
+	return errUDPDeadline
+}
+
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	return errUDPDeadline
+}
+
+func (c *udpConn) SetWriteDeadline(t time.Time) error {
+	return errUDPDeadline
+}
@@ -0,0 +1,69 @@
+package portforward_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+func TestListenUDP(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	udpListener, err := portforward.ListenUDP("127.0.0.1:0", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	listener := portforward.Serve(context.Background(), udpListener, &net.Dialer{}, &portforward.Config{
+		RemoteAddr: echo.LocalAddr().String(),
+		Network:    "udp",
+	})
+	defer listener.Close()
+
+	conn, err := net.Dial("udp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	for _, datagram := range []string{"ping", "pong"} {
+		if _, err := conn.Write([]byte(datagram)); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, 1024)
+		n, err := conn.Read(reply)
+		if err != nil || string(reply[:n]) != datagram {
+			t.Errorf("got %q, %v, want the echo of %q", reply[:n], err, datagram)
+		}
+	}
+	if stats := listener.Stats(); stats.Accepted != 1 {
+		t.Errorf("got %d accepted connections, want the datagrams of one client to share a connection", stats.Accepted)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for listener.Stats().Active != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the idle connection to be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
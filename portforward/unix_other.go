@@ -0,0 +1,14 @@
+//go:build !windows
+
+package portforward
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isAddrInUse reports whether listening failed because the socket file
+// exists.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
@@ -0,0 +1,46 @@
+package portforward_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/johanneswuerbach/terraform-provider-sshtunnel/portforward"
+)
+
+func TestListenUnixStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tunnel.sock")
+
+	// Leave a socket behind like a crashed process would
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to create socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := portforward.ListenUnix(path)
+	if err != nil {
+		t.Fatalf("Failed to listen on stale socket: %v", err)
+	}
+	defer listener.Close()
+
+	if _, err := portforward.ListenUnix(path); err == nil {
+		t.Error("Expected an error when the socket is in use")
+	}
+}
+
+func TestListenUnixNotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tunnel.sock")
+	if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if _, err := portforward.ListenUnix(path); err == nil {
+		t.Error("Expected an error when the path is not a socket")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the file to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+package portforward
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// isAddrInUse reports whether listening failed because the socket file
+// exists, Winsock reports WSAEADDRINUSE instead of EADDRINUSE.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE) || errors.Is(err, windows.WSAEADDRINUSE)
+}